@@ -0,0 +1,203 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cluster provides grouping of events based on their pairwise
+// similarity.
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/exp/rand"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/community"
+	"gonum.org/v1/gonum/graph/iterator"
+	"gonum.org/v1/gonum/graph/simple"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// ThresholdGraph is an undirected graph where edges must have a weight
+// at or above a given threshold to be returned or traversed.
+type ThresholdGraph struct {
+	*simple.WeightedUndirectedGraph
+	Thresh float64
+}
+
+// NewThresholdGraph returns a new empty ThresholdGraph with the given
+// threshold.
+func NewThresholdGraph(thresh float64) ThresholdGraph {
+	return ThresholdGraph{WeightedUndirectedGraph: simple.NewWeightedUndirectedGraph(0, 0), Thresh: thresh}
+}
+
+// From returns all nodes in g that can be reached directly from n,
+// ordered by ID.
+func (g ThresholdGraph) From(n int64) graph.Nodes {
+	if g.Node(n) == nil {
+		return nil
+	}
+
+	var nodes []graph.Node
+	for _, to := range graph.NodesOf(g.WeightedUndirectedGraph.From(n)) {
+		if g.HasEdgeBetween(n, to.ID()) {
+			nodes = append(nodes, to)
+		}
+	}
+	sort.Sort(byID(nodes))
+
+	return iterator.NewOrderedNodes(nodes)
+}
+
+// HasEdgeBetween returns whether an edge exists between nodes x and y.
+func (g ThresholdGraph) HasEdgeBetween(x, y int64) bool {
+	if !g.WeightedUndirectedGraph.HasEdgeBetween(x, y) {
+		return false
+	}
+	w, _ := g.WeightedUndirectedGraph.Weight(x, y)
+	return w >= g.Thresh
+}
+
+// Edge returns the edge from u to v if such an edge exists and nil otherwise.
+// The node v must be directly reachable from u as defined by the From method.
+func (g ThresholdGraph) Edge(u, v int64) graph.Edge {
+	return g.EdgeBetween(u, v)
+}
+
+// EdgeBetween returns the edge between nodes x and y.
+func (g ThresholdGraph) EdgeBetween(x, y int64) graph.Edge {
+	e := g.WeightedUndirectedGraph.EdgeBetween(x, y)
+	if e == nil {
+		return nil
+	}
+	if w, _ := g.WeightedUndirectedGraph.Weight(x, y); w < g.Thresh {
+		return nil
+	}
+	return e
+}
+
+// Weight returns the weight for the edge between x and y if it is
+// returned by EdgeBetween. Self edges are not considered.
+func (g ThresholdGraph) Weight(x, y int64) (w float64, ok bool) {
+	if x == y || !g.HasEdgeBetween(x, y) {
+		return 0, false
+	}
+	return g.WeightedUndirectedGraph.Weight(x, y)
+}
+
+// Method is a clustering method.
+type Method int
+
+const (
+	// Components groups nodes that are transitively
+	// connected by edges above the threshold.
+	Components Method = iota
+
+	// Louvain groups nodes by optimizing the modularity
+	// of the weighted graph of edges above the threshold.
+	// Louvain clustering is less prone to chaining distinct
+	// groups through a single bridging node.
+	Louvain
+)
+
+// Set implements the flag.Value interface.
+func (m *Method) Set(s string) error {
+	switch s {
+	case "components":
+		*m = Components
+	case "louvain":
+		*m = Louvain
+	default:
+		return fmt.Errorf("invalid clustering method: %q", s)
+	}
+	return nil
+}
+
+// String implements the flag.Value interface.
+func (m *Method) String() string {
+	switch *m {
+	case Components:
+		return "components"
+	case Louvain:
+		return "louvain"
+	default:
+		return fmt.Sprintf("Method(%d)", *m)
+	}
+}
+
+// Cluster returns the groups of nodes in g found using the given method.
+// Louvain clustering draws random numbers from src, so repeated calls
+// give the same result only if each is given a new source with the same
+// seed. If src is nil, the global random source is used. The nodes of
+// each group are sorted by ID and the groups are sorted by the ID of
+// their first node, so group order is deterministic.
+func Cluster(g ThresholdGraph, m Method, src rand.Source) [][]graph.Node {
+	var c [][]graph.Node
+	switch m {
+	case Components:
+		c = topo.ConnectedComponents(g)
+	case Louvain:
+		c = community.Modularize(g, 1, src).Communities()
+	default:
+		panic(fmt.Sprintf("cluster: invalid method: %d", m))
+	}
+	for _, n := range c {
+		sort.Sort(byID(n))
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i][0].ID() < c[j][0].ID() })
+	return c
+}
+
+type byID []graph.Node
+
+func (n byID) Len() int           { return len(n) }
+func (n byID) Less(i, j int) bool { return n[i].ID() < n[j].ID() }
+func (n byID) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
+// Modularity accumulates the modularity of clusterings over a set of
+// disjoint graphs.
+type Modularity struct {
+	in, tot2, m2 float64
+}
+
+// Add adds the clustering of g, c, to the modularity. Weights are
+// summed in node ID order so that the result does not depend on the
+// iteration order of g.
+func (q *Modularity) Add(g ThresholdGraph, c [][]graph.Node) {
+	nodes := graph.NodesOf(g.Nodes())
+	sort.Sort(byID(nodes))
+	for _, u := range nodes {
+		uid := u.ID()
+		to := g.From(uid)
+		for to.Next() {
+			w, _ := g.Weight(uid, to.Node().ID())
+			q.m2 += w
+		}
+	}
+	for _, comm := range c {
+		var tot float64
+		for i, u := range comm {
+			uid := u.ID()
+			to := g.From(uid)
+			for to.Next() {
+				w, _ := g.Weight(uid, to.Node().ID())
+				tot += w
+			}
+			for _, v := range comm[i+1:] {
+				w, _ := g.Weight(uid, v.ID())
+				q.in += 2 * w
+			}
+		}
+		q.tot2 += tot * tot
+	}
+}
+
+// Q returns the modularity of the clusterings added to q. If no
+// edges have been added, Q returns zero.
+func (q *Modularity) Q() float64 {
+	if q.m2 == 0 {
+		return 0
+	}
+	return q.in/q.m2 - q.tot2/(q.m2*q.m2)
+}
@@ -0,0 +1,223 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/exp/rand"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/community"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// dumbbell returns a graph of two four node cliques joined by a single
+// bridging edge between nodes 3 and 4. All edges have weight one.
+func dumbbell(thresh float64) ThresholdGraph {
+	g := NewThresholdGraph(thresh)
+	for _, clique := range [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}} {
+		for i, u := range clique {
+			for _, v := range clique[i+1:] {
+				g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(u), T: simple.Node(v), W: 1})
+			}
+		}
+	}
+	g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(3), T: simple.Node(4), W: 1})
+	return g
+}
+
+func ids(c [][]graph.Node) [][]int64 {
+	var id [][]int64
+	for _, n := range c {
+		var g []int64
+		for _, u := range n {
+			g = append(g, u.ID())
+		}
+		id = append(id, g)
+	}
+	return id
+}
+
+func TestClusterDumbbell(t *testing.T) {
+	g := dumbbell(0.5)
+
+	got := ids(Cluster(g, Components, nil))
+	want := [][]int64{{0, 1, 2, 3, 4, 5, 6, 7}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected components clustering: got:%v want:%v", got, want)
+	}
+
+	got = ids(Cluster(g, Louvain, rand.NewSource(1)))
+	want = [][]int64{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected louvain clustering: got:%v want:%v", got, want)
+	}
+}
+
+func TestClusterRepeatable(t *testing.T) {
+	g := dumbbell(0.5)
+	// Add a pendant chain so that the Louvain
+	// result depends on node visit order.
+	for i := 8; i < 20; i++ {
+		g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(i - 1), T: simple.Node(i), W: 1})
+	}
+
+	want := ids(Cluster(g, Louvain, rand.NewSource(1)))
+	for i := 0; i < 10; i++ {
+		// Advance the global source between calls as
+		// a threshold sweep would.
+		Cluster(g, Louvain, nil)
+		got := ids(Cluster(g, Louvain, rand.NewSource(1)))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("louvain clustering not repeatable on iteration %d: got:%v want:%v", i, got, want)
+		}
+	}
+}
+
+func TestModularity(t *testing.T) {
+	g := dumbbell(0.5)
+	for _, m := range []Method{Components, Louvain} {
+		c := Cluster(g, m, rand.NewSource(1))
+		var q Modularity
+		q.Add(g, c)
+		want := community.Q(g, c, 1)
+		if math.Abs(q.Q()-want) > 1e-12 {
+			t.Errorf("unexpected modularity for %v: got:%v want:%v", &m, q.Q(), want)
+		}
+	}
+
+	// Modularity accumulated over disjoint graphs is the
+	// modularity of their union.
+	a := dumbbell(0.5)
+	b := NewThresholdGraph(0.5)
+	u := dumbbell(0.5)
+	for _, e := range []simple.WeightedEdge{
+		{F: simple.Node(8), T: simple.Node(9), W: 1},
+		{F: simple.Node(9), T: simple.Node(10), W: 1},
+		{F: simple.Node(8), T: simple.Node(10), W: 1},
+	} {
+		b.SetWeightedEdge(e)
+		u.SetWeightedEdge(e)
+	}
+	var q Modularity
+	q.Add(a, Cluster(a, Louvain, rand.NewSource(1)))
+	q.Add(b, Cluster(b, Louvain, rand.NewSource(1)))
+	want := community.Q(u, Cluster(u, Louvain, rand.NewSource(1)), 1)
+	if math.Abs(q.Q()-want) > 1e-12 {
+		t.Errorf("unexpected accumulated modularity: got:%v want:%v", q.Q(), want)
+	}
+
+	var empty Modularity
+	if empty.Q() != 0 {
+		t.Errorf("unexpected modularity for empty graph: got:%v want:0", empty.Q())
+	}
+}
+
+func TestMethodSet(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		want    Method
+		wantErr bool
+	}{
+		{in: "components", want: Components},
+		{in: "louvain", want: Louvain},
+		{in: "kmeans", wantErr: true},
+	} {
+		var m Method
+		err := m.Set(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %q: %v", test.in, err)
+		}
+		if err == nil && (m != test.want || m.String() != test.in) {
+			t.Errorf("unexpected method for %q: got:%v want:%v", test.in, &m, test.want)
+		}
+	}
+}
+
+func TestThresholdGraph(t *testing.T) {
+	const thresh = 0.5
+	g := NewThresholdGraph(thresh)
+	for _, e := range []simple.WeightedEdge{
+		{F: simple.Node(0), T: simple.Node(1), W: 0.9},
+		{F: simple.Node(1), T: simple.Node(2), W: thresh}, // Boundary weight is retained.
+		{F: simple.Node(2), T: simple.Node(3), W: 0.4999},
+		{F: simple.Node(3), T: simple.Node(4), W: 0.6},
+		{F: simple.Node(0), T: simple.Node(4), W: 0.1},
+	} {
+		g.SetWeightedEdge(e)
+	}
+
+	for _, test := range []struct {
+		x, y int64
+		want bool
+		w    float64
+	}{
+		{x: 0, y: 1, want: true, w: 0.9},
+		{x: 1, y: 0, want: true, w: 0.9},
+		{x: 1, y: 2, want: true, w: thresh},
+		{x: 2, y: 3, want: false},
+		{x: 3, y: 4, want: true, w: 0.6},
+		{x: 0, y: 4, want: false},
+		{x: 0, y: 2, want: false},
+		{x: 0, y: 0, want: false},
+		{x: 0, y: 10, want: false},
+	} {
+		if got := g.HasEdgeBetween(test.x, test.y); got != test.want {
+			t.Errorf("unexpected HasEdgeBetween(%d, %d): got:%t want:%t", test.x, test.y, got, test.want)
+		}
+		if got := g.EdgeBetween(test.x, test.y) != nil; got != test.want {
+			t.Errorf("unexpected EdgeBetween(%d, %d) existence: got:%t want:%t", test.x, test.y, got, test.want)
+		}
+		if got := g.Edge(test.x, test.y) != nil; got != test.want {
+			t.Errorf("unexpected Edge(%d, %d) existence: got:%t want:%t", test.x, test.y, got, test.want)
+		}
+		w, ok := g.Weight(test.x, test.y)
+		if ok != test.want || w != test.w {
+			t.Errorf("unexpected Weight(%d, %d): got:%v %t want:%v %t", test.x, test.y, w, ok, test.w, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		n    int64
+		want []int64
+	}{
+		{n: 0, want: []int64{1}},
+		{n: 1, want: []int64{0, 2}},
+		{n: 2, want: []int64{1}},
+		{n: 3, want: []int64{4}},
+		{n: 4, want: []int64{3}},
+		{n: 10, want: nil},
+	} {
+		var got []int64
+		if to := g.From(test.n); to != nil {
+			for to.Next() {
+				got = append(got, to.Node().ID())
+			}
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected From(%d): got:%v want:%v", test.n, got, test.want)
+		}
+	}
+
+	got := ids(Cluster(g, Components, nil))
+	want := [][]int64{{0, 1, 2}, {3, 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected components: got:%v want:%v", got, want)
+	}
+
+	// Raising the threshold above the boundary
+	// weight splits its component.
+	g.Thresh = 0.50001
+	got = ids(Cluster(g, Components, nil))
+	want = [][]int64{{0, 1}, {2}, {3, 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected components above boundary: got:%v want:%v", got, want)
+	}
+}
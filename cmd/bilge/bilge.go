@@ -17,6 +17,10 @@ import (
 	"github.com/biogo/biogo/io/seqio/fasta"
 	"github.com/biogo/biogo/seq"
 	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/progress"
+	"github.com/kortschak/loopy/internal/sequtil"
 )
 
 var (
@@ -24,6 +28,9 @@ var (
 	thresh = flag.Float64("thresh", 0, "specify minimum total sequence complexity")
 	dist   = flag.Bool("dist", false, "only calculate complexity distribution")
 	typ    = flag.Int("type", 0, "specify complexity calculation function (0 - WF, 1 - entropic, 2 - Z)")
+	every  = flag.Duration("progress", 0, "log progress at this interval (no progress logging if zero)")
+	gz     = flag.Bool("gzip", false, "gzip compress output")
+	wrap   = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
 )
 
 func main() {
@@ -45,22 +52,36 @@ func main() {
 	}
 	defer f.Close()
 
-	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
+	out := output.Stdout(*gz)
+	p := progress.ForFile(f, *every)
+	sc := seqio.NewScanner(fasta.NewReader(p.Reader(f), linear.NewSeq("", nil, alphabet.DNAgapped)))
 	for sc.Next() {
+		p.Add(1)
 		seq := sc.Seq().(*linear.Seq)
 
 		// err is always nil for a linear.Seq Start() and End().
 		c, _ := cfn(seq, seq.Start(), seq.End())
 
 		if *dist {
-			fmt.Printf("%s\t%v\t%d\n", seq.Name(), c, seq.Len())
+			_, err = fmt.Fprintf(out, "%s\t%v\t%d\n", seq.Name(), c, seq.Len())
+			if err != nil {
+				log.Fatalf("failed to write complexity: %v", err)
+			}
 			continue
 		}
 		if c >= *thresh {
-			fmt.Printf("%60a\n", seq)
+			err = sequtil.WriteFasta(out, seq, *wrap)
+			if err != nil {
+				log.Fatalf("failed to write sequence: %v", err)
+			}
 		}
 	}
 	if err := sc.Error(); err != nil {
 		log.Fatalf("error during fasta read: %v", err)
 	}
+	p.Done()
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
 }
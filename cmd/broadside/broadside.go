@@ -9,9 +9,15 @@
 // The use of this program makes most sense when the input GFF stream is collection of
 // features that are in fil indivdual, but not in the pat or mat individuals. This
 // operation can be performed using the net command.
+//
+// If -depth-profile is given, the per-base read depth of each individual over the
+// feature used for each group's counts, extended by -flank bases on each side, is
+// written to the named file as tab separated group, individual, 1-based reference
+// position and depth lines for plotting support curves.
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
@@ -19,17 +25,26 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/biogo/biogo/feat"
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
 	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
 	"github.com/biogo/hts/sam"
+
+	"github.com/kortschak/loopy/consensus"
+	"github.com/kortschak/loopy/internal/output"
 )
 
 var (
 	fil = flag.String("fil", "", "specify bam and bai files containing filial genome alignments")
 	pat = flag.String("pat", "", "specify bam and bai files containing paternal genome alignments")
 	mat = flag.String("mat", "", "specify bam and bai files containing maternal genome alignments")
+
+	profile = flag.String("depth-profile", "", "specify the tsv output file for per-base read depth (gzip compressed if the name ends in .gz)")
+	flank   = flag.Int("flank", 1000, "specify the flank either side of each feature included in the depth profile")
 )
 
 func main() {
@@ -55,54 +70,112 @@ func main() {
 	}
 	defer f.Close()
 
+	var depths *bufio.Writer
+	if *profile != "" {
+		out, err := output.Create(*profile)
+		if err != nil {
+			log.Fatalf("failed to create depth profile file %q: %v", *profile, err)
+		}
+		defer func() {
+			err := depths.Flush()
+			if err != nil {
+				log.Fatalf("failed to write depth profile: %v", err)
+			}
+			err = out.Close()
+			if err != nil {
+				log.Fatalf("failed to close depth profile file %q: %v", *profile, err)
+			}
+		}()
+		depths = bufio.NewWriter(out)
+	}
+
 	// Collate each GFF feature on stdin into
-	// its group of features.
-	var grps []map[string]featGroup
+	// its group of features. Features without
+	// a valid group are reported and skipped.
+	var (
+		grps    []map[string]featGroup
+		skipped int
+	)
 	sc := featio.NewScanner(gff.NewReader(os.Stdin))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
 		g := f.FeatAttributes.Get("Group")
 		gid, err := strconv.Atoi(g)
-		if err != nil {
-			log.Fatalf("failed to parse group id: %v", err)
+		if err != nil || gid < 0 {
+			log.Printf("skipping feature %s:%d-%d with invalid group id %q",
+				f.SeqName, feat.ZeroToOne(f.FeatStart), f.FeatEnd, g)
+			skipped++
+			continue
 		}
 		grps = add(grps, gid, f)
 	}
 	if err := sc.Error(); err != nil {
 		log.Fatalf("error during gff read: %v", err)
 	}
+	if skipped != 0 {
+		log.Printf("skipped %d features with invalid group ids", skipped)
+	}
 
-	// For each group of features, find the counts of
-	// overlapping reads.
+	// Find the most common repeat type of each
+	// group and collect the feature that is used
+	// to count overlapping reads for each group.
+	sorted := make([][]consensus.Count, len(grps))
+	var feats []*gff.Feature
 	for gid, g := range grps {
 		if g == nil {
 			continue
 		}
-		// Iterate over each group's features, counting
-		// alignmens that overlap.
-		sm := sortedMap(g)
-		name, n := nameHeuristic(sm)
-		fmt.Printf("%d\t%d\t%s\t", gid, n, name)
-		for i, t := range sm {
-			if i == 0 {
-				nf, err := f.overlapping(t.f)
-				if err != nil {
-					log.Fatal(err)
-				}
-				np, err := p.overlapping(t.f)
-				if err != nil {
-					log.Fatal(err)
-				}
-				nm, err := m.overlapping(t.f)
-				if err != nil {
-					log.Fatal(err)
+		counts := make(map[string]int, len(g))
+		for typ, fg := range g {
+			counts[typ] = fg.n
+		}
+		sorted[gid] = consensus.Sorted(counts)
+		if len(sorted[gid]) != 0 {
+			feats = append(feats, g[sorted[gid][0].Type].f)
+		}
+	}
+	ov, err := overlapping(feats, f, p, m)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// For each group of features, report the counts
+	// of overlapping reads.
+	var k int
+	for gid, g := range grps {
+		if g == nil {
+			continue
+		}
+		var n int
+		for _, fg := range g {
+			n += fg.n
+		}
+		sm := sorted[gid]
+		fmt.Printf("%d\t%d\t%s\t", gid, n, consensus.NameOf(sm))
+		if len(sm) != 0 {
+			t := g[sm[0].Type]
+			fmt.Printf("%d\t%d\t%d\n", ov[0][k], ov[1][k], ov[2][k])
+			k++
+
+			if depths != nil {
+				for i, c := range []*counter{f, p, m} {
+					start, d, err := c.depth(t.f, *flank)
+					if err != nil {
+						log.Fatal(err)
+					}
+					for j, n := range d {
+						fmt.Fprintf(depths, "%d\t%s\t%d\t%d\n", gid, individuals[i], start+j+1, n)
+					}
 				}
-				fmt.Printf("%d\t%d\t%d\n", nf, np, nm)
 			}
 		}
 	}
 }
 
+// individuals holds the depth profile labels of the
+// filial, paternal and maternal counters.
+var individuals = []string{"fil", "pat", "mat"}
+
 // counter is a BAM/BAI reader that counts mapped reads that overlap
 // a GFF feature.
 type counter struct {
@@ -132,31 +205,202 @@ func newCounter(path string) (*counter, error) {
 		return nil, fmt.Errorf("failed to open bai data: %v", err)
 	}
 	ir.Close()
+	idx.MergeStrategy = adjacent
 
 	return &counter{f: f, r: r, h: r.Header(), idx: idx}, nil
 }
 
-// overlapping returns the number of mapped BAM reads overlapping f.
-func (c *counter) overlapping(f *gff.Feature) (int, error) {
+// search is the distance either side of a feature
+// within which index chunks are queried.
+const search = 1e4
+
+// overlapping returns the number of mapped BAM reads overlapping each of
+// the features in feats, in the order of feats.
+//
+// Features are sorted by position and consecutive features on the same
+// reference whose index chunks overlap or abut are counted together by a
+// single iterator over the union of their chunks, so that BAM blocks
+// shared between nearby features are only read once.
+func (c *counter) overlapping(feats []*gff.Feature) ([]int, error) {
+	order := make([]int, len(feats))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := feats[order[i]], feats[order[j]]
+		if a.SeqName != b.SeqName {
+			return a.SeqName < b.SeqName
+		}
+		return a.FeatStart < b.FeatStart
+	})
+
+	n := make([]int, len(feats))
+	var (
+		batch  []int
+		chunks []bgzf.Chunk
+		last   int64
+	)
+	for _, i := range order {
+		f := feats[i]
+		ref, ok := getReference(c.h.Refs(), f.SeqName)
+		if !ok {
+			return nil, fmt.Errorf("could not find reference for %q", f.SeqName)
+		}
+		fc, err := c.idx.Chunks(ref, max(0, f.FeatStart-search), min(ref.Len(), f.FeatEnd+search))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chunks: %v", err)
+		}
+		if len(batch) != 0 && (f.SeqName != feats[batch[0]].SeqName || (len(fc) != 0 && last < vOffset(fc[0].Begin))) {
+			err = c.count(n, feats, batch, chunks)
+			if err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+			chunks = chunks[:0]
+		}
+		batch = append(batch, i)
+		for _, c := range fc {
+			chunks = append(chunks, c)
+			if len(batch) == 1 || last < vOffset(c.End) {
+				last = vOffset(c.End)
+			}
+		}
+	}
+	if len(batch) != 0 {
+		err := c.count(n, feats, batch, chunks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// count adds the number of mapped BAM reads in chunks that overlap each
+// of the features indexed by batch to n. The features must be sorted by
+// start.
+func (c *counter) count(n []int, feats []*gff.Feature, batch []int, chunks []bgzf.Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	it, err := bam.NewIterator(c.r, adjacent(chunks))
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %v", err)
+	}
+	defer it.Close()
+
+	for it.Next() {
+		rec := it.Record()
+		// Only features starting after the read
+		// starts can be spanned by it.
+		i := sort.Search(len(batch), func(i int) bool {
+			return rec.Start() < feats[batch[i]].FeatStart
+		})
+		for _, j := range batch[i:] {
+			f := feats[j]
+			if rec.End() <= f.FeatStart {
+				break
+			}
+			if f.FeatEnd < rec.End() {
+				n[j]++
+			}
+		}
+	}
+	return it.Error()
+}
+
+// adjacent sorts chunks and merges those that overlap or abut. It is
+// equivalent to index.Adjacent, but takes linear time after sorting
+// rather than quadratic time, which dominates index queries over the
+// many chunks of long read alignments.
+func adjacent(chunks []bgzf.Chunk) []bgzf.Chunk {
+	if len(chunks) == 0 {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return vOffset(chunks[i].Begin) < vOffset(chunks[j].Begin) })
+	merged := chunks[:1]
+	for _, c := range chunks[1:] {
+		last := &merged[len(merged)-1]
+		if vOffset(c.Begin) <= vOffset(last.End) {
+			if vOffset(last.End) < vOffset(c.End) {
+				last.End = c.End
+			}
+			continue
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// vOffset returns the BGZF virtual offset of o.
+func vOffset(o bgzf.Offset) int64 {
+	return o.File<<16 | int64(o.Block)
+}
+
+// depth returns the per-base depth of mapped BAM reads over f extended by
+// flank bases on each side and clipped to the reference. The depth at
+// reference position start+i is held in d[i].
+func (c *counter) depth(f *gff.Feature, flank int) (start int, d []int, err error) {
 	ref, ok := getReference(c.h.Refs(), f.SeqName)
 	if !ok {
-		return -1, fmt.Errorf("could not find reference for %q", f.SeqName)
+		return 0, nil, fmt.Errorf("could not find reference for %q", f.SeqName)
 	}
-	chunks, err := c.idx.Chunks(ref, max(0, f.FeatStart-1e4), min(ref.Len(), f.FeatEnd+1e4))
+	start = max(0, f.FeatStart-flank)
+	end := min(ref.Len(), f.FeatEnd+flank)
+	d = make([]int, max(0, end-start))
+	if len(d) == 0 {
+		return start, d, nil
+	}
+	chunks, err := c.idx.Chunks(ref, start, end)
 	if err != nil {
-		return -1, fmt.Errorf("failed to get chunks: %v", err)
+		return 0, nil, fmt.Errorf("failed to get chunks: %v", err)
 	}
 	it, err := bam.NewIterator(c.r, chunks)
 	if err != nil {
-		return -1, fmt.Errorf("failed to create iterator: %v", err)
+		return 0, nil, fmt.Errorf("failed to create iterator: %v", err)
 	}
 	defer it.Close()
 
-	var n int
 	for it.Next() {
 		rec := it.Record()
-		if rec.Start() < f.FeatStart && f.FeatEnd < rec.End() {
-			n++
+		if rec.Flags&sam.Unmapped != 0 || rec.Ref.ID() != ref.ID() || rec.End() <= start || end <= rec.Start() {
+			continue
+		}
+		pos := rec.Start()
+		for _, co := range rec.Cigar {
+			n := co.Len()
+			switch co.Type() {
+			case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+				for i := max(pos, start); i < min(pos+n, end); i++ {
+					d[i-start]++
+				}
+			}
+			if co.Type().Consumes().Reference != 0 {
+				pos += n
+			}
+		}
+	}
+	return start, d, it.Error()
+}
+
+// overlapping returns the number of mapped BAM reads overlapping each of
+// feats for each of the counters. The counters are queried concurrently
+// since each holds its own BAM reader and index. The first error
+// encountered is returned.
+func overlapping(feats []*gff.Feature, counters ...*counter) ([][]int, error) {
+	n := make([][]int, len(counters))
+	errs := make([]error, len(counters))
+	var wg sync.WaitGroup
+	for i, c := range counters {
+		wg.Add(1)
+		go func(i int, c *counter) {
+			defer wg.Done()
+			n[i], errs[i] = c.overlapping(feats)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
 	}
 	return n, nil
@@ -220,72 +464,6 @@ func add(grps []map[string]featGroup, gid int, f *gff.Feature) []map[string]feat
 	return grps
 }
 
-type mapElement struct {
-	typ string
-	n   int // n is the GFF score of the feature.
-	f   *gff.Feature
-}
-
-type byCount []mapElement
-
-func (m byCount) Len() int { return len(m) }
-func (m byCount) Less(i, j int) bool {
-	if m[i].n < m[j].n {
-		return true
-	}
-	// Heuristic for sort that longer names are likely to be
-	// a tighter definition, so use them in preference.
-	return m[i].n == m[j].n && len(m[i].typ) < len(m[j].typ)
-}
-func (m byCount) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
-
-// return a sort descending slice of the groups in g.
-func sortedMap(g map[string]featGroup) []mapElement {
-	m := make([]mapElement, 0, len(g))
-	for typ, f := range g {
-		m = append(m, mapElement{typ: typ, n: f.n, f: f.f})
-	}
-	sort.Sort(sort.Reverse(byCount(m)))
-	return m
-}
-
-// make a reasonable guess at what the name of the repeat type
-// of the features in g it.
-func nameHeuristic(g []mapElement) (name string, n int) {
-	if len(g) == 0 {
-		return "", 0
-	}
-
-	// Majority rule.
-	for _, e := range g {
-		n += e.n
-	}
-	r := float64(g[0].n) / float64(n)
-	if r > 0.5 || (r == 0.5 && len(g) > 2) {
-		return g[0].typ, n
-	}
-
-	// Alu heuristic.
-	if isAlu(g[0].typ) {
-		return trunc(g[0].typ, 5), n
-	}
-
-	// Fusion.
-	var names []string
-	for _, t := range g {
-		names = append(names, t.typ)
-	}
-	return strings.Join(names, "/"), n
-}
-
-func isAlu(t string) bool {
-	return strings.HasPrefix(strings.ToLower(t), "alu")
-}
-
-func trunc(name string, n int) string {
-	return name[:min(5, len(name))]
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
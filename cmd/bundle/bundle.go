@@ -18,12 +18,18 @@ import (
 	"github.com/biogo/biogo/io/seqio"
 	"github.com/biogo/biogo/io/seqio/fasta"
 	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/sequtil"
 )
 
 var (
 	in     = flag.String("in", "", "specifies the input filename")
 	cut    = flag.Int("cut", 0, "specifies the size cut-off for inclusion")
 	bundle = flag.Int("bundle", 100e6, "specifies the sum of sequence length in a bundle")
+	gz     = flag.Bool("gzip", false, "gzip compress bundle files")
+	trim   = flag.Bool("trim-ns", false, "trim leading and trailing N from sequences before size accounting")
+	wrap   = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
 )
 
 func main() {
@@ -42,29 +48,38 @@ func main() {
 
 	sc := seqio.NewScanner(fasta.NewReader(inFile, linear.NewSeq("", nil, alphabet.DNA)))
 
+	format := "%s-%d.fa"
+	if *gz {
+		format += ".gz"
+	}
+
 	var i, size int
-	out, err := os.Create(fmt.Sprintf("%s-%d.fa", *in, i))
+	out, err := output.Create(fmt.Sprintf(format, *in, i))
 	if err != nil {
 		log.Fatalf("failed to open file bundle %d: %v", i, err)
 	}
 	for sc.Next() {
-		if sc.Seq().Len() < *cut {
+		s := sc.Seq().(*linear.Seq)
+		if !keep(s, *cut, *trim) {
 			continue
 		}
-		if size != 0 && size+sc.Seq().Len() > *bundle {
+		if size != 0 && size+s.Len() > *bundle {
 			err = out.Close()
 			if err != nil {
 				log.Fatalf("failed to close file bundle %d: %v", i, err)
 			}
 			i++
 			size = 0
-			out, err = os.Create(fmt.Sprintf("%s-%d.fa", *in, i))
+			out, err = output.Create(fmt.Sprintf(format, *in, i))
 			if err != nil {
 				log.Fatalf("failed to open file bundle %d: %v", i, err)
 			}
 		}
-		size += sc.Seq().Len()
-		fmt.Fprintf(out, "%60a\n", sc.Seq())
+		size += s.Len()
+		err = sequtil.WriteFasta(out, s, *wrap)
+		if err != nil {
+			log.Fatalf("failed to write to file bundle %d: %v", i, err)
+		}
 	}
 	if sc.Error() != nil {
 		log.Fatal(sc.Error())
@@ -74,3 +89,18 @@ func main() {
 		log.Fatalf("failed to close file bundle %d: %v", i, err)
 	}
 }
+
+// keep returns whether s is at least cut long and so is included in a
+// bundle. If trim is true, leading and trailing N are first removed from
+// s, so they do not count toward its length, and s is not included if
+// it is entirely N.
+func keep(s *linear.Seq, cut int, trim bool) bool {
+	if trim {
+		start, end := sequtil.TrimAmbiguous(s.Seq, s.Alpha)
+		if start == end {
+			return false
+		}
+		s.Seq = s.Seq[start:end]
+	}
+	return s.Len() >= cut
+}
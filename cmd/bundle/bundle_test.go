@@ -0,0 +1,39 @@
+// Copyright ©2015 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+func TestKeep(t *testing.T) {
+	for _, test := range []struct {
+		seq  string
+		cut  int
+		trim bool
+
+		want    bool
+		wantSeq string
+	}{
+		{seq: "NNNacgtNNN", cut: 10, want: true, wantSeq: "NNNacgtNNN"},
+		{seq: "NNNacgtNNN", cut: 10, trim: true, want: false, wantSeq: "acgt"},
+		{seq: "NNNacgtNNN", cut: 4, trim: true, want: true, wantSeq: "acgt"},
+		{seq: "nnacNNgtnn", cut: 6, trim: true, want: true, wantSeq: "acNNgt"},
+		{seq: "NNNN", cut: 0, want: true, wantSeq: "NNNN"},
+		{seq: "NNNN", cut: 0, trim: true, want: false},
+	} {
+		s := linear.NewSeq("read", alphabet.Letters(test.seq), alphabet.DNA)
+		got := keep(s, test.cut, test.trim)
+		if got != test.want {
+			t.Errorf("unexpected keep for %q cut=%d trim=%t: got:%t want:%t", test.seq, test.cut, test.trim, got, test.want)
+		}
+		if got && string(s.Seq) != test.wantSeq {
+			t.Errorf("unexpected sequence for %q cut=%d trim=%t: got:%q want:%q", test.seq, test.cut, test.trim, s.Seq, test.wantSeq)
+		}
+	}
+}
@@ -3,6 +3,11 @@
 // license that can be found in the LICENSE file.
 
 // carta renders a rings plot of a binned feature distribution on hg19.
+//
+// BED files given as arguments in addition to, or in place of, -in are
+// rendered as concentric density rings inside a single karyotype, from
+// the outermost ring inwards in the order given, with a legend naming
+// each file. Each ring is scaled independently.
 package main
 
 import (
@@ -16,6 +21,7 @@ import (
 
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
 	"gonum.org/v1/plot/vg"
 	"gonum.org/v1/plot/vg/draw"
 
@@ -29,9 +35,11 @@ import (
 
 var (
 	in     string
+	out    string
 	format string
 
 	binLength int
+	binStep   int
 )
 
 const (
@@ -40,17 +48,29 @@ const (
 	secondary
 )
 
+var help bool
+
 func init() {
 	flag.StringVar(&in, "in", "", "file name of a BED file to be processed.")
+	flag.StringVar(&out, "out", "", "file name of the rendered plot (defaults to the name of the first BED file with the format extension).")
 	flag.IntVar(&binLength, "length", 1e6, "specifies the density bin length.")
+	flag.IntVar(&binStep, "step", 0, "specifies the density bin step for sliding windows of -length (defaults to -length for non-overlapping windows).")
 	flag.StringVar(&format, "format", "svg", "specifies the output format of the example: eps, jpg, jpeg, pdf, png, svg, and tiff.")
-	help := flag.Bool("help", false, "output this usage message.")
+	flag.BoolVar(&help, "help", false, "output this usage message.")
+}
+
+// parseFlags parses and checks the command line flags, exiting
+// if they are not valid.
+func parseFlags() {
 	flag.Parse()
-	if *help {
+	if help {
 		flag.Usage()
 		os.Exit(0)
 	}
-	if in == "" {
+	if binStep <= 0 {
+		binStep = binLength
+	}
+	if (in == "" && flag.NArg() == 0) || binStep > binLength {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -78,11 +98,32 @@ func min(a, b int) int {
 	return b
 }
 
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func main() {
-	bf, err := readBED(in)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	parseFlags()
+
+	var files []string
+	if in != "" {
+		files = append(files, in)
+	}
+	files = append(files, flag.Args()...)
+
+	scores := make([][]rings.Scorer, len(files))
+	names := make([]string, len(files))
+	for i, f := range files {
+		bf, err := readBED(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		scores[i] = scoreFeatures(bf, binLength, binStep, hg19.Chromosomes)
+		names[i] = filepath.Base(f)
 	}
 
 	p, err := plot.New()
@@ -91,7 +132,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	hs, err := tracks(scoreFeatures(bf, binLength, hg19.Chromosomes), 15*vg.Centimeter)
+	hs, styles, err := tracks(scores, 15*vg.Centimeter)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -107,10 +148,25 @@ func main() {
 		os.Exit(1)
 	}
 
-	p.Title.Text = filepath.Base(in)
+	if len(files) == 1 {
+		p.Title.Text = names[0]
+	} else {
+		for i, n := range names {
+			p.Legend.Add(n, &plotter.Line{LineStyle: styles[i]})
+		}
+		p.Legend.Top = true
+		p.Legend.TextStyle.Font, err = vg.MakeFont("Helvetica", 10)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 	p.Title.TextStyle = draw.TextStyle{Color: color.Gray{0}, Font: font}
 
-	err = p.Save(19*vg.Centimeter, 25*vg.Centimeter, filepath.Base(in)+"."+format)
+	if out == "" {
+		out = names[0] + "." + format
+	}
+	err = p.Save(19*vg.Centimeter, 25*vg.Centimeter, out)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -141,23 +197,46 @@ func readBED(in string) ([]*bed.Bed3, error) {
 	return fs, nil
 }
 
-func scoreFeatures(b []*bed.Bed3, length int, gen []*genome.Chromosome) []rings.Scorer {
+// scoreFeatures returns the density of the features in b along each
+// chromosome in gen, placing each feature by its midpoint. The density is
+// given for adjacent bins of width step, each scored over a window of the
+// given length centred on the bin. When step is less than length the
+// windows overlap and the unit mass of each feature is shared equally
+// between the windows that contain its midpoint, so the total mass over
+// all bins is the number of features.
+func scoreFeatures(b []*bed.Bed3, length, step int, gen []*genome.Chromosome) []rings.Scorer {
+	// off is the offset of the start of each
+	// window from the start of its bin.
+	off := (step - length) / 2
+
 	var n int
 	gs := make([][]*feature, len(gen))
 	for i, c := range gen {
-		bins := make([]*feature, (c.Len()-1)/length+1)
+		bins := make([]*feature, (c.Len()-1)/step+1)
 		n += len(bins)
 		for j := range bins {
 			bins[j] = &feature{
-				start: j * length,
-				end:   min(c.Len(), (j+1)*length),
-				chr:   c,
+				start:  j * step,
+				end:    min(c.Len(), (j+1)*step),
+				window: min(c.Len(), j*step+off+length) - max(0, j*step+off),
+				chr:    c,
 			}
 		}
 		gs[i] = bins
 	}
 	for _, f := range b {
-		gs[index[strings.ToLower(f.Chrom)]][(f.Start()+f.End())/2/length].events++
+		bins := gs[index[strings.ToLower(f.Chrom)]]
+		mid := (f.Start() + f.End()) / 2
+		// Windows j*step+off <= mid < j*step+off+length.
+		first := 0
+		if mid-off-length >= 0 {
+			first = (mid-off-length)/step + 1
+		}
+		last := min((mid-off)/step, len(bins)-1)
+		mass := 1 / float64(last-first+1)
+		for _, bin := range bins[first : last+1] {
+			bin.events += mass
+		}
 	}
 
 	s := make([]rings.Scorer, 0, n)
@@ -171,9 +250,10 @@ func scoreFeatures(b []*bed.Bed3, length int, gen []*genome.Chromosome) []rings.
 
 type feature struct {
 	start, end int
+	window     int
 	name       string
 	chr        feat.Feature
-	events     int
+	events     float64
 }
 
 func (f *feature) Start() int             { return f.start }
@@ -183,11 +263,16 @@ func (f *feature) Name() string           { return f.name }
 func (f *feature) Description() string    { return "alignment bin" }
 func (f *feature) Location() feat.Feature { return f.chr }
 func (f *feature) Scores() []float64 {
-	factor := float64(binLength) / float64(f.Len())
-	return []float64{float64(f.events) * factor}
+	// Scale to the density in a bin length, undoing the
+	// sharing of feature mass between the length/step
+	// windows covering each position.
+	factor := float64(binLength) / float64(f.window) * float64(binLength) / float64(binStep)
+	return []float64{f.events * factor}
 }
 
-func tracks(scores []rings.Scorer, diameter vg.Length) ([]plot.Plotter, error) {
+// tracks returns the karyotype rings and a counts ring for each set of scores,
+// and the line style of each counts ring.
+func tracks(scores [][]rings.Scorer, diameter vg.Length) ([]plot.Plotter, []draw.LineStyle, error) {
 	var p []plot.Plotter
 
 	radius := diameter / 2
@@ -206,6 +291,11 @@ func tracks(scores []rings.Scorer, diameter vg.Length) ([]plot.Plotter, error) {
 
 		large = 6. / 110.
 		small = 2. / 110.
+
+		// ringGap is the fraction of each counts
+		// ring left empty when there is more
+		// than one.
+		ringGap = 0.1
 	)
 
 	sty := plotter.DefaultLineStyle
@@ -217,11 +307,11 @@ func tracks(scores []rings.Scorer, diameter vg.Length) ([]plot.Plotter, error) {
 	}
 	hs, err := rings.NewGappedBlocks(
 		chr,
-		rings.Arc{rings.Complete / 4 * rings.CounterClockwise, rings.Complete * rings.Clockwise},
+		rings.Arc{Theta: rings.Complete / 4 * rings.CounterClockwise, Phi: rings.Complete * rings.Clockwise},
 		radius*karyotypeInner, radius*karyotypeOuter, gap,
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	hs.LineStyle = sty
 
@@ -241,62 +331,77 @@ func tracks(scores []rings.Scorer, diameter vg.Length) ([]plot.Plotter, error) {
 	}
 	b, err := rings.NewBlocks(bands, hs, radius*karyotypeInner, radius*karyotypeOuter)
 	if err != nil {
-		return nil, fmt.Errorf("bands: %v", err)
+		return nil, nil, fmt.Errorf("bands: %v", err)
 	}
 	p = append(p, b)
 	c, err := rings.NewBlocks(cens, hs, radius*karyotypeInner, radius*karyotypeOuter)
 	if err != nil {
-		return nil, fmt.Errorf("centromeres: %v", err)
+		return nil, nil, fmt.Errorf("centromeres: %v", err)
 	}
 	p = append(p, c)
 
 	font, err := vg.MakeFont("Helvetica", radius*large)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	lb, err := rings.NewLabels(hs, radius*label, rings.NameLabels(hs.Set)...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	lb.TextStyle = draw.TextStyle{Color: color.Gray16{0}, Font: font}
 	p = append(p, lb)
 
 	smallFont, err := vg.MakeFont("Helvetica", radius*small)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	counts := make([]rings.Scorer, len(scores))
-	for i, s := range scores {
-		counts[i] = s.(*feature)
-	}
-	ct, err := rings.NewScores(counts, hs, radius*countsInner, radius*countsOuter,
-		&rings.Trace{
-			LineStyles: func() []draw.LineStyle {
-				ls := []draw.LineStyle{sty}
-				ls[0].Color = color.Gray16{0}
-				return ls
-			}(),
-			Join: true,
-			Axis: &rings.Axis{
-				Angle:     rings.Complete / 4,
-				Grid:      plotter.DefaultGridLineStyle,
-				LineStyle: sty,
-				Tick: rings.TickConfig{
-					Marker:    plot.DefaultTicks{},
+	// Divide the counts ring between the score sets,
+	// leaving a gap between adjacent rings.
+	step := (countsInner - countsOuter) / float64(len(scores))
+	styles := make([]draw.LineStyle, len(scores))
+	for i, set := range scores {
+		inner := countsInner - float64(i)*step
+		outer := inner - step
+		if i != len(scores)-1 {
+			outer += step * ringGap
+		}
+
+		styles[i] = sty
+		if len(scores) == 1 {
+			styles[i].Color = color.Gray16{0}
+		} else {
+			styles[i].Color = plotutil.Color(i)
+		}
+
+		counts := make([]rings.Scorer, len(set))
+		for j, s := range set {
+			counts[j] = s.(*feature)
+		}
+		ct, err := rings.NewScores(counts, hs, radius*vg.Length(inner), radius*vg.Length(outer),
+			&rings.Trace{
+				LineStyles: []draw.LineStyle{styles[i]},
+				Join:       true,
+				Axis: &rings.Axis{
+					Angle:     rings.Complete / 4,
+					Grid:      plotter.DefaultGridLineStyle,
 					LineStyle: sty,
-					Length:    2,
-					Label:     draw.TextStyle{Color: color.Gray16{0}, Font: smallFont},
+					Tick: rings.TickConfig{
+						Marker:    plot.DefaultTicks{},
+						LineStyle: sty,
+						Length:    2,
+						Label:     draw.TextStyle{Color: color.Gray16{0}, Font: smallFont},
+					},
 				},
 			},
-		},
-	)
-	if err != nil {
-		return nil, err
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("counts %d: %v", i, err)
+		}
+		p = append(p, ct)
 	}
-	p = append(p, ct)
 
-	return p, nil
+	return p, styles, nil
 }
 
 type colorBand struct {
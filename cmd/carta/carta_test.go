@@ -0,0 +1,148 @@
+// Copyright ©2013 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/plot/vg"
+
+	"github.com/biogo/biogo/feat/genome/human/hg19"
+	"github.com/biogo/biogo/io/featio/bed"
+	"github.com/biogo/graphics/rings"
+)
+
+func TestTracks(t *testing.T) {
+	defer func(l, s int) { binLength, binStep = l, s }(binLength, binStep)
+	binLength, binStep = 1e7, 1e7
+
+	const diameter = 15 * vg.Centimeter
+	for _, n := range []int{1, 3} {
+		// Each set has i+1 features at the start
+		// of each chromosome, so the rings are
+		// scaled to different maxima.
+		scores := make([][]rings.Scorer, n)
+		for i := range scores {
+			var b []*bed.Bed3
+			for _, c := range hg19.Chromosomes {
+				for j := 0; j <= i; j++ {
+					b = append(b, &bed.Bed3{Chrom: c.Chr, ChromStart: 1000, ChromEnd: 2000})
+				}
+			}
+			scores[i] = scoreFeatures(b, binLength, binStep, hg19.Chromosomes)
+		}
+
+		p, styles, err := tracks(scores, diameter)
+		if err != nil {
+			t.Fatalf("unexpected error for %d inputs: %v", n, err)
+		}
+		// The karyotype, band, centromere and label
+		// rings are followed by a counts ring for
+		// each input.
+		if len(p) != 4+n {
+			t.Fatalf("unexpected number of rings for %d inputs: got:%d want:%d", n, len(p), 4+n)
+		}
+		if len(styles) != n {
+			t.Fatalf("unexpected number of styles for %d inputs: got:%d want:%d", n, len(styles), n)
+		}
+
+		radius := diameter / 2
+		outer := radius * 97 / 110
+		seen := make(map[color.Color]bool)
+		for i, r := range p[4:] {
+			s, ok := r.(*rings.Scores)
+			if !ok {
+				t.Fatalf("unexpected type for counts ring %d: %T", i, r)
+			}
+			// Rings are nested inwards in input
+			// order without overlapping.
+			if s.Inner > outer || s.Outer >= s.Inner {
+				t.Errorf("unexpected radii for counts ring %d of %d: inner=%v outer=%v previous=%v", i, n, s.Inner, s.Outer, outer)
+			}
+			outer = s.Outer
+			if want := float64(i+1) * float64(binLength) / float64(binStep); s.Max != want {
+				t.Errorf("unexpected maximum for counts ring %d of %d: got:%v want:%v", i, n, s.Max, want)
+			}
+			seen[styles[i].Color] = true
+		}
+		if outer < radius*70/110 {
+			t.Errorf("counts rings extend inside counts area for %d inputs: %v", n, outer)
+		}
+		if n == 1 && styles[0].Color != (color.Gray16{0}) {
+			t.Errorf("unexpected colour for single input: %v", styles[0].Color)
+		}
+		if len(seen) != n {
+			t.Errorf("counts ring colours not distinct for %d inputs: %v", n, styles)
+		}
+	}
+}
+
+func TestScoreFeaturesMass(t *testing.T) {
+	// Features are placed at each chromosome
+	// end, where windows are truncated, as
+	// well as at random.
+	gen := hg19.Chromosomes[:3]
+	rnd := rand.New(rand.NewSource(1))
+	for _, test := range []struct{ length, step int }{
+		{length: 1e6, step: 1e6},
+		{length: 1e6, step: 5e5},
+		{length: 1e6, step: 3e5},
+		{length: 1e6, step: 1e5},
+		{length: 999999, step: 7919},
+	} {
+		var b []*bed.Bed3
+		for _, c := range gen {
+			for _, start := range []int{0, c.Len() - 1, rnd.Intn(c.Len())} {
+				b = append(b, &bed.Bed3{Chrom: c.Chr, ChromStart: start, ChromEnd: start + 1})
+			}
+		}
+		for i := 0; i < 100; i++ {
+			c := gen[rnd.Intn(len(gen))]
+			start := rnd.Intn(c.Len() - 1000)
+			b = append(b, &bed.Bed3{Chrom: c.Chr, ChromStart: start, ChromEnd: start + 1 + rnd.Intn(1000)})
+		}
+
+		// Each feature alone has unit mass held
+		// only by the windows containing its
+		// midpoint, and the masses of all the
+		// features add.
+		off := (test.step - test.length) / 2
+		for _, f := range b {
+			s := scoreFeatures([]*bed.Bed3{f}, test.length, test.step, gen)
+			if got := mass(s); math.Abs(got-1) > 1e-9 {
+				t.Errorf("unexpected mass for feature %v with length=%d step=%d: got:%v want:1",
+					f, test.length, test.step, got)
+			}
+			mid := (f.Start() + f.End()) / 2
+			for _, sc := range s {
+				bin := sc.(*feature)
+				if bin.chr.Name() != f.Chrom {
+					continue
+				}
+				contains := bin.start+off <= mid && mid < bin.start+off+test.length
+				if got := bin.events != 0; got != contains {
+					t.Errorf("unexpected mass for feature %v in bin [%d,%d) with length=%d step=%d: got:%v want nonzero:%t",
+						f, bin.start, bin.end, test.length, test.step, bin.events, contains)
+				}
+			}
+		}
+		if got := mass(scoreFeatures(b, test.length, test.step, gen)); math.Abs(got-float64(len(b))) > 1e-9 {
+			t.Errorf("unexpected total mass with length=%d step=%d: got:%v want:%d",
+				test.length, test.step, got, len(b))
+		}
+	}
+}
+
+// mass returns the sum of feature mass over all bins in s.
+func mass(s []rings.Scorer) float64 {
+	var m float64
+	for _, f := range s {
+		m += f.(*feature).events
+	}
+	return m
+}
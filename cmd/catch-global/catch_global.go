@@ -4,27 +4,63 @@
 
 // catch-global looks for target site duplications flanking reefer event
 // output by press-global.
+//
+// Inserted sequences for minus strand events are reverse complemented so
+// that they are in the orientation of the reference, and are marked in the
+// same way as sequences extracted by wring.
+//
+// If -gff is set, the events are written to the named file with a TSD
+// attribute added to each event for which a target site duplication is
+// found, using the same analysis as catch.
+//
+// Only the regions of the reads holding the events and, with -gff, their
+// TSD search windows are read, using the fai index of each read fasta
+// file, which is made if it does not exist.
+//
+// With -threads greater than one, reference sequences are processed
+// concurrently and the order of output records is not defined.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/biogo/biogo/align"
 	"github.com/biogo/biogo/alphabet"
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
-	"github.com/biogo/biogo/io/seqio"
-	"github.com/biogo/biogo/io/seqio/fasta"
-	"github.com/biogo/biogo/seq/linear"
+	"github.com/biogo/biogo/seq"
+
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/sequtil"
+	"github.com/kortschak/loopy/tsd"
 )
 
-var in = flag.String("in", "", "specify input gff file (required)")
+var alnmat = tsd.Scores{1, -2, -3}
+
+var (
+	in      = flag.String("in", "", "specify input gff file (required)")
+	gz      = flag.Bool("gzip", false, "gzip compress fasta output")
+	gffOut  = flag.String("gff", "", "write events with TSD annotation to this file if option not empty")
+	thresh  = flag.Int("thresh", 6, "minimum TSD half alignment length (ungapped)")
+	window  = flag.Int("window", 100, "window for TSD search")
+	lWindow = flag.Int("left-window", -1, "half width of the TSD search window around the left breakpoint (defaults to -window/2)")
+	rWindow = flag.Int("right-window", -1, "half width of the TSD search window around the right breakpoint (defaults to -window/2)")
+	matrix  = flag.String("matrix", "", "substitution matrix file overriding -align scores (rows and columns ordered -, a, c, g, t with gaps first)")
+	maxN    = flag.Float64("max-n", 0.5, "maximum fraction of N in either TSD search window")
+	threads = flag.Int("threads", 1, "number of reference sequences to process concurrently")
+	wrap    = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
+)
 
 func main() {
+	flag.Var(&alnmat, "align", "specify the match, mismatch and gap (or gap open and extend) parameters")
 	flag.Parse()
 	if *in == "" {
 		flag.Usage()
@@ -50,36 +86,179 @@ func main() {
 	}
 	f.Close()
 
+	var w *gff.Writer
+	if *gffOut != "" {
+		gf, err := output.Create(*gffOut)
+		if err != nil {
+			log.Fatalf("failed to create gff file %q: %v", *gffOut, err)
+		}
+		defer gf.Close()
+		w = gff.NewWriter(gf, 60, true)
+	}
+
+	lhw := *window / 2
+	if *lWindow >= 0 {
+		lhw = *lWindow
+	}
+	rhw := *window / 2
+	if *rWindow >= 0 {
+		rhw = *rWindow
+	}
+	var sub [][]int
+	if *matrix != "" {
+		sub, err = tsd.ReadMatrix(*matrix)
+		if err != nil {
+			log.Fatalf("failed to read substitution matrix: %v", err)
+		}
+	}
+
+	o := &outputs{gff: w, fasta: output.Stdout(*gz)}
+	reads := make(chan read)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, *threads); i++ {
+		// Each worker has its own aligner since
+		// alignment is not guaranteed to be reentrant.
+		sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, sub)
+		if err != nil {
+			log.Fatalf("failed to make alignment table: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range reads {
+				catch(r.file, r.name, events[r.name], sw, lhw, rhw, o)
+			}
+		}()
+	}
 	for _, ref := range flag.Args() {
-		f, err = os.Open(ref)
+		f, err := faidx.Open(ref, alphabet.DNAgapped)
 		if err != nil {
 			log.Fatalf("failed to open reference %q: %v", ref, err)
 		}
-		ssc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNA)))
-		for ssc.Next() {
-			seq := ssc.Seq().(*linear.Seq)
-			for _, f := range events[seq.Name()] {
-				fields := strings.Fields(f.FeatAttributes.Get("Read"))
-				if len(fields) != 3 {
-					log.Fatalf("bad record: %+v", f)
-				}
-				start, err := strconv.Atoi(fields[1])
-				if err != nil {
-					log.Fatalf("failed to get start coordinate: %v", err)
-				}
-				end, err := strconv.Atoi(fields[2])
+		defer f.Close()
+		for _, name := range f.Names() {
+			if len(events[name]) != 0 {
+				reads <- read{file: f, name: name}
+			}
+		}
+	}
+	close(reads)
+	wg.Wait()
+	err = o.fasta.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
+
+// read is a read sequence in an indexed fasta file.
+type read struct {
+	file *faidx.File
+	name string
+}
+
+// outputs holds the output streams shared by catch workers.
+type outputs struct {
+	mu    sync.Mutex
+	gff   *gff.Writer
+	fasta io.WriteCloser
+}
+
+// catch writes the inserted sequence of each of the events on the named
+// read in ref to o and, if o has a GFF writer, searches for TSDs flanking
+// the events, writing the annotated events to o.
+func catch(ref *faidx.File, name string, events []*gff.Feature, sw align.Aligner, lhw, rhw int, o *outputs) {
+	length, ok := ref.Len(name)
+	if !ok {
+		return
+	}
+	desc, err := ref.Desc(name)
+	if err != nil {
+		log.Fatalf("failed to read description of %q: %v", name, err)
+	}
+	for _, f := range events {
+		fields := strings.Fields(f.FeatAttributes.Get("Read"))
+		if len(fields) != 3 {
+			log.Fatalf("bad record: %+v", f)
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("failed to get start coordinate: %v", err)
+		}
+		end, err := strconv.Atoi(fields[2])
+		if err != nil {
+			log.Fatalf("failed to get end coordinate: %v", err)
+		}
+
+		// Read only the region of the read holding the
+		// insertion and, if needed, the TSD search windows.
+		lo, hi := start, end
+		var win tsd.Window
+		if o.gff != nil {
+			// If we have refined ends, use them.
+			if dup := f.FeatAttributes.Get("Dup"); dup != "" {
+				d, err := strconv.Atoi(dup)
 				if err != nil {
-					log.Fatalf("failed to get end coordinate: %v", err)
+					log.Fatalf("failed to get duplication length: %v", err)
 				}
-				tmp := *seq
-				tmp.ID += fmt.Sprintf("//%d_%d", start, end)
-				tmp.Seq = tmp.Seq[start:end]
-				fmt.Printf("%60a\n", &tmp)
+				win = tsd.DupWindows(start, end, length, d)
+			} else {
+				win = tsd.Windows(start, end, length, lhw, rhw)
 			}
+			l, h := win.Span()
+			lo, hi = min(lo, l), max(hi, h)
+		}
+		s, err := ref.Seq(name, lo, hi, seq.Plus)
+		if err != nil {
+			log.Fatalf("failed to read %q: %v", name, err)
+		}
+
+		tmp := *s
+		tmp.ID += fmt.Sprintf("//%d_%d", start, end)
+		tmp.Desc = desc
+		tmp.Seq = tmp.Seq[start-lo : end-lo]
+		ins := &tmp
+		if f.FeatStrand == seq.Minus {
+			ins = sequtil.RevComp(ins)
+			ins.ID += "(-)"
+			ins.Desc = "(sequence revcomp relative to read)"
+		}
+		o.mu.Lock()
+		err = sequtil.WriteFasta(o.fasta, ins, *wrap)
+		o.mu.Unlock()
+		if err != nil {
+			log.Fatalf("failed to write sequence: %v", err)
+		}
+
+		if o.gff == nil {
+			continue
+		}
+		t, err := tsd.Find(s, win.Shift(-lo), sw, *thresh, *maxN)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if err := ssc.Error(); err != nil {
-			log.Fatalf("error during fasta read: %v", err)
+		if t != nil {
+			t.Window = t.Window.Shift(lo)
+			f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{Tag: "TSD", Value: t.String()})
 		}
-		f.Close()
+		o.mu.Lock()
+		_, err = o.gff.Write(f)
+		o.mu.Unlock()
+		if err != nil {
+			log.Fatalf("failed to write feature: %v", err)
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
 	}
+	return b
 }
@@ -0,0 +1,152 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/internal/sequtil"
+	"github.com/kortschak/loopy/tsd"
+)
+
+const (
+	flankLeft  = "ttgacctagcgatcatgcgtaccgttagcatacgggtcaatcgagtccat"
+	flankRight = "gcatgtcgctacgtagacttcgccatgtacgtctaggatcgcaatgtctg"
+	insertion  = "acgacaacaactgcgatgtttaccggatccttgaggtcta"
+	dup        = "gattacagg"
+)
+
+// reads is a fasta file with sequence lines wrapped at 17 bases.
+var reads = func() string {
+	var buf bytes.Buffer
+	for _, r := range []struct{ header, seq string }{
+		{header: "other", seq: flankRight + flankLeft},
+		{header: "read ccs length=158", seq: flankLeft + dup + insertion + dup + flankRight},
+	} {
+		fmt.Fprintf(&buf, ">%s\n", r.header)
+		for s := r.seq; len(s) != 0; {
+			n := 17
+			if n > len(s) {
+				n = len(s)
+			}
+			fmt.Fprintf(&buf, "%s\n", s[:n])
+			s = s[n:]
+		}
+	}
+	return buf.String()
+}()
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestCatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catch-global")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reads.fa")
+	err = ioutil.WriteFile(path, []byte(reads), 0664)
+	if err != nil {
+		t.Fatalf("failed to write reads: %v", err)
+	}
+	ref, err := faidx.Open(path, alphabet.DNAgapped)
+	if err != nil {
+		t.Fatalf("failed to open reads: %v", err)
+	}
+	defer ref.Close()
+
+	// Read the complete read to compare with
+	// the regions read using the index.
+	var read *linear.Seq
+	sc := seqio.NewScanner(fasta.NewReader(strings.NewReader(reads), linear.NewSeq("", nil, alphabet.DNAgapped)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		if s.Name() == "read" {
+			read = s
+		}
+	}
+	if err := sc.Error(); err != nil {
+		t.Fatalf("failed to read reads: %v", err)
+	}
+
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+	for _, strand := range []seq.Strand{seq.Plus, seq.Minus} {
+		for _, withGFF := range []bool{false, true} {
+			var fastaBuf, gffBuf bytes.Buffer
+			o := &outputs{fasta: nopCloser{&fastaBuf}}
+			if withGFF {
+				o.gff = gff.NewWriter(&gffBuf, 60, false)
+			}
+			f := &gff.Feature{
+				SeqName:        "chr1",
+				Source:         "press-global",
+				Feature:        "insertion",
+				FeatStart:      1000,
+				FeatEnd:        1001,
+				FeatStrand:     strand,
+				FeatFrame:      gff.NoFrame,
+				FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("read %d %d", start, end)}},
+			}
+			catch(ref, "read", []*gff.Feature{f}, sw, 15, 15, o)
+
+			want := *read
+			want.ID += fmt.Sprintf("//%d_%d", start, end)
+			want.Seq = want.Seq[start:end]
+			ins := &want
+			if strand == seq.Minus {
+				ins = sequtil.RevComp(ins)
+				ins.ID += "(-)"
+				ins.Desc = "(sequence revcomp relative to read)"
+			}
+			var wantFasta bytes.Buffer
+			err = sequtil.WriteFasta(&wantFasta, ins, 60)
+			if err != nil {
+				t.Fatalf("failed to write fasta: %v", err)
+			}
+			if fastaBuf.String() != wantFasta.String() {
+				t.Errorf("unexpected fasta output for %v strand event with gff=%t:\ngot:\n%s\nwant:\n%s",
+					strand, withGFF, &fastaBuf, &wantFasta)
+			}
+
+			if !withGFF {
+				continue
+			}
+			attr := f.FeatAttributes.Get("TSD")
+			if attr == "" {
+				t.Fatalf("no TSD found for %v strand event", strand)
+			}
+			fields := strings.Fields(attr)
+			if fields[1] != fmt.Sprint(start) || fields[2] != fmt.Sprint(end) {
+				t.Errorf("unexpected TSD attribute coordinates for %v strand event: got:%s %s want:%d %d",
+					strand, fields[1], fields[2], start, end)
+			}
+			if !strings.Contains(gffBuf.String(), "TSD") {
+				t.Errorf("TSD not written for %v strand event:\n%s", strand, &gffBuf)
+			}
+		}
+	}
+}
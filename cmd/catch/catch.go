@@ -4,61 +4,110 @@
 
 // catch looks for target site duplications flanking reefer event
 // output by press.
+//
+// Events with a TSD are annotated with the TSD alignment in a TSD
+// attribute. The TSD is searched for on the read as sequenced. Only
+// events with a TSD are written. Each event on a read is searched
+// independently, so an event without a TSD does not prevent the search
+// for the remaining events on the same read.
+//
+// With -band greater than zero, the TSD alignment is restricted to a
+// diagonal band, which is much faster for large windows. The band must
+// be wider than the longest expected TSD, and affine gap scoring is not
+// available with a band.
+//
+// TSDs are direct repeats, but for events on the minus strand an inverted
+// duplication, where the right copy is the reverse complement of the left,
+// is also searched for and the better scoring of the two is kept. The TSD
+// attribute of an inverted duplication ends with "inverted". The -inverted
+// flag extends the inverted search to all events or disables it. The kept
+// match is recorded in an Orientation attribute, "forward" for a direct
+// repeat and "inverted" for an inverted duplication.
+//
+// A TSD is accepted when each aligned half has at least -thresh bases
+// other than N and, with -min-identity, when the fraction of alignment
+// columns that are matches is at least the given value. Gap and N columns
+// count against identity, so a long alignment with many mismatches and
+// gaps is rejected even though its halves are long enough.
+//
+// Only the regions of the reads holding the events and their TSD search
+// windows are read, using the fai index of each read fasta file, which is
+// made if it does not exist.
+//
+// With -threads greater than one, reference sequences are processed
+// concurrently and the order of output records is not defined.
+//
+// Alignment scoring does not distinguish soft-masked (lower case) from
+// unmasked bases. With -uppercase, masking is also removed from the
+// sequences as they are read, so that insertion sequences and TSD
+// alignments are written in upper case.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/biogo/biogo/align"
 	"github.com/biogo/biogo/alphabet"
 	"github.com/biogo/biogo/io/featio"
+	"github.com/biogo/biogo/io/featio/bed"
 	"github.com/biogo/biogo/io/featio/gff"
-	"github.com/biogo/biogo/io/seqio"
-	"github.com/biogo/biogo/io/seqio/fasta"
-	"github.com/biogo/biogo/seq/linear"
-)
-
-type mat [3]int
-
-var alnmat = mat{1, -2, -3}
+	"github.com/biogo/biogo/seq"
 
-func (v *mat) Set(s string) error {
-	fields := strings.Split(s, ",")
-	if len(fields) != 3 {
-		return fmt.Errorf("invalid number of fields: %q", s)
-	}
-	var err error
-	for i, f := range fields {
-		v[i], err = strconv.Atoi(f)
-		if err != nil {
-			return fmt.Errorf("invalid fields: %v", err)
-		}
-	}
-	return nil
-}
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/provenance"
+	"github.com/kortschak/loopy/internal/sequtil"
+	"github.com/kortschak/loopy/tsd"
+)
 
-func (v *mat) String() string { return fmt.Sprintf("%d,%d,%d", v[0], v[1], v[2]) }
+var alnmat = tsd.Scores{1, -2, -3}
 
 var (
 	in       = flag.String("in", "", "input gff file (required)")
 	thresh   = flag.Int("thresh", 6, "minimum TSD half alignment length (ungapped)")
+	minIdent = flag.Float64("min-identity", 0, "minimum fraction of TSD alignment columns that are matches")
+	inverted = flag.String("inverted", "minus", `search for inverted TSDs for events on the minus strand ("minus"), all events ("all") or no events ("none")`)
 	window   = flag.Int("window", 100, "window for TSD search")
-	fastaOut = flag.String("fasta-out", "", "write insertions to this file if option not empty")
+	lWindow  = flag.Int("left-window", -1, "half width of the TSD search window around the left breakpoint (defaults to -window/2)")
+	rWindow  = flag.Int("right-window", -1, "half width of the TSD search window around the right breakpoint (defaults to -window/2)")
+	fastaOut = flag.String("fasta-out", "", "write insertions to this file if option not empty (gzip compressed if the name ends in .gz)")
+	matrix   = flag.String("matrix", "", "substitution matrix file overriding -align scores (rows and columns ordered -, a, c, g, t with gaps first)")
+	bedOut   = flag.String("bed", "", "write left and right TSD spans to this BED file if option not empty (gzip compressed if the name ends in .gz)")
+	maxN     = flag.Float64("max-n", 0.5, "maximum fraction of N in either TSD search window")
+	threads  = flag.Int("threads", 1, "number of reference sequences to process concurrently")
+	upper    = flag.Bool("uppercase", false, "convert soft-masked (lower case) sequence to upper case on reading")
+	gz       = flag.Bool("gzip", false, "gzip compress the GFF output")
+	band     = flag.Int("band", 0, "restrict TSD alignment to this distance from the diagonal through the window centres (full alignment if zero)")
+	wrap     = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
 )
 
 func main() {
-	flag.Var(&alnmat, "align", "specify the match, mismatch and gap parameters")
+	flag.Var(&alnmat, "align", "specify the match, mismatch and gap (or gap open and extend) parameters")
 	flag.Parse()
-	if *in == "" {
+	if *in == "" || (*inverted != "minus" && *inverted != "all" && *inverted != "none") {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	stamp, ok, err := provenance.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("failed to read provenance of %q: %v", *in, err)
+	}
+	if !ok {
+		log.Printf("no provenance for %q: cannot verify coordinate system", *in)
+	} else if stamp.Origin != provenance.GFF && stamp.Origin != provenance.ReadOffset {
+		// Only Read attribute coordinates are used, and
+		// these are the same for both known origins.
+		log.Fatalf("unexpected coordinate origin in %q: %d", *in, stamp.Origin)
+	}
+
 	f, err := os.Open(*in)
 	if err != nil {
 		log.Fatalf("failed to open %q: %v", *in, err)
@@ -78,148 +127,232 @@ func main() {
 	}
 	f.Close()
 
-	w := gff.NewWriter(os.Stdout, 60, true)
+	gout := output.Stdout(*gz)
+	w := gff.NewWriter(gout, 60, true)
 	w.WriteComment("Right coordinates (field 5) and strand (field 7) are hypothetical.")
+	if ok {
+		w.WriteComment(stamp.String())
+	}
 
-	var out *os.File
+	var out io.WriteCloser
 	if *fastaOut != "" {
-		out, err = os.Create(*fastaOut)
+		out, err = output.Create(*fastaOut)
 		if err != nil {
 			log.Fatalf("failed to create fasta insertion output file %q: %v", *fastaOut, err)
 		}
 		defer out.Close()
 	}
 
-	hw := *window / 2
-	sw := makeTable(alphabet.DNAgapped, alnmat)
+	var bw *bed.Writer
+	if *bedOut != "" {
+		bf, err := output.Create(*bedOut)
+		if err != nil {
+			log.Fatalf("failed to create BED output file %q: %v", *bedOut, err)
+		}
+		defer bf.Close()
+		bw, err = bed.NewWriter(bf, 5)
+		if err != nil {
+			log.Fatalf("failed to create BED writer: %v", err)
+		}
+	}
+
+	lhw := *window / 2
+	if *lWindow >= 0 {
+		lhw = *lWindow
+	}
+	rhw := *window / 2
+	if *rWindow >= 0 {
+		rhw = *rWindow
+	}
+	var sub [][]int
+	if *matrix != "" {
+		sub, err = tsd.ReadMatrix(*matrix)
+		if err != nil {
+			log.Fatalf("failed to read substitution matrix: %v", err)
+		}
+	}
+	o := &outputs{gff: w, fasta: out, bed: bw}
+	reads := make(chan read)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, *threads); i++ {
+		// Each worker has its own aligner since
+		// alignment is not guaranteed to be reentrant.
+		var sw align.Aligner
+		if *band > 0 {
+			sw, err = tsd.NewBandedAligner(alphabet.DNAgapped, alnmat, sub, *band)
+		} else {
+			sw, err = tsd.NewAligner(alphabet.DNAgapped, alnmat, sub)
+		}
+		if err != nil {
+			log.Fatalf("failed to make alignment table: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range reads {
+				catch(r.file, r.name, events[r.name], sw, lhw, rhw, o)
+			}
+		}()
+	}
 	for _, ref := range flag.Args() {
-		f, err = os.Open(ref)
+		f, err := faidx.Open(ref, alphabet.DNAgapped)
 		if err != nil {
 			log.Fatalf("failed to open reference %q: %v", ref, err)
 		}
-		ssc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
-	loop:
-		for ssc.Next() {
-			seq := ssc.Seq().(*linear.Seq)
-			for _, f := range events[seq.Name()] {
-				fields := strings.Fields(f.FeatAttributes.Get("Read"))
-				if len(fields) != 3 {
-					log.Fatalf("bad record: %+v", f)
-				}
-				start, err := strconv.Atoi(fields[1])
-				if err != nil {
-					log.Fatalf("failed to get start coordinate: %v", err)
-				}
-				end, err := strconv.Atoi(fields[2])
-				if err != nil {
-					log.Fatalf("failed to get end coordinate: %v", err)
-				}
+		defer f.Close()
+		for _, name := range f.Names() {
+			if len(events[name]) != 0 {
+				reads <- read{file: f, name: name}
+			}
+		}
+	}
+	close(reads)
+	wg.Wait()
 
-				if out != nil {
-					insert := *seq
-					if insert.Desc != "" {
-						insert.Desc += " "
-					}
-					insert.Desc += fmt.Sprintf("[%d,%d)", start, end)
-					insert.Seq = insert.Seq[start:end]
-					fmt.Fprintf(out, "%60a\n", &insert)
-				}
+	err = gout.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
 
-				var lOff, lEnd, rOff, rEnd int
-				// If we have refined ends, use them.
-				if dup := f.FeatAttributes.Get("Dup"); dup != "" {
-					d, err := strconv.Atoi(dup)
-					if err != nil {
-						log.Fatalf("failed to get duplication length: %v", err)
-					}
-					lOff = max(0, start-d)
-					lEnd = start
-					rOff = end
-					rEnd = min(len(seq.Seq), end+d)
-				} else {
-					lOff = max(0, start-hw)
-					lEnd = min(len(seq.Seq), start+hw)
-					rOff = max(0, end-hw)
-					rEnd = min(len(seq.Seq), end+hw)
-
-					// Ensure windows don't overlap.
-					if lEnd > rOff {
-						lEnd = (lEnd + rOff) / 2
-						rOff = lEnd
-					}
-				}
+// read is a read sequence in an indexed fasta file.
+type read struct {
+	file *faidx.File
+	name string
+}
 
-				if lEnd-lOff < *thresh || rEnd-rOff < *thresh {
-					// Don't do fruitless work.
-					continue loop
-				}
+// outputs holds the output streams shared by catch workers.
+type outputs struct {
+	mu    sync.Mutex
+	gff   *gff.Writer
+	fasta io.Writer
+	bed   *bed.Writer
+}
 
-				left := *seq
-				left.ID = "prefix"
-				left.Seq = left.Seq[lOff:lEnd]
-				right := *seq
-				right.ID = "postfix"
-				right.Seq = right.Seq[rOff:rEnd]
+// catch searches for TSDs flanking each of the events on the named read
+// in ref, writing annotated events and their insertions and TSD spans to o.
+func catch(ref *faidx.File, name string, events []*gff.Feature, sw align.Aligner, lhw, rhw int, o *outputs) {
+	length, ok := ref.Len(name)
+	if !ok {
+		return
+	}
+	desc, err := ref.Desc(name)
+	if err != nil {
+		log.Fatalf("failed to read description of %q: %v", name, err)
+	}
+	for _, f := range events {
+		fields := strings.Fields(f.FeatAttributes.Get("Read"))
+		if len(fields) != 3 {
+			log.Fatalf("bad record: %+v", f)
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("failed to get start coordinate: %v", err)
+		}
+		end, err := strconv.Atoi(fields[2])
+		if err != nil {
+			log.Fatalf("failed to get end coordinate: %v", err)
+		}
 
-				aln, err := sw.Align(&right, &left)
-				if err != nil {
-					log.Fatal(err)
-				}
+		var win tsd.Window
+		// If we have refined ends, use them.
+		if dup := f.FeatAttributes.Get("Dup"); dup != "" {
+			d, err := strconv.Atoi(dup)
+			if err != nil {
+				log.Fatalf("failed to get duplication length: %v", err)
+			}
+			win = tsd.DupWindows(start, end, length, d)
+		} else {
+			win = tsd.Windows(start, end, length, lhw, rhw)
+		}
 
-				fa := align.Format(&right, &left, aln, '-')
-				for _, seg := range fa {
-					var n int
-					for _, l := range seg.(alphabet.Letters) {
-						if l != '-' {
-							n++
-						}
-					}
-					if n < *thresh {
-						continue loop
-					}
-				}
+		// Read only the region of the read holding
+		// the insertion and the TSD search windows.
+		lo, hi := win.Span()
+		lo, hi = min(lo, start), max(hi, end)
+		s, err := ref.Seq(name, lo, hi, seq.Plus)
+		if err != nil {
+			log.Fatalf("failed to read %q: %v", name, err)
+		}
+		if *upper {
+			sequtil.Upper(s.Seq, s.Alpha)
+		}
 
-				var sc int
-				for _, seg := range aln {
-					type scorer interface {
-						Score() int
-					}
-					sc += seg.(scorer).Score()
-				}
-				f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{
-					Tag: "TSD", Value: fmt.Sprintf(`%v %d %d %v "%v" %d`,
-						fa[0], aln[len(aln)-1].Features()[0].End()+lOff,
-						aln[0].Features()[1].Start()+rOff, fa[1],
-						aln, sc),
-				})
-				w.Write(f)
+		if o.fasta != nil {
+			insert := *s
+			insert.Desc = desc
+			if insert.Desc != "" {
+				insert.Desc += " "
 			}
+			insert.Desc += fmt.Sprintf("[%d,%d)", start, end)
+			insert.Seq = insert.Seq[start-lo : end-lo]
+			o.mu.Lock()
+			sequtil.WriteFasta(o.fasta, &insert, *wrap)
+			o.mu.Unlock()
+		}
+
+		t, err := tsd.Find(s, win.Shift(-lo), sw, *thresh, *maxN)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if err := ssc.Error(); err != nil {
-			log.Fatalf("error during fasta read: %v", err)
+		if t != nil && t.Identity < *minIdent {
+			t = nil
 		}
-		f.Close()
-	}
-}
+		if searchInverted(f) {
+			it, err := tsd.FindInverted(s, win.Shift(-lo), sw, *thresh, *maxN)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if it != nil && it.Identity >= *minIdent && (t == nil || it.Score > t.Score) {
+				t = it
+			}
+		}
+		if t == nil {
+			continue
+		}
+		t.Window = t.Window.Shift(lo)
+		f.FeatAttributes = append(f.FeatAttributes,
+			gff.Attribute{Tag: "TSD", Value: t.String()},
+			gff.Attribute{Tag: "Orientation", Value: t.Orientation()},
+		)
 
-func makeTable(alpha alphabet.Alphabet, alnmat mat) align.SW {
-	match := alnmat[0]
-	mismatch := alnmat[1]
-	gap := alnmat[2]
-	sw := make(align.SW, alpha.Len())
-	for i := range sw {
-		row := make([]int, alpha.Len())
-		for j := range row {
-			row[j] = mismatch
+		o.mu.Lock()
+		_, err = o.gff.Write(f)
+		if err != nil {
+			log.Fatalf("failed to write feature: %v", err)
 		}
-		row[i] = match
-		sw[i] = row
-	}
-	for i := range sw {
-		sw[0][i] = gap
-		sw[i][0] = gap
+		if o.bed != nil {
+			l, r := t.Spans()
+			for _, b := range []*bed.Bed5{
+				{
+					Chrom:      name,
+					ChromStart: l[0],
+					ChromEnd:   l[1],
+					FeatName:   fields[0] + "/left",
+					FeatScore:  t.Score,
+				},
+				{
+					Chrom:      name,
+					ChromStart: r[0],
+					ChromEnd:   r[1],
+					FeatName:   fields[0] + "/right",
+					FeatScore:  t.Score,
+				},
+			} {
+				_, err = o.bed.Write(b)
+				if err != nil {
+					log.Fatalf("failed to write BED record: %v", err)
+				}
+			}
+		}
+		o.mu.Unlock()
 	}
-	return sw
+}
+
+// searchInverted returns whether an inverted TSD should be searched for
+// for the event f.
+func searchInverted(f *gff.Feature) bool {
+	return *inverted == "all" || (*inverted == "minus" && f.FeatStrand == seq.Minus)
 }
 
 func min(a, b int) int {
@@ -0,0 +1,488 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/featio/bed"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/seq"
+
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/tsd"
+)
+
+const (
+	flankLeft  = "ttgacctagcgatcatgcgtaccgttagcatacgggtcaatcgagtccat"
+	flankRight = "gcatgtcgctacgtagacttcgccatgtacgtctaggatcgcaatgtctg"
+	insertion  = "acgacaacaactgcgatgtttaccggatccttgaggtcta"
+	dup        = "gattacagg"
+)
+
+// readFile writes reads, given as name, description and sequence, to a
+// fasta file in dir with sequence lines wrapped at 17 bases and returns
+// the opened file.
+func readFile(t *testing.T, dir string, reads ...[3]string) *faidx.File {
+	var buf bytes.Buffer
+	for _, r := range reads {
+		fmt.Fprintf(&buf, ">%s", r[0])
+		if r[1] != "" {
+			fmt.Fprintf(&buf, " %s", r[1])
+		}
+		buf.WriteByte('\n')
+		for s := r[2]; len(s) != 0; {
+			n := 17
+			if n > len(s) {
+				n = len(s)
+			}
+			fmt.Fprintf(&buf, "%s\n", s[:n])
+			s = s[n:]
+		}
+	}
+	path := filepath.Join(dir, "reads.fa")
+	err := ioutil.WriteFile(path, buf.Bytes(), 0664)
+	if err != nil {
+		t.Fatalf("failed to write reads: %v", err)
+	}
+	f, err := faidx.Open(path, alphabet.DNAgapped)
+	if err != nil {
+		t.Fatalf("failed to open reads: %v", err)
+	}
+	return f
+}
+
+func TestCatch(t *testing.T) {
+	read := flankLeft + dup + insertion + dup + flankRight
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+	dir, err := ioutil.TempDir("", "catch")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ref := readFile(t, dir,
+		[3]string{"other", "", flankRight + flankLeft},
+		[3]string{"read", "ccs length=158", read},
+	)
+	defer ref.Close()
+
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+
+	for _, strand := range []seq.Strand{seq.Plus, seq.Minus} {
+		var gffBuf, bedBuf bytes.Buffer
+		bw, err := bed.NewWriter(&bedBuf, 5)
+		if err != nil {
+			t.Fatalf("failed to make BED writer: %v", err)
+		}
+		var fastaBuf bytes.Buffer
+		o := &outputs{gff: gff.NewWriter(&gffBuf, 60, false), fasta: &fastaBuf, bed: bw}
+		f := &gff.Feature{
+			SeqName:        "chr1",
+			Source:         "press",
+			Feature:        "insertion",
+			FeatStart:      1000,
+			FeatEnd:        1001,
+			FeatStrand:     strand,
+			FeatFrame:      gff.NoFrame,
+			FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("read %d %d", start, end)}},
+		}
+		catch(ref, "read", []*gff.Feature{f}, sw, 15, 15, o)
+
+		// The insertion is written as read, regardless of strand.
+		wantFasta := fmt.Sprintf(">read ccs length=158 [%d,%d)\n%s\n", start, end, read[start:end])
+		if fastaBuf.String() != wantFasta {
+			t.Errorf("unexpected fasta output for %v strand event:\ngot:\n%s\nwant:\n%s", strand, &fastaBuf, wantFasta)
+		}
+
+		if o := f.FeatAttributes.Get("Orientation"); o != "forward" {
+			t.Errorf("unexpected Orientation attribute for %v strand event: got:%q want:forward", strand, o)
+		}
+		attr := f.FeatAttributes.Get("TSD")
+		if attr == "" {
+			t.Fatalf("no TSD found for %v strand event", strand)
+		}
+		if !strings.Contains(gffBuf.String(), "TSD") {
+			t.Errorf("TSD not written for %v strand event:\n%s", strand, &gffBuf)
+		}
+
+		// The TSD attribute holds the end of the left copy
+		// and the start of the right copy.
+		fields := strings.Fields(attr)
+		wantBED := fmt.Sprintf("read\t%d\t%s\tread/left\t%s\nread\t%s\t%d\tread/right\t%s\n",
+			len(flankLeft), fields[1], fields[len(fields)-1],
+			fields[2], end+len(dup), fields[len(fields)-1])
+		if bedBuf.String() != wantBED {
+			t.Errorf("unexpected BED output for %v strand event:\ngot:\n%s\nwant:\n%s", strand, &bedBuf, wantBED)
+		}
+		if fields[1] != fmt.Sprint(start) || fields[2] != fmt.Sprint(end) {
+			t.Errorf("unexpected TSD attribute coordinates for %v strand event: got:%s %s want:%d %d",
+				strand, fields[1], fields[2], start, end)
+		}
+	}
+}
+
+func TestCatchEvents(t *testing.T) {
+	// The first event has a refined duplication length
+	// of zero, so has empty TSD search windows and no
+	// TSD. The second event is the constructed insertion.
+	read := flankLeft + dup + insertion + dup + flankRight
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+	dir, err := ioutil.TempDir("", "catch")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ref := readFile(t, dir, [3]string{"read", "", read})
+	defer ref.Close()
+
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+
+	var gffBuf bytes.Buffer
+	o := &outputs{gff: gff.NewWriter(&gffBuf, 60, false)}
+	events := []*gff.Feature{
+		{
+			SeqName:    "chr1",
+			Source:     "press",
+			Feature:    "insertion",
+			FeatStart:  500,
+			FeatEnd:    501,
+			FeatStrand: seq.Plus,
+			FeatFrame:  gff.NoFrame,
+			FeatAttributes: gff.Attributes{
+				{Tag: "Read", Value: fmt.Sprintf("read %d %d", start, end)},
+				{Tag: "Dup", Value: "0"},
+			},
+		},
+		{
+			SeqName:        "chr1",
+			Source:         "press",
+			Feature:        "insertion",
+			FeatStart:      1000,
+			FeatEnd:        1001,
+			FeatStrand:     seq.Plus,
+			FeatFrame:      gff.NoFrame,
+			FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("read %d %d", start, end)}},
+		},
+	}
+	catch(ref, "read", events, sw, 15, 15, o)
+
+	if tsd := events[0].FeatAttributes.Get("TSD"); tsd != "" {
+		t.Errorf("unexpected TSD for event with zero length duplication: %q", tsd)
+	}
+	if events[1].FeatAttributes.Get("TSD") == "" {
+		t.Errorf("no TSD found for event following an event without a TSD")
+	}
+	// Only the event with a TSD is written.
+	lines := strings.Split(strings.TrimSpace(gffBuf.String()), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "chr1\tpress\tinsertion\t1001\t") {
+		t.Errorf("unexpected GFF output:\n%s", &gffBuf)
+	}
+}
+
+func TestCatchConcurrent(t *testing.T) {
+	// Each read holds the constructed insertion
+	// and is searched by one of several workers
+	// sharing the outputs, as with -threads.
+	const n = 16
+	read := flankLeft + dup + insertion + dup + flankRight
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+	dir, err := ioutil.TempDir("", "catch")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	var reads [][3]string
+	events := make(map[string][]*gff.Feature)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("read%d", i)
+		reads = append(reads, [3]string{name, "", read})
+		events[name] = []*gff.Feature{{
+			SeqName:        "chr1",
+			Source:         "press",
+			Feature:        "insertion",
+			FeatStart:      1000 * i,
+			FeatEnd:        1000*i + 1,
+			FeatStrand:     seq.Plus,
+			FeatFrame:      gff.NoFrame,
+			FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("%s %d %d", name, start, end)}},
+		}}
+	}
+	ref := readFile(t, dir, reads...)
+	defer ref.Close()
+
+	var gffBuf, fastaBuf, bedBuf bytes.Buffer
+	bw, err := bed.NewWriter(&bedBuf, 5)
+	if err != nil {
+		t.Fatalf("failed to make BED writer: %v", err)
+	}
+	o := &outputs{gff: gff.NewWriter(&gffBuf, 60, false), fasta: &fastaBuf, bed: bw}
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+		if err != nil {
+			t.Fatalf("failed to make aligner: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				catch(ref, name, events[name], sw, 15, 15, o)
+			}
+		}()
+	}
+	for _, r := range reads {
+		work <- r[0]
+	}
+	close(work)
+	wg.Wait()
+
+	// Every event is written once with its TSD,
+	// and records are not interleaved.
+	gffLines := strings.Split(strings.TrimSpace(gffBuf.String()), "\n")
+	if len(gffLines) != n {
+		t.Errorf("unexpected number of GFF records: got:%d want:%d\n%s", len(gffLines), n, &gffBuf)
+	}
+	for _, l := range gffLines {
+		if len(strings.Split(l, "\t")) != 9 || !strings.Contains(l, "TSD") {
+			t.Errorf("unexpected GFF record: %q", l)
+		}
+	}
+	if got := strings.Count(fastaBuf.String(), ">"); got != n {
+		t.Errorf("unexpected number of fasta records: got:%d want:%d", got, n)
+	}
+	bedLines := strings.Split(strings.TrimSpace(bedBuf.String()), "\n")
+	if len(bedLines) != 2*n {
+		t.Fatalf("unexpected number of BED records: got:%d want:%d\n%s", len(bedLines), 2*n, &bedBuf)
+	}
+	// The left and right spans of each event are adjacent.
+	for i := 0; i < len(bedLines); i += 2 {
+		l := strings.Fields(bedLines[i])
+		r := strings.Fields(bedLines[i+1])
+		if l[0] != r[0] || l[3] != l[0]+"/left" || r[3] != r[0]+"/right" {
+			t.Errorf("unexpected BED record pair:\n%s\n%s", bedLines[i], bedLines[i+1])
+		}
+	}
+}
+
+func TestCatchUppercase(t *testing.T) {
+	defer func(u bool) { *upper = u }(*upper)
+
+	// The left copy of the duplication and the
+	// start of the insertion are soft-masked.
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+	read := strings.ToUpper(flankLeft) + dup + insertion[:10] + strings.ToUpper(insertion[10:]+dup+flankRight)
+	dir, err := ioutil.TempDir("", "catch")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ref := readFile(t, dir, [3]string{"read", "", read})
+	defer ref.Close()
+
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+	for _, test := range []struct {
+		upper     bool
+		wantFasta string
+	}{
+		{upper: false, wantFasta: read[start:end]},
+		{upper: true, wantFasta: strings.ToUpper(read[start:end])},
+	} {
+		*upper = test.upper
+		var gffBuf, fastaBuf bytes.Buffer
+		o := &outputs{gff: gff.NewWriter(&gffBuf, 60, false), fasta: &fastaBuf}
+		f := &gff.Feature{
+			SeqName:        "chr1",
+			Source:         "press",
+			Feature:        "insertion",
+			FeatStart:      1000,
+			FeatEnd:        1001,
+			FeatStrand:     seq.Plus,
+			FeatFrame:      gff.NoFrame,
+			FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("read %d %d", start, end)}},
+		}
+		catch(ref, "read", []*gff.Feature{f}, sw, 15, 15, o)
+
+		wantFasta := fmt.Sprintf(">read [%d,%d)\n%s\n", start, end, test.wantFasta)
+		if fastaBuf.String() != wantFasta {
+			t.Errorf("unexpected fasta output with uppercase=%t:\ngot:\n%s\nwant:\n%s", test.upper, &fastaBuf, wantFasta)
+		}
+
+		// The masked left copy aligns to the unmasked
+		// right copy in both cases.
+		fields := strings.Fields(f.FeatAttributes.Get("TSD"))
+		if len(fields) == 0 {
+			t.Fatalf("no TSD found with uppercase=%t", test.upper)
+		}
+		if fields[1] != fmt.Sprint(start) || fields[2] != fmt.Sprint(end) {
+			t.Errorf("unexpected TSD attribute coordinates with uppercase=%t: got:%s %s want:%d %d",
+				test.upper, fields[1], fields[2], start, end)
+		}
+		wantLeft := strings.ToUpper(dup)
+		if !test.upper {
+			wantLeft = dup
+		}
+		if !strings.HasSuffix(fields[3], wantLeft) {
+			t.Errorf("unexpected left copy case with uppercase=%t: got:%s want suffix:%s", test.upper, fields[3], wantLeft)
+		}
+	}
+}
+
+func TestCatchMinIdentity(t *testing.T) {
+	defer func(m float64) { *minIdent = m }(*minIdent)
+
+	dir, err := ioutil.TempDir("", "catch")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// The mismatched read has a TSD with one
+	// mismatch in nine columns.
+	ref := readFile(t, dir,
+		[3]string{"exact", "", flankLeft + dup + insertion + dup + flankRight},
+		[3]string{"mismatched", "", flankLeft + dup + insertion + "gatcacagg" + flankRight},
+	)
+	defer ref.Close()
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+	for _, test := range []struct {
+		read     string
+		minIdent float64
+		want     bool
+	}{
+		{read: "exact", minIdent: 0, want: true},
+		{read: "exact", minIdent: 1, want: true},
+		{read: "mismatched", minIdent: 0, want: true},
+		{read: "mismatched", minIdent: 0.8, want: true},
+		{read: "mismatched", minIdent: 0.9, want: false},
+	} {
+		*minIdent = test.minIdent
+		var buf bytes.Buffer
+		o := &outputs{gff: gff.NewWriter(&buf, 60, false)}
+		f := &gff.Feature{
+			SeqName:        "chr1",
+			Source:         "press",
+			Feature:        "insertion",
+			FeatStart:      1000,
+			FeatEnd:        1001,
+			FeatStrand:     seq.Plus,
+			FeatFrame:      gff.NoFrame,
+			FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("%s %d %d", test.read, start, end)}},
+		}
+		catch(ref, test.read, []*gff.Feature{f}, sw, 15, 15, o)
+		got := f.FeatAttributes.Get("TSD") != ""
+		if got != test.want {
+			t.Errorf("unexpected TSD result for %s with min-identity=%v: got:%t want:%t",
+				test.read, test.minIdent, got, test.want)
+		}
+		if written := buf.Len() != 0; written != test.want {
+			t.Errorf("unexpected output for %s with min-identity=%v: got:%q", test.read, test.minIdent, &buf)
+		}
+	}
+}
+
+func TestCatchInverted(t *testing.T) {
+	defer func(i string) { *inverted = i }(*inverted)
+
+	// The right copy of the duplication is
+	// the reverse complement of the left.
+	const invDup = "cctgtaatc"
+	dir, err := ioutil.TempDir("", "catch")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ref := readFile(t, dir, [3]string{"read", "", flankLeft + dup + insertion + invDup + flankRight})
+	defer ref.Close()
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, alnmat, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+	for _, test := range []struct {
+		mode   string
+		strand seq.Strand
+		want   bool
+	}{
+		{mode: "minus", strand: seq.Minus, want: true},
+		{mode: "minus", strand: seq.Plus, want: false},
+		{mode: "all", strand: seq.Plus, want: true},
+		{mode: "all", strand: seq.Minus, want: true},
+		{mode: "none", strand: seq.Minus, want: false},
+	} {
+		*inverted = test.mode
+		var gffBuf, bedBuf bytes.Buffer
+		bw, err := bed.NewWriter(&bedBuf, 5)
+		if err != nil {
+			t.Fatalf("failed to make BED writer: %v", err)
+		}
+		o := &outputs{gff: gff.NewWriter(&gffBuf, 60, false), bed: bw}
+		f := &gff.Feature{
+			SeqName:        "chr1",
+			Source:         "press",
+			Feature:        "insertion",
+			FeatStart:      1000,
+			FeatEnd:        1001,
+			FeatStrand:     test.strand,
+			FeatFrame:      gff.NoFrame,
+			FeatAttributes: gff.Attributes{{Tag: "Read", Value: fmt.Sprintf("read %d %d", start, end)}},
+		}
+		catch(ref, "read", []*gff.Feature{f}, sw, 15, 15, o)
+
+		attr := f.FeatAttributes.Get("TSD")
+		if got := attr != ""; got != test.want {
+			t.Errorf("unexpected TSD result for %v strand event with -inverted=%s: got:%q want found:%t",
+				test.strand, test.mode, attr, test.want)
+		}
+		if !test.want {
+			continue
+		}
+		if !strings.HasSuffix(attr, " inverted") {
+			t.Errorf("TSD not marked inverted for %v strand event with -inverted=%s: %q", test.strand, test.mode, attr)
+			continue
+		}
+		if o := f.FeatAttributes.Get("Orientation"); o != "inverted" {
+			t.Errorf("unexpected Orientation attribute for %v strand event with -inverted=%s: got:%q want:inverted",
+				test.strand, test.mode, o)
+		}
+		// The BED spans are the constructed copies.
+		fields := strings.Fields(attr)
+		score := fields[len(fields)-2]
+		wantBED := fmt.Sprintf("read\t%d\t%d\tread/left\t%s\nread\t%d\t%d\tread/right\t%s\n",
+			len(flankLeft), start, score, end, end+len(invDup), score)
+		if bedBuf.String() != wantBED {
+			t.Errorf("unexpected BED output for %v strand event with -inverted=%s:\ngot:\n%s\nwant:\n%s",
+				test.strand, test.mode, &bedBuf, wantBED)
+		}
+	}
+}
@@ -8,11 +8,14 @@
 //
 // uniquely - not CCS reads
 // non-uniqu - CCS reads
+//
+// Read names must follow the PacBio read naming convention of
+// movie/hole/start_end for subreads or movie/hole/ccs for CCS reads.
+// Reads are grouped by their movie and hole number.
 package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -21,10 +24,14 @@ import (
 
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/internal/ccs"
+	"github.com/kortschak/loopy/internal/readname"
 )
 
 var (
-	in = flag.String("in", "", "specify input gff file (required)")
+	in        = flag.String("in", "", "specify input gff file (required)")
+	withCoord = flag.Bool("with-coord", false, "include the coordinate of unique reads in the unique list")
 )
 
 func main() {
@@ -49,43 +56,33 @@ func main() {
 		if read == "" {
 			continue
 		}
-		read = strings.Fields(read)[0]
-		idx := strings.LastIndex(read, "/")
-		e, ok := names[read[:idx]]
+		n, err := readname.Parse(strings.Fields(read)[0])
+		if err != nil {
+			log.Fatalf("failed to parse read name: %v", err)
+		}
+		e, ok := names[n.ZMW()]
 		if !ok {
 			e = make(map[string]struct{})
-			names[read[:idx]] = e
+			names[n.ZMW()] = e
 		}
-		e[read[idx+1:]] = struct{}{}
+		e[n.Sub] = struct{}{}
 	}
 	if err := sc.Error(); err != nil {
 		log.Fatalf("error during fasta read: %v", err)
 	}
 	f.Close()
 
-	base := filepath.Base(*in)
-	unique, err := os.Create(base + ".unique.text")
-	if err != nil {
-		log.Fatalf("failed to create %q: %v", base+".unique.text", err)
-	}
-	defer unique.Close()
-	nonUnique, err := os.Create(base + ".non-unique.text")
-	if err != nil {
-		log.Fatalf("failed to create %q: %v", base+".non-unique.text", err)
-	}
-	defer nonUnique.Close()
+	lists := make(map[string][]string, len(names))
 	for name, coords := range names {
-		switch len(coords) {
-		case 0:
-		case 1:
-			fmt.Fprintln(unique, name)
-		default:
-			s := make([]string, 0, len(coords))
-			for c := range coords {
-				s = append(s, c)
-			}
-			sort.Strings(s)
-			fmt.Fprintf(nonUnique, "%s\t%v\n", name, s)
+		s := make([]string, 0, len(coords))
+		for c := range coords {
+			s = append(s, c)
 		}
+		sort.Strings(s)
+		lists[name] = s
+	}
+	err = ccs.WriteLists(filepath.Base(*in), lists, *withCoord)
+	if err != nil {
+		log.Fatalf("failed to write lists: %v", err)
 	}
 }
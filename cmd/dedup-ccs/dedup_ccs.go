@@ -7,6 +7,11 @@
 //
 // uniquely - not CCS reads
 // non-uniqu - CCS reads
+//
+// By default reads are grouped by ZMW, and read names must be PacBio
+// subread or CCS names. With -depth, reads are grouped by the given number
+// of leading name components: 1 groups by movie, 2 by ZMW and 3 by complete
+// subread name, identifying reads that appear more than once.
 package main
 
 import (
@@ -15,21 +20,25 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/biogo/biogo/alphabet"
 	"github.com/biogo/biogo/io/seqio"
 	"github.com/biogo/biogo/io/seqio/fasta"
 	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/ccs"
+	"github.com/kortschak/loopy/internal/readname"
 )
 
 var (
-	in = flag.String("in", "", "specify input fasta file (required)")
+	in        = flag.String("in", "", "specify input fasta file (required)")
+	withCoord = flag.Bool("with-coord", false, "include the coordinate of unique reads in the unique list")
+	depth     = flag.Int("depth", 0, "number of leading read name components used to group reads (group by all but the last component if zero)")
 )
 
 func main() {
 	flag.Parse()
-	if *in == "" {
+	if *in == "" || *depth < 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -45,32 +54,38 @@ func main() {
 	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
 	for sc.Next() {
 		seq := sc.Seq().(*linear.Seq)
-		idx := strings.LastIndex(seq.ID, "/")
-		names[seq.ID[:idx]] = append(names[seq.ID[:idx]], seq.ID[idx+1:])
+		zmw, sub, err := group(seq.ID, *depth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names[zmw] = append(names[zmw], sub)
 	}
 	if err := sc.Error(); err != nil {
 		log.Fatalf("error during fasta read: %v", err)
 	}
 	f.Close()
 
-	base := filepath.Base(*in)
-	unique, err := os.Create(base + ".unique.text")
-	if err != nil {
-		log.Fatalf("failed to create %q: %v", base+".unique.text", err)
-	}
-	defer unique.Close()
-	nonUnique, err := os.Create(base + ".non-unique.text")
+	err = ccs.WriteLists(filepath.Base(*in), names, *withCoord)
 	if err != nil {
-		log.Fatalf("failed to create %q: %v", base+".non-unique.text", err)
+		log.Fatalf("failed to write lists: %v", err)
 	}
-	defer nonUnique.Close()
-	for name, coords := range names {
-		switch len(coords) {
-		case 0:
-		case 1:
-			fmt.Fprintln(unique, name)
-		default:
-			fmt.Fprintf(nonUnique, "%s\t%v\n", name, coords)
+}
+
+// group returns the grouping prefix of the read name and the remainder
+// of the name. If depth is zero, the prefix is the ZMW of the read and
+// the remainder is its subread range or ccs suffix, otherwise the name
+// is split after its first depth components.
+func group(name string, depth int) (prefix, rest string, err error) {
+	if depth == 0 {
+		n, err := readname.Parse(name)
+		if err != nil {
+			return "", "", err
 		}
+		return n.ZMW(), n.Sub, nil
+	}
+	prefix, rest, ok := readname.SplitAt(name, depth)
+	if !ok {
+		return "", "", fmt.Errorf("invalid read name: %q", name)
 	}
+	return prefix, rest, nil
 }
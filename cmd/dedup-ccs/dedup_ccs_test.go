@@ -0,0 +1,115 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// oldGroup is the depth zero grouping of read names before read
+// names were parsed by the readname package.
+func oldGroup(name string) (zmw, sub string) {
+	idx := strings.LastIndex(name, "/")
+	return name[:idx], name[idx+1:]
+}
+
+func TestGroup(t *testing.T) {
+	for _, name := range []string{
+		"m160101_000000_42156_c1/10/0_300",
+		"m160101_000000_42156_c1/10/350_600",
+		"m1/4096/1200_5300",
+		"m1/10/ccs",
+	} {
+		zmw, sub, err := group(name, 0)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", name, err)
+			continue
+		}
+		oldZMW, oldSub := oldGroup(name)
+		if zmw != oldZMW || sub != oldSub {
+			t.Errorf("group differs from old grouping for %q: got:%q %q old:%q %q", name, zmw, sub, oldZMW, oldSub)
+		}
+	}
+
+	// Names that are not PacBio read names are errors at depth zero.
+	for _, name := range []string{
+		"read",
+		"m1/0_300",
+		"m1/10/0_300/extra",
+		"m1/hole/0_300",
+		"m1/10/0-300",
+	} {
+		_, _, err := group(name, 0)
+		if err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
+
+func TestGroupDepth(t *testing.T) {
+	const (
+		sequel = "m54006_160504_020705"
+		rs     = "m160101_000000_42156_c100923082550000001823210305251145_s1_p0"
+	)
+	names := []string{
+		sequel + "/4194368/0_10387",
+		sequel + "/4194368/10433_20011",
+		sequel + "/4194368/ccs",
+		sequel + "/4260003/ccs",
+		rs + "/10/350_600",
+	}
+	byZMW := map[string][]string{
+		sequel + "/4194368": {"0_10387", "10433_20011", "ccs"},
+		sequel + "/4260003": {"ccs"},
+		rs + "/10":          {"350_600"},
+	}
+	for _, test := range []struct {
+		depth int
+		want  map[string][]string
+	}{
+		{depth: 0, want: byZMW},
+		{
+			depth: 1,
+			want: map[string][]string{
+				sequel: {"4194368/0_10387", "4194368/10433_20011", "4194368/ccs", "4260003/ccs"},
+				rs:     {"10/350_600"},
+			},
+		},
+		{depth: 2, want: byZMW},
+		{
+			depth: 3,
+			want: map[string][]string{
+				names[0]: {""},
+				names[1]: {""},
+				names[2]: {""},
+				names[3]: {""},
+				names[4]: {""},
+			},
+		},
+	} {
+		got := make(map[string][]string)
+		for _, name := range names {
+			prefix, rest, err := group(name, test.depth)
+			if err != nil {
+				t.Errorf("unexpected error for %q at depth %d: %v", name, test.depth, err)
+				continue
+			}
+			got[prefix] = append(got[prefix], rest)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected grouping at depth %d:\ngot: %v\nwant:%v", test.depth, got, test.want)
+		}
+	}
+
+	// PacBio names have only three components.
+	for _, name := range names {
+		_, _, err := group(name, 4)
+		if err == nil {
+			t.Errorf("expected error for %q at depth 4", name)
+		}
+	}
+}
@@ -6,7 +6,10 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -14,37 +17,61 @@ import (
 
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/internal/output"
 )
 
-var thresh = flag.Int("thresh", 0, "specify minimum element length")
+var (
+	thresh = flag.Int("thresh", 0, "specify minimum element length")
+	gz     = flag.Bool("gzip", false, "gzip compress the GFF output")
+)
 
 func main() {
 	flag.Parse()
 
-	w := gff.NewWriter(os.Stdout, 60, false)
-	sc := featio.NewScanner(gff.NewReader(os.Stdin))
+	out := output.Stdout(*gz)
+	err := fathom(out, os.Stdin, *thresh)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
+
+// fathom writes the features in the GFF stream src with a repeat element
+// length of at least thresh to dst. The element length is the sum of the
+// end and remainder fields of the Repeat attribute.
+func fathom(dst io.Writer, src io.Reader, thresh int) error {
+	w := gff.NewWriter(dst, 60, false)
+	sc := featio.NewScanner(gff.NewReader(src))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
 		r := f.FeatAttributes.Get("Repeat")
 		fields := strings.Fields(r)
-		if len(fields) < 4 {
-			log.Fatal("invalid repeat attribute")
+		if len(fields) < 5 {
+			return errors.New("invalid repeat attribute")
 		}
 		end, err := strconv.Atoi(fields[3])
 		if err != nil {
-			log.Fatalf("failed to parse end coordinate: %v", err)
+			return fmt.Errorf("failed to parse end coordinate: %v", err)
 		}
 		remainder, err := strconv.Atoi(fields[4])
 		if err != nil {
-			log.Fatalf("failed to parse remains coordinate: %v", err)
+			return fmt.Errorf("failed to parse remains coordinate: %v", err)
 		}
 		length := end + remainder
-		if length < *thresh {
+		if length < thresh {
 			continue
 		}
-		w.Write(f)
+		_, err = w.Write(f)
+		if err != nil {
+			return fmt.Errorf("failed to write feature: %v", err)
+		}
 	}
 	if err := sc.Error(); err != nil {
-		log.Fatalf("error during gff read: %v", err)
+		return fmt.Errorf("error during gff read: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,72 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden checks got against the contents of the named file in testdata,
+// writing got to the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestFathomGolden(t *testing.T) {
+	// The testdata events have element lengths
+	// of 311, 6170, 6030 and 50.
+	for _, thresh := range []int{0, 311, 312, 6100, 7000} {
+		f, err := os.Open(filepath.Join("testdata", "events.gff"))
+		if err != nil {
+			t.Fatalf("failed to open events: %v", err)
+		}
+		var buf bytes.Buffer
+		err = fathom(&buf, f, thresh)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error for thresh=%d: %v", thresh, err)
+		}
+		golden(t, fmt.Sprintf("thresh-%d.gff", thresh), buf.Bytes())
+	}
+}
+
+func TestFathomInvalid(t *testing.T) {
+	for _, repeat := range []string{
+		"",
+		"AluY SINE/Alu 1 300",
+		"AluY SINE/Alu 1 end 11",
+		"AluY SINE/Alu 1 300 (11)",
+	} {
+		src := "chr1\tpress\tinsertion\t1001\t1300\t.\t+\t.\tRepeat " + repeat + "\n"
+		var buf bytes.Buffer
+		err := fathom(&buf, strings.NewReader(src), 0)
+		if err == nil {
+			t.Errorf("expected error for repeat attribute %q", repeat)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// haul extracts fasta sequences with IDs listed in the names parameter
+// file. Sequences are read from the -in file or stdin if -in is not set.
+//
+// IDs may be matched in full, by prefix or by regular expression. When
+// -ordered is set, sequences are written in the order of the names file
+// rather than the order of the input; this requires holding matching
+// sequences in memory until all input has been read.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+var (
+	in      = flag.String("in", "", "specify input fasta file (default to stdin)")
+	names   = flag.String("names", "", "specify file containing names of sequences to extract (required)")
+	match   = flag.String("match", "full", `specify ID matching ("full", "prefix" or "regex")`)
+	ordered = flag.Bool("ordered", false, "output sequences in the order of the names file")
+	gz      = flag.Bool("gzip", false, "gzip compress fasta output")
+	wrap    = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
+)
+
+func main() {
+	flag.Parse()
+	if *names == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	list, err := readNames(*names)
+	if err != nil {
+		log.Fatalf("failed to read names file %q: %v", *names, err)
+	}
+	m, err := newMatcher(*match, list)
+	if err != nil {
+		log.Fatalf("failed to create matcher: %v", err)
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatalf("failed to open %q: %v", *in, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	out := output.Stdout(*gz)
+	err = haul(out, r, list, m, *ordered)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
+
+// haul writes the fasta sequences read from src with IDs matching m to
+// dst. If ordered is true, sequences are written in the order of the
+// names in list that they match, otherwise in the order they are read.
+func haul(dst io.Writer, src io.Reader, list []string, m *matcher, ordered bool) error {
+	// held holds matching sequences for each name
+	// when output is in names file order.
+	var held [][]*linear.Seq
+	if ordered {
+		held = make([][]*linear.Seq, len(list))
+	}
+
+	sc := seqio.NewScanner(fasta.NewReader(src, linear.NewSeq("", nil, alphabet.DNA)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		i := m.match(s.ID)
+		if i < 0 {
+			continue
+		}
+		if ordered {
+			held[i] = append(held[i], s)
+			continue
+		}
+		err := sequtil.WriteFasta(dst, s, *wrap)
+		if err != nil {
+			return fmt.Errorf("failed to write sequence: %v", err)
+		}
+	}
+	if err := sc.Error(); err != nil {
+		return fmt.Errorf("error during fasta read: %v", err)
+	}
+
+	for i, seqs := range held {
+		if len(seqs) == 0 {
+			log.Printf("no sequence for %q", list[i])
+		}
+		for _, s := range seqs {
+			err := sequtil.WriteFasta(dst, s, *wrap)
+			if err != nil {
+				return fmt.Errorf("failed to write sequence: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// readNames returns the non-blank lines of the named file.
+func readNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var list []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name := strings.TrimSpace(sc.Text())
+		if name == "" {
+			continue
+		}
+		list = append(list, name)
+	}
+	return list, sc.Err()
+}
+
+// matcher matches sequence IDs against a list of names.
+type matcher struct {
+	full   map[string]int
+	prefix []string
+	regex  []*regexp.Regexp
+}
+
+// newMatcher returns a matcher for the given list of names using
+// the specified matching method.
+func newMatcher(method string, list []string) (*matcher, error) {
+	var m matcher
+	switch method {
+	case "full":
+		m.full = make(map[string]int, len(list))
+		for i, n := range list {
+			if _, ok := m.full[n]; !ok {
+				m.full[n] = i
+			}
+		}
+	case "prefix":
+		m.prefix = list
+	case "regex":
+		m.regex = make([]*regexp.Regexp, len(list))
+		for i, n := range list {
+			re, err := regexp.Compile(n)
+			if err != nil {
+				return nil, err
+			}
+			m.regex[i] = re
+		}
+	default:
+		return nil, fmt.Errorf("invalid match method: %q", method)
+	}
+	return &m, nil
+}
+
+// match returns the index of the first name in the list that
+// matches id, or -1 if no name matches.
+func (m *matcher) match(id string) int {
+	switch {
+	case m.full != nil:
+		i, ok := m.full[id]
+		if !ok {
+			return -1
+		}
+		return i
+	case m.prefix != nil:
+		for i, p := range m.prefix {
+			if strings.HasPrefix(id, p) {
+				return i
+			}
+		}
+	case m.regex != nil:
+		for i, re := range m.regex {
+			if re.MatchString(id) {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,134 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const reads = `>m1/10/ccs first
+acgt
+>m1/20/0_100
+ggcc
+>m2/5/ccs
+tttt
+>other
+aaaa
+`
+
+var haulTests = []struct {
+	match   string
+	names   []string
+	ordered bool
+	want    string
+	wantLog string
+}{
+	{
+		match: "full",
+		names: []string{"m2/5/ccs", "m1/10/ccs"},
+		want:  ">m1/10/ccs first\nacgt\n>m2/5/ccs\ntttt\n",
+	},
+	{
+		match:   "full",
+		names:   []string{"m2/5/ccs", "m3/1/ccs", "m1/10/ccs"},
+		ordered: true,
+		want:    ">m2/5/ccs\ntttt\n>m1/10/ccs first\nacgt\n",
+		wantLog: `no sequence for "m3/1/ccs"` + "\n",
+	},
+	{
+		match: "prefix",
+		names: []string{"m2/", "m1/"},
+		want:  ">m1/10/ccs first\nacgt\n>m1/20/0_100\nggcc\n>m2/5/ccs\ntttt\n",
+	},
+	{
+		match:   "prefix",
+		names:   []string{"m2/", "m1/"},
+		ordered: true,
+		want:    ">m2/5/ccs\ntttt\n>m1/10/ccs first\nacgt\n>m1/20/0_100\nggcc\n",
+	},
+	{
+		// Each sequence is held for the first
+		// name it matches.
+		match:   "prefix",
+		names:   []string{"m1/2", "m1/"},
+		ordered: true,
+		want:    ">m1/20/0_100\nggcc\n>m1/10/ccs first\nacgt\n",
+	},
+	{
+		match:   "regex",
+		names:   []string{"^m2/", "/ccs$"},
+		ordered: true,
+		want:    ">m2/5/ccs\ntttt\n>m1/10/ccs first\nacgt\n",
+	},
+}
+
+func TestHaul(t *testing.T) {
+	log.SetFlags(0)
+	defer log.SetFlags(log.LstdFlags)
+	for _, test := range haulTests {
+		m, err := newMatcher(test.match, test.names)
+		if err != nil {
+			t.Fatalf("unexpected error making %s matcher: %v", test.match, err)
+		}
+		var buf, logBuf bytes.Buffer
+		log.SetOutput(&logBuf)
+		err = haul(&buf, strings.NewReader(reads), test.names, m, test.ordered)
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			t.Fatalf("unexpected error for %s match of %q: %v", test.match, test.names, err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("unexpected output for %s match of %q with ordered=%t:\ngot:\n%s\nwant:\n%s",
+				test.match, test.names, test.ordered, &buf, test.want)
+		}
+		if logBuf.String() != test.wantLog {
+			t.Errorf("unexpected log output for %s match of %q with ordered=%t: got:%q want:%q",
+				test.match, test.names, test.ordered, &logBuf, test.wantLog)
+		}
+	}
+}
+
+func TestNewMatcherErrors(t *testing.T) {
+	for _, test := range []struct {
+		match string
+		names []string
+	}{
+		{match: "glob", names: []string{"m1/*"}},
+		{match: "regex", names: []string{"m1/[0-9"}},
+	} {
+		_, err := newMatcher(test.match, test.names)
+		if err == nil {
+			t.Errorf("expected error for %s match of %q", test.match, test.names)
+		}
+	}
+}
+
+func TestReadNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haul")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "names.txt")
+	err = ioutil.WriteFile(path, []byte("m2/5/ccs\n\n  m1/10/ccs \n\n"), 0664)
+	if err != nil {
+		t.Fatalf("failed to write names: %v", err)
+	}
+	got, err := readNames(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"m2/5/ccs", "m1/10/ccs"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected names: got:%q want:%q", got, want)
+	}
+}
@@ -9,7 +9,6 @@ package main
 import (
 	"bufio"
 	"flag"
-	"fmt"
 	"log"
 	"os"
 
@@ -17,9 +16,16 @@ import (
 	"github.com/biogo/biogo/io/seqio"
 	"github.com/biogo/biogo/io/seqio/fasta"
 	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/sequtil"
 )
 
-var exclude = flag.String("exclude", "", "specify file containing excluded reads")
+var (
+	exclude = flag.String("exclude", "", "specify file containing excluded reads")
+	gz      = flag.Bool("gzip", false, "gzip compress fasta output")
+	wrap    = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
+)
 
 func main() {
 	flag.Parse()
@@ -42,15 +48,23 @@ func main() {
 		log.Fatalf("failed to read exclude file: %v", err)
 	}
 
+	out := output.Stdout(*gz)
 	sc := seqio.NewScanner(fasta.NewReader(os.Stdin, linear.NewSeq("", nil, alphabet.DNA)))
 	for sc.Next() {
 		s := sc.Seq().(*linear.Seq)
 		if _, ok := nameSet[s.ID]; ok {
 			continue
 		}
-		fmt.Printf("%60a\n", s)
+		err = sequtil.WriteFasta(out, s, *wrap)
+		if err != nil {
+			log.Fatalf("failed to write sequence: %v", err)
+		}
 	}
 	if err := sc.Error(); err != nil {
 		log.Fatalf("error during gff read: %v", err)
 	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
 }
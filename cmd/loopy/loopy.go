@@ -6,27 +6,44 @@
 // structural variation features.
 //
 // The program is based on the original python code by Steve Turner.
+//
+// Discordances are classed by the placement of the flank hit relative to
+// the core hit. Flanks on a different contig give flank features, flanks on
+// the same strand of the same contig give insertion and deletion features,
+// and flanks on the opposite strand of the same contig give inversion
+// features spanning from the core end nearest the flank to the far end of
+// the flank. Each class can be turned off with -trans, -indel and -inv.
+// Same strand pairs separated on the reference by more than -max-gap are
+// taken to be rearrangements rather than indels and give flank features.
+//
+// With -bedpe, discordances where a remapped flank maps to a different
+// contig to the core of the read are also written to a BEDPE file pairing
+// the core and flank loci, so that candidate translocations can be viewed
+// in paired-end browsers. Loci are given on the plus strand of the
+// reference with the reference strand of each hit. Each record is named
+// for its read and has the side of the read the flank came from as an
+// extra field.
+//
+// The -seed flag defaults to a fixed value so that repeated runs give the
+// same hits; it is not used with -run-blasr=false.
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"regexp"
 
 	"github.com/biogo/biogo/alphabet"
-	"github.com/biogo/biogo/io/featio/gff"
 	"github.com/biogo/biogo/io/seqio"
 	"github.com/biogo/biogo/io/seqio/fasta"
-	"github.com/biogo/biogo/seq"
 	"github.com/biogo/biogo/seq/linear"
 
 	"github.com/kortschak/loopy/blasr"
+	"github.com/kortschak/loopy/loopy"
 )
 
 var (
@@ -35,14 +52,31 @@ var (
 	suff      = flag.String("suff", "", "input reference suffix array path")
 	blasrPath = flag.String("blasr", "", "path to blasr if not in $PATH")
 	procs     = flag.Int("procs", 1, "number of blasr threads")
+	seed      = flag.Int("seed", 1, "blasr random seed for breaking ties between equal scoring alignments (blasr default if zero)")
 	flank     = flag.Int("flank", 50, "minimum flank length")
+	flankSeq  = flag.Int("min-flank-seq", -1, "minimum unmapped flank length to remap (defaults to -flank)")
+	flankAln  = flag.Int("min-flank-aln", -1, "minimum remapped flank alignment length (defaults to -flank)")
 	length    = flag.Int("length", 200, "minimum blasr search alignment length")
 	discords  = flag.Bool("discords", false, "output GFF file of discordant features")
+	trans     = flag.Bool("trans", true, "write discordances with flanks mapping to a different contig to the core")
+	indel     = flag.Bool("indel", true, "write insertions and deletions between flanks and cores on the same strand of a contig")
+	maxGap    = flag.Int("max-gap", 0, "maximum reference gap between same strand flank and core reported as an indel; longer gaps give flank features (no limit if zero)")
+	inv       = flag.Bool("inv", true, "write inversions with flanks on the opposite strand of the core's contig")
+	bedpe     = flag.Bool("bedpe", false, "output BEDPE file pairing core and flank loci of inter-contig discordances")
+	asJSON    = flag.Bool("json", false, "output results as JSON objects instead of tab separated fields")
+	header    = flag.Bool("header", false, "write a column name header line before tab separated results")
+	minMapQV  = flag.Int("min-mapqv", 0, "minimum blasr mapQV for core hits (hits with unavailable mapQV are dropped if non-zero)")
+	flankQV   = flag.Bool("flank-mapqv", false, "apply -min-mapqv to flank hits")
+	exclude   = flag.String("exclude-contigs", "", "regular expression matching reference contigs to exclude (e.g. _alt$|^chrUn_|_random$|^HLA-)")
+	flankSim  = flag.Float64("min-flank-similarity", 0, "minimum blasr percent similarity of flank hits used for discordance features")
 	run       = flag.Bool("run-blasr", true, `actually run blasr
     	false is useful to reconstruct output from fasta input
     	and loopy .blasr outputs`,
 	)
 
+	dumpFile = flag.String("dump", "", "write all parsed core and flank hits in long format to this file if option not empty (JSON lines with -json)")
+	qcFile   = flag.String("qc", "", "write unmapped read and flank counts to this TSV file if option not empty")
+
 	outFile = flag.String("out", "", "output file name (default to stdout)")
 	errFile = flag.String("err", "", "output file name (default to stderr)")
 )
@@ -60,6 +94,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *flankSeq < 0 {
+		*flankSeq = *flank
+	}
+	if *flankAln < 0 {
+		*flankAln = *flank
+	}
+
 	var err error
 	if *errFile != "" {
 		errStream, err = os.Create(*errFile)
@@ -90,7 +131,7 @@ func main() {
 	rightSeqs := out + ".right.in.fa"
 
 	log.Printf("writing flanks to %q and %q", leftSeqs, rightSeqs)
-	err = writeFlankSeqs(*reads, core, *flank, leftSeqs, rightSeqs)
+	err = writeFlankSeqs(*reads, core, *flankSeq, leftSeqs, rightSeqs)
 	if err != nil {
 		log.Fatalf("failed to write flanks: %v", err)
 	}
@@ -107,29 +148,90 @@ func main() {
 		log.Fatalf("failed right flank remapping: %v", err)
 	}
 
-	var w *gff.Writer
+	if *dumpFile != "" {
+		err = dumpHits(*dumpFile, core, left, right, *asJSON)
+		if err != nil {
+			log.Fatalf("failed to dump hits: %v", err)
+		}
+	}
+
+	var w io.Writer
 	if *discords {
 		f, err := os.Create(out + ".gff")
 		if err != nil {
 			log.Fatalf("failed to create GFF outfile: %q", out+".gff")
 		}
-		w = gff.NewWriter(f, 60, true)
+		w = f
 		defer f.Close()
 	}
-	err = writeResults(core, left, right, outStream, *length, *flank, w)
+	var pairs io.Writer
+	if *bedpe {
+		f, err := os.Create(out + ".bedpe")
+		if err != nil {
+			log.Fatalf("failed to create BEDPE outfile: %q", out+".bedpe")
+		}
+		pairs = f
+		defer f.Close()
+	}
+	var excl *regexp.Regexp
+	if *exclude != "" {
+		excl, err = regexp.Compile(*exclude)
+		if err != nil {
+			log.Fatalf("invalid contig exclusion pattern: %v", err)
+		}
+	}
+	filt := loopy.Filter{
+		Length:     *length,
+		Flank:      *flankAln,
+		MinMapQV:   *minMapQV,
+		FlankMapQV: *flankQV,
+
+		MinFlankSimilarity: *flankSim,
+		MaxGap:             *maxGap,
+
+		Exclude: excl,
+	}
+	for _, class := range []struct {
+		write bool
+		event loopy.Event
+	}{
+		{write: *trans, event: loopy.Translocation},
+		{write: *indel, event: loopy.Indel},
+		{write: *inv, event: loopy.Inversion},
+	} {
+		if class.write {
+			filt.Events |= class.event
+		}
+	}
+	if filt.Events == 0 && (*discords || *bedpe) {
+		log.Fatal("no discordance classes selected: need at least one of -trans, -indel or -inv")
+	}
+	if *header && !*asJSON {
+		err = loopy.WriteHeader(outStream)
+		if err != nil {
+			log.Fatalf("failed to write header: %v", err)
+		}
+	}
+	err = loopy.WriteResults(outStream, w, pairs, core, left, right, *asJSON, filt)
 	if err != nil {
 		log.Fatalf("failed to write results: %v", err)
 	}
-}
 
-// hitSet represents a collection of blasr mapping results.
-type hitSet map[string]*blasrHit
+	err = reportUnmapped([]phase{
+		{name: "core", unmapped: unmappedPath(*reads)},
+		{name: "left-flank", unmapped: unmappedPath(leftSeqs)},
+		{name: "right-flank", unmapped: unmappedPath(rightSeqs)},
+	}, *qcFile)
+	if err != nil {
+		log.Fatalf("failed to report unmapped reads: %v", err)
+	}
+}
 
-// hitSetFrom returns a hitSet from mapping reads to the given reference
+// hitSetFrom returns a loopy.HitSet from mapping reads to the given reference
 // using the suffix array file if provided. If run is false, blasr is not
-// run and the existing blasr output is used to reconstruct the hitSet.
+// run and the existing blasr output is used to reconstruct the HitSet.
 // procs specifies the number of blasr threads to use.
-func hitSetFrom(reads, ref, suff string, procs int, run bool) (hitSet, error) {
+func hitSetFrom(reads, ref, suff string, procs int, run bool) (loopy.HitSet, error) {
 	base := filepath.Base(reads)
 	b := blasr.BLASR{
 		Cmd: *blasrPath,
@@ -138,9 +240,10 @@ func hitSetFrom(reads, ref, suff string, procs int, run bool) (hitSet, error) {
 		BestN: 1, Format: 4,
 
 		Aligned:   base + ".blasr",
-		Unaligned: base + ".blasr.unmapped",
+		Unaligned: unmappedPath(reads),
 
-		Procs: procs,
+		Procs:      procs,
+		RandomSeed: *seed,
 	}
 	if run {
 		cmd, err := b.BuildCommand()
@@ -161,24 +264,14 @@ func hitSetFrom(reads, ref, suff string, procs int, run bool) (hitSet, error) {
 	}
 	defer f.Close()
 
-	hits := make(hitSet)
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		b, err := newBlasrHit(sc.Text())
-		if err != nil {
-			return nil, err
-		}
-		hits[b.qName] = b
-	}
-
-	return hits, sc.Err()
+	return loopy.ReadHits(f)
 }
 
 // writeFlankSeqs writes fasta files containing the sequence of unmapped flanks
 // identified in the primary hits provided. cutoff specifies the minimum sequence
 // length to consider. left and right specify the filenames for the left and right
 // flank fasta sequence files.
-func writeFlankSeqs(reads string, hits hitSet, cutoff int, left, right string) error {
+func writeFlankSeqs(reads string, hits loopy.HitSet, cutoff int, left, right string) error {
 	f, err := os.Open(reads)
 	if err != nil {
 		return err
@@ -194,32 +287,7 @@ func writeFlankSeqs(reads string, hits hitSet, cutoff int, left, right string) e
 		return err
 	}
 
-	r := fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNA))
-	sc := seqio.NewScanner(r)
-	for sc.Next() {
-		seq := sc.Seq().(*linear.Seq)
-		h, ok := hits[seq.Name()]
-		if !ok {
-			continue
-		}
-
-		all := seq.Seq
-		if h.qStart >= cutoff {
-			seq.Seq = all[:h.qStart]
-			_, err := fmt.Fprintf(lf, "%60a\n", seq)
-			if err != nil {
-				return err
-			}
-		}
-		if h.qLen-h.qEnd >= cutoff {
-			seq.Seq = all[h.qEnd:]
-			_, err := fmt.Fprintf(rf, "%60a\n", seq)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	err = sc.Error()
+	err = loopy.WriteFlankSeqs(lf, rf, f, hits, cutoff)
 	if err != nil {
 		return err
 	}
@@ -230,283 +298,89 @@ func writeFlankSeqs(reads string, hits hitSet, cutoff int, left, right string) e
 	return rf.Close()
 }
 
-// writeResults writes out the results of the analysis in a format similar to the
-// Pacific Biosciences bridgemapper program (29 tab separated fields). It also writes
-// candidate discordances to the discords gff.Writer if it is not nil. Flanks less than
-// flank long are not considered and primay mappings less than length long are omitted.
-func writeResults(core, left, right hitSet, out io.Writer, length, flank int, discords *gff.Writer) error {
-	for id, c := range core {
-		if c.qEnd-c.qStart < length {
-			continue
-		}
-		l, ok := left[id]
-		if ok && abs(l.tEnd-l.tStart) < flank {
-			l = nil
-		}
-		r, ok := right[id]
-		if ok && abs(r.tEnd-r.tStart) < flank {
-			r = nil
-		}
-		if l == nil && r == nil {
-			continue
-		}
-		_, err := fmt.Fprintf(out, "%s\t%d\t%v\t%v\t%v\n", id, c.qLen, l, c, r)
-		if err != nil {
-			return err
-		}
-		if discords != nil {
-			for _, f := range [2]*blasrHit{l, r} {
-				if f == nil {
-					continue
-				}
-				if f.tName != c.tName {
-					_, err = discords.Write(&gff.Feature{
-						SeqName:    f.tName,
-						Feature:    "flank",
-						Source:     "loopy",
-						FeatStart:  f.tStart,
-						FeatEnd:    f.tEnd,
-						FeatScore:  floatPtr(float64(f.score)),
-						FeatStrand: f.qStrand,
-						FeatFrame:  gff.NoFrame,
-					})
-					if err != nil {
-						return err
-					}
-				} else if f.tStrand == c.tStrand {
-					for _, g := range gapOrOverlap(f, c, flank) {
-						_, err = discords.Write(g)
-						if err != nil {
-							return err
-						}
-					}
-				}
-			}
-		}
+// dumpHits writes the hits of core, left and right to the named file
+// in long format.
+func dumpHits(path string, core, left, right loopy.HitSet, asJSON bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func abs(a int) int {
-	if a < 0 {
-		return -a
+	err = loopy.WriteHits(f, core, left, right, asJSON)
+	if err != nil {
+		f.Close()
+		return err
 	}
-	return a
+	return f.Close()
 }
 
-func floatPtr(f float64) *float64 {
-	return &f
+// unmappedPath returns the path of the blasr unaligned read output for
+// the given reads file.
+func unmappedPath(reads string) string {
+	return filepath.Base(reads) + ".blasr.unmapped"
 }
 
-// gapOrOverlap returns features that describe insertion or deletion events
-// in the reads relative to the reference. Only features cutoff or longer are
-// returned and pairs of read insertion/reference deletion that are within
-// cutoff in length are discarded.
-func gapOrOverlap(flank, core *blasrHit, cutoff int) []*gff.Feature {
-	if flank.tName != core.tName {
-		panic("bad hit pair")
-	}
-
-	var (
-		qGapStart, qGapEnd int
-		tGapStart, tGapEnd int
-	)
-	if flank.qStart < core.qStart {
-		qGapStart = flank.qEnd
-		qGapEnd = core.qStart
-
-		tGapStart = flank.tEnd
-		tGapEnd = core.tStart
-	} else {
-		qGapStart = core.qEnd
-		qGapEnd = core.qEnd + flank.qStart
+// phase is a blasr mapping phase.
+type phase struct {
+	name     string // name is the name of the phase used in reports.
+	unmapped string // unmapped is the blasr unaligned read output of the phase.
+}
 
-		tGapStart = core.tEnd
-		tGapEnd = flank.tStart
-	}
-	if tGapEnd < tGapStart {
-		tGapEnd, tGapStart = tGapStart, tGapEnd
+// reportUnmapped logs the number of reads left unmapped by blasr in each
+// of the mapping phases. If path is not empty, the counts are also written
+// to the named file as tab separated phase names and counts. The unaligned
+// read output may not exist, for example when blasr output was generated
+// outside loopy and -run-blasr=false is used, so phases without unaligned
+// read output are logged and reported with a count of NA.
+func reportUnmapped(phases []phase, path string) error {
+	counts := make([]string, len(phases))
+	for i, p := range phases {
+		n, err := countSeqs(p.unmapped)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			counts[i] = "NA"
+			log.Printf("%s unmapped: no unaligned read output %q", p.name, p.unmapped)
+			continue
+		}
+		counts[i] = fmt.Sprint(n)
+		log.Printf("%s unmapped: %d", p.name, n)
 	}
-
-	if abs((qGapEnd-qGapStart)-(tGapEnd-tGapStart)) < cutoff {
+	if path == "" {
 		return nil
 	}
 
-	f := make([]*gff.Feature, 0, 2)
-	if qGapEnd-qGapStart >= cutoff {
-		f = append(f, &gff.Feature{
-			SeqName:   flank.tName,
-			Feature:   "insertion",
-			Source:    "loopy",
-			FeatStart: tGapStart,
-
-			// We may have a zero-length feature, but GFF is
-			// broken by design, so paper over that here.
-			FeatEnd: max(tGapEnd, tGapStart+1),
-
-			FeatStrand: flank.qStrand,
-			FeatFrame:  gff.NoFrame,
-			FeatAttributes: gff.Attributes{{
-				Tag:   "Query",
-				Value: fmt.Sprintf("%s %d %d", flank.qName, qGapStart, qGapEnd),
-			}},
-		})
-	}
-	if tGapEnd-tGapStart >= cutoff {
-		f = append(f, &gff.Feature{
-			SeqName:    flank.tName,
-			Feature:    "deletion",
-			Source:     "loopy",
-			FeatStart:  tGapStart,
-			FeatEnd:    tGapEnd,
-			FeatStrand: flank.qStrand,
-			FeatFrame:  gff.NoFrame,
-		})
-	}
-	return f
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-const (
-	qnameField = iota
-	tnameField
-	scoreField
-	pctsimilarityField
-	qstrandField
-	qstartField
-	qendField
-	qseqlengthField
-	tstrandField
-	tstartField
-	tendField
-	tseqlengthField
-	mapqvField
-	ncellsField
-	clusterScoreField
-	probscoreField
-	numSigClustersField
-
-	numFields
-)
-
-// blasrHits is a blasr mapping event.
-type blasrHit struct {
-	qName   string
-	qStrand seq.Strand
-	qStart  int
-	qEnd    int
-	qLen    int
-
-	tName   string
-	tStrand seq.Strand
-	tStart  int
-	tEnd    int
-	tLen    int
-
-	score      int
-	similarity float64
-	mapQV      int
-}
-
-func handlePanic(err *error) {
-	r := recover()
-	if r != nil {
-		switch r := r.(type) {
-		case error:
-			*err = r
-		default:
-			panic(r)
-		}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
 	}
-}
-
-// newBlasrHit returns a blasrHit parsed from a blasr format 4 line.
-func newBlasrHit(line string) (b *blasrHit, err error) {
-	defer handlePanic(&err)
-	fields := strings.Fields(line)
-	return &blasrHit{
-		// The original code strips the subread start and end from the qname.
-		// This is incorrect since multiple movies may exists in the read file,
-		// resulting in clobbered map entries (this is also true in the
-		// original python).
-		// The consequence of this may be miscalculation of query start, end
-		// and length values resulting in index out of range or silent sequence
-		// truncation.
-		// The alternative is to group by read, but I can't see the benefit of
-		// that here.
-		qName: fields[qnameField],
-
-		qStrand: mustStrand(mustAtoi(fields[qstrandField])),
-		qStart:  mustAtoi(fields[qstartField]),
-		qEnd:    mustAtoi(fields[qendField]),
-		qLen:    mustAtoi(fields[qseqlengthField]),
-
-		tName:   fields[tnameField],
-		tStrand: mustStrand(mustAtoi(fields[tstrandField])),
-		tStart:  mustAtoi(fields[tstartField]),
-		tEnd:    mustAtoi(fields[tendField]),
-		tLen:    mustAtoi(fields[tseqlengthField]),
-
-		score:      mustAtoi(fields[scoreField]),
-		similarity: mustAtof(fields[pctsimilarityField]),
-		mapQV:      mustAtoi(fields[mapqvField]),
-	}, nil
-}
-
-func mustAtoi(s string) int {
-	i, err := strconv.Atoi(s)
+	_, err = fmt.Fprintln(f, "phase\tunmapped")
 	if err != nil {
-		panic(err)
+		f.Close()
+		return err
+	}
+	for i, p := range phases {
+		_, err = fmt.Fprintf(f, "%s\t%s\n", p.name, counts[i])
+		if err != nil {
+			f.Close()
+			return err
+		}
 	}
-	return i
+	return f.Close()
 }
 
-func mustAtof(s string) float64 {
-	f, err := strconv.ParseFloat(s, 64)
+// countSeqs returns the number of fasta sequences in the named file.
+func countSeqs(path string) (int, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	return f
-}
+	defer f.Close()
 
-func mustStrand(s int) seq.Strand {
-	switch s {
-	case 0:
-		return seq.Minus
-	case 1:
-		return seq.Plus
-	default:
-		panic(fmt.Sprintf("bad strand value: %d", s))
+	var n int
+	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNA)))
+	for sc.Next() {
+		n++
 	}
-}
-
-func (b *blasrHit) String() string {
-	const empty = "_\t_\t_\t_\t_\t_\t_\t_\t_"
-	if b == nil {
-		return empty
-	}
-
-	start := b.tStart
-	end := b.tEnd
-	if b.tStrand == 1 {
-		start = b.tLen - start
-		end = b.tLen - end
-	}
-	return fmt.Sprintf("%d\t%d\t%s\t%d\t%d\t%d\t%d\t%f\t%d",
-		b.qStart,
-		b.qEnd,
-		b.tName,
-		b.tStrand,
-		start,
-		end,
-		b.score,
-		b.similarity,
-		b.mapQV,
-	)
+	return n, sc.Error()
 }
@@ -0,0 +1,49 @@
+// Copyright ©2015 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReportUnmapped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loopy")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"reads.fa.blasr.unmapped":         ">a\nacgt\n>b\nacgt\n>c\nacgt\n",
+		"reads.fa.left.fa.blasr.unmapped": "",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0664)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	qc := filepath.Join(dir, "qc.tsv")
+	err = reportUnmapped([]phase{
+		{name: "core", unmapped: filepath.Join(dir, "reads.fa.blasr.unmapped")},
+		{name: "left-flank", unmapped: filepath.Join(dir, "reads.fa.left.fa.blasr.unmapped")},
+		{name: "right-flank", unmapped: filepath.Join(dir, "reads.fa.right.fa.blasr.unmapped")},
+	}, qc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := ioutil.ReadFile(qc)
+	if err != nil {
+		t.Fatalf("failed to read qc output: %v", err)
+	}
+	want := "phase\tunmapped\ncore\t3\nleft-flank\t0\nright-flank\tNA\n"
+	if string(got) != want {
+		t.Errorf("unexpected qc output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
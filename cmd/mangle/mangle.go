@@ -21,11 +21,16 @@ import (
 	"github.com/biogo/biogo/io/seqio"
 	"github.com/biogo/biogo/io/seqio/fasta"
 	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/sequtil"
 )
 
 var (
 	apply          = flag.String("unmangle", "", "apply the inverse name mangling to the specified map/out file")
 	queryNameField = flag.Int("name-field", 0, "specify the name field of the map/out file to unmangle")
+	gz             = flag.Bool("gzip", false, "gzip compress mangled fasta output")
+	wrap           = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
 )
 
 func main() {
@@ -40,6 +45,7 @@ func main() {
 func mangle() {
 	seen := make(map[string]bool)
 	hash := sha1.New()
+	out := output.Stdout(*gz)
 	sc := seqio.NewScanner(fasta.NewReader(os.Stdin, linear.NewSeq("", nil, alphabet.DNA)))
 	for sc.Next() {
 		s := sc.Seq().(*linear.Seq)
@@ -55,7 +61,18 @@ func mangle() {
 		}
 		seen[s.ID] = true
 		hash.Reset()
-		fmt.Printf("%60a\n", s)
+		err := sequtil.WriteFasta(out, s, *wrap)
+		if err != nil {
+			log.Fatalf("failed to write sequence: %v", err)
+		}
+	}
+	err := sc.Error()
+	if err != nil {
+		log.Fatalf("error during fasta read: %v", err)
+	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
 	}
 }
 
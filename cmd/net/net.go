@@ -5,25 +5,60 @@
 // net performs set operation on reefer pressed events. Input gff feature score
 // field must be either not set or set by previous use of net. The coordinate
 // systems used for the different inputs is expected to be the same.
+//
+// If the inputs carry the provenance comment written by press, the coordinate
+// origins of the inputs must agree and the threshold used by net must not be
+// less than the threshold used to produce either input. The output carries
+// the provenance comment of net, with the coordinate origin of the inputs,
+// followed by a comment recording the set operation, its parameters and the
+// input file names.
+//
+// Unless -coordinate-check=false is given, the reference sequence names of
+// the inputs are compared as a guard against inputs from different reference
+// builds. It is an error for the inputs to have no names in common, and the
+// names found in only one input are logged.
+//
+// The score of each event is its support, the number of features in its
+// group, or the sum of scores of its group's features if the input scores
+// are set by previous use of net. The sub operation retains the scores of
+// events in a. The union operation adds the scores of matched events in b
+// to the scores of the events in a that they match, so an event in b that
+// matches more than one event in a contributes to each. The intersect
+// operation writes the sum of the scores of each matched pair.
+//
+// Output features carry only the group and repeat attributes needed to
+// identify them unless -keep-attrs lists other attribute tags, such as the
+// TSD attribute added by catch, to carry through the operation. For each
+// listed tag, the first value found among the features of a group is kept.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/events"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/provenance"
 )
 
 var (
-	left   = flag.String("a", "", "specify left gff file (required)")
-	right  = flag.String("b", "", "specify right gff file (required)")
-	thresh = flag.Float64("thresh", 0.90, "specify minumum jaccard similarity for identity between events - must be >= value used by press")
-	op     = flag.String("op", "sub", `specify set operation (from "sub" (a\b), "union" (a∪b), "intersect" (a∩b)`)
+	left       = flag.String("a", "", "specify left gff file (required)")
+	right      = flag.String("b", "", "specify right gff file (required)")
+	thresh     = flag.Float64("thresh", 0.90, "specify minumum jaccard similarity for identity between events - must be >= value used by press")
+	minSupport = flag.Float64("min-support", 1, "specify minimum group support (score) for participation in the set operation")
+	gz         = flag.Bool("gzip", false, "gzip compress the GFF output")
+	op         = flag.String("op", "sub", `specify set operation (from "sub" (a\b), "union" (a∪b), "intersect" (a∩b)`)
+	keepAttrs  = flag.String("keep-attrs", "", "specify a comma separated list of attribute tags to retain from input features")
+	coordCheck = flag.Bool("coordinate-check", true, "check that the inputs share reference sequence names")
 )
 
 func main() {
@@ -33,59 +68,155 @@ func main() {
 		os.Exit(1)
 	}
 
-	a, err := events(*left)
+	origin, err := checkProvenance(*left, *right, *thresh)
 	if err != nil {
 		log.Fatal(err)
 	}
-	b, err := events(*right)
+
+	var keep map[string]bool
+	if *keepAttrs != "" {
+		keep = make(map[string]bool)
+		for _, tag := range strings.Split(*keepAttrs, ",") {
+			keep[strings.TrimSpace(tag)] = true
+		}
+	}
+
+	a, err := os.Open(*left)
+	if err != nil {
+		log.Fatalf("failed to open %q: %v", *left, err)
+	}
+	defer a.Close()
+	b, err := os.Open(*right)
+	if err != nil {
+		log.Fatalf("failed to open %q: %v", *right, err)
+	}
+	defer b.Close()
+
+	out := output.Stdout(*gz)
+	err = net(out, a, b, *left, *right, origin, keep)
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
+
+// net performs the -op set operation on the events in the GFF streams a and
+// b, read from the files named nameA and nameB, and writes the result to dst
+// with provenance comments for the given coordinate origin. The attributes
+// with tags in keep are retained from the input features.
+func net(dst io.Writer, a, b io.Reader, nameA, nameB string, origin int, keep map[string]bool) error {
+	ea, contigsA, err := readEvents(a, *minSupport, keep)
+	if err != nil {
+		return fmt.Errorf("%q: %v", nameA, err)
+	}
+	eb, contigsB, err := readEvents(b, *minSupport, keep)
+	if err != nil {
+		return fmt.Errorf("%q: %v", nameB, err)
+	}
+	if *coordCheck {
+		err = checkContigs(nameA, nameB, contigsA, contigsB)
+		if err != nil {
+			return err
+		}
+	}
 
 	var c []*gff.Feature
 	switch *op {
 	case "sub":
-		c = sub(a, b, *thresh)
+		c = sub(ea, eb, *thresh)
 	case "union":
-		c = union(a, b, *thresh)
+		c = union(ea, eb, *thresh)
 	case "intersect":
-		c = intersect(a, b, *thresh)
+		c = intersect(ea, eb, *thresh)
 	}
-	w := gff.NewWriter(os.Stdout, 60, true)
+	w := gff.NewWriter(dst, 60, true)
+	w.WriteComment(provenance.Stamp{Tool: "net", Thresh: *thresh, Origin: origin}.String())
+	w.WriteComment(fmt.Sprintf("op=%s thresh=%v min-support=%v a=%q b=%q", *op, *thresh, *minSupport, nameA, nameB))
 	for _, v := range c {
-		w.Write(v)
+		_, err = w.Write(v)
+		if err != nil {
+			return fmt.Errorf("failed to write feature: %v", err)
+		}
 	}
+	return nil
 }
 
 func validOp(op string) bool {
 	return op == "sub" || op == "union" || op == "intersect"
 }
 
-// events returns the maximally extended events from the press gff file given.
-func events(file string) (map[int]*gff.Feature, error) {
-	f, err := os.Open(file)
+// checkProvenance checks that the provenance stamps of the files a and b are
+// consistent with each other and with the given threshold, and returns the
+// coordinate origin of the inputs, or zero if it is not known. If either file
+// has no stamp, a warning is logged and no check is made.
+func checkProvenance(a, b string, thresh float64) (origin int, err error) {
+	sa, okA, err := provenance.ReadFile(a)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read provenance of %q: %v", a, err)
+	}
+	sb, okB, err := provenance.ReadFile(b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open %q: %v", file, err)
+		return 0, fmt.Errorf("failed to read provenance of %q: %v", b, err)
+	}
+	if !okA || !okB {
+		for _, f := range []struct {
+			name string
+			ok   bool
+		}{{a, okA}, {b, okB}} {
+			if !f.ok {
+				log.Printf("no provenance for %q: cannot verify coordinate system", f.name)
+			}
+		}
+		if okA {
+			return sa.Origin, nil
+		}
+		return sb.Origin, nil
 	}
-	defer f.Close()
-	set := make(map[int]*gff.Feature)
-	sc := featio.NewScanner(gff.NewReader(f))
+	err = sa.Check(thresh, sb)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %v", a, err)
+	}
+	err = sb.Check(thresh, sa)
+	if err != nil {
+		return 0, fmt.Errorf("%q: %v", b, err)
+	}
+	if sa.Origin != 0 {
+		return sa.Origin, nil
+	}
+	return sb.Origin, nil
+}
+
+// readEvents returns the maximally extended events from the press gff stream r
+// and the set of reference sequence names of its features.
+// The score of each event is the sum of the scores of its features, with unset
+// scores counted as one. Events with a support score less than minSupport are
+// omitted. The attributes of the returned events are Group and the repeat type
+// and class of Repeat followed by the first value of each attribute in keep
+// found among the features of the event.
+func readEvents(r io.Reader, minSupport float64, keep map[string]bool) (set map[int]*gff.Feature, contigs map[string]bool, err error) {
+	set = make(map[int]*gff.Feature)
+	contigs = make(map[string]bool)
+	sc := featio.NewScanner(gff.NewReader(r))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
-		r := strings.TrimRightFunc(f.FeatAttributes.Get("Repeat"), func(r rune) bool {
-			return r == ' ' || ('0' <= r && r <= '9')
-		})
+		contigs[f.SeqName] = true
+		r := repeatClass(f.FeatAttributes.Get("Repeat"))
 		g := f.FeatAttributes.Get("Group")
 		gid, err := strconv.Atoi(g)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse group ID: %v", err)
+			return nil, nil, fmt.Errorf("failed to parse group ID: %v", err)
 		}
 		p, ok := set[gid]
 		if !ok {
+			attrs := f.FeatAttributes
 			f.FeatAttributes = gff.Attributes{
 				{Tag: "Group", Value: g},
 				{Tag: "Repeat", Value: r},
 			}
+			f.FeatAttributes = keepAttributes(f.FeatAttributes, attrs, keep)
 			if f.FeatScore == nil {
 				f.FeatScore = new(float64)
 				*(f.FeatScore) = 1
@@ -93,7 +224,12 @@ func events(file string) (map[int]*gff.Feature, error) {
 			set[gid] = f
 			continue
 		}
-		*(p.FeatScore)++
+		p.FeatAttributes = keepAttributes(p.FeatAttributes, f.FeatAttributes, keep)
+		if f.FeatScore != nil {
+			*(p.FeatScore) += *f.FeatScore
+		} else {
+			*(p.FeatScore)++
+		}
 		if f.FeatStart < p.FeatStart {
 			p.FeatStart = f.FeatStart
 		}
@@ -102,98 +238,182 @@ func events(file string) (map[int]*gff.Feature, error) {
 		}
 	}
 	if err := sc.Error(); err != nil {
-		return nil, fmt.Errorf("error during gff read: %v", err)
+		return nil, nil, fmt.Errorf("error during gff read: %v", err)
+	}
+	for gid, f := range set {
+		if *f.FeatScore < minSupport {
+			delete(set, gid)
+		}
+	}
+	return set, contigs, nil
+}
+
+// repeatClass returns the repeat type and class fields of the Repeat
+// attribute value r, dropping the element coordinates.
+func repeatClass(r string) string {
+	fields := strings.Fields(r)
+	return strings.Join(fields[:min(2, len(fields))], " ")
+}
+
+// checkContigs checks that the reference sequence names of the features
+// read from the files a and b, ca and cb, are consistent with the inputs
+// sharing a coordinate system. If neither input is empty and they have no
+// name in common, an error is returned. Names found in only one input are
+// logged.
+func checkContigs(a, b string, ca, cb map[string]bool) error {
+	if len(ca) == 0 || len(cb) == 0 {
+		return nil
+	}
+	var shared int
+	for n := range ca {
+		if cb[n] {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return fmt.Errorf("no reference sequence names shared by %q and %q: inputs may use different references", a, b)
+	}
+	for _, f := range []struct {
+		name       string
+		this, that map[string]bool
+	}{{a, ca, cb}, {b, cb, ca}} {
+		var only []string
+		for n := range f.this {
+			if !f.that[n] {
+				only = append(only, n)
+			}
+		}
+		if len(only) != 0 {
+			sort.Strings(only)
+			log.Printf("reference sequence names only in %q: %s", f.name, strings.Join(only, " "))
+		}
+	}
+	return nil
+}
+
+// keepAttributes appends the attributes in src with tags in keep to dst,
+// unless dst already has an attribute with the tag.
+func keepAttributes(dst, src gff.Attributes, keep map[string]bool) gff.Attributes {
+	for _, a := range src {
+		if keep[a.Tag] && dst.Get(a.Tag) == "" {
+			dst = append(dst, a)
+		}
+	}
+	return dst
+}
+
+// retained returns the attributes of an event returned by readEvents
+// other than Group and Repeat.
+func retained(e *gff.Feature) gff.Attributes {
+	var attrs gff.Attributes
+	for _, a := range e.FeatAttributes {
+		if a.Tag != "Group" && a.Tag != "Repeat" {
+			attrs = append(attrs, a)
+		}
 	}
-	return set, nil
+	return attrs
 }
 
 // sub returns the result of the set operation a\b. It does this using the
 // naive O(n^2) approach rather than using a collection of interval trees
-// since len(a) and len(b) are small.
+// since len(a) and len(b) are small. Events are returned in group order.
 func sub(a, b map[int]*gff.Feature, thresh float64) []*gff.Feature {
-	for ka, ea := range a {
+	c := make([]*gff.Feature, 0, len(a))
+	for _, i := range keys(a) {
+		ea := a[i]
+		var matched bool
 		for _, eb := range b {
-			if jaccard(ea, eb) >= thresh {
-				delete(a, ka)
+			if events.Jaccard(ea, eb) >= thresh {
+				matched = true
 				break
 			}
 		}
-	}
-	c := make([]*gff.Feature, 0, len(a))
-	for _, e := range a {
-		c = append(c, e)
+		if !matched {
+			c = append(c, ea)
+		}
 	}
 	return c
 }
 
 // union returns the result of the set operation a∪b. It does this using the
 // naive O(n^2) approach rather than using a collection of interval trees
-// since len(a) and len(b) are small.
+// since len(a) and len(b) are small. Each event in a is included with its
+// group as a GroupA attribute and a GroupB attribute for each event in b
+// that it matches, and with its score increased by the scores of the
+// events in b that it matches. Events in b that match no event in a are
+// included once with their group as a GroupB attribute and their score
+// unchanged. Events are returned in group order with the events of a first.
 func union(a, b map[int]*gff.Feature, thresh float64) []*gff.Feature {
-	for ka, ea := range a {
-		if ka < 0 {
-			// Ignore newly added events from b.
-			continue
-		}
-		for kb, eb := range b {
-			if jaccard(ea, eb) >= thresh {
-				a[ka].FeatAttributes = gff.Attributes{
-					{Tag: "GroupA", Value: fmt.Sprint(ka)},
-					{Tag: "GroupB", Value: fmt.Sprint(kb)},
-				}
-			} else {
-				a[ka].FeatAttributes = gff.Attributes{
-					{Tag: "GroupA", Value: fmt.Sprint(ka)},
-				}
-				eb.FeatAttributes = gff.Attributes{
-					{Tag: "GroupB", Value: fmt.Sprint(kb)},
-				}
-				a[-kb] = eb
+	ka := keys(a)
+	kb := keys(b)
+	matched := make(map[int]bool)
+	c := make([]*gff.Feature, 0, len(a)+len(b))
+	for _, i := range ka {
+		ea := a[i]
+		kept := retained(ea)
+		ea.FeatAttributes = gff.Attributes{{Tag: "GroupA", Value: fmt.Sprint(i)}}
+		score := *ea.FeatScore
+		for _, j := range kb {
+			if events.Jaccard(ea, b[j]) >= thresh {
+				ea.FeatAttributes = append(ea.FeatAttributes, gff.Attribute{Tag: "GroupB", Value: fmt.Sprint(j)})
+				score += *b[j].FeatScore
+				matched[j] = true
 			}
 		}
+		ea.FeatScore = &score
+		ea.FeatAttributes = append(ea.FeatAttributes, kept...)
+		c = append(c, ea)
 	}
-	c := make([]*gff.Feature, 0, len(a))
-	for _, e := range a {
-		c = append(c, e)
+	for _, j := range kb {
+		if matched[j] {
+			continue
+		}
+		eb := b[j]
+		eb.FeatAttributes = append(gff.Attributes{{Tag: "GroupB", Value: fmt.Sprint(j)}}, retained(eb)...)
+		c = append(c, eb)
 	}
 	return c
 }
 
+// keys returns the sorted keys of m.
+func keys(m map[int]*gff.Feature) []int {
+	k := make([]int, 0, len(m))
+	for i := range m {
+		k = append(k, i)
+	}
+	sort.Ints(k)
+	return k
+}
+
 // intersect returns the result of the set operation a∩b. It does this using the
 // naive O(n^2) approach rather than using a collection of interval trees
-// since len(a) and len(b) are small.
+// since len(a) and len(b) are small. Each matching pair of events is included as
+// the event in a with the sum of the scores of the pair. Pairs are returned
+// in group order of a and then of b.
 func intersect(a, b map[int]*gff.Feature, thresh float64) []*gff.Feature {
+	ka := keys(a)
+	kb := keys(b)
 	var c []*gff.Feature
-	for ka, ea := range a {
-		for kb, eb := range b {
-			if jaccard(ea, eb) >= thresh {
-				r := strings.TrimRightFunc(ea.FeatAttributes.Get("Repeat"), func(r rune) bool {
-					return r == ' ' || ('0' <= r && r <= '9')
-				})
-				ea.FeatAttributes = gff.Attributes{
-					{Tag: "Group", Value: fmt.Sprint(ka)},
-					{Tag: "GroupOther", Value: fmt.Sprint(kb)},
-					{Tag: "Repeat", Value: r},
-				}
-				c = append(c, ea)
+	for _, i := range ka {
+		ea := a[i]
+		for _, j := range kb {
+			eb := b[j]
+			if events.Jaccard(ea, eb) >= thresh {
+				e := *ea
+				e.FeatAttributes = append(gff.Attributes{
+					{Tag: "Group", Value: fmt.Sprint(i)},
+					{Tag: "GroupOther", Value: fmt.Sprint(j)},
+					{Tag: "Repeat", Value: ea.FeatAttributes.Get("Repeat")},
+				}, retained(ea)...)
+				score := *ea.FeatScore + *eb.FeatScore
+				e.FeatScore = &score
+				c = append(c, &e)
 			}
 		}
 	}
 	return c
 }
 
-func jaccard(a, b *gff.Feature) float64 {
-	n := intersection(a, b)
-	return float64(n) / (float64(a.Len() + b.Len() - n))
-}
-
-func intersection(a, b *gff.Feature) int {
-	if a.SeqName != b.SeqName {
-		return 0
-	}
-	return max(0, min(a.FeatEnd, b.FeatEnd)-max(a.FeatStart, b.FeatStart))
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
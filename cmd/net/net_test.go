@@ -0,0 +1,440 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/io/featio"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/seq"
+
+	"github.com/kortschak/loopy/internal/provenance"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden checks got against the contents of the named file in testdata,
+// writing got to the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// writeFiles writes the named contents to files in a new temporary
+// directory and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "net")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o664)
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func stamped(s provenance.Stamp) string {
+	return "##gff-version 2\n# " + s.String() + "\n"
+}
+
+func TestCheckProvenance(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"press.gff":        stamped(provenance.Stamp{Tool: "press", Thresh: 0.9, Origin: provenance.GFF}),
+		"press-loose.gff":  stamped(provenance.Stamp{Tool: "press", Thresh: 0.95, Origin: provenance.GFF}),
+		"press-global.gff": stamped(provenance.Stamp{Tool: "press-global", Thresh: 0.9, Origin: provenance.ReadOffset}),
+		"deletions.gff":    stamped(provenance.Stamp{Tool: "press-global", Thresh: 0.9, Origin: provenance.GFF}),
+		"plain.gff":        "##gff-version 2\n",
+	})
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		a, b       string
+		thresh     float64
+		wantOrigin int
+		wantErr    string
+	}{
+		{a: "press.gff", b: "press.gff", thresh: 0.9, wantOrigin: provenance.GFF},
+		{a: "press.gff", b: "deletions.gff", thresh: 0.9, wantOrigin: provenance.GFF},
+		{a: "press.gff", b: "press-global.gff", thresh: 0.9, wantErr: "coordinate origin mismatch"},
+		{a: "press-global.gff", b: "press.gff", thresh: 0.9, wantErr: "coordinate origin mismatch"},
+		{a: "press.gff", b: "press-loose.gff", thresh: 0.9, wantErr: "threshold 0.9 less than 0.95"},
+		{a: "press-global.gff", b: "press-global.gff", thresh: 0.9, wantOrigin: provenance.ReadOffset},
+		{a: "plain.gff", b: "press-global.gff", thresh: 0.9, wantOrigin: provenance.ReadOffset},
+		{a: "plain.gff", b: "plain.gff", thresh: 0.9, wantOrigin: 0},
+	} {
+		origin, err := checkProvenance(filepath.Join(dir, test.a), filepath.Join(dir, test.b), test.thresh)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("unexpected error for %s and %s: got:%v want:%q", test.a, test.b, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s and %s: %v", test.a, test.b, err)
+			continue
+		}
+		if origin != test.wantOrigin {
+			t.Errorf("unexpected origin for %s and %s: got:%d want:%d", test.a, test.b, origin, test.wantOrigin)
+		}
+	}
+}
+
+// event returns an event in group g on chr1 spanning [start,end) with the
+// given score as returned by readEvents.
+func event(g, start, end int, score float64) *gff.Feature {
+	return &gff.Feature{
+		SeqName:    "chr1",
+		Source:     "press",
+		Feature:    "insertion",
+		FeatStart:  start,
+		FeatEnd:    end,
+		FeatScore:  &score,
+		FeatStrand: seq.Plus,
+		FeatFrame:  gff.NoFrame,
+		FeatAttributes: gff.Attributes{
+			{Tag: "Group", Value: fmt.Sprint(g)},
+			{Tag: "Repeat", Value: "AluY 1 300"},
+		},
+	}
+}
+
+func TestUnion(t *testing.T) {
+	a := map[int]*gff.Feature{
+		0: event(0, 1000, 1300, 2),
+		1: event(1, 5000, 5300, 3),
+	}
+	b := map[int]*gff.Feature{
+		0: event(0, 9000, 9300, 4),
+		1: event(1, 1000, 1300, 5),
+		2: event(2, 5000, 5300, 6),
+	}
+	got := union(a, b, 0.9)
+
+	type result struct {
+		start int
+		score float64
+		attrs string
+	}
+	var gotResults []result
+	for _, e := range got {
+		var attrs []string
+		for _, a := range e.FeatAttributes {
+			attrs = append(attrs, a.Tag+" "+a.Value)
+		}
+		gotResults = append(gotResults, result{start: e.FeatStart, score: *e.FeatScore, attrs: strings.Join(attrs, ";")})
+	}
+	// Each event in a is written once and the unmatched event
+	// in b is written once, with group 0 of b distinct from
+	// group 0 of a.
+	want := []result{
+		{start: 1000, score: 7, attrs: "GroupA 0;GroupB 1"},
+		{start: 5000, score: 9, attrs: "GroupA 1;GroupB 2"},
+		{start: 9000, score: 4, attrs: "GroupB 0"},
+	}
+	if !reflect.DeepEqual(gotResults, want) {
+		t.Errorf("unexpected union:\ngot: %v\nwant:%v", gotResults, want)
+	}
+}
+
+// tsdA holds two groups of events, the second feature of group 0 carrying
+// a TSD attribute added by catch. tsdB holds an event matching group 1.
+const (
+	tsdA = `##gff-version 2
+chr1	press	insertion	1001	1300	.	+	.	Group 0; Repeat AluY 1 300
+chr1	press	insertion	1001	1302	.	+	.	Group 0; Repeat AluY 1 300; TSD gattacagg 1000 1300 gattacagg "[[0,9)/[0,9)=9]" 9
+chr1	press	insertion	5001	5300	.	+	.	Group 1; Repeat L1 1 300; TSD ccgtta 5000 5300 ccgtta "[[0,6)/[0,6)=6]" 6
+`
+	tsdB = `##gff-version 2
+chr1	press	insertion	5001	5300	.	+	.	Group 0; Repeat L1 1 300
+`
+)
+
+func TestSubKeepAttrs(t *testing.T) {
+	const tsd = `gattacagg 1000 1300 gattacagg "[[0,9)/[0,9)=9]" 9`
+	for _, test := range []struct {
+		keep map[string]bool
+		want string
+	}{
+		{keep: nil, want: ""},
+		{keep: map[string]bool{"TSD": true}, want: tsd},
+	} {
+		a, _, err := readEvents(strings.NewReader(tsdA), 1, test.keep)
+		if err != nil {
+			t.Fatalf("unexpected error reading a: %v", err)
+		}
+		b, _, err := readEvents(strings.NewReader(tsdB), 1, test.keep)
+		if err != nil {
+			t.Fatalf("unexpected error reading b: %v", err)
+		}
+		c := sub(a, b, 0.9)
+		if len(c) != 1 {
+			t.Fatalf("unexpected number of events with keep=%v: got:%d want:1", test.keep, len(c))
+		}
+
+		// Write and read back the result to check that the
+		// attribute survives GFF formatting.
+		var buf bytes.Buffer
+		w := gff.NewWriter(&buf, 60, true)
+		_, err = w.Write(c[0])
+		if err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+		sc := featio.NewScanner(gff.NewReader(&buf))
+		if !sc.Next() {
+			t.Fatalf("failed to read back event: %v", sc.Error())
+		}
+		f := sc.Feat().(*gff.Feature)
+		if got := f.FeatAttributes.Get("Group"); got != "0" {
+			t.Errorf("unexpected group with keep=%v: got:%s want:0", test.keep, got)
+		}
+		if got := f.FeatAttributes.Get("TSD"); got != test.want {
+			t.Errorf("unexpected TSD attribute with keep=%v:\ngot: %s\nwant:%s", test.keep, got, test.want)
+		}
+	}
+}
+
+// scored holds events with scores set by press support, by previous use
+// of net and unset.
+const (
+	scoredA = `##gff-version 2
+chr1	press	insertion	1001	1300	.	+	.	Group 0; Repeat AluY 1 300
+chr1	press	insertion	1001	1300	.	+	.	Group 0; Repeat AluY 1 300
+chr1	net	insertion	5001	5300	2.5	+	.	Group 1; Repeat L1 1 300
+chr1	net	insertion	5001	5300	1.5	+	.	Group 1; Repeat L1 1 300
+chr1	press	insertion	9001	9300	.	+	.	Group 2; Repeat SVA 1 300
+`
+	scoredB = `##gff-version 2
+chr1	press	insertion	1001	1300	.	+	.	Group 0; Repeat AluY 1 300
+chr1	net	insertion	5001	5300	3	+	.	Group 1; Repeat L1 1 300
+chr1	net	insertion	5001	5300	3	+	.	Group 2; Repeat L1 1 300
+chr1	press	insertion	20001	20300	.	+	.	Group 3; Repeat MER 1 300
+`
+)
+
+func TestScores(t *testing.T) {
+	type result struct {
+		start int
+		score float64
+	}
+	for _, test := range []struct {
+		op         string
+		minSupport float64
+		want       []result
+	}{
+		{
+			// Unmatched events in a retain their scores.
+			op:   "sub",
+			want: []result{{start: 9000, score: 1}},
+		},
+		{
+			// Events in a gain the scores of each matched
+			// event in b, and unmatched events in b retain
+			// their scores.
+			op: "union",
+			want: []result{
+				{start: 1000, score: 2 + 1},
+				{start: 5000, score: 4 + 3 + 3},
+				{start: 9000, score: 1},
+				{start: 20000, score: 1},
+			},
+		},
+		{
+			// Each matched pair is written with the sum of
+			// the scores of the pair.
+			op: "intersect",
+			want: []result{
+				{start: 1000, score: 2 + 1},
+				{start: 5000, score: 4 + 3},
+				{start: 5000, score: 4 + 3},
+			},
+		},
+		{
+			// Groups with support less than min-support
+			// do not take part.
+			op:         "union",
+			minSupport: 2,
+			want: []result{
+				{start: 1000, score: 2},
+				{start: 5000, score: 4 + 3 + 3},
+			},
+		},
+	} {
+		minSupport := test.minSupport
+		if minSupport == 0 {
+			minSupport = 1
+		}
+		a, _, err := readEvents(strings.NewReader(scoredA), minSupport, nil)
+		if err != nil {
+			t.Fatalf("unexpected error reading a: %v", err)
+		}
+		b, _, err := readEvents(strings.NewReader(scoredB), minSupport, nil)
+		if err != nil {
+			t.Fatalf("unexpected error reading b: %v", err)
+		}
+		var c []*gff.Feature
+		switch test.op {
+		case "sub":
+			c = sub(a, b, 0.9)
+		case "union":
+			c = union(a, b, 0.9)
+		case "intersect":
+			c = intersect(a, b, 0.9)
+		}
+		var got []result
+		for _, e := range c {
+			got = append(got, result{start: e.FeatStart, score: *e.FeatScore})
+		}
+		sort.Slice(got, func(i, j int) bool {
+			if got[i].start != got[j].start {
+				return got[i].start < got[j].start
+			}
+			return got[i].score < got[j].score
+		})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected scores for %s with min-support=%v:\ngot: %v\nwant:%v", test.op, minSupport, got, test.want)
+		}
+	}
+}
+
+func TestNetGolden(t *testing.T) {
+	defer func(o string, s float64) { *op, *minSupport = o, s }(*op, *minSupport)
+
+	// Groups 0 and 1 of a match groups 1 and 0 of b.
+	// Group 3 of a overlaps group 2 of b below the
+	// threshold. Group 3 of b is on a contig not in a.
+	for _, test := range []struct {
+		op         string
+		minSupport float64
+		keep       map[string]bool
+		golden     string
+	}{
+		{op: "sub", golden: "sub.gff"},
+		{op: "union", golden: "union.gff"},
+		{op: "intersect", golden: "intersect.gff"},
+		{op: "sub", minSupport: 2, golden: "sub-support.gff"},
+		{op: "union", keep: map[string]bool{"TSD": true}, golden: "union-tsd.gff"},
+	} {
+		*op = test.op
+		*minSupport = test.minSupport
+		if *minSupport == 0 {
+			*minSupport = 1
+		}
+		a, err := os.Open(filepath.Join("testdata", "a.gff"))
+		if err != nil {
+			t.Fatalf("failed to open a: %v", err)
+		}
+		b, err := os.Open(filepath.Join("testdata", "b.gff"))
+		if err != nil {
+			t.Fatalf("failed to open b: %v", err)
+		}
+		var buf bytes.Buffer
+		err = net(&buf, a, b, "a.gff", "b.gff", provenance.GFF, test.keep)
+		a.Close()
+		b.Close()
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.golden, err)
+		}
+		golden(t, test.golden, buf.Bytes())
+	}
+}
+
+func TestNetComments(t *testing.T) {
+	defer func(o string, th, s float64) { *op, *thresh, *minSupport = o, th, s }(*op, *thresh, *minSupport)
+
+	for _, test := range []struct {
+		op           string
+		thresh       float64
+		minSupport   float64
+		origin       int
+		nameA, nameB string
+		want         string
+	}{
+		{
+			op: "sub", thresh: 0.9, minSupport: 1, origin: provenance.GFF,
+			nameA: "a.gff", nameB: "b.gff",
+			want: `# op=sub thresh=0.9 min-support=1 a="a.gff" b="b.gff"`,
+		},
+		{
+			op: "union", thresh: 0.95, minSupport: 2.5, origin: provenance.ReadOffset,
+			nameA: "run 1/a.gff", nameB: `b"2".gff`,
+			want: `# op=union thresh=0.95 min-support=2.5 a="run 1/a.gff" b="b\"2\".gff"`,
+		},
+		{
+			op: "intersect", thresh: 1, minSupport: 3, origin: 0,
+			nameA: "a.gff", nameB: "a.gff",
+			want: `# op=intersect thresh=1 min-support=3 a="a.gff" b="a.gff"`,
+		},
+	} {
+		*op = test.op
+		*thresh = test.thresh
+		*minSupport = test.minSupport
+
+		var buf bytes.Buffer
+		err := net(&buf, strings.NewReader(scoredA), strings.NewReader(scoredB), test.nameA, test.nameB, test.origin, nil)
+		if err != nil {
+			t.Fatalf("unexpected error for op=%s: %v", test.op, err)
+		}
+		out := buf.String()
+
+		// The comments follow the GFF version line.
+		lines := strings.SplitN(out, "\n", 4)
+		if len(lines) < 4 {
+			t.Fatalf("short output for op=%s:\n%s", test.op, out)
+		}
+		stamp := provenance.Stamp{Tool: "net", Thresh: test.thresh, Origin: test.origin}
+		want := []string{"##gff-version 2", "# " + stamp.String(), test.want}
+		if !reflect.DeepEqual(lines[:3], want) {
+			t.Errorf("unexpected header for op=%s:\ngot: %q\nwant:%q", test.op, lines[:3], want)
+		}
+
+		// The stamp is readable by later pipeline steps.
+		got, ok, err := provenance.Read(strings.NewReader(out))
+		if err != nil || !ok {
+			t.Errorf("failed to read provenance for op=%s: ok=%t err=%v", test.op, ok, err)
+		} else if got != stamp {
+			t.Errorf("unexpected provenance for op=%s: got:%+v want:%+v", test.op, got, stamp)
+		}
+
+		// The comments do not interfere with reading
+		// the output as input to net. Union output has
+		// GroupA and GroupB attributes in place of Group,
+		// so it cannot be read back.
+		if test.op == "union" {
+			continue
+		}
+		_, _, err = readEvents(strings.NewReader(out), 1, nil)
+		if err != nil {
+			t.Errorf("unexpected error reading output of op=%s: %v", test.op, err)
+		}
+	}
+}
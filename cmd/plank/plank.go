@@ -3,22 +3,28 @@
 // license that can be found in the LICENSE file.
 
 // plank drops GFF lines from stdin containing Read attributes in
-// the exclude parameter file.
+// the exclude parameter file. Each line of the exclude file is a whole
+// Read attribute value, the read name and the event's read coordinates.
 package main
 
 import (
 	"bufio"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/internal/output"
 )
 
 var (
 	exclude = flag.String("exclude", "", "specify file containing excluded reads")
 	retain  = flag.Bool("retain", false, "write excluded reads to stderr")
+	gz      = flag.Bool("gzip", false, "gzip compress the GFF output")
 )
 
 func main() {
@@ -28,44 +34,70 @@ func main() {
 		os.Exit(1)
 	}
 
-	nameSet := make(map[string]struct{})
 	f, err := os.Open(*exclude)
 	if err != nil {
 		log.Fatalf("failed to open exclude file %q: %v", *exclude, err)
 	}
-	ls := bufio.NewScanner(f)
-	for ls.Scan() {
-		nameSet[ls.Text()] = struct{}{}
-	}
-	err = ls.Err()
+	nameSet, err := readNames(f)
+	f.Close()
 	if err != nil {
 		log.Fatalf("failed to read exclude file: %v", err)
 	}
 
-	w := gff.NewWriter(os.Stdout, 60, true)
-	var excl *gff.Writer
+	out := output.Stdout(*gz)
+	var excl io.Writer
 	if *retain {
-		excl = gff.NewWriter(os.Stderr, 60, true)
+		excl = os.Stderr
+	}
+	err = plank(out, excl, os.Stdin, nameSet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
+
+// readNames returns the set of lines in r.
+func readNames(r io.Reader) (map[string]struct{}, error) {
+	nameSet := make(map[string]struct{})
+	ls := bufio.NewScanner(r)
+	for ls.Scan() {
+		nameSet[ls.Text()] = struct{}{}
+	}
+	return nameSet, ls.Err()
+}
+
+// plank writes the features in the GFF stream src to dst unless their Read
+// attribute is in exclude. Excluded features are written to excl if it is
+// not nil.
+func plank(dst, excl io.Writer, src io.Reader, exclude map[string]struct{}) error {
+	w := gff.NewWriter(dst, 60, true)
+	var xw *gff.Writer
+	if excl != nil {
+		xw = gff.NewWriter(excl, 60, true)
 	}
-	sc := featio.NewScanner(gff.NewReader(os.Stdin))
+	sc := featio.NewScanner(gff.NewReader(src))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
 		n := f.FeatAttributes.Get("Read")
-		if _, ok := nameSet[n]; ok {
-			if excl != nil {
-				_, err := excl.Write(f)
+		if _, ok := exclude[n]; ok {
+			if xw != nil {
+				_, err := xw.Write(f)
 				if err != nil {
-					log.Fatalf("failed to write feature: %v", err)
+					return fmt.Errorf("failed to write feature: %v", err)
 				}
 			}
 			continue
 		}
 		_, err := w.Write(f)
 		if err != nil {
-			log.Fatalf("failed to write feature: %v", err)
+			return fmt.Errorf("failed to write feature: %v", err)
 		}
 	}
 	if err := sc.Error(); err != nil {
-		log.Fatalf("error during gff read: %v", err)
+		return fmt.Errorf("error during gff read: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,72 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden checks got against the contents of the named file in testdata,
+// writing got to the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestPlankGolden(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "exclude.txt"))
+	if err != nil {
+		t.Fatalf("failed to open exclude file: %v", err)
+	}
+	exclude, err := readNames(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("unexpected error reading exclude file: %v", err)
+	}
+
+	// The exclude file holds the whole Read attribute
+	// value of two events and the bare name of the
+	// read of a third, which does not exclude it.
+	for _, retain := range []bool{false, true} {
+		f, err := os.Open(filepath.Join("testdata", "events.gff"))
+		if err != nil {
+			t.Fatalf("failed to open events: %v", err)
+		}
+		var kept, excl bytes.Buffer
+		if retain {
+			err = plank(&kept, &excl, f, exclude)
+		} else {
+			err = plank(&kept, nil, f, exclude)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error with retain=%t: %v", retain, err)
+		}
+		golden(t, "kept.gff", kept.Bytes())
+		if retain {
+			golden(t, "excluded.gff", excl.Bytes())
+		}
+	}
+}
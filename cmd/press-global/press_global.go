@@ -7,120 +7,382 @@
 //
 // The arguments for press-global differ from press in that the input
 // on stdin is the set of reefer results, rather than the censor features.
+//
+// With -del, deletions are considered identical when both of their
+// reference breakpoints lie within -del-dist of each other, rather than
+// by jaccard similarity; the -thresh and -curve options then apply to an
+// edge weight of either zero or one. The written deletion coordinates are
+// shrunk by half the length of the read interval of the deletion.
+//
+// Insertion coordinates are the reference start of the reefer feature
+// offset by the one-based read start of its Read attribute, and so lie one
+// base to the right of coordinates derived as press does. The provenance
+// comment records this origin so that net refuses to combine insertion
+// output from press and press-global. Deletion coordinates are reference
+// coordinates.
+//
+// With -ref, event coordinates are clamped to the bounds of their contig
+// and clamped events are given a Clamped attribute holding the unclamped
+// start and end.
+//
+// Events on different contigs are never grouped together, so with -threads
+// greater than one the similarity graph of each contig is built, and its
+// connected components found, concurrently. Output is the same for any
+// number of threads.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"golang.org/x/exp/rand"
 
 	"gonum.org/v1/gonum/graph"
-	"gonum.org/v1/gonum/graph/iterator"
 	"gonum.org/v1/gonum/graph/simple"
-	"gonum.org/v1/gonum/graph/topo"
 
+	"github.com/biogo/biogo/feat"
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/io/seqio/fai"
 	"github.com/biogo/biogo/seq"
 	"github.com/biogo/store/interval"
+
+	"github.com/kortschak/loopy/cluster"
+	"github.com/kortschak/loopy/events"
+	"github.com/kortschak/loopy/internal/group"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/provenance"
+	"github.com/kortschak/loopy/internal/sequtil"
 )
 
 var (
 	thresh   = flag.Float64("thresh", 0.90, "specify minumum jaccard similarity for identity between events")
 	curve    = flag.String("curve", "", "specify the tsv output file for threshold response")
-	gffOut   = flag.String("gff", "", "specify the gff output file for remapping")
+	compat   = flag.Bool("curve-compat", false, "write only the thresh and reduction columns to the -curve file")
+	gffOut   = flag.String("gff", "", "specify the gff output file for remapping (gzip compressed if the name ends in .gz)")
 	deletion = flag.Bool("del", false, "specify that the input are deletions")
+	delDist  = flag.Int("del-dist", 20, "specify maximum breakpoint distance for identity between deletions (with -del)")
+	dedup    = flag.Bool("dedup", true, "remove exact duplicate features before grouping")
+	summary  = flag.Bool("summarize", false, "write one feature spanning each group with a Support attribute instead of each member")
+	ref      = flag.String("ref", "", "specify a reference fasta or fai index for clamping event coordinates to contig bounds")
+	threads  = flag.Int("threads", 1, "number of contigs to process concurrently")
+	seed     = flag.Uint64("seed", 1, "specify the random seed for louvain clustering")
+
+	dupContigs = flag.Bool("allow-dup-contigs", false, "log duplicate reference sequence names in -ref fasta instead of failing")
+
+	method = cluster.Components
 )
 
 func main() {
+	flag.Var(&method, "cluster", `specify clustering method ("components" or "louvain")`)
 	flag.Parse()
 
-	var v []*gff.Feature
-	trees := make(map[string]*interval.IntTree)
+	var lengths map[string]int
+	if *ref != "" {
+		var err error
+		lengths, err = readLengths(*ref)
+		if err != nil {
+			log.Fatalf("failed to read reference lengths: %v", err)
+		}
+	}
+
+	ev, err := readEvents(os.Stdin, lengths)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dedup {
+		log.Printf("removed %d duplicate features", ev.dups)
+	}
+	v := ev.v
 
-	sc := featio.NewScanner(gff.NewReader(os.Stdin))
+	blocks := buildBlocks(ev.cmp, ev.trees, *thresh, max(1, *threads))
+	cc, q := clusterBlocks(blocks, *thresh, method, max(1, *threads))
+	fmt.Printf("number of unique events = %d, total number of nodes = %d, modularity = %f\n", len(cc), len(v), q.Q())
+	if *gffOut != "" {
+		gf, err := output.Create(*gffOut)
+		if err != nil {
+			log.Fatalf("failed to create gff file %q: %v", *gffOut, err)
+		}
+		w := gff.NewWriter(gf, 60, true)
+		w.WriteComment("Right coordinates (field 5) and strand (field 7) are hypothetical.")
+		origin := provenance.ReadOffset
+		if *deletion {
+			origin = provenance.GFF
+		}
+		w.WriteComment(provenance.Stamp{Tool: "press-global", Thresh: *thresh, Origin: origin}.String())
+		for i, c := range cc {
+			if *summary {
+				w.Write(group.Summarize(c, v, i))
+				continue
+			}
+			for _, e := range c {
+				f := v[e.ID()]
+				f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{Tag: "Group", Value: fmt.Sprint(i)})
+				w.Write(f)
+			}
+		}
+		err = gf.Close()
+		if err != nil {
+			log.Fatalf("failed to close gff file %q: %v", *gffOut, err)
+		}
+	}
+
+	if *curve != "" {
+		cf, err := os.Create(*curve)
+		if err != nil {
+			log.Fatalf("failed to create curve file %q: %v", *curve, err)
+		}
+		if *compat {
+			fmt.Fprintln(cf, "thresh\treduction")
+		} else {
+			fmt.Fprintln(cf, "thresh\treduction\tcomponents\tlargest")
+		}
+		for t := 0.05; t < 1.04; t += 0.05 {
+			c, _ := clusterBlocks(blocks, t, method, max(1, *threads))
+			fmt.Fprintf(cf, "%.2f\t%f", t, 1-float64(len(c))/float64(len(v)))
+			if !*compat {
+				var largest int
+				for _, n := range c {
+					largest = max(largest, len(n))
+				}
+				fmt.Fprintf(cf, "\t%d\t%d", len(c), largest)
+			}
+			fmt.Fprintln(cf)
+		}
+		cf.Close()
+	}
+}
+
+// eventSet holds the events read from reefer output.
+type eventSet struct {
+	// v holds the events in base coordinates
+	// as they are written.
+	v []*gff.Feature
+
+	// cmp holds the features compared to find
+	// similar events. For insertions these are
+	// the features in v. The written coordinates
+	// of a deletion are shrunk by half the length
+	// of its read interval, so deletions are
+	// instead compared by their reference
+	// breakpoints.
+	cmp []*gff.Feature
+
+	// trees holds the interval trees of the
+	// features in cmp for each contig.
+	trees map[string]*interval.IntTree
+
+	// dups is the number of exact duplicate
+	// features removed with -dedup.
+	dups int
+}
+
+// readEvents returns the events in the reefer GFF output read from r. If
+// lengths is not nil, event coordinates are clamped to the contig lengths
+// it holds.
+func readEvents(r io.Reader, lengths map[string]int) (*eventSet, error) {
+	ev := eventSet{trees: make(map[string]*interval.IntTree)}
+	seen := make(map[dupKey]bool)
+	sc := featio.NewScanner(gff.NewReader(r))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
 		fields := strings.Fields(f.FeatAttributes.Get("Read"))
 		if len(fields) != 3 {
-			log.Fatalf("bad record: %+v", f)
+			return nil, fmt.Errorf("bad record: %+v", f)
 		}
 		var err error
 		e := *f
 		e.FeatStart, err = strconv.Atoi(fields[1])
 		if err != nil {
-			log.Fatalf("bad record: %+v: %v", f, err)
+			return nil, fmt.Errorf("bad record: %+v: %v", f, err)
 		}
 		e.FeatEnd, err = strconv.Atoi(fields[2])
 		if err != nil {
-			log.Fatalf("bad record: %+v: %v", f, err)
+			return nil, fmt.Errorf("bad record: %+v: %v", f, err)
 		}
 		b := baseCoordsOf(&e, f, *deletion)
-		t, ok := trees[b.SeqName]
+		if lengths != nil {
+			clamp(b, lengths)
+		}
+		if *dedup {
+			k := dupKey{
+				contig: b.SeqName,
+				start:  b.FeatStart,
+				end:    b.FeatEnd,
+				strand: b.FeatStrand,
+				read:   fields[0],
+			}
+			if seen[k] {
+				ev.dups++
+				continue
+			}
+			seen[k] = true
+		}
+		c := b
+		if *deletion {
+			c = f
+		}
+		t, ok := ev.trees[c.SeqName]
 		if !ok {
 			t = &interval.IntTree{}
-			trees[b.SeqName] = t
+			ev.trees[c.SeqName] = t
 		}
-		t.Insert(gffInterval{id: uintptr(len(v)), Feature: b}, true)
-		v = append(v, b)
+		t.Insert(gffInterval{id: uintptr(len(ev.v)), Feature: c}, true)
+		ev.v = append(ev.v, b)
+		ev.cmp = append(ev.cmp, c)
 	}
 	if err := sc.Error(); err != nil {
-		log.Fatalf("error during gff read: %v", err)
+		return nil, fmt.Errorf("error during gff read: %v", err)
 	}
-	for _, t := range trees {
+	for _, t := range ev.trees {
 		t.AdjustRanges()
 	}
+	return &ev, nil
+}
+
+// block is the similarity graph of the events on a single contig.
+// Since events on different contigs have no similarity, the complete
+// similarity graph is the disjoint union of the blocks.
+type block struct {
+	nodes []int
+	g     cluster.ThresholdGraph
+}
+
+// buildBlocks returns the similarity graph blocks for the events in v
+// using the per-contig interval trees in trees. Node IDs are the indexes
+// of the events in v. Blocks are built concurrently by the given number
+// of workers and are returned in the order of the first event on each
+// contig.
+func buildBlocks(v []*gff.Feature, trees map[string]*interval.IntTree, thresh float64, workers int) []block {
+	var blocks []block
+	index := make(map[string]int)
+	for i, f := range v {
+		b, ok := index[f.SeqName]
+		if !ok {
+			b = len(blocks)
+			index[f.SeqName] = b
+			blocks = append(blocks, block{g: cluster.NewThresholdGraph(thresh)})
+		}
+		blocks[b].nodes = append(blocks[b].nodes, i)
+	}
+
+	work := make(chan *block)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range work {
+				addEdges(b, v, trees)
+			}
+		}()
+	}
+	for i := range blocks {
+		work <- &blocks[i]
+	}
+	close(work)
+	wg.Wait()
+	return blocks
+}
 
-	g := thresholdGraph{WeightedUndirectedGraph: simple.NewWeightedUndirectedGraph(1, 0), thresh: *thresh}
-	for i, from := range v {
-		if g.Node(int64(i)) == nil {
-			g.AddNode(simple.Node(i))
+// addEdges adds the nodes of b and the weighted edges between them to
+// the graph of b.
+func addEdges(b *block, v []*gff.Feature, trees map[string]*interval.IntTree) {
+	for _, i := range b.nodes {
+		from := v[i]
+		if b.g.Node(int64(i)) == nil {
+			b.g.AddNode(simple.Node(i))
 		}
-		for _, _to := range trees[from.SeqName].Get(gffInterval{Feature: from}) {
+		query := from
+		if *deletion {
+			// Deletions may be close without overlapping,
+			// so widen the search by the breakpoint distance.
+			window := *from
+			window.FeatStart -= *delDist
+			window.FeatEnd += *delDist
+			query = &window
+		}
+		for _, _to := range trees[from.SeqName].Get(gffInterval{Feature: query}) {
 			to := _to.(gffInterval)
 			if from == to.Feature {
 				continue
 			}
-			jac := jaccard(from, to.Feature)
-			if jac > 0 {
-				g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(i), T: simple.Node(to.id), W: jac})
+			var w float64
+			if *deletion {
+				w = breakpoints(from, to.Feature, *delDist)
+			} else {
+				w = events.Jaccard(from, to.Feature)
+			}
+			if w > 0 {
+				b.g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(i), T: simple.Node(to.id), W: w})
 			}
 		}
 	}
+}
 
-	cc := topo.ConnectedComponents(g)
-	fmt.Printf("number of unique events = %d, total number of nodes = %d\n", len(cc), g.Nodes().Len())
-	if *gffOut != "" {
-		gf, err := os.Create(*gffOut)
-		if err != nil {
-			log.Fatalf("failed to create gff file %q: %v", *gffOut, err)
-		}
-		w := gff.NewWriter(gf, 60, true)
-		w.WriteComment("Right coordinates (field 5) and strand (field 7) are hypothetical.")
-		for i, c := range cc {
-			for _, e := range c {
-				f := v[e.ID()]
-				f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{Tag: "Group", Value: fmt.Sprint(i)})
-				w.Write(f)
+// clusterBlocks returns the groups of events in the blocks at the given
+// threshold and the modularity of the grouping. The groups are ordered
+// as they would be by clustering the complete graph. Connected components
+// are found concurrently by the given number of workers. Louvain
+// clustering is performed on the complete graph with a single source
+// seeded by -seed so that its result does not depend on the number of
+// workers.
+func clusterBlocks(blocks []block, thresh float64, m cluster.Method, workers int) ([][]graph.Node, *cluster.Modularity) {
+	var q cluster.Modularity
+	if m != cluster.Components {
+		g := cluster.NewThresholdGraph(thresh)
+		for _, b := range blocks {
+			nodes := b.g.Nodes()
+			for nodes.Next() {
+				g.AddNode(nodes.Node())
+			}
+			edges := b.g.WeightedEdges()
+			for edges.Next() {
+				g.SetWeightedEdge(edges.WeightedEdge())
 			}
 		}
-		gf.Close()
+		cc := cluster.Cluster(g, m, rand.NewSource(*seed))
+		q.Add(g, cc)
+		return cc, &q
 	}
 
-	if *curve != "" {
-		cf, err := os.Create(*curve)
-		if err != nil {
-			log.Fatalf("failed to create curve file %q: %v", *curve, err)
-		}
-		fmt.Fprintln(cf, "thresh\treduction")
-		for g.thresh = 0.05; g.thresh < 1.04; g.thresh += 0.05 {
-			fmt.Fprintf(cf, "%.2f\t%f\n", g.thresh, 1-float64(len(topo.ConnectedComponents(g)))/float64(g.Nodes().Len()))
-		}
-		cf.Close()
+	groups := make([][][]graph.Node, len(blocks))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				g := blocks[i].g
+				g.Thresh = thresh
+				groups[i] = cluster.Cluster(g, m, nil)
+			}
+		}()
 	}
+	for i := range blocks {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var cc [][]graph.Node
+	for i, c := range groups {
+		g := blocks[i].g
+		g.Thresh = thresh
+		q.Add(g, c)
+		cc = append(cc, c...)
+	}
+	sort.Slice(cc, func(i, j int) bool { return cc[i][0].ID() < cc[j][0].ID() })
+	return cc, &q
 }
 
 func baseCoordsOf(f, ref *gff.Feature, isDeletion bool) *gff.Feature {
@@ -141,6 +403,54 @@ func baseCoordsOf(f, ref *gff.Feature, isDeletion bool) *gff.Feature {
 	return &b
 }
 
+// clamp clamps the coordinates of f to the bounds of its contig if the
+// contig length is known, adding a Clamped attribute holding the
+// unclamped coordinates if f was out of bounds.
+func clamp(f *gff.Feature, lengths map[string]int) {
+	n, ok := lengths[f.SeqName]
+	if !ok || n == 0 {
+		return
+	}
+	if f.FeatStart >= 0 && f.FeatEnd <= n {
+		return
+	}
+	orig := fmt.Sprintf("%d %d", feat.ZeroToOne(f.FeatStart), f.FeatEnd)
+	f.FeatStart = min(max(0, f.FeatStart), n-1)
+	f.FeatEnd = max(min(n, f.FeatEnd), f.FeatStart+1)
+	f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{Tag: "Clamped", Value: orig})
+}
+
+// readLengths returns the lengths of the contigs in the named fasta
+// file, or fai index if the file name has a .fai extension.
+func readLengths(path string) (map[string]int, error) {
+	if filepath.Ext(path) != ".fai" {
+		return sequtil.ContigLengths(path, *dupContigs)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx, err := fai.ReadFrom(f)
+	if err != nil {
+		return nil, err
+	}
+	lengths := make(map[string]int, len(idx))
+	for name, r := range idx {
+		lengths[name] = r.Length
+	}
+	return lengths, nil
+}
+
+// dupKey identifies features that are exact duplicates.
+type dupKey struct {
+	contig     string
+	start, end int
+	strand     seq.Strand
+	read       string
+}
+
 type gffInterval struct {
 	id uintptr
 	*gff.Feature
@@ -154,16 +464,25 @@ func (i gffInterval) Overlap(b interval.IntRange) bool {
 	return i.FeatEnd > b.Start && i.FeatStart < b.End
 }
 
-func jaccard(a, b *gff.Feature) float64 {
-	n := intersection(a, b)
-	return float64(n) / (float64(a.Len() + b.Len() - n))
-}
-
-func intersection(a, b *gff.Feature) int {
+// breakpoints returns 1 if both the start and end breakpoints of a
+// and b are within dist of each other and 0 otherwise. Deletions are
+// defined by their reference breakpoints, and jaccard similarity is
+// noisy for the short intervals that represent them.
+func breakpoints(a, b *gff.Feature, dist int) float64 {
 	if a.SeqName != b.SeqName {
 		return 0
 	}
-	return max(0, min(a.FeatEnd, b.FeatEnd)-max(a.FeatStart, b.FeatStart))
+	if abs(a.FeatStart-b.FeatStart) > dist || abs(a.FeatEnd-b.FeatEnd) > dist {
+		return 0
+	}
+	return 1
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
 }
 
 func min(a, b int) int {
@@ -179,53 +498,3 @@ func max(a, b int) int {
 	}
 	return b
 }
-
-// thesholdGraph is an undirected graph where edges must be above
-// a given threshold to be returned or traversed.
-type thresholdGraph struct {
-	*simple.WeightedUndirectedGraph
-	thresh float64
-}
-
-// From returns all nodes in g that can be reached directly from n.
-func (g thresholdGraph) From(n int64) graph.Nodes {
-	if g.Node(n) == nil {
-		return nil
-	}
-
-	var nodes []graph.Node
-	for _, to := range graph.NodesOf(g.WeightedUndirectedGraph.From(n)) {
-		if g.HasEdgeBetween(n, to.ID()) {
-			nodes = append(nodes, to)
-		}
-	}
-
-	return iterator.NewOrderedNodes(nodes)
-}
-
-// HasEdgeBetween returns whether an edge exists between nodes x and y.
-func (g thresholdGraph) HasEdgeBetween(x, y int64) bool {
-	if !g.WeightedUndirectedGraph.HasEdgeBetween(x, y) {
-		return false
-	}
-	w, _ := g.Weight(x, y)
-	return w >= g.thresh
-}
-
-// Edge returns the edge from u to v if such an edge exists and nil otherwise.
-// The node v must be directly reachable from u as defined by the From method.
-func (g thresholdGraph) Edge(u, v int64) graph.Edge {
-	return g.EdgeBetween(u, v)
-}
-
-// EdgeBetween returns the edge between nodes x and y.
-func (g thresholdGraph) EdgeBetween(x, y int64) graph.Edge {
-	e := g.WeightedUndirectedGraph.EdgeBetween(x, y)
-	if e == nil {
-		return nil
-	}
-	if w, _ := g.Weight(x, y); w < g.thresh {
-		return nil
-	}
-	return e
-}
@@ -0,0 +1,255 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/rand"
+
+	"gonum.org/v1/gonum/graph"
+
+	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/cluster"
+)
+
+// groups returns the IDs of the nodes in each group of c.
+func groups(c [][]graph.Node) [][]int64 {
+	var ids [][]int64
+	for _, n := range c {
+		var g []int64
+		for _, u := range n {
+			g = append(g, u.ID())
+		}
+		ids = append(ids, g)
+	}
+	return ids
+}
+
+// deletions holds two deletions of slightly different lengths at the
+// same locus, and a third deletion at a nearby locus. The read intervals
+// of the first two deletions differ in length, so their written
+// coordinates are shrunk by different amounts.
+const deletions = `##gff-version 2
+chr1	reefer	discordance	1001	1300	.	+	.	Read a 991 1010
+chr1	reefer	discordance	1006	1302	.	-	.	Read b 951 1050
+chr1	reefer	discordance	1101	1400	.	+	.	Read c 991 1010
+`
+
+func TestDeletionBreakpoints(t *testing.T) {
+	defer func(d bool, dist int) { *deletion, *delDist = d, dist }(*deletion, *delDist)
+	*deletion = true
+	*delDist = 10
+
+	ev, err := readEvents(strings.NewReader(deletions), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The written coordinates are shrunk.
+	var got [][2]int
+	for _, f := range ev.v {
+		got = append(got, [2]int{f.FeatStart, f.FeatEnd})
+	}
+	want := [][2]int{{1009, 1291}, {1054, 1253}, {1109, 1391}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected written coordinates: got:%v want:%v", got, want)
+	}
+
+	blocks := buildBlocks(ev.cmp, ev.trees, *thresh, 1)
+	c, _ := clusterBlocks(blocks, *thresh, method, 1)
+	gotGroups := groups(c)
+	wantGroups := [][]int64{{0, 1}, {2}}
+	if !reflect.DeepEqual(gotGroups, wantGroups) {
+		t.Errorf("unexpected deletion groups: got:%v want:%v", gotGroups, wantGroups)
+	}
+}
+
+// triplicate holds three identical insertion events from the same read
+// and an insertion at the same locus from a different read.
+const triplicate = `##gff-version 2
+chr1	reefer	discordance	5001	5001	.	+	.	Read a 101 300
+chr1	reefer	discordance	5001	5001	.	+	.	Read a 101 300
+chr1	reefer	discordance	5001	5001	.	+	.	Read a 101 300
+chr1	reefer	discordance	5001	5001	.	+	.	Read b 101 300
+`
+
+func TestDedup(t *testing.T) {
+	defer func(d bool) { *dedup = d }(*dedup)
+	for _, test := range []struct {
+		dedup     bool
+		wantNodes int
+		wantDups  int
+	}{
+		{dedup: true, wantNodes: 2, wantDups: 2},
+		{dedup: false, wantNodes: 4, wantDups: 0},
+	} {
+		*dedup = test.dedup
+		ev, err := readEvents(strings.NewReader(triplicate), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ev.dups != test.wantDups {
+			t.Errorf("unexpected number of duplicates removed with dedup=%t: got:%d want:%d",
+				test.dedup, ev.dups, test.wantDups)
+		}
+		if len(ev.v) != test.wantNodes {
+			t.Errorf("unexpected number of events with dedup=%t: got:%d want:%d",
+				test.dedup, len(ev.v), test.wantNodes)
+		}
+		var reads []string
+		for _, f := range ev.v {
+			reads = append(reads, strings.Fields(f.FeatAttributes.Get("Read"))[0])
+		}
+		if test.dedup && !reflect.DeepEqual(reads, []string{"a", "b"}) {
+			t.Errorf("unexpected surviving reads with dedup=%t: got:%v want:[a b]", test.dedup, reads)
+		}
+
+		var nodes int
+		for _, b := range buildBlocks(ev.cmp, ev.trees, *thresh, 1) {
+			nodes += b.g.Nodes().Len()
+		}
+		if nodes != test.wantNodes {
+			t.Errorf("unexpected number of graph nodes with dedup=%t: got:%d want:%d",
+				test.dedup, nodes, test.wantNodes)
+		}
+	}
+}
+
+// insertions holds an insertion near the end of a contig and an
+// insertion within the contig bounds.
+const insertions = `##gff-version 2
+chr1	reefer	discordance	9901	9901	.	+	.	Read a 101 300
+chr1	reefer	discordance	5001	5001	.	+	.	Read b 101 300
+`
+
+func TestClamp(t *testing.T) {
+	ev, err := readEvents(strings.NewReader(insertions), map[string]int{"chr1": 10100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, test := range []struct {
+		start, end int
+		clamped    string
+	}{
+		{start: 10001, end: 10100, clamped: "10002 10200"},
+		{start: 5101, end: 5300},
+	} {
+		f := ev.v[i]
+		if f.FeatStart != test.start || f.FeatEnd != test.end {
+			t.Errorf("unexpected coordinates for event %d: got:%d-%d want:%d-%d",
+				i, f.FeatStart, f.FeatEnd, test.start, test.end)
+		}
+		if got := f.FeatAttributes.Get("Clamped"); got != test.clamped {
+			t.Errorf("unexpected Clamped attribute for event %d: got:%q want:%q", i, got, test.clamped)
+		}
+	}
+
+	// Without lengths, coordinates are not clamped.
+	ev, err = readEvents(strings.NewReader(insertions), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f := ev.v[0]; f.FeatEnd != 10200 || f.FeatAttributes.Get("Clamped") != "" {
+		t.Errorf("unexpected clamping without lengths: %+v", f)
+	}
+}
+
+func TestClampBounds(t *testing.T) {
+	for _, test := range []struct {
+		start, end int
+		wantStart  int
+		wantEnd    int
+		clamped    string
+	}{
+		{start: -10, end: 50, wantStart: 0, wantEnd: 50, clamped: "-10 50"},
+		{start: -10, end: -5, wantStart: 0, wantEnd: 1, clamped: "-10 -5"},
+		{start: 120, end: 150, wantStart: 99, wantEnd: 100, clamped: "121 150"},
+		{start: 10, end: 100, wantStart: 10, wantEnd: 100},
+	} {
+		f := &gff.Feature{SeqName: "chr1", FeatStart: test.start, FeatEnd: test.end}
+		clamp(f, map[string]int{"chr1": 100})
+		if f.FeatStart != test.wantStart || f.FeatEnd != test.wantEnd {
+			t.Errorf("unexpected clamped coordinates for %d-%d: got:%d-%d want:%d-%d",
+				test.start, test.end, f.FeatStart, f.FeatEnd, test.wantStart, test.wantEnd)
+		}
+		if got := f.FeatAttributes.Get("Clamped"); got != test.clamped {
+			t.Errorf("unexpected Clamped attribute for %d-%d: got:%q want:%q", test.start, test.end, got, test.clamped)
+		}
+	}
+}
+
+// randomEvents returns n insertion events in reefer GFF format placed
+// around a few hot spots on each of several contigs, with the contigs
+// interleaved in the order of the events.
+func randomEvents(n int, src rand.Source) string {
+	rnd := rand.New(src)
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "##gff-version 2")
+	for i := 0; i < n; i++ {
+		contig := rnd.Intn(5)
+		pos := 10000*(1+rnd.Intn(4)) + rnd.Intn(200)
+		start := rnd.Intn(1000)
+		end := start + 200 + rnd.Intn(200)
+		fmt.Fprintf(&buf, "chr%d\treefer\tdiscordance\t%d\t%d\t.\t+\t.\tRead r%d %d %d\n",
+			contig, pos, pos+1, i, start, end)
+	}
+	return buf.String()
+}
+
+func TestClusterDeterminism(t *testing.T) {
+	ev, err := readEvents(strings.NewReader(randomEvents(500, rand.NewSource(1))), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, m := range []cluster.Method{cluster.Components, cluster.Louvain} {
+		for _, thresh := range []float64{0.1, 0.5, 0.9} {
+			serial := buildBlocks(ev.cmp, ev.trees, thresh, 1)
+			want, wantQ := clusterBlocks(serial, thresh, m, 1)
+			wantGroups := groups(want)
+
+			if m == cluster.Components {
+				// Components of the blocks are the
+				// components of the complete graph.
+				g := cluster.NewThresholdGraph(thresh)
+				for _, b := range serial {
+					nodes := b.g.Nodes()
+					for nodes.Next() {
+						g.AddNode(nodes.Node())
+					}
+					edges := b.g.WeightedEdges()
+					for edges.Next() {
+						g.SetWeightedEdge(edges.WeightedEdge())
+					}
+				}
+				got := groups(cluster.Cluster(g, m, nil))
+				if !reflect.DeepEqual(got, wantGroups) {
+					t.Errorf("unexpected %v groups at thresh=%v for complete graph: got:%v want:%v",
+						&m, thresh, got, wantGroups)
+				}
+			}
+
+			for _, workers := range []int{1, 2, 4, 8} {
+				for rep := 0; rep < 3; rep++ {
+					blocks := buildBlocks(ev.cmp, ev.trees, thresh, workers)
+					got, gotQ := clusterBlocks(blocks, thresh, m, workers)
+					if !reflect.DeepEqual(groups(got), wantGroups) {
+						t.Errorf("unexpected %v groups at thresh=%v with %d workers: got:%v want:%v",
+							&m, thresh, workers, groups(got), wantGroups)
+					}
+					if gotQ.Q() != wantQ.Q() {
+						t.Errorf("unexpected %v modularity at thresh=%v with %d workers: got:%v want:%v",
+							&m, thresh, workers, gotQ.Q(), wantQ.Q())
+					}
+				}
+			}
+		}
+	}
+}
@@ -3,128 +3,341 @@
 // license that can be found in the LICENSE file.
 
 // press identifies, annotates and counts unique reefer events.
+//
+// Events from more than one run may be grouped together by giving repeated
+// -in and -ref pairs. Each feature is then labelled with a Run attribute
+// identifying the input it came from, either the corresponding -run value
+// or the base name of the -in file.
+//
+// By default press holds all reference features in memory and builds a
+// single graph over every event before identifying groups. With -streaming,
+// the reference GFF must be sorted by contig (as produced by sort -k1,1) and
+// events are grouped one contig at a time; since jaccard similarity is zero
+// between features on different contigs, the groups are the same as in the
+// default mode, but only one contig's reference-placed events and similarity
+// graph are held in memory at a time. The -in events are keyed by read name
+// rather than contig, so they are all read into memory before grouping in
+// both modes. The cost is that input must be sorted, group numbering follows
+// the input contig order and the check that every event has a reference
+// feature can only be made after output has been written.
+//
+// Events are grouped without regard to strand unless -stranded is given,
+// in which case plus and minus strand events at the same locus are placed
+// in separate groups.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"gonum.org/v1/gonum/graph"
-	"gonum.org/v1/gonum/graph/iterator"
+	"golang.org/x/exp/rand"
+
 	"gonum.org/v1/gonum/graph/simple"
-	"gonum.org/v1/gonum/graph/topo"
 
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/cluster"
+	"github.com/kortschak/loopy/events"
+	"github.com/kortschak/loopy/internal/group"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/provenance"
 )
 
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringList) String() string { return fmt.Sprint([]string(*s)) }
+
 var (
-	in     = flag.String("in", "", "specify input gff file (required)")
-	ref    = flag.String("ref", "", "specify input reference gff file (required)")
-	thresh = flag.Float64("thresh", 0.90, "specify minumum jaccard similarity for identity between events")
-	curve  = flag.String("curve", "", "specify the tsv output file for threshold response")
-	gffOut = flag.String("gff", "", "specify the gff output file for remapping")
+	thresh       = flag.Float64("thresh", 0.90, "specify minumum jaccard similarity for identity between events")
+	curve        = flag.String("curve", "", "specify the tsv output file for threshold response")
+	compat       = flag.Bool("curve-compat", false, "write only the thresh and reduction columns to the -curve file")
+	gffOut       = flag.String("gff", "", "specify the gff output file for remapping (gzip compressed if the name ends in .gz)")
+	summary      = flag.Bool("summarize", false, "write one feature spanning each group with a Support attribute instead of each member")
+	allowMissing = flag.Bool("allow-missing", false, "warn rather than terminate when events have no reference feature")
+	minSupport   = flag.Int("min-support", 1, "specify the minimum number of events in an output group")
+	stranded     = flag.Bool("stranded", false, "treat events on opposite strands as non-overlapping")
+	streaming    = flag.Bool("streaming", false, "process reference features one contig at a time (requires ref sorted by contig)")
+	seed         = flag.Uint64("seed", 1, "specify the random seed for louvain clustering")
+
+	in, ref, runs stringList
+
+	method = cluster.Components
 )
 
 func main() {
+	flag.Var(&in, "in", "specify input gff file (required, may be repeated)")
+	flag.Var(&ref, "ref", "specify input reference gff file for each -in (required, may be repeated)")
+	flag.Var(&runs, "run", "specify a Run attribute label for each -in (defaults to -in file name if more than one -in)")
+	flag.Var(&method, "cluster", `specify clustering method ("components" or "louvain")`)
 	flag.Parse()
-	if *in == "" || *ref == "" {
+	if len(in) == 0 || len(in) != len(ref) || (len(runs) != 0 && len(runs) != len(in)) {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *streaming && len(in) > 1 {
+		log.Fatal("streaming mode requires a single -in and -ref pair")
+	}
+	if len(runs) == 0 && len(in) > 1 {
+		for _, path := range in {
+			runs = append(runs, filepath.Base(path))
+		}
+	}
 
-	f, err := os.Open(*in)
-	if err != nil {
-		log.Fatalf("failed to open %q: %v", *in, err)
+	var w *gff.Writer
+	if *gffOut != "" {
+		gf, err := output.Create(*gffOut)
+		if err != nil {
+			log.Fatalf("failed to create gff file %q: %v", *gffOut, err)
+		}
+		defer gf.Close()
+		w = gff.NewWriter(gf, 60, true)
+		w.WriteComment("Right coordinates (field 5) and strand (field 7) are hypothetical.")
+		w.WriteComment(provenance.Stamp{Tool: "press", Thresh: *thresh, Origin: provenance.GFF}.String())
 	}
-	events := make(map[string]*gff.Feature)
-	got := make(map[string]bool)
-	sc := featio.NewScanner(gff.NewReader(f))
-	for sc.Next() {
-		f := sc.Feat().(*gff.Feature)
-		events[strings.TrimSuffix(f.SeqName, "(-)")] = f
+	var s *sweep
+	if *curve != "" {
+		s = newSweep()
 	}
-	if err := sc.Error(); err != nil {
-		log.Fatalf("error during gff read: %v", err)
+
+	evs := make([]io.Reader, len(in))
+	refs := make([]io.Reader, len(ref))
+	for i := range in {
+		e, err := os.Open(in[i])
+		if err != nil {
+			log.Fatalf("failed to open %q: %v", in[i], err)
+		}
+		defer e.Close()
+		evs[i] = e
+		r, err := os.Open(ref[i])
+		if err != nil {
+			log.Fatalf("failed to open %q: %v", ref[i], err)
+		}
+		defer r.Close()
+		refs[i] = r
 	}
-	f.Close()
+	var q cluster.Modularity
+	groups, unfiltered, nodes := pressAll(w, s, &q, evs, refs, runs)
 
-	f, err = os.Open(*ref)
-	if err != nil {
-		log.Fatalf("failed to open %q: %v", *ref, err)
+	if *minSupport > 1 {
+		fmt.Printf("number of unique events = %d (%d before support filter), total number of nodes = %d, modularity = %f\n", groups, unfiltered, nodes, q.Q())
+	} else {
+		fmt.Printf("number of unique events = %d, total number of nodes = %d, modularity = %f\n", groups, nodes, q.Q())
 	}
-	var v []*gff.Feature
-	sc = featio.NewScanner(gff.NewReader(f))
-	for sc.Next() {
-		f := sc.Feat().(*gff.Feature)
-		fields := strings.Fields(f.FeatAttributes.Get("Read"))
-		if len(fields) != 3 {
-			log.Fatalf("bad record: %+v", f)
+
+	if s != nil {
+		cf, err := os.Create(*curve)
+		if err != nil {
+			log.Fatalf("failed to create curve file %q: %v", *curve, err)
+		}
+		if *compat {
+			fmt.Fprintln(cf, "thresh\treduction")
+		} else {
+			fmt.Fprintln(cf, "thresh\treduction\tcomponents\tlargest")
+		}
+		for i, t := range s.thresh {
+			fmt.Fprintf(cf, "%.2f\t%f", t, 1-float64(s.components[i])/float64(s.nodes))
+			if !*compat {
+				fmt.Fprintf(cf, "\t%d\t%d", s.components[i], s.largest[i])
+			}
+			fmt.Fprintln(cf)
+		}
+		cf.Close()
+	}
+}
+
+// pressAll groups the events read from each GFF stream in evs, placed by the
+// reference features read from the corresponding stream in refs and labelled
+// with the corresponding Run attribute in runs if it is not empty. Groups are
+// written to w and the threshold response added to s as for press, and the
+// modularity of the grouping is added to q. It returns the number of groups
+// retained, the total number of groups found and the number of events grouped.
+func pressAll(w *gff.Writer, s *sweep, q *cluster.Modularity, evs, refs []io.Reader, runs []string) (groups, unfiltered, nodes int) {
+	var (
+		v []*gff.Feature
+
+		contig string
+		seen   = make(map[string]bool)
+
+		events map[string]*gff.Feature
+		got    map[string]bool
+	)
+	for i, r := range evs {
+		events = readEvents(r)
+		got = make(map[string]bool)
+
+		n := len(v)
+		sc := featio.NewScanner(gff.NewReader(refs[i]))
+		for sc.Next() {
+			f := sc.Feat().(*gff.Feature)
+			if *streaming && f.SeqName != contig {
+				if seen[f.SeqName] {
+					log.Fatalf("reference features not sorted by contig: %q found after %q", f.SeqName, contig)
+				}
+				seen[f.SeqName] = true
+				kept, total := press(v, groups, w, s, q)
+				groups += kept
+				unfiltered += total
+				nodes += len(v)
+				v = v[:0]
+				contig = f.SeqName
+			}
+			fields := strings.Fields(f.FeatAttributes.Get("Read"))
+			if len(fields) != 3 {
+				log.Fatalf("bad record: %+v", f)
+			}
+			e, ok := events[fmt.Sprintf("%s//%s_%s", fields[0], fields[1], fields[2])]
+			if ok {
+				got[fmt.Sprintf("%s//%s_%s", fields[0], fields[1], fields[2])] = true
+				b := baseCoordsOf(e, f)
+				if len(runs) != 0 {
+					b.FeatAttributes = append(b.FeatAttributes, gff.Attribute{Tag: "Run", Value: runs[i]})
+				}
+				v = append(v, b)
+			}
+		}
+		if err := sc.Error(); err != nil {
+			log.Fatalf("error during gff read: %v", err)
 		}
-		e, ok := events[fmt.Sprintf("%s//%s_%s", fields[0], fields[1], fields[2])]
-		if ok {
-			got[fmt.Sprintf("%s//%s_%s", fields[0], fields[1], fields[2])] = true
-			v = append(v, baseCoordsOf(e, f))
+
+		if !*streaming {
+			if len(events) != len(v)-n {
+				missing(events, got)
+			}
 		}
 	}
+	kept, total := press(v, groups, w, s, q)
+	groups += kept
+	unfiltered += total
+	nodes += len(v)
+	if *streaming && len(events) != len(got) {
+		missing(events, got)
+	}
+	return groups, unfiltered, nodes
+}
+
+// readEvents returns the events in the GFF stream r keyed
+// by their unstranded sequence name.
+func readEvents(r io.Reader) map[string]*gff.Feature {
+	events := make(map[string]*gff.Feature)
+	sc := featio.NewScanner(gff.NewReader(r))
+	for sc.Next() {
+		f := sc.Feat().(*gff.Feature)
+		events[strings.TrimSuffix(f.SeqName, "(-)")] = f
+	}
 	if err := sc.Error(); err != nil {
 		log.Fatalf("error during gff read: %v", err)
 	}
-	f.Close()
+	return events
+}
 
-	if len(events) != len(v) {
-		log.Println("failed to collect all reference features:")
-		for k := range events {
-			if !got[k] {
-				log.Printf("missing: %s", k)
-			}
+// missing reports the events that do not have a corresponding
+// reference feature and terminates the program unless -allow-missing
+// is set.
+func missing(events map[string]*gff.Feature, got map[string]bool) {
+	log.Println("failed to collect all reference features:")
+	var n int
+	for k := range events {
+		if !got[k] {
+			log.Printf("missing: %s", k)
+			n++
 		}
+	}
+	if !*allowMissing {
 		log.Fatal("terminating")
 	}
+	log.Printf("dropped %d events without reference features", n)
+}
+
+// press groups the features in v, writing them to w if it is not nil with
+// group numbers starting from offset, and adds the threshold response of
+// the features to s if it is not nil. The modularity of the grouping is
+// added to q. Groups with fewer than -min-support members are not written
+// or numbered. It returns the number of groups retained and the total
+// number of groups found.
+func press(v []*gff.Feature, offset int, w *gff.Writer, s *sweep, q *cluster.Modularity) (kept, total int) {
+	if len(v) == 0 {
+		return 0, 0
+	}
 
-	g := thresholdGraph{WeightedUndirectedGraph: simple.NewWeightedUndirectedGraph(1, 0), thresh: *thresh}
+	similarity := events.Jaccard
+	if *stranded {
+		similarity = events.StrandedJaccard
+	}
+	g := cluster.NewThresholdGraph(*thresh)
+	for i := range v {
+		g.AddNode(simple.Node(i))
+	}
 	// The sets of event are small at this stage,
 	// so we do things the naive way rather than
 	// setting up a set of interval trees.
 	for i := range v[:len(v)-1] {
 		for j := range v[i+1:] {
-			g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(i), T: simple.Node(j + i + 1), W: jaccard(v[i], v[j+i+1])})
+			g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(i), T: simple.Node(j + i + 1), W: similarity(v[i], v[j+i+1])})
 		}
 	}
 
-	cc := topo.ConnectedComponents(g)
-	fmt.Printf("number of unique events = %d, total number of nodes = %d\n", len(cc), g.Nodes().Len())
-	if *gffOut != "" {
-		gf, err := os.Create(*gffOut)
-		if err != nil {
-			log.Fatalf("failed to create gff file %q: %v", *gffOut, err)
+	cc := cluster.Cluster(g, method, rand.NewSource(*seed))
+	q.Add(g, cc)
+	for _, c := range cc {
+		if len(c) < *minSupport {
+			continue
 		}
-		w := gff.NewWriter(gf, 60, true)
-		w.WriteComment("Right coordinates (field 5) and strand (field 7) are hypothetical.")
-		for i, c := range cc {
+		if w != nil && *summary {
+			w.Write(group.Summarize(c, v, offset+kept))
+		} else if w != nil {
 			for _, e := range c {
 				f := v[e.ID()]
-				f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{Tag: "Group", Value: fmt.Sprint(i)})
+				f.FeatAttributes = append(f.FeatAttributes, gff.Attribute{Tag: "Group", Value: fmt.Sprint(offset + kept)})
 				w.Write(f)
 			}
 		}
-		gf.Close()
+		kept++
 	}
 
-	if *curve != "" {
-		cf, err := os.Create(*curve)
-		if err != nil {
-			log.Fatalf("failed to create curve file %q: %v", *curve, err)
-		}
-		fmt.Fprintln(cf, "thresh\treduction")
-		for g.thresh = 0.05; g.thresh < 1.04; g.thresh += 0.05 {
-			fmt.Fprintf(cf, "%.2f\t%f\n", g.thresh, 1-float64(len(topo.ConnectedComponents(g)))/float64(g.Nodes().Len()))
+	if s != nil {
+		s.nodes += g.Nodes().Len()
+		for i, t := range s.thresh {
+			g.Thresh = t
+			c := cluster.Cluster(g, method, rand.NewSource(*seed))
+			s.components[i] += len(c)
+			for _, n := range c {
+				s.largest[i] = max(s.largest[i], len(n))
+			}
 		}
-		cf.Close()
 	}
+
+	return kept, len(cc)
+}
+
+// sweep holds the number of connected components and the size
+// of the largest component found over a range of thresholds.
+type sweep struct {
+	thresh     []float64
+	components []int
+	largest    []int
+	nodes      int
+}
+
+func newSweep() *sweep {
+	var s sweep
+	for t := 0.05; t < 1.04; t += 0.05 {
+		s.thresh = append(s.thresh, t)
+	}
+	s.components = make([]int, len(s.thresh))
+	s.largest = make([]int, len(s.thresh))
+	return &s
 }
 
 func baseCoordsOf(f, ref *gff.Feature) *gff.Feature {
@@ -138,78 +351,9 @@ func baseCoordsOf(f, ref *gff.Feature) *gff.Feature {
 	return &b
 }
 
-func jaccard(a, b *gff.Feature) float64 {
-	n := intersection(a, b)
-	return float64(n) / (float64(a.Len() + b.Len() - n))
-}
-
-func intersection(a, b *gff.Feature) int {
-	if a.SeqName != b.SeqName {
-		return 0
-	}
-	return max(0, min(a.FeatEnd, b.FeatEnd)-max(a.FeatStart, b.FeatStart))
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 func max(a, b int) int {
 	if a > b {
 		return a
 	}
 	return b
 }
-
-// thesholdGraph is an undirected graph where edges must be above
-// a given threshold to be returned or traversed.
-type thresholdGraph struct {
-	*simple.WeightedUndirectedGraph
-	thresh float64
-}
-
-// From returns all nodes in g that can be reached directly from n.
-func (g thresholdGraph) From(n int64) graph.Nodes {
-	if g.Node(n) == nil {
-		return nil
-	}
-
-	var nodes []graph.Node
-	for _, to := range graph.NodesOf(g.WeightedUndirectedGraph.From(n)) {
-		if g.HasEdgeBetween(n, to.ID()) {
-			nodes = append(nodes, to)
-		}
-	}
-
-	return iterator.NewOrderedNodes(nodes)
-}
-
-// HasEdgeBetween returns whether an edge exists between nodes x and y.
-func (g thresholdGraph) HasEdgeBetween(x, y int64) bool {
-	if !g.WeightedUndirectedGraph.HasEdgeBetween(x, y) {
-		return false
-	}
-	w, _ := g.Weight(x, y)
-	return w >= g.thresh
-}
-
-// Edge returns the edge from u to v if such an edge exists and nil otherwise.
-// The node v must be directly reachable from u as defined by the From method.
-func (g thresholdGraph) Edge(u, v int64) graph.Edge {
-	return g.EdgeBetween(u, v)
-}
-
-// EdgeBetween returns the edge between nodes x and y.
-func (g thresholdGraph) EdgeBetween(x, y int64) graph.Edge {
-	e := g.WeightedUndirectedGraph.EdgeBetween(x, y)
-	if e == nil {
-		return nil
-	}
-	if w, _ := g.Weight(x, y); w < g.thresh {
-		return nil
-	}
-	return e
-}
@@ -0,0 +1,161 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/biogo/io/featio"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/seq"
+
+	"github.com/kortschak/loopy/cluster"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden checks got against the contents of the named file in testdata,
+// writing got to the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestPressStranded(t *testing.T) {
+	defer func(s bool) { *stranded = s }(*stranded)
+
+	for _, test := range []struct {
+		stranded bool
+		want     []string
+	}{
+		{stranded: false, want: []string{"0", "0", "0"}},
+		{stranded: true, want: []string{"0", "0", "1"}},
+	} {
+		*stranded = test.stranded
+
+		// Two plus strand events and one minus strand
+		// event at the same locus.
+		var v []*gff.Feature
+		for _, strand := range []seq.Strand{seq.Plus, seq.Plus, seq.Minus} {
+			v = append(v, &gff.Feature{
+				SeqName:    "chr1",
+				Source:     "press",
+				Feature:    "insertion",
+				FeatStart:  1000,
+				FeatEnd:    1300,
+				FeatStrand: strand,
+				FeatFrame:  gff.NoFrame,
+			})
+		}
+		var buf bytes.Buffer
+		var q cluster.Modularity
+		kept, total := press(v, 0, gff.NewWriter(&buf, 60, false), nil, &q)
+		wantGroups := 1
+		if test.stranded {
+			wantGroups = 2
+		}
+		if kept != wantGroups || total != wantGroups {
+			t.Errorf("unexpected number of groups with stranded=%t: got:%d %d want:%d",
+				test.stranded, kept, total, wantGroups)
+		}
+
+		var got []string
+		sc := featio.NewScanner(gff.NewReader(&buf))
+		for sc.Next() {
+			got = append(got, sc.Feat().(*gff.Feature).FeatAttributes.Get("Group"))
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output: %v", err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected groups with stranded=%t: got:%v want:%v", test.stranded, got, test.want)
+		}
+	}
+}
+
+func TestPressGolden(t *testing.T) {
+	defer func(sum, str, stream bool, ms int) {
+		*summary, *stranded, *streaming, *minSupport = sum, str, stream, ms
+	}(*summary, *stranded, *streaming, *minSupport)
+
+	// The testdata reference features place two Alu events
+	// on chr1 and one on chr2, and two L1 events on opposite
+	// strands on chr1, one identified by a minus strand name.
+	// One reference feature on chr2 has no event.
+	for _, test := range []struct {
+		summary, stranded, streaming bool
+		minSupport                   int
+		runs                         []string
+
+		golden                    string
+		groups, unfiltered, nodes int
+	}{
+		{golden: "default.gff", groups: 3, unfiltered: 3, nodes: 5},
+		{streaming: true, golden: "default.gff", groups: 3, unfiltered: 3, nodes: 5},
+		{stranded: true, golden: "stranded.gff", groups: 4, unfiltered: 4, nodes: 5},
+		{summary: true, golden: "summary.gff", groups: 3, unfiltered: 3, nodes: 5},
+		{minSupport: 2, golden: "support.gff", groups: 2, unfiltered: 3, nodes: 5},
+		{runs: []string{"a", "b"}, golden: "runs.gff", groups: 3, unfiltered: 3, nodes: 10},
+	} {
+		*summary = test.summary
+		*stranded = test.stranded
+		*streaming = test.streaming
+		*minSupport = test.minSupport
+		if *minSupport == 0 {
+			*minSupport = 1
+		}
+
+		n := len(test.runs)
+		if n == 0 {
+			n = 1
+		}
+		var evs, refs []io.Reader
+		for i := 0; i < n; i++ {
+			for _, f := range []struct {
+				name string
+				dst  *[]io.Reader
+			}{
+				{name: "events.gff", dst: &evs},
+				{name: "ref.gff", dst: &refs},
+			} {
+				r, err := os.Open(filepath.Join("testdata", f.name))
+				if err != nil {
+					t.Fatalf("failed to open %s: %v", f.name, err)
+				}
+				defer r.Close()
+				*f.dst = append(*f.dst, r)
+			}
+		}
+
+		var buf bytes.Buffer
+		var q cluster.Modularity
+		groups, unfiltered, nodes := pressAll(gff.NewWriter(&buf, 60, true), nil, &q, evs, refs, test.runs)
+		if groups != test.groups || unfiltered != test.unfiltered || nodes != test.nodes {
+			t.Errorf("unexpected counts for %s with streaming=%t: got:%d %d %d want:%d %d %d",
+				test.golden, test.streaming, groups, unfiltered, nodes, test.groups, test.unfiltered, test.nodes)
+		}
+		golden(t, test.golden, buf.Bytes())
+	}
+}
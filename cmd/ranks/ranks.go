@@ -6,16 +6,19 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/consensus"
 )
 
 var doGrouping = flag.Bool("group", false, "output grouped counts")
@@ -23,44 +26,59 @@ var doGrouping = flag.Bool("group", false, "output grouped counts")
 func main() {
 	flag.Parse()
 
+	err := ranks(os.Stdout, os.Stdin, *doGrouping)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ranks writes the group and repeat type of each feature in the GFF stream
+// src to dst, or if grouping is true, the counts of each repeat type in each
+// group followed by the consensus name of the group.
+func ranks(dst io.Writer, src io.Reader, grouping bool) error {
 	var grps []map[string]int
-	sc := featio.NewScanner(gff.NewReader(os.Stdin))
+	sc := featio.NewScanner(gff.NewReader(src))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
 		r := f.FeatAttributes.Get("Repeat")
 		g := f.FeatAttributes.Get("Group")
-		typ := strings.Fields(r)[0]
-		if !*doGrouping {
-			fmt.Printf("%s\t%s\n", g, typ)
+		fields := strings.Fields(r)
+		if len(fields) == 0 {
+			return errors.New("invalid repeat attribute")
+		}
+		typ := fields[0]
+		if !grouping {
+			fmt.Fprintf(dst, "%s\t%s\n", g, typ)
 		}
 		gid, err := strconv.Atoi(g)
 		if err != nil {
-			log.Fatalf("failed to parse group id: %v", err)
+			return fmt.Errorf("failed to parse group id: %v", err)
 		}
 		grps = add(grps, gid, typ)
 	}
 	if err := sc.Error(); err != nil {
-		log.Fatalf("error during gff read: %v", err)
+		return fmt.Errorf("error during gff read: %v", err)
 	}
 
-	if !*doGrouping {
-		return
+	if !grouping {
+		return nil
 	}
 	for gid, g := range grps {
 		if g == nil {
 			continue
 		}
-		fmt.Printf("%d\t", gid)
-		m := sortedMap(g)
+		fmt.Fprintf(dst, "%d\t", gid)
+		m := consensus.Sorted(g)
 		for i, t := range m {
 			if i != 0 {
-				fmt.Print(" ")
+				fmt.Fprint(dst, " ")
 			}
-			fmt.Printf("%s:%d", t.typ, t.n)
+			fmt.Fprintf(dst, "%s:%d", t.Type, t.N)
 		}
-		name := nameHeuristic(m)
-		fmt.Printf("\t%s\t%s\n", name, trunc(name, 5))
+		name := consensus.NameOf(m)
+		fmt.Fprintf(dst, "\t%s\t%s\n", name, consensus.Trunc(name, 5))
 	}
+	return nil
 }
 
 func add(grps []map[string]int, gid int, typ string) []map[string]int {
@@ -80,73 +98,3 @@ func add(grps []map[string]int, gid int, typ string) []map[string]int {
 	grps[gid][typ]++
 	return grps
 }
-
-type mapElement struct {
-	typ string
-	n   int
-}
-
-type byCount []mapElement
-
-func (m byCount) Len() int { return len(m) }
-func (m byCount) Less(i, j int) bool {
-	if m[i].n < m[j].n {
-		return true
-	}
-	// Heuristic for sort that longer names are likely to be
-	// a tighter definition, so use them in preference.
-	return m[i].n == m[j].n && len(m[i].typ) < len(m[j].typ)
-}
-func (m byCount) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
-
-func sortedMap(g map[string]int) []mapElement {
-	m := make([]mapElement, 0, len(g))
-	for typ, n := range g {
-		m = append(m, mapElement{typ: typ, n: n})
-	}
-	sort.Sort(sort.Reverse(byCount(m)))
-	return m
-}
-
-func nameHeuristic(g []mapElement) string {
-	if len(g) == 0 {
-		return ""
-	}
-
-	// Majority rule.
-	var n int
-	for _, e := range g {
-		n += e.n
-	}
-	r := float64(g[0].n) / float64(n)
-	if r > 0.5 || (r == 0.5 && len(g) > 2) {
-		return g[0].typ
-	}
-
-	// Alu heuristic.
-	if isAlu(g[0].typ) {
-		return trunc(g[0].typ, 5)
-	}
-
-	// Fusion.
-	var names []string
-	for _, t := range g {
-		names = append(names, t.typ)
-	}
-	return strings.Join(names, "/")
-}
-
-func isAlu(t string) bool {
-	return strings.HasPrefix(strings.ToLower(t), "alu")
-}
-
-func trunc(name string, n int) string {
-	return name[:min(5, len(name))]
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
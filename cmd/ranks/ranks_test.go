@@ -0,0 +1,70 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden checks got against the contents of the named file in testdata,
+// writing got to the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestRanksGolden(t *testing.T) {
+	// The testdata events have no group 3.
+	for _, grouping := range []bool{false, true} {
+		f, err := os.Open(filepath.Join("testdata", "events.gff"))
+		if err != nil {
+			t.Fatalf("failed to open events: %v", err)
+		}
+		var buf bytes.Buffer
+		err = ranks(&buf, f, grouping)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error for grouping=%t: %v", grouping, err)
+		}
+		golden(t, fmt.Sprintf("group-%t.tsv", grouping), buf.Bytes())
+	}
+}
+
+func TestRanksInvalid(t *testing.T) {
+	for _, attrs := range []string{
+		"Group 0",
+		"Repeat AluY SINE/Alu 1 300 11",
+		"Repeat AluY SINE/Alu 1 300 11; Group one",
+	} {
+		src := "chr1\tpress\tinsertion\t1001\t1300\t.\t+\t.\t" + attrs + "\n"
+		var buf bytes.Buffer
+		err := ranks(&buf, strings.NewReader(src), true)
+		if err == nil {
+			t.Errorf("expected error for attributes %q", attrs)
+		}
+	}
+}
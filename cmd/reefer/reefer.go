@@ -4,79 +4,211 @@
 
 // reefer performs blasr alignment and analysis of internal mismatches to
 // identify candidate structural variation features.
+//
+// With -inversions, features that are dominated by mismatches rather than
+// indels are realigned to the reference in reverse complement, and are
+// reported with the feature type inversion when that alignment explains
+// them better than the forward alignment.
+//
+// With -dump-alignments, the left and right junction alignments that
+// placed the breakpoints of each refined feature are written to the named
+// file after a header line giving the read name, the written feature and
+// the zero-based half-open breakpoints derived from the alignments. Each
+// alignment is shown with the offsets of its reference and read windows.
+// The read windows and breakpoints of a read aligned to the minus strand
+// are in the orientation of the reference, as in the alignment record.
+//
+// The GFF output is written to <reads>.gff, or <reads>.gff.gz with -gzip,
+// in the working directory unless -o names another file or is "-" to write
+// to standard output. When -o names a file, it is gzip compressed if the
+// name ends in .gz.
+//
+// Output coordinates are 1-based and fully closed. By default, a feature
+// that is empty on the reference is written as the following base. With
+// -gff3-sites, empty features are written following GFF3, with start equal
+// to end and the site to the right of the given base. The read interval of
+// the Read attribute follows the same convention, so the read coordinates
+// of a deletion that is empty on the read are written in the same way.
+//
+// By default the complete refinement reference is held in memory. With
+// -ref-cache-mb, contigs are instead read from the reference as they are
+// needed using its fai index, which is made if it does not exist, and
+// the least recently used contigs are dropped to keep within the given
+// budget. Duplicate contig names cannot be indexed, so -allow-dup-contigs
+// has no effect in this mode. Refinement results are the same in both
+// modes.
+//
+// Reads that produce more than -max-events-per-read features, typically
+// chimeric or low quality reads, are logged and none of their features
+// are written. The limit is applied before refinement so dropped reads
+// add no alignment cost.
+//
+// A feature is reported if its length on the reference is at least -min-ref
+// or its length on the read is at least -min-query, so deletions and
+// insertions can be given different minimum sizes. Either defaults to -min.
+//
+// Reads no longer than -window cannot be smoothed and are skipped. If more
+// than the -short-warn fraction of reads are skipped, a warning is logged
+// since the output will be missing most or all features.
+//
+// The smoothed cost of a single event may rise briefly to zero, splitting
+// the event into fragments. With -merge-gap, consecutive fragments on a read
+// that are closer than the given distance on the reference are merged
+// before the -min, -min-ref and -min-query size filters are applied.
+//
+// Duplicated reads in the input give features that are identical in
+// reference, reference interval and read name, which would inflate event
+// support in press. With -dedup, such duplicates are suppressed when they
+// are among the given number of most recently written features, and the
+// number suppressed is logged.
+//
+// Soft clipped read ends are given no cost, but a long clip often marks the
+// breakpoint of a larger event that the read only partly spans. With
+// -min-clip, clips at least the given length are also written as features
+// of type clip at the breakpoint on the reference, with the Read attribute
+// giving the clipped read segment and a Clip attribute giving the clipped
+// side of the alignment on the reference, left or right, and the clip length.
+// Clips are not reported for reads that are skipped as too short or as
+// having more than -max-events-per-read features.
+//
+// Only CIGAR = and X operations distinguish matches from mismatches, so
+// alignments using M operations, as written by most aligners other than
+// blasr with --cigarUseSeqMatch, give no features. Such alignments are
+// counted and a warning is logged, or with -strict-cigar the run fails at
+// the first of them.
+//
+// With -resume, an interrupted run can be continued from the existing
+// blasr alignments and partial GFF output. Reads with features in the GFF
+// output are skipped and new features are appended without repeating the
+// header. The features of the last read in the partial output may be
+// incomplete, so they and any partial final line are removed and the read
+// is processed again. If the partial output holds no features, it is
+// rewritten from the start. Reads that gave no features are not recorded
+// in the output and so are always processed again, and the -trace and
+// -dump-alignments files only hold the output for reads processed by the
+// resumed run. Resumption is not available for compressed or standard
+// output.
+//
+// blasr breaks ties between equal scoring alignments randomly, so the
+// blasr random seed is set by -seed to a fixed default to make repeated
+// runs reproducible. The seed has no effect with -run-blasr=false.
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/biogo/biogo/align"
 	"github.com/biogo/biogo/alphabet"
-	"github.com/biogo/biogo/feat"
-	"github.com/biogo/biogo/io/featio/gff"
-	"github.com/biogo/biogo/io/seqio"
-	"github.com/biogo/biogo/io/seqio/fasta"
-	"github.com/biogo/biogo/seq"
-	"github.com/biogo/biogo/seq/linear"
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 
 	"github.com/kortschak/loopy/blasr"
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/progress"
+	"github.com/kortschak/loopy/internal/sequtil"
+	"github.com/kortschak/loopy/reefer"
 )
 
-type mat [3]int
+// mat holds alignment scoring parameters. A three value mat specifies
+// match, mismatch and linear gap scores. A four value mat specifies
+// match, mismatch, gap open and gap extend scores for affine gap
+// alignment; the gap open score is added to the gap extend score
+// at the first position of each gap.
+type mat []int
 
 var alnmat = mat{1, -1, -1}
 
 func (v *mat) Set(s string) error {
 	fields := strings.Split(s, ",")
-	if len(fields) != 3 {
+	if len(fields) != 3 && len(fields) != 4 {
 		return fmt.Errorf("invalid number of fields: %q", s)
 	}
+	m := make(mat, len(fields))
 	var err error
 	for i, f := range fields {
-		v[i], err = strconv.Atoi(f)
+		m[i], err = strconv.Atoi(f)
 		if err != nil {
 			return fmt.Errorf("invalid fields: %v", err)
 		}
 	}
+	*v = m
 	return nil
 }
 
-func (v *mat) String() string { return fmt.Sprintf("%d,%d,%d", v[0], v[1], v[2]) }
+func (v *mat) String() string {
+	f := make([]string, len(*v))
+	for i, s := range *v {
+		f[i] = strconv.Itoa(s)
+	}
+	return strings.Join(f, ",")
+}
 
 var (
 	reads       = flag.String("reads", "", "input fasta sequence read file name (required)")
 	ref         = flag.String("reference", "", "input reference sequence file name (required)")
 	suff        = flag.String("suff", "", "input reference suffix array path")
-	useBam      = flag.Bool("bam", false, "use bam file inputs if not running blasr")
+	useBam      = flag.Bool("bam", false, "use bam format for blasr alignment output")
 	refine      = flag.Bool("refine", true, "use paired SW alignment to refine breakpoints")
 	refWindow   = flag.Int("ref-window", 300, "window for refinement around middle of reference indel")
 	queryWindow = flag.Int("read-window", 500, "window for refinement beyond ends of of read indel")
 	minQueryGap = flag.Int("min-read-gap", 50, "minimum distance between read breakpoints")
 	minRefFlank = flag.Int("min-ref-flank", 10, "minimum distance from end of reference window")
+	flankMode   = flag.String("flank-mode", "abs", "interpret breakpoint refinement thresholds as absolute lengths (abs) or window fractions (frac)")
+	refFlankFrc = flag.Float64("min-ref-flank-frac", 0.03, "minimum distance from end of reference window as a fraction of its length (with -flank-mode frac)")
+	queryGapFrc = flag.Float64("min-read-gap-frac", 0.1, "minimum distance between read breakpoints as a fraction of the read window length (with -flank-mode frac)")
+	matrix      = flag.String("matrix", "", "substitution matrix file overriding -align scores (rows and columns ordered -, a, c, g, t with gaps first)")
+	maxAlign    = flag.Int("max-align", 0, "maximum product of reference and read window lengths for refinement (no limit if zero)")
+	inversions  = flag.Bool("inversions", false, "test mismatch dominated features for inversion by reverse complement alignment (requires -refine)")
+	invCover    = flag.Float64("min-inversion-cover", 0.8, "minimum fraction of the read segment covered by the reverse complement alignment for an inversion")
+	dumpFile    = flag.String("dump-alignments", "", "output file name for the junction alignments of refined features (no dump if empty, requires -refine)")
 	verbose     = flag.Bool("v", false, "verbose logging of breakpoint adjustment")
 	blasrPath   = flag.String("blasr", "", "path to blasr if not in $PATH")
 	procs       = flag.Int("procs", 1, "number of blasr threads")
+	seed        = flag.Int("seed", 1, "blasr random seed for breaking ties between equal scoring alignments (blasr default if zero)")
 	window      = flag.Int("window", 50, "smoothing window")
+	shortWarn   = flag.Float64("short-warn", 0.5, "warn if more than this fraction of reads are no longer than the smoothing window (no warning if zero)")
+	tail        = flag.Bool("tail", false, "smooth the final window of each read over the remaining partial window so features near the read end are detected")
+	shape       = flag.String("window-shape", "flat", "smoothing window weighting (flat, triangular or gaussian)")
 	minSize     = flag.Int("min", 300, "minimum feature size")
+	minRefSize  = flag.Int("min-ref", 0, "minimum feature size on the reference (defaults to -min)")
+	minQrySize  = flag.Int("min-query", 0, "minimum feature size on the read (defaults to -min)")
+	maxEvents   = flag.Int("max-events-per-read", 0, "drop all features of reads with more than this many features (no limit if zero)")
+	mergeGap    = flag.Int("merge-gap", 0, "merge consecutive candidate features of a read separated on the reference by less than this distance (no merging if zero)")
+	minClip     = flag.Int("min-clip", 0, "report soft clips at read alignment ends at least this long as clip features (no clip features if zero)")
+	strictCigar = flag.Bool("strict-cigar", false, "fail on alignments with CIGAR M operations instead of warning (reefer requires = and X operations)")
+	dedup       = flag.Int("dedup", 0, "suppress features identical to one of this many recently written features (no suppression if zero)")
+	traceFile   = flag.String("trace", "", "output file name for smoothed cost traces (no trace if empty)")
+	traceEvery  = flag.Int("trace-every", 1, "write the smoothed cost trace for every nth read")
+	exclude     = flag.String("exclude-contigs", "", "regular expression matching reference contigs to exclude (e.g. _alt$|^chrUn_|_random$|^HLA-)")
+	outFile     = flag.String("o", "", `GFF output file name ("-" for stdout, default <reads>.gff in the working directory)`)
+	gz          = flag.Bool("gzip", false, "gzip compress the GFF output")
+	resume      = flag.Bool("resume", false, "append to a partial GFF output, skipping reads already written (requires -run-blasr=false)")
+	sites       = flag.Bool("gff3-sites", false, "write features that are empty on the reference or read as GFF3 zero length sites")
+	every       = flag.Duration("progress", 0, "log progress at this interval (no progress logging if zero)")
 	run         = flag.Bool("run-blasr", true, `actually run blasr
     	false is useful to reconstruct output from fasta input
     	and reefer .blasr outputs`,
 	)
 
+	dupContigs = flag.Bool("allow-dup-contigs", false, "log duplicate reference sequence names instead of failing")
+	refCache   = flag.Int("ref-cache-mb", 0, "read refinement reference contigs on demand from the fai indexed reference, caching at most this many MB of sequence (load all contigs if zero)")
+
 	errFile   = flag.String("err", "", "output file name (default to stderr)")
 	errStream = os.Stderr
 )
 
 func main() {
-	flag.Var(&alnmat, "align", "specify the match, mismatch and gap parameters for breakpoint refinement")
+	flag.Var(&alnmat, "align", "specify the match, mismatch and gap (or gap open and extend) parameters for breakpoint refinement")
 	flag.Parse()
 	if *reads == "" || (*ref == "" && *run) {
 		fmt.Fprintln(os.Stderr, "invalid argument: must have reads, reference and block size set")
@@ -84,7 +216,25 @@ func main() {
 		os.Exit(1)
 	}
 
-	var err error
+	if *resume && (*run || *gz || *outFile == "-") {
+		fmt.Fprintln(os.Stderr, "invalid argument: resume requires -run-blasr=false and uncompressed file output")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *flankMode != "abs" && *flankMode != "frac" {
+		fmt.Fprintf(os.Stderr, "invalid argument: unknown flank mode %q\n", *flankMode)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	windowShape, err := reefer.ParseShape(*shape)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid argument: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	if *errFile != "" {
 		errStream, err = os.Create(*errFile)
 		if err != nil {
@@ -96,45 +246,166 @@ func main() {
 	}
 
 	// Set up breakpoint refiner.
-	var br *refiner
+	var br *reefer.Refiner
 	if *refine {
-		refSeq, err := readContigs(*ref)
+		var refSeq reefer.Reference
+		if *refCache > 0 {
+			c, err := faidx.NewCache(*ref, alphabet.DNAgapped, *refCache<<20)
+			if err != nil {
+				log.Fatalf("failed to open indexed reference sequences: %v", err)
+			}
+			defer c.Close()
+			refSeq = c
+		} else {
+			seqs, err := sequtil.ReadContigs(*ref, alphabet.DNAgapped, *dupContigs)
+			if err != nil {
+				log.Fatalf("failed to read reference sequences: %v", err)
+			}
+			refSeq = reefer.Contigs(seqs)
+		}
+		var sub [][]int
+		if *matrix != "" {
+			sub, err = readMatrix(*matrix)
+			if err != nil {
+				log.Fatalf("failed to read substitution matrix: %v", err)
+			}
+		}
+		sw, err := makeTable(alnmat, sub)
 		if err != nil {
-			log.Fatalf("failed to read reference sequences: %v", err)
+			log.Fatalf("failed to make alignment table: %v", err)
 		}
-		br = &refiner{
-			refWindow:   *refWindow,
-			queryWindow: *queryWindow,
-			minQueryGap: *minQueryGap,
-			minRefFlank: *minRefFlank,
-			ref:         refSeq,
-			sw:          makeTable(alnmat),
+		br = &reefer.Refiner{
+			RefWindow:   *refWindow,
+			QueryWindow: *queryWindow,
+			MinQueryGap: *minQueryGap,
+			MinRefFlank: *minRefFlank,
+			MaxAlign:    *maxAlign,
+			Ref:         refSeq,
+			Aligner:     sw,
+
+			Fractional:      *flankMode == "frac",
+			MinRefFlankFrac: *refFlankFrc,
+			MinQueryGapFrac: *queryGapFrc,
+
+			Inversions:        *inversions,
+			MinInversionCover: *invCover,
+		}
+		if *dumpFile != "" {
+			df, err := os.Create(*dumpFile)
+			if err != nil {
+				log.Fatalf("failed to create alignment dump file: %v", err)
+			}
+			dw := bufio.NewWriter(df)
+			defer func() {
+				err := dw.Flush()
+				if err != nil {
+					log.Printf("failed to write alignment dump: %v", err)
+				}
+				df.Close()
+			}()
+			br.Dump = dw
 		}
 	}
 
-	out := filepath.Base(*reads)
-	f, err := os.Create(out + ".gff")
-	if err != nil {
-		log.Fatalf("failed to create GFF outfile: %q", out+".gff")
+	var excl *regexp.Regexp
+	if *exclude != "" {
+		excl, err = regexp.Compile(*exclude)
+		if err != nil {
+			log.Fatalf("invalid contig exclusion pattern: %v", err)
+		}
+	}
+
+	var tr *reefer.Tracer
+	if *traceFile != "" {
+		tf, err := os.Create(*traceFile)
+		if err != nil {
+			log.Fatalf("failed to create trace file: %v", err)
+		}
+		defer tf.Close()
+		tr, err = reefer.NewTracer(tf, *traceEvery)
+		if err != nil {
+			log.Fatalf("failed to write trace header: %v", err)
+		}
+	}
+
+	var (
+		f    io.WriteCloser
+		done map[string]bool
+
+		// header is true if the GFF output
+		// already has its header.
+		header bool
+	)
+	switch out := *outFile; {
+	case out == "-":
+		f = output.Stdout(*gz)
+	case *resume:
+		if out == "" {
+			out = filepath.Base(*reads) + ".gff"
+		}
+		f, done, header, err = resumeFrom(out)
+		if err != nil {
+			log.Fatalf("failed to open GFF outfile for resumption: %v", err)
+		}
+		log.Printf("resuming %q: skipping %d reads", out, len(done))
+	default:
+		if out == "" {
+			out = filepath.Base(*reads) + ".gff"
+			if *gz {
+				out += ".gz"
+			}
+		}
+		f, err = output.Create(out)
+		if err != nil {
+			log.Fatalf("failed to create GFF outfile: %q", out)
+		}
 	}
-	w := gff.NewWriter(f, 60, true)
-	defer f.Close()
 	log.Printf("finding alignments for reads in %q", *reads)
 	ext := "sam"
-	if *useBam && !*run {
+	if *useBam {
 		ext = "bam"
 	}
-	err = deletions(*reads, *ref, *suff, ext, *procs, *run, *window, *minSize, br, w)
+	cfg := reefer.Config{
+		Window:  *window,
+		Shape:   windowShape,
+		MinSize: *minSize,
+		Refiner: br,
+		Trace:   tr,
+		Verbose: *verbose,
+
+		PointSites: *sites,
+		Tail:       *tail,
+		MaxEvents:  *maxEvents,
+		MergeGap:   *mergeGap,
+		ShortWarn:  *shortWarn,
+		Dedup:      *dedup,
+
+		MinClip:     *minClip,
+		StrictCigar: *strictCigar,
+
+		MinRefSize:   *minRefSize,
+		MinQuerySize: *minQrySize,
+
+		NoHeader: header,
+	}
+	err = deletions(*reads, *ref, *suff, ext, *procs, *run, excl, done, cfg, f)
 	if err != nil {
 		log.Fatalf("failed mapping: %v", err)
 	}
+	err = f.Close()
+	if err != nil {
+		log.Fatalf("failed to close GFF outfile: %v", err)
+	}
 }
 
 // deletions analyses *sam.Records from mapping reads to the given reference
-// using the suffix array file if provided. If run is false, blasr is not
-// run and the existing blasr output is used to provide the *sam.Records.
-// procs specifies the number of blasr threads to use.
-func deletions(reads, ref, suff, ext string, procs int, run bool, window, min int, br *refiner, w *gff.Writer) error {
+// using the suffix array file if provided, writing GFF features to w according
+// to cfg. If run is false, blasr is not run and the existing blasr output is
+// used to provide the *sam.Records. procs specifies the number of blasr threads
+// to use. If ext is "bam" and blasr is run, the SAM output of blasr is converted
+// to BAM. Records aligned to reference contigs with names matching exclude are
+// ignored if exclude is not nil.
+func deletions(reads, ref, suff, ext string, procs int, run bool, exclude *regexp.Regexp, done map[string]bool, cfg reefer.Config, w io.Writer) error {
 	base := filepath.Base(reads)
 	b := blasr.BLASR{
 		Cmd: *blasrPath,
@@ -147,11 +418,13 @@ func deletions(reads, ref, suff, ext string, procs int, run bool, window, min in
 		SAMQV:         true,
 		CIGARSeqMatch: true,
 
-		Aligned:   base + ".blasr." + ext,
+		Aligned:   base + ".blasr.sam",
 		Unaligned: base + ".blasr.unmapped.fasta",
 
-		Procs: procs,
+		Procs:      procs,
+		RandomSeed: *seed,
 	}
+	aligned := base + ".blasr." + ext
 	if run {
 		cmd, err := b.BuildCommand()
 		if err != nil {
@@ -163,403 +436,296 @@ func deletions(reads, ref, suff, ext string, procs int, run bool, window, min in
 		if err != nil {
 			return err
 		}
+		if ext == "bam" {
+			err = samToBAM(b.Aligned, aligned, procs)
+			if err != nil {
+				return err
+			}
+			err = os.Remove(b.Aligned)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	f, err := os.Open(b.Aligned)
+	f, err := os.Open(aligned)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	p := progress.ForFile(f, *every)
 
-	cost := [...]float64{
-		sam.CigarInsertion: -2,
-		sam.CigarDeletion:  -2,
-		sam.CigarEqual:     1,
-		sam.CigarMismatch:  -1,
-
-		// Included for explicitness
-		sam.CigarSoftClipped: 0,
-
-		// Included to ensure no bounds panic.
-		// All CIGAR operations not listed above
-		// are given a zero cost.
-		sam.CigarBack: 0,
-	}
-
-	_, err = w.WriteComment(fmt.Sprintf("smoothing window=%d", window))
-	if err != nil {
-		return nil
-	}
-	_, err = w.WriteComment(fmt.Sprintf("minimum feature length=%d", min))
-	if err != nil {
-		return nil
-	}
-	gf := &gff.Feature{
-		Source:         "reefer",
-		Feature:        "discordance",
-		FeatFrame:      gff.NoFrame,
-		FeatAttributes: gff.Attributes{{Tag: "Read"}, {Tag: "Dup"}},
-	}
-	var sr interface {
-		Read() (*sam.Record, error)
-	}
+	var (
+		sr reefer.RecordReader
+		h  *sam.Header
+	)
 	switch ext {
 	case "sam":
-		sr, err = sam.NewReader(f)
+		var r *sam.Reader
+		r, err = sam.NewReader(p.Reader(f))
 		if err != nil {
 			return err
 		}
+		sr = r
+		h = r.Header()
 	case "bam":
 		var br *bam.Reader
-		br, err = bam.NewReader(f, 0)
+		br, err = bam.NewReader(p.Reader(f), 0)
 		if err != nil {
 			return err
 		}
 		defer br.Close()
 		sr = br
+		h = br.Header()
 	default:
 		panic("reefer: invalid extension")
 	}
-	for {
-		r, err := sr.Read()
-		if err != nil {
-			if err != io.EOF {
-				return err
-			}
-			break
-		}
+	if cfg.Refiner != nil {
+		checkRefs(h, cfg.Refiner.Ref, exclude)
+	}
+	if exclude != nil {
+		sr = reefer.Exclude(sr, exclude)
+	}
+	if len(done) != 0 {
+		sr = reefer.Skip(sr, done)
+	}
+	cfg.Programs = h.Progs()
+	err = reefer.Discordances(w, progressReader{r: sr, p: p}, cfg)
+	if err != nil {
+		return err
+	}
+	p.Done()
+	return nil
+}
 
-		var (
-			scores []costPos
-			ref    = r.Start()
-			query  int
-		)
-		for _, co := range r.Cigar {
-			for i := 0; i < co.Len(); i++ {
-				scores = append(scores, costPos{
-					ref:   ref,
-					query: query,
-					cost:  cost[co.Type()],
-				})
-				consume := co.Type().Consumes()
-				ref += consume.Reference
-				query += consume.Query
-			}
-		}
-		if len(scores) <= window {
+// checkRefs logs a warning if any of the reference contigs in h that
+// are not excluded are missing from ref. Features on missing contigs
+// cannot be refined; the usual cause is a difference in contig naming
+// between the reference used for mapping and for refinement.
+func checkRefs(h *sam.Header, ref reefer.Reference, exclude *regexp.Regexp) {
+	var missing []string
+	for _, r := range h.Refs() {
+		name := r.Name()
+		if exclude != nil && exclude.MatchString(name) {
 			continue
 		}
-		smoothed := make([]costPos, len(scores)-window)
-		for i := range scores[:len(scores)-window] {
-			smoothed[i] = mean(scores[i : i+window])
-		}
-
-		var d deletion
-		for i, v := range smoothed[1:] {
-			switch {
-			case d.record == nil && v.cost < 0 && smoothed[i].cost >= 0:
-				d = deletion{record: r, rstart: v.ref + 1, qstart: v.query + 1}
-			case d.record != nil && v.cost >= 0 && smoothed[i].cost < 0:
-				d.rend = v.ref
-				d.qend = v.query
-				if d.rend-d.rstart >= min || d.qend-d.qstart >= min {
-					gf.SeqName = d.record.Ref.Name()
-					gf.FeatStrand = strandFor(d.record)
-					if gf.FeatStrand == seq.Minus {
-						len := d.record.Seq.Length
-						d.qstart, d.qend = len-d.qend, len-d.qstart
-					}
-
-					// Adjust ends based on paired SW alignments.
-					var refined bool
-					d, refined, err = br.adjust(d)
-					if err != nil && *verbose {
-						log.Printf("failed alignment %s: %v", d.record.Name, err)
-					}
-
-					gf.FeatStart = d.rstart
-					gf.FeatEnd = d.rend
-					if gf.FeatStart == gf.FeatEnd {
-						// This is disgusting garbage resulting from
-						// GFF not allowing zero length features.
-						gf.FeatEnd++
-					}
-
-					if refined {
-						gf.FeatAttributes = gf.FeatAttributes[:2]
-						gf.FeatAttributes[1].Value = strconv.Itoa(d.dup)
-					} else {
-						gf.FeatAttributes = gf.FeatAttributes[:1]
-					}
-					gf.FeatAttributes[0].Value = fmt.Sprintf("%s %d %d", d.record.Name, feat.ZeroToOne(d.qstart), d.qend)
-					_, err = w.Write(gf)
-					if err != nil {
-						return err
-					}
-				}
-				d.record = nil
-			}
+		if !ref.Has(name) {
+			missing = append(missing, name)
 		}
 	}
-	return nil
-}
-
-type deletion struct {
-	record *sam.Record
-
-	rstart, rend, dup int
-	qstart, qend      int
-}
-
-type costPos struct {
-	ref, query int
-	cost       float64
+	if len(missing) == 0 {
+		return
+	}
+	const examples = 5
+	eg := missing
+	if len(eg) > examples {
+		eg = eg[:examples]
+	}
+	log.Printf("warning: %d of %d alignment reference contigs are missing from the refinement reference (e.g. %q): features on these contigs will not be refined",
+		len(missing), len(h.Refs()), eg)
 }
 
-func mean(c []costPos) costPos {
-	var mean costPos
-	for _, v := range c {
-		mean.cost += v.cost
-		mean.ref += v.ref
-		mean.query += v.query
-	}
-	scale := float64(len(c))
-	mean.cost /= scale
-	mean.ref = int(float64(mean.ref)/scale + 0.5)
-	mean.query = int(float64(mean.query)/scale + 0.5)
-	return mean
+// progressReader is a reefer.RecordReader that reports the number
+// of records read to p.
+type progressReader struct {
+	r reefer.RecordReader
+	p *progress.Reporter
 }
 
-func strandFor(r *sam.Record) seq.Strand {
-	if r.Flags&sam.Reverse != 0 {
-		return seq.Minus
+func (r progressReader) Read() (*sam.Record, error) {
+	rec, err := r.r.Read()
+	if err == nil {
+		r.p.Add(1)
 	}
-	return seq.Plus
+	return rec, err
 }
 
-type refiner struct {
-	refWindow   int
-	queryWindow int
-	minQueryGap int
-	minRefFlank int
-
-	ref map[string]*linear.Seq
-	sw  align.SW
-}
+// samToBAM converts the SAM file src to the BAM file dst, using
+// procs compression goroutines.
+func samToBAM(src, dst string, procs int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sr, err := sam.NewReader(f)
+	if err != nil {
+		return err
+	}
 
-func readContigs(file string) (map[string]*linear.Seq, error) {
-	f, err := os.Open(file)
+	out, err := os.Create(dst)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer out.Close()
+	bw, err := bam.NewWriter(out, sr.Header(), procs)
+	if err != nil {
+		return err
 	}
-	seqs := make(map[string]*linear.Seq)
-	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
-	for sc.Next() {
-		s := sc.Seq().(*linear.Seq)
-		seqs[s.ID] = s
+	for {
+		r, err := sr.Read()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		err = bw.Write(r)
+		if err != nil {
+			return err
+		}
 	}
+	err = bw.Close()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return seqs, nil
+	return out.Close()
 }
 
-func makeTable(alnmat mat) align.SW {
+// makeTable returns a Smith-Waterman aligner for the given scoring
+// parameters. If sub is not nil, it is used as the substitution matrix
+// in place of the match, mismatch and gap values of alnmat, and must be
+// square with the dimension of the alphabet. If alnmat has four values,
+// an affine gap aligner is returned.
+func makeTable(alnmat mat, sub [][]int) (align.Aligner, error) {
 	alpha := alphabet.DNAgapped
-	match := alnmat[0]
-	mismatch := alnmat[1]
-	gap := alnmat[2]
-	sw := make(align.SW, alpha.Len())
-	for i := range sw {
-		row := make([]int, alpha.Len())
-		for j := range row {
-			row[j] = mismatch
-		}
-		row[i] = match
-		sw[i] = row
-	}
-	for i := range sw {
-		sw[0][i] = gap
-		sw[i][0] = gap
-	}
-	return sw
-}
-
-// adjustDeletion performs a deletion ends refinement based on a
-// pair of Smith-Waterman alignments.
-//
-//                    l      s   e      r
-//  ref:         -----|------+~~~+------|----------
-//
-//  query_left:  ----|-----------+~~~~~~|~~~~~~+---------------
-//                   l           s      m      e
-//  query_right: ----------------+~~~~~~|~~~~~~+-----------|---
-//                               s      m      e           r
-//
-//  where ~~ is the region found by CIGAR score walking above in the
-//  deletions function.
-//
-//  align ref(l..r) with query_left(l..m) -> ref(s)-query_left(s)
-//  align ref(l..r) with query_right(m..r) -> ref(e)-query_left(e)
-//
-// This can give either of two outcomes:
-//  1. ref(s) < ref(e)
-//  2. ref(e) <= ref(s)
-//
-// The first case is a standard colinear alignment:
-//
-//                              s   e
-//  ref:             -----------+---+-----------------
-//                             /     \
-//                            /       \
-//                           /         \
-//                          /           \
-//  query: ----------------+-------------+---------------
-//                         s             e
-//
-//
-// The second case is a non-colinear alignment:
-//
-//                              e   s
-//  ref:             -----------+---+-----------------
-//                               \ /
-//                                /
-//                               / \
-//                              /   \
-//                             /     \
-//                            /       \
-//                           /         \
-//                          /           \
-//  query: ----------------+-------------+---------------
-//                         s             e
-//
-//
-// which has a potential target site duplication interpretation:
-//
-//                              e   s
-//  ref:             -----------+---+-----------------
-//                             / \ / \
-//                            /   /   \
-//                           /   / \   \
-//                          /   /   \   \
-//                         /   /     \   \
-//                        /   /       \   \
-//                       /   /         \   \
-//                      /   /           \   \
-//  query: ------------+---+-------------+---+-----------
-//                         s             e
-//
-// adjustDeletions handles the second case by making ref(s=e) for the
-// reference and adding annotation for the length of the duplication
-// (d) in ref:
-//
-//                             s|e s+d
-//  ref:             -----------+---+-----------------
-//                             / \ / \
-//                            /   /   \
-//                           /   / \   \
-//                          /   /   \   \
-//                         /   /     \   \
-//                        /   /       \   \
-//                       /   /         \   \
-//                      /   /           \   \
-//  query: ------------+---+-------------+---+-----------
-//                    s-d  s             e  e+d
-//
-func (r *refiner) adjust(d deletion) (refined deletion, ok bool, err error) {
-	if r == nil {
-		return d, false, nil
-	}
-	if d.qend-d.qstart < d.rend-d.rstart {
-		// Do not do any work for deletions.
-		return d, false, fmt.Errorf("not an insertion: len(q)=%d len(r)=%d", d.qend-d.qstart, d.rend-d.rstart)
-	}
-
-	name := d.record.Ref.Name()
-	ref, ok := r.ref[name]
-	if !ok {
-		return d, false, fmt.Errorf("no reference sequence for %q", name)
+	var sw align.SW
+	if sub != nil {
+		if len(sub) != alpha.Len() {
+			return nil, fmt.Errorf("invalid matrix dimensions: %d rows for alphabet of length %d", len(sub), alpha.Len())
+		}
+		for i, row := range sub {
+			if len(row) != alpha.Len() {
+				return nil, fmt.Errorf("invalid matrix dimensions: row %d has %d columns for alphabet of length %d", i, len(row), alpha.Len())
+			}
+		}
+		sw = sub
+	} else {
+		match := alnmat[0]
+		mismatch := alnmat[1]
+		gap := alnmat[len(alnmat)-1]
+		sw = make(align.SW, alpha.Len())
+		for i := range sw {
+			row := make([]int, alpha.Len())
+			for j := range row {
+				row[j] = mismatch
+			}
+			row[i] = match
+			sw[i] = row
+		}
+		for i := range sw {
+			sw[0][i] = gap
+			sw[i][0] = gap
+		}
 	}
-
-	rs := *ref
-	rOff := max(0, d.rstart-r.refWindow/2)
-	rs.Seq = ref.Seq[rOff:min(d.rend+r.refWindow/2, len(ref.Seq))]
-
-	q := alphabet.BytesToLetters(d.record.Seq.Expand())
-
-	// Align the left junction of the qeuery to
-	// the reference around the indel site.
-	qsl := linear.NewSeq(d.record.Name, nil, alphabet.DNAgapped)
-	qOffLeft := max(0, d.qstart-r.queryWindow)
-	qsl.Seq = q[qOffLeft : (d.qstart+d.qend)/2]
-	alnl, err := r.sw.Align(&rs, qsl)
-	if err != nil {
-		return d, false, err
+	if len(alnmat) == 4 {
+		return align.SWAffine{Matrix: align.Linear(sw), GapOpen: alnmat[2]}, nil
 	}
+	return sw, nil
+}
 
-	// Align the right junction of the qeuery to
-	// the reference around the indel site.
-	qsr := linear.NewSeq(d.record.Name, nil, alphabet.DNAgapped)
-	qOffRight := (d.qstart + d.qend) / 2
-	qsr.Seq = q[qOffRight:min(d.qend+r.queryWindow, len(q))]
-	alnr, err := r.sw.Align(&rs, qsr)
+// resumeFrom opens the partial GFF output in the named file for appending,
+// returning the names of the reads with features in it and whether the
+// header of the output is retained. The features of the last read in the
+// file and any partial final line are removed since they may be incomplete,
+// and the last read is not included in the returned set. If the file holds
+// no features, it is truncated to empty since its header may be incomplete.
+func resumeFrom(path string) (f *os.File, done map[string]bool, header bool, err error) {
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
-		return d, false, err
+		return nil, nil, false, err
 	}
+	done = make(map[string]bool)
+	var (
+		r = bufio.NewReader(f)
 
-	// Get left and right ends of insertion in read
-	// and the aligned segment of the reference.
-	left := alnl[len(alnl)-1].Features()
-	right := alnr[0].Features()
-
-	// Bail out if the alignment extends too far.
-	// We might have continued alignment.
-	if flank := right[0].Start(); flank < r.minRefFlank {
-		return d, false, fmt.Errorf("skipping: right ref flank less than %d from left: len(flank)=%v",
-			r.minRefFlank, flank)
-	}
-	if flank := left[0].End(); len(rs.Seq)-flank < r.minRefFlank {
-		return d, false, fmt.Errorf("skipping: left ref flank less than %d from right: len(flank)=%v",
-			r.minRefFlank, len(rs.Seq)-flank)
+		off   int64
+		last  string
+		start int64
+	)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			// Any remaining text is a partial line.
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, false, err
+		}
+		if line != "\n" && !strings.HasPrefix(line, "#") {
+			name, err := readName(line)
+			if err != nil {
+				f.Close()
+				return nil, nil, false, fmt.Errorf("%s at offset %d", err, off)
+			}
+			if name != last {
+				if last != "" {
+					done[last] = true
+				}
+				last = name
+				start = off
+			}
+		}
+		off += int64(len(line))
 	}
-
-	centrel := r.queryWindow + (d.qend-d.qstart)/2
-	centrer := 0
-
-	// Bail out if the insertion is too short.
-	// We might have continued alignment.
-	if gap := centrel - left[1].End(); gap < r.minQueryGap {
-		return d, false, fmt.Errorf("skipping left: left query gap less than %d from centre: len(gap)=%v",
-			r.minQueryGap, gap)
+	header = last != ""
+	if header {
+		off = start
+	} else {
+		off = 0
 	}
-	if gap := right[1].Start() - centrer; gap < r.minQueryGap {
-		return d, false, fmt.Errorf("skipping right: right query gap less than %d from centre: len(gap)=%v",
-			r.minQueryGap, gap)
+	err = f.Truncate(off)
+	if err == nil {
+		_, err = f.Seek(off, io.SeekStart)
 	}
-
-	d.rstart = rOff + left[0].End()
-	d.rend = rOff + right[0].Start()
-	if d.rend <= d.rstart {
-		d.dup = d.rstart - d.rend
-		d.rstart = d.rend
+	if err != nil {
+		f.Close()
+		return nil, nil, false, err
 	}
-
-	d.qstart = qOffLeft + left[1].End()
-	d.qend = qOffRight + alnr[0].Features()[1].Start()
-
-	return d, true, nil
+	return f, done, header, nil
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+// readName returns the read name from the Read attribute of the reefer
+// GFF feature line.
+func readName(line string) (string, error) {
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+	if len(fields) < 9 {
+		return "", fmt.Errorf("malformed GFF line %q", line)
+	}
+	for _, attr := range strings.Split(fields[8], ";") {
+		f := strings.Fields(attr)
+		if len(f) == 4 && f[0] == "Read" {
+			return f[1], nil
+		}
 	}
-	return b
+	return "", fmt.Errorf("no read attribute in GFF line %q", line)
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// readMatrix reads a whitespace separated substitution matrix from the
+// named file. Blank lines and lines starting with '#' are ignored.
+func readMatrix(path string) ([][]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m [][]int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		row := make([]int, len(fields))
+		for i, v := range fields {
+			row[i], err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid matrix value: %v", err)
+			}
+		}
+		m = append(m, row)
 	}
-	return b
+	return m, sc.Err()
 }
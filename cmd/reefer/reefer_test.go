@@ -0,0 +1,172 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/seq/linear"
+	"github.com/biogo/hts/sam"
+
+	"github.com/kortschak/loopy/reefer"
+)
+
+// testReads is the reefer package testdata SAM file.
+var testReads = filepath.Join("..", "..", "reefer", "testdata", "reads.sam")
+
+// discordances writes the features found in testReads to w, skipping the
+// reads in done, with the header written unless noHeader is true.
+func discordances(t *testing.T, w *os.File, done map[string]bool, noHeader bool) {
+	f, err := os.Open(testReads)
+	if err != nil {
+		t.Fatalf("failed to open alignments: %v", err)
+	}
+	defer f.Close()
+	sr, err := sam.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read alignment header: %v", err)
+	}
+	cfg := reefer.Config{Window: 50, MinSize: 100, Programs: sr.Header().Progs(), NoHeader: noHeader}
+	err = reefer.Discordances(w, reefer.Skip(sr, done), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reefer")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reads.gff")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create output: %v", err)
+	}
+	discordances(t, f, nil, false)
+	f.Close()
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if bytes.Count(want, []byte("\n#")) == 0 {
+		t.Fatalf("no header in complete output:\n%s", want)
+	}
+
+	// Interrupt the output at every byte and resume.
+	for cut := 0; cut <= len(want); cut++ {
+		err = ioutil.WriteFile(path, want[:cut], 0664)
+		if err != nil {
+			t.Fatalf("failed to write partial output: %v", err)
+		}
+		f, done, header, err := resumeFrom(path)
+		if err != nil {
+			t.Fatalf("unexpected error resuming from %d bytes: %v", cut, err)
+		}
+		discordances(t, f, done, header)
+		f.Close()
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("unexpected output resuming from %d bytes:\ngot:\n%s\nwant:\n%s", cut, got, want)
+		}
+	}
+}
+
+func TestMakeTableAffine(t *testing.T) {
+	// The query has a two base deletion
+	// relative to the reference.
+	ref := linear.NewSeq("ref", alphabet.BytesToLetters([]byte("gattacattggtcagt")), alphabet.DNAgapped)
+	query := linear.NewSeq("query", alphabet.BytesToLetters([]byte("gattacaggtcagt")), alphabet.DNAgapped)
+
+	for _, test := range []struct {
+		align     string
+		affine    bool
+		wantAln   string
+		wantScore int
+	}{
+		// The gap costs 6 with linear scoring, so
+		// the alignment extends across the gap.
+		{
+			align:     "1,-2,-3",
+			wantAln:   "[[0,7)/[0,7)=7 [7,9)/-=-6 [9,16)/[7,14)=7]",
+			wantScore: 8,
+		},
+		// The gap costs 9 with the open score, so only
+		// the longer ungapped part is aligned.
+		{
+			align:     "1,-2,-3,-3",
+			affine:    true,
+			wantAln:   "[[9,16)/[7,14)=7]",
+			wantScore: 7,
+		},
+	} {
+		var m mat
+		err := m.Set(test.align)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", test.align, err)
+		}
+		sw, err := makeTable(m, nil)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", test.align, err)
+		}
+		if _, ok := sw.(align.SWAffine); ok != test.affine {
+			t.Errorf("unexpected aligner type for %q: got:%T", test.align, sw)
+		}
+		aln, err := sw.Align(ref, query)
+		if err != nil {
+			t.Fatalf("unexpected alignment error for %q: %v", test.align, err)
+		}
+		if got := fmt.Sprint(aln); got != test.wantAln {
+			t.Errorf("unexpected alignment for %q: got:%s want:%s", test.align, got, test.wantAln)
+		}
+		var score int
+		for _, seg := range aln {
+			score += seg.(interface{ Score() int }).Score()
+		}
+		if score != test.wantScore {
+			t.Errorf("unexpected alignment score for %q: got:%d want:%d", test.align, score, test.wantScore)
+		}
+	}
+}
+
+func TestReadMatrix(t *testing.T) {
+	for _, test := range []struct {
+		file         string
+		wantReadErr  bool
+		wantTableErr bool
+	}{
+		{file: "matrix.txt"},
+		{file: "missing.txt", wantReadErr: true},
+		{file: "bad-value.txt", wantReadErr: true},
+		{file: "ragged.txt", wantTableErr: true},
+		{file: "short.txt", wantTableErr: true},
+	} {
+		// The tsd package testdata holds matrix files
+		// in the format used by -matrix.
+		sub, err := readMatrix(filepath.Join("..", "..", "tsd", "testdata", test.file))
+		if (err != nil) != test.wantReadErr {
+			t.Errorf("unexpected read error for %s: got:%v want error:%t", test.file, err, test.wantReadErr)
+		}
+		if err != nil {
+			continue
+		}
+		_, err = makeTable(alnmat, sub)
+		if (err != nil) != test.wantTableErr {
+			t.Errorf("unexpected table error for %s: got:%v want error:%t", test.file, err, test.wantTableErr)
+		}
+	}
+}
@@ -4,6 +4,9 @@
 
 // rinse removes events that are either too close to the end of a read
 // or a contig, or map to a site of a repeat of the same class.
+//
+// Contig lengths are read from the fai index of the contig fasta file,
+// which is made if it does not exist. Contig names must be unique.
 package main
 
 import (
@@ -11,16 +14,16 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 
 	"github.com/biogo/biogo/alphabet"
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/gff"
-	"github.com/biogo/biogo/io/seqio"
-	"github.com/biogo/biogo/io/seqio/fasta"
-	"github.com/biogo/biogo/seq/linear"
 	"github.com/biogo/store/interval"
+
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/readname"
 )
 
 var (
@@ -29,6 +32,7 @@ var (
 	ref     = flag.String("ref", "", "annotation gff file")
 	contigs = flag.String("contigs", "", "contig fasta file")
 	buf     = flag.Int("buffer", 100, "minimum distance from end of read")
+	gz      = flag.Bool("gzip", false, "gzip compress the GFF output")
 )
 
 func main() {
@@ -46,17 +50,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to read mapping file: %v", err)
 	}
-	contigLength, err := readContigs(*contigs)
+	contigFile, err := faidx.Open(*contigs, alphabet.DNA)
 	if err != nil {
 		log.Fatalf("failed to read contig file: %v", err)
 	}
+	defer contigFile.Close()
 
 	f, err := os.Open(*in)
 	if err != nil {
 		log.Fatalf("failed to open %q: %v", *in, err)
 	}
 
-	w := gff.NewWriter(os.Stdout, 60, true)
+	out := output.Stdout(*gz)
+	w := gff.NewWriter(out, 60, true)
 
 	sc := featio.NewScanner(gff.NewReader(f))
 	for sc.Next() {
@@ -76,11 +82,11 @@ func main() {
 		}
 		fields := strings.Fields(repeat)
 
-		name := strings.Split(f.SeqName, "//")
-		if len(name) != 2 {
+		read, _, _, _, err := readname.ParseExtracted(f.SeqName)
+		if err != nil {
 			log.Fatalf("unexpected sequence name in input: %q", f.SeqName)
 		}
-		contigSide, ok := mapping[name[0]]
+		contigSide, ok := mapping[read]
 		if !ok {
 			log.Fatalf("unexpected sequence name in input: %q", f.SeqName)
 		}
@@ -88,7 +94,7 @@ func main() {
 			log.Printf("too close to contig start:\n\texcluding %#v\n\tcontig %#v\n\n%d < %d", f, contigSide, contigSide.FeatStart, *buf)
 			continue
 		}
-		length, ok := contigLength[contigSide.SeqName]
+		length, ok := contigFile.Len(contigSide.SeqName)
 		if !ok {
 			log.Fatalf("unexpected sequence name in contig mapping: %q", contigSide.SeqName)
 		}
@@ -120,34 +126,34 @@ func main() {
 			}
 			continue
 		}
-		w.Write(f)
+		_, err = w.Write(f)
+		if err != nil {
+			log.Fatalf("failed to write feature: %v", err)
+		}
 	}
 	err = sc.Error()
 	if err != nil {
 		log.Fatalf("error during GFF read: %v", err)
 	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
 }
 
 func within(buffer int, name string) (bool, error) {
-	fields := strings.Split(name, "//")
-	if len(fields) != 2 {
-		return false, fmt.Errorf("wrong number of fields: %q", name)
-	}
-	readRangeIdx := strings.LastIndex(fields[0], "/")
-	if readRangeIdx < 0 {
-		return false, fmt.Errorf("no path separator: %q", fields[0])
-	}
-
-	readStart, readEnd, err := underscorePair(fields[0][readRangeIdx+1:])
+	read, featStart, featEnd, _, err := readname.ParseExtracted(name)
 	if err != nil {
 		return false, err
 	}
-	readLen := readEnd - readStart
-
-	featStart, featEnd, err := underscorePair(strings.TrimSuffix(fields[1], "(-)"))
+	n, err := readname.Parse(read)
 	if err != nil {
 		return false, err
 	}
+	if n.IsCCS() {
+		return false, fmt.Errorf("no subread range: %q", read)
+	}
+	readLen := n.End - n.Start
 
 	if featStart < buffer {
 		return false, nil
@@ -158,22 +164,6 @@ func within(buffer int, name string) (bool, error) {
 	return true, nil
 }
 
-func underscorePair(s string) (left, right int, err error) {
-	fields := strings.Split(s, "_")
-	if len(fields) != 2 {
-		return 0, 0, fmt.Errorf("too many fields: %q", s)
-	}
-	left, err = strconv.Atoi(fields[0])
-	if err != nil {
-		return 0, 0, err
-	}
-	right, err = strconv.Atoi(fields[1])
-	if err != nil {
-		return 0, 0, err
-	}
-	return left, right, nil
-}
-
 func readMappings(file string) (map[string]*gff.Feature, error) {
 	f, err := os.Open(file)
 	if err != nil {
@@ -196,23 +186,6 @@ func readMappings(file string) (map[string]*gff.Feature, error) {
 	return mapping, nil
 }
 
-func readContigs(file string) (map[string]int, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	lengths := make(map[string]int)
-	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNA)))
-	for sc.Next() {
-		s := sc.Seq()
-		lengths[s.Name()] = s.Len()
-	}
-	if err != nil {
-		log.Fatalf("error during fasta read: %v", err)
-	}
-	return lengths, nil
-}
-
 func readAnnotations(file string) (map[string]*interval.IntTree, error) {
 	f, err := os.Open(file)
 	if err != nil {
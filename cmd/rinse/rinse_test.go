@@ -0,0 +1,105 @@
+// Copyright ©2015 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// oldWithin and oldUnderscorePair are the read name handling of within
+// before read names were parsed by the readname package.
+func oldWithin(buffer int, name string) (bool, error) {
+	fields := strings.Split(name, "//")
+	if len(fields) != 2 {
+		return false, fmt.Errorf("wrong number of fields: %q", name)
+	}
+	readRangeIdx := strings.LastIndex(fields[0], "/")
+	if readRangeIdx < 0 {
+		return false, fmt.Errorf("no path separator: %q", fields[0])
+	}
+
+	readStart, readEnd, err := oldUnderscorePair(fields[0][readRangeIdx+1:])
+	if err != nil {
+		return false, err
+	}
+	readLen := readEnd - readStart
+
+	featStart, featEnd, err := oldUnderscorePair(strings.TrimSuffix(fields[1], "(-)"))
+	if err != nil {
+		return false, err
+	}
+
+	if featStart < buffer {
+		return false, nil
+	}
+	if readLen-featEnd < buffer {
+		return false, nil
+	}
+	return true, nil
+}
+
+func oldUnderscorePair(s string) (left, right int, err error) {
+	fields := strings.Split(s, "_")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("too many fields: %q", s)
+	}
+	left, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	right, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return left, right, nil
+}
+
+func TestWithin(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want bool
+	}{
+		{name: "m160101_000000_42156_c1/10/0_1000//100_900", want: true},
+		{name: "m1/10/0_1000//100_900(-)", want: true},
+		{name: "m1/10/0_1000//99_900", want: false},
+		{name: "m1/10/0_1000//100_901", want: false},
+		{name: "m1/10/5000_6000//100_900", want: true},
+		{name: "m1/10/5000_6000//100_950", want: false},
+	} {
+		got, err := within(100, test.name)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("unexpected result for %q: got:%t want:%t", test.name, got, test.want)
+		}
+		old, err := oldWithin(100, test.name)
+		if err != nil {
+			t.Errorf("unexpected error for old parse of %q: %v", test.name, err)
+			continue
+		}
+		if got != old {
+			t.Errorf("result differs from old parse for %q: got:%t old:%t", test.name, got, old)
+		}
+	}
+
+	// Names that are not extracted from PacBio subreads are errors.
+	for _, name := range []string{
+		"m1/10/0_1000",
+		"m1/10/ccs//100_900",
+		"m1/10//100_900",
+		"read/0_1000//100_900",
+		"m1/10/0_1000//100-900",
+	} {
+		_, err := within(100, name)
+		if err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
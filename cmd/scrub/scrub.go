@@ -0,0 +1,176 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// scrub checks a multiple fasta sequence file for features that censor
+// and blasr do not handle and writes a clean copy of the sequences.
+//
+// CRLF line endings and blank lines are reported and are always removed
+// from the output. Sequence letters other than ACGTN are reported for each
+// sequence and, if -fix-ambiguous is given, are replaced with N, or n for
+// lower case letters. Duplicate sequence IDs are a fatal error since there
+// is no way to tell which hits belong to which sequence after analysis.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+var (
+	fix  = flag.Bool("fix-ambiguous", false, "replace letters other than ACGTN with N")
+	gz   = flag.Bool("gzip", false, "gzip compress scrubbed fasta output")
+	wrap = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
+)
+
+func main() {
+	flag.Parse()
+
+	out := output.Stdout(*gz)
+	sum, err := scrubFasta(out, os.Stdin, *fix, *wrap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+
+	if sum.crlf != 0 {
+		log.Printf("normalized %d CRLF line endings", sum.crlf)
+	}
+	if sum.blank != 0 {
+		log.Printf("removed %d blank lines", sum.blank)
+	}
+	if sum.ambiguous != 0 {
+		if *fix {
+			log.Printf("replaced %d letters in %d sequences", sum.fixed, sum.ambiguous)
+		} else {
+			log.Printf("%d sequences contain letters other than ACGTN: use -fix-ambiguous to replace them", sum.ambiguous)
+		}
+	}
+}
+
+// summary holds the number of problems found by scrubFasta.
+type summary struct {
+	// crlf and blank are the number of CRLF
+	// line endings and blank lines.
+	crlf, blank int
+
+	// ambiguous is the number of sequences with
+	// letters other than ACGTN and fixed is the
+	// number of letters replaced.
+	ambiguous, fixed int
+}
+
+// scrubFasta writes a clean copy of the fasta sequences read from src to
+// dst with lines wrapped at the given width, replacing letters other than
+// ACGTN if fix is true, and returns a summary of the problems found. The
+// letters other than ACGTN in each sequence are logged. It is an error for
+// src to hold duplicate sequence IDs.
+func scrubFasta(dst io.Writer, src io.Reader, fix bool, wrap int) (summary, error) {
+	var sum summary
+	in := &lineChecker{r: src}
+	seen := make(map[string]bool)
+	sc := seqio.NewScanner(fasta.NewReader(in, linear.NewSeq("", nil, alphabet.DNA)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		if seen[s.ID] {
+			return sum, fmt.Errorf("duplicate sequence ID: %s", s.ID)
+		}
+		seen[s.ID] = true
+
+		n := scrub(s.Seq, fix)
+		if n != 0 {
+			sum.ambiguous++
+			if fix {
+				sum.fixed += n
+				log.Printf("%s: replaced %d letters other than ACGTN", s.ID, n)
+			} else {
+				log.Printf("%s: %d letters other than ACGTN", s.ID, n)
+			}
+		}
+
+		err := sequtil.WriteFasta(dst, s, wrap)
+		if err != nil {
+			return sum, fmt.Errorf("failed to write sequence: %v", err)
+		}
+	}
+	err := sc.Error()
+	if err != nil {
+		return sum, fmt.Errorf("error during fasta read: %v", err)
+	}
+	sum.crlf = in.crlf
+	sum.blank = in.blank
+	return sum, nil
+}
+
+// scrub returns the number of letters in s that are not ACGTN, replacing
+// them with N, preserving case, if fix is true.
+func scrub(s alphabet.Letters, fix bool) int {
+	var n int
+	for i, l := range s {
+		switch l {
+		case 'A', 'C', 'G', 'T', 'N', 'a', 'c', 'g', 't', 'n':
+			continue
+		}
+		n++
+		if !fix {
+			continue
+		}
+		if 'a' <= l && l <= 'z' {
+			s[i] = 'n'
+		} else {
+			s[i] = 'N'
+		}
+	}
+	return n
+}
+
+// lineChecker is an io.Reader that counts CRLF line endings and blank
+// lines in the data read through it. Blank lines include lines holding
+// only white space.
+type lineChecker struct {
+	r io.Reader
+
+	crlf, blank int
+
+	// inLine is true if the last byte read was not
+	// the end of a line and the current line holds
+	// a non-space byte.
+	inLine bool
+	// lastCR is true if the last byte read was '\r'.
+	lastCR bool
+}
+
+func (c *lineChecker) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	for _, v := range b[:n] {
+		switch v {
+		case '\n':
+			if c.lastCR {
+				c.crlf++
+			}
+			if !c.inLine {
+				c.blank++
+			}
+			c.inLine = false
+		case '\r', ' ', '\t', '\v', '\f':
+		default:
+			c.inLine = true
+		}
+		c.lastCR = v == '\r'
+	}
+	return n, err
+}
@@ -0,0 +1,51 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScrubFasta(t *testing.T) {
+	before, err := ioutil.ReadFile(filepath.Join("testdata", "before.fa"))
+	if err != nil {
+		t.Fatalf("failed to read input: %v", err)
+	}
+	for _, test := range []struct {
+		fix  bool
+		want string
+		sum  summary
+	}{
+		{fix: false, want: "after.fa", sum: summary{crlf: 4, blank: 3, ambiguous: 1}},
+		{fix: true, want: "after-fixed.fa", sum: summary{crlf: 4, blank: 3, ambiguous: 1, fixed: 6}},
+	} {
+		var buf bytes.Buffer
+		sum, err := scrubFasta(&buf, bytes.NewReader(before), test.fix, 60)
+		if err != nil {
+			t.Fatalf("unexpected error with fix=%t: %v", test.fix, err)
+		}
+		if sum != test.sum {
+			t.Errorf("unexpected summary with fix=%t: got:%+v want:%+v", test.fix, sum, test.sum)
+		}
+		want, err := ioutil.ReadFile(filepath.Join("testdata", test.want))
+		if err != nil {
+			t.Fatalf("failed to read expected output: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("unexpected output with fix=%t:\ngot:\n%s\nwant:\n%s", test.fix, &buf, want)
+		}
+	}
+}
+
+func TestScrubFastaDuplicate(t *testing.T) {
+	_, err := scrubFasta(ioutil.Discard, strings.NewReader(">a\nacgt\n>b\nacgt\n>a\nacgt\n"), false, 60)
+	if err == nil || !strings.Contains(err.Error(), "duplicate sequence ID: a") {
+		t.Errorf("unexpected error for duplicate ID: got:%v want:duplicate sequence ID: a", err)
+	}
+}
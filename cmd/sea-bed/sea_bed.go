@@ -4,6 +4,14 @@
 
 // sea-bed outputs a set of fasta sequences based on a reference and
 // set of bed files.
+//
+// Reference sequence case is retained in the output unless -uppercase is
+// given, in which case soft-masked (lower case) sequence is converted to
+// upper case as the reference is read.
+//
+// Only the regions to be written are read from the reference, using its fai
+// index, which is made if it does not exist. Reference sequence names must
+// be unique.
 package main
 
 import (
@@ -16,14 +24,23 @@ import (
 	"github.com/biogo/biogo/alphabet"
 	"github.com/biogo/biogo/io/featio"
 	"github.com/biogo/biogo/io/featio/bed"
-	"github.com/biogo/biogo/io/seqio"
-	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq"
 	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/faidx"
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/progress"
+	"github.com/kortschak/loopy/internal/sequtil"
 )
 
 var (
 	ref   = flag.String("ref", "", "genome fasta file")
 	flank = flag.Int("flank", 0, "genome fasta file")
+	every = flag.Duration("progress", 0, "log progress at this interval (no progress logging if zero)")
+	gz    = flag.Bool("gzip", false, "gzip compress fasta output files")
+	trim  = flag.Bool("trim-ns", false, "trim leading and trailing N from output sequences")
+	upper = flag.Bool("uppercase", false, "convert soft-masked (lower case) reference sequence to upper case on reading")
+	wrap  = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
 )
 
 func main() {
@@ -37,10 +54,11 @@ func main() {
 		os.Exit(0)
 	}
 
-	seqs, err := readContigs(*ref)
+	genome, err := faidx.Open(*ref, alphabet.DNA)
 	if err != nil {
-		log.Fatalf("failed to read reference file: %v", err)
+		log.Fatalf("failed to open reference file: %v", err)
 	}
+	defer genome.Close()
 
 	for _, in := range flag.Args() {
 		bf, err := os.Open(in)
@@ -48,28 +66,39 @@ func main() {
 			log.Fatalf("failed to open bed file: %v", err)
 		}
 
-		br, err := bed.NewReader(bf, 3)
+		p := progress.ForFile(bf, *every)
+		br, err := bed.NewReader(p.Reader(bf), 3)
 		if err != nil {
 			log.Fatalf("failed to read bed file: %v", err)
 		}
 
-		out, err := os.Create(basename(in) + ".mfa")
+		name := basename(in) + ".mfa"
+		if *gz {
+			name += ".gz"
+		}
+		out, err := output.Create(name)
 		if err != nil {
 			log.Fatalf("failed to create fasta file: %v", err)
 		}
 
 		sc := featio.NewScanner(br)
 		for sc.Next() {
+			p.Add(1)
 			f := sc.Feat().(*bed.Bed3)
-			s := *seqs[f.Chrom]
-			start := max(0, f.ChromStart-*flank)
-			end := min(f.ChromEnd+*flank, len(s.Seq))
-			s.Seq = s.Seq[start:end]
-			s.ID = fmt.Sprintf("%s[%d,%d)", s.ID, start, end)
+			s, err := region(genome, f, *flank, *trim)
+			if err != nil {
+				log.Fatalf("failed to read reference region: %v", err)
+			}
+			if s == nil {
+				continue
+			}
+			if *upper {
+				sequtil.Upper(s.Seq, s.Alpha)
+			}
 			if *flank != 0 {
 				s.Desc = fmt.Sprintf("flanking [%d,%d)", f.ChromStart, f.ChromEnd)
 			}
-			_, err := fmt.Fprintf(out, "%60a\n", &s)
+			err = sequtil.WriteFasta(out, s, *wrap)
 			if err != nil {
 				log.Fatalf("failed to write fasta sequence: %v", err)
 			}
@@ -78,26 +107,46 @@ func main() {
 		if err != nil {
 			log.Fatalf("failed to read bed file: %v", err)
 		}
-		out.Close()
+		p.Done()
+		err = out.Close()
+		if err != nil {
+			log.Fatalf("failed to close fasta file: %v", err)
+		}
 		bf.Close()
 	}
 }
 
-func readContigs(file string) (map[string]*linear.Seq, error) {
-	f, err := os.Open(file)
+// region returns the reference sequence for the feature f extended by
+// flank on each side and clipped to the bounds of its contig. If trim is
+// true, leading and trailing N are removed from the sequence. The returned
+// sequence is named with its contig and half-open interval and has the
+// description of the contig. If the trimmed sequence is empty, region
+// returns nil.
+func region(ref *faidx.File, f *bed.Bed3, flank int, trim bool) (*linear.Seq, error) {
+	length, ok := ref.Len(f.Chrom)
+	if !ok {
+		return nil, fmt.Errorf("no reference sequence for %q", f.Chrom)
+	}
+	start := max(0, f.ChromStart-flank)
+	end := min(f.ChromEnd+flank, length)
+	s, err := ref.Seq(f.Chrom, start, end, seq.Plus)
 	if err != nil {
 		return nil, err
 	}
-	seqs := make(map[string]*linear.Seq)
-	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNA)))
-	for sc.Next() {
-		s := sc.Seq().(*linear.Seq)
-		seqs[s.ID] = s
+	if trim {
+		i, j := sequtil.TrimAmbiguous(s.Seq, s.Alpha)
+		if i == j {
+			return nil, nil
+		}
+		s.Seq = s.Seq[i:j]
+		start, end = start+i, start+j
 	}
+	s.Desc, err = ref.Desc(f.Chrom)
 	if err != nil {
-		log.Fatalf("error during fasta read: %v", err)
+		return nil, err
 	}
-	return seqs, nil
+	s.ID = fmt.Sprintf("%s[%d,%d)", s.ID, start, end)
+	return s, nil
 }
 
 func basename(path string) string {
@@ -0,0 +1,114 @@
+// Copyright ©2016 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/featio/bed"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/faidx"
+)
+
+// reference is an N-padded contig with a scaffold gap, wrapped over
+// several lines, and a second contig.
+const reference = `>chr1 padded contig
+NNNNNacgta
+cgtNNNNNac
+gtNNNNN
+>chr2
+acgtacgtac
+gt
+`
+
+func TestRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sea-bed")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ref.fa")
+	err = ioutil.WriteFile(path, []byte(reference), 0664)
+	if err != nil {
+		t.Fatalf("failed to write reference: %v", err)
+	}
+
+	// Read the complete reference to compare with
+	// the regions read using the index.
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open reference: %v", err)
+	}
+	whole := make(map[string]*linear.Seq)
+	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNA)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		whole[s.Name()] = s
+	}
+	f.Close()
+	if err := sc.Error(); err != nil {
+		t.Fatalf("failed to read reference: %v", err)
+	}
+
+	ref, err := faidx.Open(path, alphabet.DNA)
+	if err != nil {
+		t.Fatalf("failed to open indexed reference: %v", err)
+	}
+	defer ref.Close()
+
+	for _, test := range []struct {
+		chrom      string
+		start, end int
+		flank      int
+		trim       bool
+
+		wantStart, wantEnd int
+		wantOK             bool
+	}{
+		{chrom: "chr1", start: 0, end: 27, wantStart: 0, wantEnd: 27, wantOK: true},
+		{chrom: "chr1", start: 0, end: 27, trim: true, wantStart: 5, wantEnd: 22, wantOK: true},
+		{chrom: "chr1", start: 7, end: 20, trim: true, wantStart: 7, wantEnd: 20, wantOK: true},
+		{chrom: "chr1", start: 7, end: 20, flank: 4, trim: true, wantStart: 5, wantEnd: 22, wantOK: true},
+		{chrom: "chr1", start: 7, end: 20, flank: 4, wantStart: 3, wantEnd: 24, wantOK: true},
+		{chrom: "chr1", start: 13, end: 18, trim: true, wantOK: false},
+		{chrom: "chr1", start: 0, end: 5, flank: 10, trim: true, wantStart: 5, wantEnd: 13, wantOK: true},
+		{chrom: "chr2", start: 8, end: 12, flank: 10, wantStart: 0, wantEnd: 12, wantOK: true},
+	} {
+		f := &bed.Bed3{Chrom: test.chrom, ChromStart: test.start, ChromEnd: test.end}
+		got, err := region(ref, f, test.flank, test.trim)
+		if err != nil {
+			t.Errorf("unexpected error for %s[%d,%d): %v", test.chrom, test.start, test.end, err)
+			continue
+		}
+		if (got != nil) != test.wantOK {
+			t.Errorf("unexpected region for %s[%d,%d) flank=%d trim=%t: got:%v want ok:%t",
+				test.chrom, test.start, test.end, test.flank, test.trim, got, test.wantOK)
+			continue
+		}
+		if got == nil {
+			continue
+		}
+		want := *whole[test.chrom]
+		want.Seq = want.Seq[test.wantStart:test.wantEnd]
+		want.ID = fmt.Sprintf("%s[%d,%d)", want.ID, test.wantStart, test.wantEnd)
+		if gotFa, wantFa := fmt.Sprintf("%a", got), fmt.Sprintf("%a", &want); gotFa != wantFa {
+			t.Errorf("unexpected region for %s[%d,%d) flank=%d trim=%t:\ngot:\n%s\nwant:\n%s",
+				test.chrom, test.start, test.end, test.flank, test.trim, gotFa, wantFa)
+		}
+	}
+
+	_, err = region(ref, &bed.Bed3{Chrom: "chrX", ChromStart: 0, ChromEnd: 1}, 0, false)
+	if err == nil {
+		t.Error("expected error for missing reference sequence")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// sound reports a summary of the sequence length distribution of a set of
+// fasta sequences read from stdin.
+//
+// The summary includes the number of sequences, the total number of bases,
+// the minimum, maximum, mean and median lengths, the N50 and a histogram of
+// lengths with a configurable bucket width.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+var (
+	width  = flag.Int("bucket", 1000, "specify the histogram bucket width")
+	asJSON = flag.Bool("json", false, "output summary as JSON")
+)
+
+func main() {
+	flag.Parse()
+	if *width < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var lengths []int
+	sc := seqio.NewScanner(fasta.NewReader(os.Stdin, linear.NewSeq("", nil, alphabet.DNA)))
+	for sc.Next() {
+		lengths = append(lengths, sc.Seq().Len())
+	}
+	if err := sc.Error(); err != nil {
+		log.Fatalf("error during fasta read: %v", err)
+	}
+
+	s := summarise(lengths, *width)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err := enc.Encode(s)
+		if err != nil {
+			log.Fatalf("failed to write summary: %v", err)
+		}
+		return
+	}
+	fmt.Printf("count\t%d\n", s.Count)
+	fmt.Printf("total\t%d\n", s.Total)
+	fmt.Printf("min\t%d\n", s.Min)
+	fmt.Printf("max\t%d\n", s.Max)
+	fmt.Printf("mean\t%f\n", s.Mean)
+	fmt.Printf("median\t%f\n", s.Median)
+	fmt.Printf("N50\t%d\n", s.N50)
+	fmt.Println()
+	fmt.Println("start\tend\tcount")
+	for _, b := range s.Histogram {
+		fmt.Printf("%d\t%d\t%d\n", b.Start, b.End, b.Count)
+	}
+}
+
+// summary is a summary of a sequence length distribution.
+type summary struct {
+	Count     int     `json:"count"`
+	Total     int     `json:"total"`
+	Min       int     `json:"min"`
+	Max       int     `json:"max"`
+	Mean      float64 `json:"mean"`
+	Median    float64 `json:"median"`
+	N50       int     `json:"n50"`
+	Histogram []bin   `json:"histogram"`
+}
+
+// bin is a histogram bucket holding the number of sequences
+// with length in [Start,End).
+type bin struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Count int `json:"count"`
+}
+
+// summarise returns the summary of the given sequence lengths using
+// histogram buckets of the given width. The lengths slice is sorted
+// by summarise.
+func summarise(lengths []int, width int) summary {
+	if len(lengths) == 0 {
+		return summary{}
+	}
+	sort.Ints(lengths)
+
+	s := summary{
+		Count: len(lengths),
+		Min:   lengths[0],
+		Max:   lengths[len(lengths)-1],
+	}
+	for _, l := range lengths {
+		s.Total += l
+	}
+	s.Mean = float64(s.Total) / float64(s.Count)
+	if s.Count%2 == 1 {
+		s.Median = float64(lengths[s.Count/2])
+	} else {
+		s.Median = float64(lengths[s.Count/2-1]+lengths[s.Count/2]) / 2
+	}
+
+	// The N50 is the length of the shortest sequence
+	// in the set of longest sequences that together
+	// make up at least half of the total bases.
+	var sum int
+	for i := len(lengths) - 1; i >= 0; i-- {
+		sum += lengths[i]
+		if 2*sum >= s.Total {
+			s.N50 = lengths[i]
+			break
+		}
+	}
+
+	s.Histogram = make([]bin, s.Max/width+1)
+	for i := range s.Histogram {
+		s.Histogram[i].Start = i * width
+		s.Histogram[i].End = (i + 1) * width
+	}
+	for _, l := range lengths {
+		s.Histogram[l/width].Count++
+	}
+	// Trim empty buckets below the shortest sequence.
+	s.Histogram = s.Histogram[s.Min/width:]
+
+	return s
+}
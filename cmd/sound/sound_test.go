@@ -0,0 +1,87 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var summariseTests = []struct {
+	name    string
+	lengths []int
+	width   int
+	want    summary
+}{
+	{
+		name: "empty",
+		want: summary{},
+	},
+	{
+		name:    "single",
+		lengths: []int{1500},
+		width:   1000,
+		want: summary{
+			Count: 1, Total: 1500, Min: 1500, Max: 1500, Mean: 1500, Median: 1500, N50: 1500,
+			Histogram: []bin{{Start: 1000, End: 2000, Count: 1}},
+		},
+	},
+	{
+		name:    "odd count",
+		lengths: []int{4, 1, 3, 2, 10},
+		width:   5,
+		want: summary{
+			Count: 5, Total: 20, Min: 1, Max: 10, Mean: 4, Median: 3, N50: 10,
+			Histogram: []bin{{Start: 0, End: 5, Count: 4}, {Start: 5, End: 10, Count: 0}, {Start: 10, End: 15, Count: 1}},
+		},
+	},
+	{
+		name:    "even count",
+		lengths: []int{1, 2, 3, 4},
+		width:   2,
+		want: summary{
+			Count: 4, Total: 10, Min: 1, Max: 4, Mean: 2.5, Median: 2.5, N50: 3,
+			Histogram: []bin{{Start: 0, End: 2, Count: 1}, {Start: 2, End: 4, Count: 2}, {Start: 4, End: 6, Count: 1}},
+		},
+	},
+	{
+		// The longest sequence holds exactly half of an
+		// even total, so it alone is the N50 set.
+		name:    "even total tie",
+		lengths: []int{2, 3, 5},
+		width:   10,
+		want: summary{
+			Count: 3, Total: 10, Min: 2, Max: 5, Mean: 10.0 / 3, Median: 3, N50: 5,
+			Histogram: []bin{{Start: 0, End: 10, Count: 3}},
+		},
+	},
+	{
+		name:    "even total tie with equal lengths",
+		lengths: []int{1, 1, 2, 2, 2},
+		width:   10,
+		want: summary{
+			Count: 5, Total: 8, Min: 1, Max: 2, Mean: 1.6, Median: 2, N50: 2,
+			Histogram: []bin{{Start: 0, End: 10, Count: 5}},
+		},
+	},
+	{
+		name:    "trimmed histogram",
+		lengths: []int{2500, 2600, 4000},
+		width:   1000,
+		want: summary{
+			Count: 3, Total: 9100, Min: 2500, Max: 4000, Mean: 9100.0 / 3, Median: 2600, N50: 2600,
+			Histogram: []bin{{Start: 2000, End: 3000, Count: 2}, {Start: 3000, End: 4000, Count: 0}, {Start: 4000, End: 5000, Count: 1}},
+		},
+	},
+}
+
+func TestSummarise(t *testing.T) {
+	for _, test := range summariseTests {
+		got := summarise(append([]int(nil), test.lengths...), test.width)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected summary for %s:\ngot: %+v\nwant:%+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// survey checks the consistency of a reefer GFF file with a reference
+// before it is used in a long running analysis.
+//
+// Each feature is checked to have a Read attribute holding a read name
+// and integer start and end, to be on a contig present in the reference
+// and to lie within the bounds of that contig. If the GFF file has a
+// provenance stamp, its coordinate origin is also checked. All problems
+// are reported on stdout, with each missing contig reported only for its
+// first feature, and survey exits with a non-zero status if any are
+// found. No alignment is performed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/biogo/feat"
+	"github.com/biogo/biogo/io/featio"
+	"github.com/biogo/biogo/io/featio/gff"
+
+	"github.com/kortschak/loopy/internal/provenance"
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+var (
+	in  = flag.String("in", "", "specify input reefer gff file (required)")
+	ref = flag.String("ref", "", "specify reference fasta file (required)")
+
+	dupContigs = flag.Bool("allow-dup-contigs", false, "log duplicate reference sequence names instead of failing")
+)
+
+func main() {
+	flag.Parse()
+	if *in == "" || *ref == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	lengths, err := sequtil.ContigLengths(*ref, *dupContigs)
+	if err != nil {
+		log.Fatalf("failed to read reference: %v", err)
+	}
+
+	var problems int
+	report := func(format string, args ...interface{}) {
+		problems++
+		fmt.Printf(format+"\n", args...)
+	}
+
+	stamp, ok, err := provenance.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("failed to read provenance of %q: %v", *in, err)
+	}
+	if ok && stamp.Origin != provenance.GFF {
+		report("unexpected coordinate origin: %d", stamp.Origin)
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("failed to open %q: %v", *in, err)
+	}
+	defer f.Close()
+
+	var n int
+	missing := make(map[string]bool)
+	sc := featio.NewScanner(gff.NewReader(f))
+	for sc.Next() {
+		n++
+		f := sc.Feat().(*gff.Feature)
+		pos := fmt.Sprintf("feature %d (%s:%d-%d)", n, f.SeqName, feat.ZeroToOne(f.FeatStart), f.FeatEnd)
+
+		read := f.FeatAttributes.Get("Read")
+		fields := strings.Fields(read)
+		if len(fields) != 3 {
+			report("%s: bad Read attribute: %q", pos, read)
+		} else {
+			start, errs := strconv.Atoi(fields[1])
+			end, erre := strconv.Atoi(fields[2])
+			switch {
+			case errs != nil || erre != nil:
+				report("%s: bad Read coordinates: %q", pos, read)
+			case start < 1 || end < start:
+				report("%s: invalid Read coordinates: %q", pos, read)
+			}
+		}
+
+		length, ok := lengths[f.SeqName]
+		if !ok {
+			if !missing[f.SeqName] {
+				report("%s: contig %q not in reference", pos, f.SeqName)
+			}
+			missing[f.SeqName] = true
+			continue
+		}
+		if f.FeatStart < 0 || f.FeatEnd > length {
+			report("%s: outside contig bounds [1,%d]", pos, length)
+		}
+	}
+	if err := sc.Error(); err != nil {
+		log.Fatalf("error during gff read: %v", err)
+	}
+
+	log.Printf("checked %d features: %d problems", n, problems)
+	if problems != 0 {
+		os.Exit(1)
+	}
+}
@@ -3,15 +3,18 @@
 // license that can be found in the LICENSE file.
 
 // wring extracts a set of sequences from a SAM file based on a reefer GFF.
+//
+// The -strand flag restricts extraction to reads aligned to the plus or
+// minus strand of the reference; the strand of a read is taken from the
+// first SAM record with its name.
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"strconv"
-	"strings"
 
 	"github.com/biogo/biogo/alphabet"
 	"github.com/biogo/biogo/feat"
@@ -19,85 +22,139 @@ import (
 	"github.com/biogo/biogo/io/featio/gff"
 	"github.com/biogo/biogo/seq/linear"
 	"github.com/biogo/hts/sam"
+
+	"github.com/kortschak/loopy/internal/output"
+	"github.com/kortschak/loopy/internal/readname"
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+var (
+	gz     = flag.Bool("gzip", false, "gzip compress fasta output")
+	wrap   = flag.Int("wrap", 60, "fasta sequence line width (single line if zero)")
+	strand = flag.String("strand", "both", `extract reads aligned to the plus strand ("plus"), minus strand ("minus") or both ("both")`)
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	flag.Parse()
+	if flag.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "invalid invocation: must have at least one reads file")
 		os.Exit(1)
 	}
+	if *strand != "both" && *strand != "plus" && *strand != "minus" {
+		fmt.Fprintf(os.Stderr, "invalid invocation: unknown strand %q\n", *strand)
+		os.Exit(1)
+	}
+
+	extract, err := readRanges(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	extract := make(map[string][2]int)
-	sc := featio.NewScanner(gff.NewReader(os.Stdin))
+	out := output.Stdout(*gz)
+	for _, reads := range flag.Args() {
+		sf, err := os.Open(reads)
+		if err != nil {
+			log.Fatalf("failed to open %q: %v", reads, err)
+		}
+		err = wring(out, sf, extract)
+		if err != nil {
+			log.Fatalf("failed to extract from %q: %v", reads, err)
+		}
+		sf.Close()
+	}
+	err = out.Close()
+	if err != nil {
+		log.Fatalf("failed to close output: %v", err)
+	}
+}
+
+// readRanges returns the set of read ranges to extract for each read
+// named in the Read attributes of the GFF stream r.
+func readRanges(r io.Reader) (map[string][][2]int, error) {
+	extract := make(map[string][][2]int)
+	sc := featio.NewScanner(gff.NewReader(r))
 	for sc.Next() {
 		f := sc.Feat().(*gff.Feature)
 		read := f.FeatAttributes.Get("Read")
 		if read == "" {
 			continue
 		}
-		fields := strings.Fields(read)
-		name := fields[0]
-		start, err := strconv.Atoi(fields[1])
+		name, start, end, err := readname.ParseAttribute(read)
 		if err != nil {
-			log.Fatalf("failed to parse %q: %v", read, err)
+			return nil, fmt.Errorf("failed to parse %q: %v", read, err)
 		}
-		end, err := strconv.Atoi(fields[2])
-		if err != nil {
-			log.Fatalf("failed to parse %q: %v", read, err)
+		rng := [2]int{start, end}
+		if !hasRange(extract[name], rng) {
+			extract[name] = append(extract[name], rng)
 		}
-		extract[name] = [2]int{start, end}
 	}
 	err := sc.Error()
 	if err != nil {
-		log.Fatalf("error during GFF read: %v", err)
+		return nil, fmt.Errorf("error during GFF read: %v", err)
 	}
+	return extract, nil
+}
 
-	for _, reads := range os.Args[1:] {
-		sf, err := os.Open(reads)
-		if err != nil {
-			log.Fatalf("failed to open %q: %v", reads, err)
-		}
-		sr, err := sam.NewReader(sf)
+// wring writes the ranges in extract of the reads in the SAM stream src
+// that are aligned to the -strand strand to dst in fasta format. Reads are
+// removed from extract as they are found.
+func wring(dst io.Writer, src io.Reader, extract map[string][][2]int) error {
+	sr, err := sam.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to open SAM input: %v", err)
+	}
+	for {
+		r, err := sr.Read()
 		if err != nil {
-			log.Fatalf("failed to open SAM input %q: %v", reads, err)
-		}
-		for {
-			r, err := sr.Read()
-			if err != nil {
-				if err != io.EOF {
-					log.Fatalf("unexpected error reading SAM: %v", err)
-				}
-				break
+			if err != io.EOF {
+				return fmt.Errorf("unexpected error reading SAM: %v", err)
 			}
+			return nil
+		}
 
-			v, ok := extract[r.Name]
-			if !ok {
-				continue
-			}
-			// Currently reefer only expects a single hit per read,
-			// so any multiples are due to duplicate read file input.
-			// Update this behaviour if we change reefer to look at
-			// remapping soft-clipped segments.
-			delete(extract, r.Name)
+		ranges, ok := extract[r.Name]
+		if !ok {
+			continue
+		}
+		// A read may have more than one event, so all ranges
+		// are extracted. Multiple records with the same name
+		// are due to duplicate read file input, so only the
+		// first is used.
+		delete(extract, r.Name)
 
-			reverse := r.Flags&sam.Reverse != 0
-			rng := fmt.Sprintf("//%d_%d", v[0], v[1])
+		reverse := r.Flags&sam.Reverse != 0
+		if (*strand == "plus" && reverse) || (*strand == "minus" && !reverse) {
+			continue
+		}
+		seq := alphabet.BytesToLetters(r.Seq.Expand())
+		for _, v := range ranges {
+			name := readname.Extracted(r.Name, v[0], v[1], reverse)
+			// Ranges are one-based in the orientation of the
+			// read, so convert to a zero-based interval before
+			// putting them into the orientation of the SAM
+			// sequence.
+			v[0] = feat.OneToZero(v[0])
 			if reverse {
-				rng += "(-)"
-				len := r.Seq.Length
-				v[0], v[1] = len-v[1], len-v[0]
+				n := r.Seq.Length
+				v[0], v[1] = n-v[1], n-v[0]
 			}
-			v[0] = feat.OneToZero(v[0])
-			s := linear.NewSeq(
-				r.Name+rng,
-				alphabet.BytesToLetters(r.Seq.Expand())[v[0]:v[1]],
-				alphabet.DNA,
-			)
+			s := linear.NewSeq(name, seq[v[0]:v[1]], alphabet.DNA)
 			if reverse {
 				s.Desc = "(sequence revcomp relative to read)"
 			}
-			fmt.Printf("%60a\n", s)
+			err = sequtil.WriteFasta(dst, s, *wrap)
+			if err != nil {
+				return fmt.Errorf("failed to write sequence: %v", err)
+			}
+		}
+	}
+}
+
+func hasRange(ranges [][2]int, r [2]int) bool {
+	for _, v := range ranges {
+		if v == r {
+			return true
 		}
-		sf.Close()
 	}
+	return false
 }
@@ -0,0 +1,116 @@
+// Copyright ©2015 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+	"github.com/biogo/hts/sam"
+)
+
+// The reefer testdata holds the del and tsd reads aligned to the
+// plus strand and the minus read aligned to the minus strand, and
+// their reefer features.
+var (
+	testReads  = filepath.Join("..", "..", "reefer", "testdata", "reads.sam")
+	testEvents = filepath.Join("..", "..", "reefer", "testdata", "plain.gff")
+)
+
+// samSeqs returns the sequences of the records in the testdata reads
+// in the orientation of the reference.
+func samSeqs(t *testing.T) map[string]string {
+	f, err := os.Open(testReads)
+	if err != nil {
+		t.Fatalf("failed to open reads: %v", err)
+	}
+	defer f.Close()
+	sr, err := sam.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read SAM header: %v", err)
+	}
+	seqs := make(map[string]string)
+	for {
+		r, err := sr.Read()
+		if err == io.EOF {
+			return seqs
+		}
+		if err != nil {
+			t.Fatalf("failed to read record: %v", err)
+		}
+		seqs[r.Name] = string(r.Seq.Expand())
+	}
+}
+
+func TestWringStrand(t *testing.T) {
+	defer func(s string) { *strand = s }(*strand)
+
+	// want holds the extracted sequence name, and its read
+	// and interval in the SAM sequence, for each read.
+	type extraction struct {
+		name, read string
+		start, end int
+	}
+	var (
+		del   = extraction{name: "del//991_1011", read: "del", start: 990, end: 1011}
+		minus = extraction{name: "minus//892_1207(-)", read: "minus", start: 1900 - 1207, end: 1900 - 891}
+		tsd   = extraction{name: "tsd//509_889", read: "tsd", start: 508, end: 889}
+	)
+	seqs := samSeqs(t)
+	for _, test := range []struct {
+		strand string
+		want   []extraction
+	}{
+		{strand: "both", want: []extraction{del, minus, tsd}},
+		{strand: "plus", want: []extraction{del, tsd}},
+		{strand: "minus", want: []extraction{minus}},
+	} {
+		*strand = test.strand
+
+		f, err := os.Open(testEvents)
+		if err != nil {
+			t.Fatalf("failed to open events: %v", err)
+		}
+		extract, err := readRanges(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error reading events: %v", err)
+		}
+		f, err = os.Open(testReads)
+		if err != nil {
+			t.Fatalf("failed to open reads: %v", err)
+		}
+		var buf bytes.Buffer
+		err = wring(&buf, f, extract)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error for strand=%s: %v", test.strand, err)
+		}
+
+		var got, want []string
+		sc := seqio.NewScanner(fasta.NewReader(&buf, linear.NewSeq("", nil, alphabet.DNA)))
+		for sc.Next() {
+			s := sc.Seq().(*linear.Seq)
+			got = append(got, s.ID, s.Seq.String())
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output for strand=%s: %v", test.strand, err)
+		}
+		for _, e := range test.want {
+			want = append(want, e.name, seqs[e.read][e.start:e.end])
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected extraction for strand=%s:\ngot: %q\nwant:%q", test.strand, got, want)
+		}
+	}
+}
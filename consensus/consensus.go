@@ -0,0 +1,96 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package consensus provides naming of groups of repeat annotated events.
+package consensus
+
+import (
+	"sort"
+	"strings"
+)
+
+// Count is the number of events in a group annotated with a repeat type.
+type Count struct {
+	Type string
+	N    int
+}
+
+type byCount []Count
+
+func (c byCount) Len() int { return len(c) }
+func (c byCount) Less(i, j int) bool {
+	if c[i].N < c[j].N {
+		return true
+	}
+	// Heuristic for sort that longer names are likely to be
+	// a tighter definition, so use them in preference.
+	return c[i].N == c[j].N && len(c[i].Type) < len(c[j].Type)
+}
+func (c byCount) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+
+// Sorted returns the repeat type counts in g sorted in descending order
+// of count. Types with equal counts are ordered with longer names first.
+func Sorted(g map[string]int) []Count {
+	c := make([]Count, 0, len(g))
+	for typ, n := range g {
+		c = append(c, Count{Type: typ, N: n})
+	}
+	sort.Sort(sort.Reverse(byCount(c)))
+	return c
+}
+
+// Name returns a reasonable guess at the name of the repeat type of
+// a group of events with the repeat type counts in g.
+func Name(g map[string]int) string {
+	return NameOf(Sorted(g))
+}
+
+// NameOf returns a reasonable guess at the name of the repeat type of
+// a group of events with the sorted repeat type counts in g.
+//
+// If the most common type accounts for more than half of the events,
+// or exactly half when there are more than two types, it is the name
+// of the group. Otherwise if the most common type is an Alu, the name
+// is the Alu subfamily truncated to five characters. Failing these,
+// the name is the fusion of all the types, separated by slashes.
+func NameOf(g []Count) string {
+	if len(g) == 0 {
+		return ""
+	}
+
+	// Majority rule.
+	var n int
+	for _, e := range g {
+		n += e.N
+	}
+	r := float64(g[0].N) / float64(n)
+	if r > 0.5 || (r == 0.5 && len(g) > 2) {
+		return g[0].Type
+	}
+
+	// Alu heuristic.
+	if IsAlu(g[0].Type) {
+		return Trunc(g[0].Type, 5)
+	}
+
+	// Fusion.
+	names := make([]string, len(g))
+	for i, t := range g {
+		names[i] = t.Type
+	}
+	return strings.Join(names, "/")
+}
+
+// IsAlu returns whether the repeat type t is an Alu element.
+func IsAlu(t string) bool {
+	return strings.HasPrefix(strings.ToLower(t), "alu")
+}
+
+// Trunc returns name truncated to at most n bytes.
+func Trunc(name string, n int) string {
+	if n > len(name) {
+		n = len(name)
+	}
+	return name[:n]
+}
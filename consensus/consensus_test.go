@@ -0,0 +1,107 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package consensus
+
+import (
+	"reflect"
+	"testing"
+)
+
+var nameTests = []struct {
+	name   string
+	counts map[string]int
+	want   string
+}{
+	{
+		name:   "empty",
+		counts: nil,
+		want:   "",
+	},
+	{
+		name:   "single",
+		counts: map[string]int{"L1HS": 3},
+		want:   "L1HS",
+	},
+	{
+		name:   "majority",
+		counts: map[string]int{"L1HS": 3, "AluYa5": 1, "SVA_F": 1},
+		want:   "L1HS",
+	},
+	{
+		name:   "half of two types is not a majority",
+		counts: map[string]int{"L1HS": 2, "SVA_F": 2},
+		want:   "SVA_F/L1HS",
+	},
+	{
+		name:   "half of more than two types",
+		counts: map[string]int{"L1HS": 2, "SVA_F": 1, "THE1B": 1},
+		want:   "L1HS",
+	},
+	{
+		name:   "alu",
+		counts: map[string]int{"AluYa5": 2, "L1HS": 2},
+		want:   "AluYa",
+	},
+	{
+		name:   "alu lower case",
+		counts: map[string]int{"aluSx1": 2, "L1HS": 1, "SVA_F": 1, "THE1B": 1},
+		want:   "aluSx",
+	},
+	{
+		name:   "short alu",
+		counts: map[string]int{"Alu": 1, "L1": 1},
+		want:   "Alu",
+	},
+	{
+		name:   "fusion",
+		counts: map[string]int{"L1HS": 1, "SVA_F": 1, "THE1B": 2, "MER": 1},
+		want:   "THE1B/SVA_F/L1HS/MER",
+	},
+	{
+		name:   "alu not most common",
+		counts: map[string]int{"L1HS": 2, "AluYa5": 1, "SVA": 1, "MER1A": 1},
+		want:   "L1HS/AluYa5/MER1A/SVA",
+	},
+}
+
+func TestName(t *testing.T) {
+	for _, test := range nameTests {
+		got := Name(test.counts)
+		if got != test.want {
+			t.Errorf("unexpected name for %s: got:%q want:%q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSorted(t *testing.T) {
+	got := Sorted(map[string]int{"L1": 2, "L1HS": 2, "SVA_F": 5, "MER": 1})
+	want := []Count{
+		{Type: "SVA_F", N: 5},
+		{Type: "L1HS", N: 2},
+		{Type: "L1", N: 2},
+		{Type: "MER", N: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected sort order: got:%v want:%v", got, want)
+	}
+}
+
+func TestTrunc(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		n    int
+		want string
+	}{
+		{name: "AluYa5", n: 5, want: "AluYa"},
+		{name: "AluY", n: 5, want: "AluY"},
+		{name: "", n: 5, want: ""},
+		{name: "AluY", n: 0, want: ""},
+	} {
+		got := Trunc(test.name, test.n)
+		if got != test.want {
+			t.Errorf("unexpected truncation of %q to %d: got:%q want:%q", test.name, test.n, got, test.want)
+		}
+	}
+}
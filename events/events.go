@@ -0,0 +1,58 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package events provides similarity measures for reefer events.
+package events
+
+import "github.com/biogo/biogo/io/featio/gff"
+
+// Jaccard returns the Jaccard similarity of the intervals of a and b,
+// the length of their intersection divided by the length of their union.
+// Features on different contigs have a similarity of zero. Zero length
+// features have a similarity of one if they are at the same position on
+// the same contig and zero otherwise.
+func Jaccard(a, b *gff.Feature) float64 {
+	n := Intersection(a, b)
+	u := a.Len() + b.Len() - n
+	if u == 0 {
+		if a.SeqName == b.SeqName && a.FeatStart == b.FeatStart {
+			return 1
+		}
+		return 0
+	}
+	return float64(n) / float64(u)
+}
+
+// StrandedJaccard returns the Jaccard similarity of a and b as for
+// Jaccard, except that features on opposite strands have a similarity
+// of zero.
+func StrandedJaccard(a, b *gff.Feature) float64 {
+	if a.FeatStrand != b.FeatStrand {
+		return 0
+	}
+	return Jaccard(a, b)
+}
+
+// Intersection returns the length of the intersection of the intervals
+// of a and b. Features on different contigs have no intersection.
+func Intersection(a, b *gff.Feature) int {
+	if a.SeqName != b.SeqName {
+		return 0
+	}
+	return max(0, min(a.FeatEnd, b.FeatEnd)-max(a.FeatStart, b.FeatStart))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,138 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package events
+
+import (
+	"testing"
+
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/seq"
+)
+
+func feature(name string, start, end int, strand seq.Strand) *gff.Feature {
+	return &gff.Feature{SeqName: name, FeatStart: start, FeatEnd: end, FeatStrand: strand}
+}
+
+var similarityTests = []struct {
+	name         string
+	a, b         *gff.Feature
+	intersection int
+	jaccard      float64
+}{
+	{
+		name:         "identical",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr1", 100, 200, seq.Plus),
+		intersection: 100,
+		jaccard:      1,
+	},
+	{
+		name:         "overlapping",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr1", 150, 250, seq.Plus),
+		intersection: 50,
+		jaccard:      50.0 / 150,
+	},
+	{
+		name:         "disjoint",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr1", 300, 400, seq.Plus),
+		intersection: 0,
+		jaccard:      0,
+	},
+	{
+		name:         "nested",
+		a:            feature("chr1", 100, 400, seq.Plus),
+		b:            feature("chr1", 200, 300, seq.Plus),
+		intersection: 100,
+		jaccard:      100.0 / 300,
+	},
+	{
+		name:         "touching",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr1", 200, 300, seq.Plus),
+		intersection: 0,
+		jaccard:      0,
+	},
+	{
+		name:         "different contig",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr2", 100, 200, seq.Plus),
+		intersection: 0,
+		jaccard:      0,
+	},
+	{
+		name:         "opposite strand",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr1", 100, 200, seq.Minus),
+		intersection: 100,
+		jaccard:      1,
+	},
+	{
+		name:         "empty identical",
+		a:            feature("chr1", 100, 100, seq.Plus),
+		b:            feature("chr1", 100, 100, seq.Plus),
+		intersection: 0,
+		jaccard:      1,
+	},
+	{
+		name:         "empty different position",
+		a:            feature("chr1", 100, 100, seq.Plus),
+		b:            feature("chr1", 101, 101, seq.Plus),
+		intersection: 0,
+		jaccard:      0,
+	},
+	{
+		name:         "empty different contig",
+		a:            feature("chr1", 100, 100, seq.Plus),
+		b:            feature("chr2", 100, 100, seq.Plus),
+		intersection: 0,
+		jaccard:      0,
+	},
+	{
+		name:         "empty within",
+		a:            feature("chr1", 100, 200, seq.Plus),
+		b:            feature("chr1", 150, 150, seq.Plus),
+		intersection: 0,
+		jaccard:      0,
+	},
+}
+
+func TestIntersection(t *testing.T) {
+	for _, test := range similarityTests {
+		for _, p := range [][2]*gff.Feature{{test.a, test.b}, {test.b, test.a}} {
+			got := Intersection(p[0], p[1])
+			if got != test.intersection {
+				t.Errorf("unexpected intersection for %s: got:%d want:%d", test.name, got, test.intersection)
+			}
+		}
+	}
+}
+
+func TestStrandedJaccard(t *testing.T) {
+	for _, test := range similarityTests {
+		want := test.jaccard
+		if test.a.FeatStrand != test.b.FeatStrand {
+			want = 0
+		}
+		for _, p := range [][2]*gff.Feature{{test.a, test.b}, {test.b, test.a}} {
+			got := StrandedJaccard(p[0], p[1])
+			if got != want {
+				t.Errorf("unexpected stranded Jaccard similarity for %s: got:%v want:%v", test.name, got, want)
+			}
+		}
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	for _, test := range similarityTests {
+		for _, p := range [][2]*gff.Feature{{test.a, test.b}, {test.b, test.a}} {
+			got := Jaccard(p[0], p[1])
+			if got != test.jaccard {
+				t.Errorf("unexpected Jaccard similarity for %s: got:%v want:%v", test.name, got, test.jaccard)
+			}
+		}
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/biogo/graphics v0.0.0-20180817081713-2d4ffb8a8b38
 	github.com/biogo/hts v1.0.2
 	github.com/biogo/store v0.0.0-20200104231603-2c6ad937eb83
+	golang.org/x/exp v0.0.0-20190125153040-c74c464bbbf2
 	gonum.org/v1/gonum v0.6.2
 	gonum.org/v1/plot v0.0.0-20190515093506-e2840ee46a6b
 )
@@ -0,0 +1,56 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ccs provides helpers for identifying PacBio reads that have been
+// sequenced multiple times.
+package ccs
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// WriteLists writes the read names in names to base+".unique.text" and
+// base+".non-unique.text" according to the number of coordinate suffixes
+// each name holds. Non-unique names are written with their coordinates as
+// a tab separated pair. Unique names are written alone unless withCoord is
+// true, in which case they are written in the same format as non-unique
+// names.
+func WriteLists(base string, names map[string][]string, withCoord bool) error {
+	unique, err := os.Create(base + ".unique.text")
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %v", base+".unique.text", err)
+	}
+	defer unique.Close()
+	nonUnique, err := os.Create(base + ".non-unique.text")
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %v", base+".non-unique.text", err)
+	}
+	defer nonUnique.Close()
+	for name, coords := range names {
+		switch len(coords) {
+		case 0:
+			// This should never happen since a name
+			// is only added with a coordinate.
+			log.Printf("no coordinates for %q", name)
+		case 1:
+			if withCoord {
+				_, err = fmt.Fprintf(unique, "%s\t%v\n", name, coords)
+			} else {
+				_, err = fmt.Fprintln(unique, name)
+			}
+		default:
+			_, err = fmt.Fprintf(nonUnique, "%s\t%v\n", name, coords)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = unique.Close()
+	if err != nil {
+		return err
+	}
+	return nonUnique.Close()
+}
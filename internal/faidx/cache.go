@@ -0,0 +1,70 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package faidx
+
+import (
+	"container/list"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+// Cache is a least recently used cache of the complete sequences of an
+// indexed fasta file. Sequences are read on demand and the least recently
+// used sequences are evicted when the total length of cached sequence
+// exceeds the cache budget. The most recently used sequence is always
+// retained, even if it is longer than the budget. Cache is not safe for
+// concurrent use.
+type Cache struct {
+	*File
+
+	budget int
+	used   int
+	lru    *list.List
+	seqs   map[string]*list.Element
+}
+
+// NewCache returns a Cache for the named fasta file holding at most
+// budget bytes of sequence read using the alphabet alpha. The file is
+// opened as described for Open.
+func NewCache(path string, alpha alphabet.Alphabet, budget int) (*Cache, error) {
+	f, err := Open(path, alpha)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		File:   f,
+		budget: budget,
+		lru:    list.New(),
+		seqs:   make(map[string]*list.Element),
+	}, nil
+}
+
+// Contig returns the complete named sequence, or nil if it is not in
+// the indexed fasta file.
+func (c *Cache) Contig(name string) (*linear.Seq, error) {
+	if e, ok := c.seqs[name]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*linear.Seq), nil
+	}
+	length, ok := c.Len(name)
+	if !ok {
+		return nil, nil
+	}
+	s, err := c.Seq(name, 0, length, seq.Plus)
+	if err != nil {
+		return nil, err
+	}
+	c.seqs[name] = c.lru.PushFront(s)
+	c.used += s.Len()
+	for c.used > c.budget && c.lru.Len() > 1 {
+		e := c.lru.Back()
+		old := c.lru.Remove(e).(*linear.Seq)
+		delete(c.seqs, old.Name())
+		c.used -= old.Len()
+	}
+	return s, nil
+}
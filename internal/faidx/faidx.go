@@ -0,0 +1,273 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package faidx provides random access to the sequences of fai indexed
+// fasta files.
+package faidx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio/fai"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+// File is a fai indexed fasta file. The methods of File are safe for
+// concurrent use.
+type File struct {
+	f     *os.File
+	idx   fai.Index
+	alpha alphabet.Alphabet
+}
+
+// Open opens the named fasta file for random access, reading sequences
+// using the alphabet alpha. The fai index is read from path+".fai". If the
+// index does not exist it is built and written there, or kept in memory if
+// it cannot be written.
+func Open(path string, alpha alphabet.Alphabet) (*File, error) {
+	idx, err := readIndex(path + ".fai")
+	if os.IsNotExist(err) {
+		idx, err = buildIndex(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f, idx: idx, alpha: alpha}, nil
+}
+
+func readIndex(path string) (fai.Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return fai.ReadFrom(f)
+}
+
+// buildIndex returns the fai index of the named fasta file, writing it
+// to path+".fai" if possible.
+func buildIndex(path string) (fai.Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx, err := Index(f)
+	if err != nil {
+		return nil, fmt.Errorf("faidx: failed to index %q: %v", path, err)
+	}
+	w, err := os.Create(path + ".fai")
+	if err != nil {
+		return idx, nil
+	}
+	err = writeIndex(w, idx)
+	if err != nil {
+		w.Close()
+		os.Remove(path + ".fai")
+		return idx, nil
+	}
+	err = w.Close()
+	if err != nil {
+		os.Remove(path + ".fai")
+	}
+	return idx, nil
+}
+
+// Index returns the fai index of the fasta data in r. All sequence lines
+// of each record other than the last must have the same length.
+func Index(r io.Reader) (fai.Index, error) {
+	idx := make(fai.Index)
+	var (
+		rec     *fai.Record
+		offset  int64
+		short   bool
+		lineNum int
+	)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		lineNum++
+		n := len(line)
+		terminated := line[n-1] == '\n'
+		text := bytes.TrimRight(line, "\r\n")
+		switch {
+		case bytes.HasPrefix(text, []byte(">")):
+			if rec != nil {
+				idx[rec.Name] = *rec
+			}
+			fields := bytes.Fields(text[1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("missing sequence name at line %d", lineNum)
+			}
+			name := string(fields[0])
+			if _, exists := idx[name]; exists {
+				return nil, fmt.Errorf("duplicate sequence name %q at line %d", name, lineNum)
+			}
+			rec = &fai.Record{Name: name, Start: offset + int64(n)}
+			short = false
+		case len(text) == 0:
+			// Blank lines end the sequence lines of a record.
+			if rec != nil && rec.Length != 0 {
+				short = true
+			}
+		default:
+			if rec == nil {
+				return nil, fmt.Errorf("sequence data before header at line %d", lineNum)
+			}
+			if rec.BasesPerLine == 0 {
+				rec.Start = offset
+				rec.BasesPerLine = len(text)
+				rec.BytesPerLine = n
+			} else if short || len(text) > rec.BasesPerLine || (len(text) == rec.BasesPerLine && terminated && n != rec.BytesPerLine) {
+				return nil, fmt.Errorf("inconsistent line length for %q at line %d", rec.Name, lineNum)
+			}
+			if len(text) < rec.BasesPerLine {
+				short = true
+			}
+			rec.Length += len(text)
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+	}
+	if rec != nil {
+		idx[rec.Name] = *rec
+	}
+	return idx, nil
+}
+
+// writeIndex writes idx to w in fai format in file order.
+func writeIndex(w io.Writer, idx fai.Index) error {
+	recs := make([]fai.Record, 0, len(idx))
+	for _, r := range idx {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Start < recs[j].Start })
+	for _, r := range recs {
+		_, err := fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", r.Name, r.Length, r.Start, r.BasesPerLine, r.BytesPerLine)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Has returns whether the named sequence is in the file.
+func (f *File) Has(name string) bool {
+	_, ok := f.idx[name]
+	return ok
+}
+
+// Names returns the names of the sequences in the file in file order.
+func (f *File) Names() []string {
+	recs := make([]fai.Record, 0, len(f.idx))
+	for _, r := range f.idx {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Start < recs[j].Start })
+	names := make([]string, len(recs))
+	for i, r := range recs {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// Desc returns the description of the named sequence, the text of its
+// header line following the first space or tab, as it is read by the
+// biogo fasta reader.
+func (f *File) Desc(name string) (string, error) {
+	r, ok := f.idx[name]
+	if !ok {
+		return "", fmt.Errorf("faidx: no sequence %q", name)
+	}
+	// The header line ends immediately before
+	// the first sequence byte of the record.
+	var line []byte
+	for size := int64(256); ; size *= 2 {
+		from := r.Start - size
+		if from < 0 {
+			from = 0
+		}
+		b := make([]byte, r.Start-from)
+		_, err := f.f.ReadAt(b, from)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		i := bytes.LastIndexByte(b, '\n')
+		if i >= 0 || from == 0 {
+			line = bytes.TrimSpace(b[i+1:])
+			break
+		}
+	}
+	if !bytes.HasPrefix(line, []byte(">")) {
+		return "", fmt.Errorf("faidx: no header line for %q", name)
+	}
+	i := bytes.IndexAny(line, " \t")
+	if i < 0 {
+		return "", nil
+	}
+	return string(line[i+1:]), nil
+}
+
+// Len returns the length of the named sequence and whether
+// it is in the file.
+func (f *File) Len(name string) (int, bool) {
+	r, ok := f.idx[name]
+	return r.Length, ok
+}
+
+// Seq returns the half-open interval [start, end) of the named sequence.
+// The returned sequence has the name of the contig and no description.
+// If strand is seq.Minus the returned sequence is reverse complemented.
+func (f *File) Seq(name string, start, end int, strand seq.Strand) (*linear.Seq, error) {
+	r, ok := f.idx[name]
+	if !ok {
+		return nil, fmt.Errorf("faidx: no sequence %q", name)
+	}
+	if start < 0 || end < start || r.Length < end {
+		return nil, fmt.Errorf("faidx: interval [%d,%d) out of range for %q of length %d", start, end, name, r.Length)
+	}
+	s := linear.NewSeq(name, nil, f.alpha)
+	if start != end {
+		from := r.Position(start)
+		b := make([]byte, r.Position(end-1)+1-from)
+		_, err := f.f.ReadAt(b, from)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		b = bytes.Replace(b, []byte{'\n'}, nil, -1)
+		b = bytes.Replace(b, []byte{'\r'}, nil, -1)
+		s.Seq = alphabet.BytesToLetters(b)
+	}
+	if strand == seq.Minus {
+		s = sequtil.RevComp(s)
+	}
+	return s, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.f.Close()
+}
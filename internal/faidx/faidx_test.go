@@ -0,0 +1,187 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package faidx
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+// fastaRecords returns fasta text for sequences with the given names,
+// lengths, line widths and descriptions, and the expected names in
+// file order.
+func fastaRecords(rnd *rand.Rand) (string, []string) {
+	const bases = "acgtnACGTN"
+	var (
+		buf   strings.Builder
+		names []string
+	)
+	for i, rec := range []struct {
+		name, desc string
+		length     int
+		width      int
+	}{
+		{name: "chr1", desc: "first contig", length: 1000, width: 60},
+		{name: "chr2", length: 59, width: 60},
+		{name: "chr3", desc: "exact\tlines", length: 120, width: 60},
+		{name: "chr4", desc: strings.Repeat("long ", 200), length: 777, width: 70},
+		{name: "chr5", length: 1, width: 60},
+	} {
+		names = append(names, rec.name)
+		buf.WriteString(">" + rec.name)
+		if rec.desc != "" {
+			buf.WriteString(" " + rec.desc)
+		}
+		buf.WriteString("\n")
+		for j := 0; j < rec.length; j++ {
+			buf.WriteByte(bases[rnd.Intn(len(bases))])
+			if (j+1)%rec.width == 0 || j == rec.length-1 {
+				buf.WriteString("\n")
+			}
+		}
+		if i == 0 {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String(), names
+}
+
+// readAll returns the sequences in the named fasta file keyed by name.
+func readAll(t *testing.T, path string) map[string]*linear.Seq {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open fasta: %v", err)
+	}
+	defer f.Close()
+	seqs := make(map[string]*linear.Seq)
+	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		seqs[s.Name()] = s
+	}
+	if err := sc.Error(); err != nil {
+		t.Fatalf("failed to read fasta: %v", err)
+	}
+	return seqs
+}
+
+func TestSeq(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faidx")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rnd := rand.New(rand.NewSource(1))
+	text, names := fastaRecords(rnd)
+	path := filepath.Join(dir, "ref.fa")
+	err = ioutil.WriteFile(path, []byte(text), 0664)
+	if err != nil {
+		t.Fatalf("failed to write fasta: %v", err)
+	}
+
+	f, err := Open(path, alphabet.DNAgapped)
+	if err != nil {
+		t.Fatalf("failed to open indexed fasta: %v", err)
+	}
+	defer f.Close()
+	if _, err := os.Stat(path + ".fai"); err != nil {
+		t.Errorf("index not written: %v", err)
+	}
+
+	if got := f.Names(); !reflect.DeepEqual(got, names) {
+		t.Errorf("unexpected names: got:%v want:%v", got, names)
+	}
+
+	want := readAll(t, path)
+	for _, name := range names {
+		w := want[name]
+		n, ok := f.Len(name)
+		if !ok || n != w.Len() {
+			t.Errorf("unexpected length for %s: got:%d %t want:%d", name, n, ok, w.Len())
+			continue
+		}
+		desc, err := f.Desc(name)
+		if err != nil {
+			t.Errorf("unexpected error for description of %s: %v", name, err)
+		}
+		if desc != w.Desc {
+			t.Errorf("unexpected description for %s: got:%q want:%q", name, desc, w.Desc)
+		}
+
+		// Intervals at the ends of the sequence and its
+		// lines, and random intervals.
+		type interval struct{ start, end int }
+		ivs := []interval{{0, 0}, {0, n}, {0, 1}, {n - 1, n}, {n, n}}
+		for _, b := range []int{59, 60, 61, 69, 70, 71} {
+			if b < n {
+				ivs = append(ivs, interval{b, n}, interval{0, b}, interval{b, b + 1})
+			}
+		}
+		for i := 0; i < 50; i++ {
+			start := rnd.Intn(n + 1)
+			ivs = append(ivs, interval{start, start + rnd.Intn(n-start+1)})
+		}
+		for _, iv := range ivs {
+			for _, strand := range []seq.Strand{seq.Plus, seq.Minus} {
+				got, err := f.Seq(name, iv.start, iv.end, strand)
+				if err != nil {
+					t.Errorf("unexpected error for %s[%d,%d) %v: %v", name, iv.start, iv.end, strand, err)
+					continue
+				}
+				ws := *w
+				ws.Seq = w.Seq[iv.start:iv.end]
+				ref := &ws
+				if strand == seq.Minus {
+					ref = sequtil.RevComp(ref)
+				}
+				if string(got.Seq) != string(ref.Seq) {
+					t.Errorf("unexpected sequence for %s[%d,%d) %v:\ngot: %s\nwant:%s",
+						name, iv.start, iv.end, strand, got.Seq, ref.Seq)
+				}
+				if got.Strand != ref.Strand {
+					t.Errorf("unexpected strand for %s[%d,%d) %v: got:%v want:%v",
+						name, iv.start, iv.end, strand, got.Strand, ref.Strand)
+				}
+			}
+		}
+	}
+
+	for _, iv := range [][2]int{{-1, 10}, {10, 5}, {0, 1001}} {
+		_, err := f.Seq("chr1", iv[0], iv[1], seq.Plus)
+		if err == nil {
+			t.Errorf("expected error for chr1[%d,%d)", iv[0], iv[1])
+		}
+	}
+	if _, err := f.Seq("chrX", 0, 1, seq.Plus); err == nil {
+		t.Error("expected error for missing sequence")
+	}
+	if _, err := f.Desc("chrX"); err == nil {
+		t.Error("expected error for description of missing sequence")
+	}
+
+	// A second open reads the written index.
+	g, err := Open(path, alphabet.DNAgapped)
+	if err != nil {
+		t.Fatalf("failed to reopen indexed fasta: %v", err)
+	}
+	defer g.Close()
+	if !reflect.DeepEqual(g.idx, f.idx) {
+		t.Errorf("unexpected read index: got:%v want:%v", g.idx, f.idx)
+	}
+}
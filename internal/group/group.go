@@ -0,0 +1,54 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package group provides summaries of groups of clustered events.
+package group
+
+import (
+	"fmt"
+
+	"github.com/biogo/biogo/io/featio/gff"
+	"gonum.org/v1/gonum/graph"
+)
+
+// Summarize returns a feature representing the group of features in v
+// identified by the nodes in c. The returned feature spans all members
+// of the group, has a score and a Support attribute equal to the number
+// of members and carries the attributes of the first member other than
+// its Read attribute. The group number is recorded in a Group attribute.
+func Summarize(c []graph.Node, v []*gff.Feature, group int) *gff.Feature {
+	f := *v[c[0].ID()]
+	for _, e := range c[1:] {
+		m := v[e.ID()]
+		f.FeatStart = min(f.FeatStart, m.FeatStart)
+		f.FeatEnd = max(f.FeatEnd, m.FeatEnd)
+	}
+	var attrs gff.Attributes
+	for _, a := range f.FeatAttributes {
+		if a.Tag != "Read" {
+			attrs = append(attrs, a)
+		}
+	}
+	f.FeatAttributes = append(attrs,
+		gff.Attribute{Tag: "Group", Value: fmt.Sprint(group)},
+		gff.Attribute{Tag: "Support", Value: fmt.Sprint(len(c))},
+	)
+	support := float64(len(c))
+	f.FeatScore = &support
+	return &f
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package group
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/biogo/biogo/io/featio/gff"
+	"gonum.org/v1/gonum/graph/simple"
+
+	"github.com/kortschak/loopy/cluster"
+	"github.com/kortschak/loopy/events"
+)
+
+func TestSummarize(t *testing.T) {
+	v := []*gff.Feature{
+		{SeqName: "chr1", FeatStart: 100, FeatEnd: 200, FeatAttributes: gff.Attributes{{Tag: "Read", Value: "a 1 100"}, {Tag: "TSD", Value: "x"}}},
+		{SeqName: "chr1", FeatStart: 110, FeatEnd: 210, FeatAttributes: gff.Attributes{{Tag: "Read", Value: "b 1 100"}}},
+		{SeqName: "chr1", FeatStart: 90, FeatEnd: 195, FeatAttributes: gff.Attributes{{Tag: "Read", Value: "c 1 100"}}},
+		{SeqName: "chr1", FeatStart: 1000, FeatEnd: 1100, FeatAttributes: gff.Attributes{{Tag: "Read", Value: "d 1 100"}}},
+		{SeqName: "chr2", FeatStart: 100, FeatEnd: 200, FeatAttributes: gff.Attributes{{Tag: "Read", Value: "e 1 100"}}},
+		{SeqName: "chr2", FeatStart: 100, FeatEnd: 200, FeatAttributes: gff.Attributes{{Tag: "Read", Value: "f 1 100"}}},
+	}
+	g := cluster.NewThresholdGraph(0.5)
+	for i := range v {
+		g.AddNode(simple.Node(i))
+	}
+	for i, a := range v {
+		for j, b := range v[i+1:] {
+			if w := events.Jaccard(a, b); w > 0 {
+				g.SetWeightedEdge(simple.WeightedEdge{F: simple.Node(i), T: simple.Node(i + 1 + j), W: w})
+			}
+		}
+	}
+
+	want := []struct {
+		start, end int
+		support    int
+		attrs      int
+	}{
+		{start: 90, end: 210, support: 3, attrs: 3},
+		{start: 1000, end: 1100, support: 1, attrs: 2},
+		{start: 100, end: 200, support: 2, attrs: 2},
+	}
+	groups := cluster.Cluster(g, cluster.Components, nil)
+	if len(groups) != len(want) {
+		t.Fatalf("unexpected number of groups: got:%d want:%d", len(groups), len(want))
+	}
+	for i, c := range groups {
+		f := Summarize(c, v, i)
+		support, err := strconv.Atoi(f.FeatAttributes.Get("Support"))
+		if err != nil {
+			t.Fatalf("invalid Support attribute for group %d: %v", i, err)
+		}
+		if support != len(c) || support != want[i].support {
+			t.Errorf("unexpected support for group %d: got:%d component size:%d want:%d", i, support, len(c), want[i].support)
+		}
+		if f.FeatScore == nil || *f.FeatScore != float64(len(c)) {
+			t.Errorf("unexpected score for group %d: got:%v want:%d", i, f.FeatScore, len(c))
+		}
+		if f.FeatStart != want[i].start || f.FeatEnd != want[i].end {
+			t.Errorf("unexpected span for group %d: got:[%d,%d) want:[%d,%d)", i, f.FeatStart, f.FeatEnd, want[i].start, want[i].end)
+		}
+		if f.FeatAttributes.Get("Read") != "" {
+			t.Errorf("unexpected Read attribute for group %d: %q", i, f.FeatAttributes.Get("Read"))
+		}
+		if f.FeatAttributes.Get("Group") != strconv.Itoa(i) {
+			t.Errorf("unexpected Group attribute for group %d: %q", i, f.FeatAttributes.Get("Group"))
+		}
+		if len(f.FeatAttributes) != want[i].attrs {
+			t.Errorf("unexpected attributes for group %d: %v", i, f.FeatAttributes)
+		}
+	}
+
+	// The member features are not altered.
+	if v[0].FeatStart != 100 || v[0].FeatEnd != 200 || len(v[0].FeatAttributes) != 2 {
+		t.Errorf("member feature altered: %+v", v[0])
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package output provides output destinations that are transparently
+// gzip compressed.
+package output
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// Create creates the named file for writing. If the path ends in ".gz"
+// the written data is gzip compressed. The returned io.WriteCloser must
+// be closed to flush all written data.
+func Create(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	return &gzipWriter{Writer: gzip.NewWriter(f), c: f}, nil
+}
+
+// Stdout returns an io.WriteCloser writing to os.Stdout. If gz is true
+// the written data is gzip compressed. Closing the returned io.WriteCloser
+// flushes all written data but does not close os.Stdout.
+func Stdout(gz bool) io.WriteCloser {
+	if !gz {
+		return nopCloser{os.Stdout}
+	}
+	return &gzipWriter{Writer: gzip.NewWriter(os.Stdout)}
+}
+
+// gzipWriter is a gzip.Writer that closes its underlying
+// destination if it has one.
+type gzipWriter struct {
+	*gzip.Writer
+	c io.Closer
+}
+
+func (w *gzipWriter) Close() error {
+	err := w.Writer.Close()
+	if w.c == nil {
+		return err
+	}
+	cerr := w.c.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
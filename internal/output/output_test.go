@@ -0,0 +1,211 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/biogo/io/featio"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/seq"
+)
+
+var features = []*gff.Feature{
+	{
+		SeqName:    "chr1",
+		Source:     "reefer",
+		Feature:    "discordance",
+		FeatStart:  1000,
+		FeatEnd:    1300,
+		FeatStrand: seq.Plus,
+		FeatFrame:  gff.NoFrame,
+		FeatAttributes: gff.Attributes{
+			{Tag: "Read", Value: "m1/1/ccs 100 400"},
+			{Tag: "Dup", Value: "12"},
+		},
+	},
+	{
+		SeqName:    "chr2",
+		Source:     "reefer",
+		Feature:    "discordance",
+		FeatStart:  5000,
+		FeatEnd:    5001,
+		FeatStrand: seq.Minus,
+		FeatFrame:  gff.NoFrame,
+		FeatAttributes: gff.Attributes{
+			{Tag: "Read", Value: "m2/2/ccs 1000 1900"},
+		},
+	},
+}
+
+// writeGFF writes a comment and the test features to w.
+func writeGFF(t *testing.T, w io.Writer) {
+	gw := gff.NewWriter(w, 60, true)
+	_, err := gw.WriteComment("Right coordinates (field 5) and strand (field 7) are hypothetical.")
+	if err != nil {
+		t.Fatalf("unexpected error writing comment: %v", err)
+	}
+	for _, f := range features {
+		_, err = gw.Write(f)
+		if err != nil {
+			t.Fatalf("unexpected error writing feature: %v", err)
+		}
+	}
+}
+
+// readGFF returns the features in the GFF stream in r.
+func readGFF(t *testing.T, r io.Reader) []*gff.Feature {
+	var got []*gff.Feature
+	sc := featio.NewScanner(gff.NewReader(r))
+	for sc.Next() {
+		got = append(got, sc.Feat().(*gff.Feature))
+	}
+	if err := sc.Error(); err != nil {
+		t.Fatalf("unexpected error reading GFF: %v", err)
+	}
+	return got
+}
+
+func TestCreate(t *testing.T) {
+	var want bytes.Buffer
+	writeGFF(t, &want)
+
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name string
+		gzip bool
+	}{
+		{name: "events.gff", gzip: false},
+		{name: "events.gff.gz", gzip: true},
+	} {
+		path := filepath.Join(dir, test.name)
+		w, err := Create(path)
+		if err != nil {
+			t.Fatalf("unexpected error creating %s: %v", test.name, err)
+		}
+		writeGFF(t, w)
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing %s: %v", test.name, err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", test.name, err)
+		}
+		var r io.Reader = f
+		gz, err := gzip.NewReader(f)
+		if (err == nil) != test.gzip {
+			t.Errorf("unexpected gzip header validity for %s: got:%t want:%t", test.name, err == nil, test.gzip)
+		}
+		if test.gzip {
+			if err != nil {
+				f.Close()
+				continue
+			}
+			r = gz
+		} else {
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				t.Fatalf("failed to rewind %s: %v", test.name, err)
+			}
+		}
+		got, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", test.name, err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("unexpected content of %s:\ngot:\n%s\nwant:\n%s", test.name, got, &want)
+		}
+		feats := readGFF(t, bytes.NewReader(got))
+		if !reflect.DeepEqual(feats, readGFF(t, bytes.NewReader(want.Bytes()))) {
+			t.Errorf("unexpected features re-read from %s: got:%v", test.name, feats)
+		}
+		if len(feats) != len(features) {
+			t.Errorf("unexpected number of features re-read from %s: got:%d want:%d", test.name, len(feats), len(features))
+		}
+	}
+}
+
+func TestCreateError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"events.gff", "events.gff.gz"} {
+		_, err := Create(filepath.Join(dir, "missing", name))
+		if err == nil {
+			t.Errorf("expected error creating %s in a missing directory", name)
+		}
+	}
+}
+
+func TestStdout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+
+	var want bytes.Buffer
+	writeGFF(t, &want)
+
+	for _, gz := range []bool{false, true} {
+		f, err := ioutil.TempFile(dir, "stdout")
+		if err != nil {
+			t.Fatalf("failed to make temporary file: %v", err)
+		}
+		os.Stdout = f
+
+		w := Stdout(gz)
+		writeGFF(t, w)
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing output with gzip=%t: %v", gz, err)
+		}
+
+		// Closing the output must leave os.Stdout open.
+		_, err = f.Write(nil)
+		if err != nil {
+			t.Errorf("unexpected error writing to stdout after close with gzip=%t: %v", gz, err)
+		}
+
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatalf("failed to rewind stdout: %v", err)
+		}
+		var r io.Reader = f
+		if gz {
+			r, err = gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("unexpected error reading gzip stdout: %v", err)
+			}
+		}
+		got, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("unexpected error reading stdout with gzip=%t: %v", gz, err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("unexpected stdout content with gzip=%t:\ngot:\n%s\nwant:\n%s", gz, got, &want)
+		}
+	}
+}
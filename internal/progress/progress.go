@@ -0,0 +1,108 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package progress provides periodic logging of progress through
+// long running input processing.
+package progress
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Reporter logs the number of records processed and, when the
+// total input size is known, the fraction of input consumed and
+// an estimated time to completion. All methods of a nil Reporter
+// are no-ops.
+type Reporter struct {
+	name  string
+	total int64
+	every time.Duration
+
+	bytes   int64
+	records int64
+	start   time.Time
+	last    time.Time
+}
+
+// New returns a Reporter for the named input that logs at intervals
+// of every. If total is positive it is taken to be the size of the
+// input in bytes. If every is not positive, New returns nil.
+func New(name string, total int64, every time.Duration) *Reporter {
+	if every <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &Reporter{name: name, total: total, every: every, start: now, last: now}
+}
+
+// ForFile returns a Reporter for f with the total input size taken
+// from the file's size. If every is not positive, ForFile returns nil.
+func ForFile(f *os.File, every time.Duration) *Reporter {
+	if every <= 0 {
+		return nil
+	}
+	var total int64
+	fi, err := f.Stat()
+	if err == nil && fi.Mode().IsRegular() {
+		total = fi.Size()
+	}
+	return New(f.Name(), total, every)
+}
+
+// Reader returns an io.Reader that counts the bytes read from r.
+// If p is nil, r is returned unaltered.
+func (p *Reporter) Reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return countReader{r: r, p: p}
+}
+
+type countReader struct {
+	r io.Reader
+	p *Reporter
+}
+
+func (r countReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.bytes += int64(n)
+	return n, err
+}
+
+// Add adds n to the count of processed records and logs progress
+// if the reporting interval has elapsed.
+func (p *Reporter) Add(n int) {
+	if p == nil {
+		return
+	}
+	p.records += int64(n)
+	now := time.Now()
+	if now.Sub(p.last) < p.every {
+		return
+	}
+	p.last = now
+	p.log(now)
+}
+
+// Done logs the final record count.
+func (p *Reporter) Done() {
+	if p == nil {
+		return
+	}
+	log.Printf("%s: processed %d records in %v", p.name, p.records, time.Since(p.start).Round(time.Second))
+}
+
+func (p *Reporter) log(now time.Time) {
+	if p.total <= 0 || p.bytes == 0 {
+		log.Printf("%s: processed %d records", p.name, p.records)
+		return
+	}
+	frac := float64(p.bytes) / float64(p.total)
+	elapsed := now.Sub(p.start)
+	eta := time.Duration(float64(elapsed) * (1/frac - 1))
+	log.Printf("%s: processed %d records (%.1f%%) eta %v", p.name, p.records, 100*frac, eta.Round(time.Second))
+}
@@ -0,0 +1,117 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package provenance provides recording and checking of the coordinate
+// system and grouping parameters used to produce a GFF file.
+package provenance
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// prefix is the comment text identifying a provenance comment.
+const prefix = "loopy-provenance"
+
+// Coordinate origins are the position given to the first base of a
+// reference sequence in the feature coordinates of a GFF file. An origin
+// of zero is unknown.
+const (
+	// GFF is the coordinate origin of GFF files
+	// following the GFF specification.
+	GFF = 1
+
+	// ReadOffset is the coordinate origin of GFF
+	// files whose features are placed by adding
+	// one-based Read attribute positions to zero-based
+	// reference positions, so that they lie one base
+	// to the right of the GFF origin.
+	ReadOffset = 2
+)
+
+// Stamp is a record of the tool, grouping threshold and coordinate
+// origin used to produce a GFF file.
+type Stamp struct {
+	Tool   string
+	Thresh float64
+	Origin int
+}
+
+// String returns the comment text for the stamp. It is suitable
+// for passing to gff.Writer's WriteComment method.
+func (s Stamp) String() string {
+	return fmt.Sprintf("%s tool=%s thresh=%v origin=%d", prefix, s.Tool, s.Thresh, s.Origin)
+}
+
+// Read returns the first provenance stamp found in the leading comment
+// lines of the GFF stream r. If no stamp is present, ok is false.
+func Read(r io.Reader) (s Stamp, ok bool, err error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		text := strings.TrimSpace(strings.TrimLeft(line, "#"))
+		if !strings.HasPrefix(text, prefix+" ") {
+			continue
+		}
+		s, err = parse(strings.TrimPrefix(text, prefix+" "))
+		return s, err == nil, err
+	}
+	return Stamp{}, false, sc.Err()
+}
+
+// ReadFile returns the provenance stamp of the named GFF file.
+func ReadFile(path string) (s Stamp, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Stamp{}, false, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func parse(text string) (Stamp, error) {
+	var (
+		s   Stamp
+		err error
+	)
+	for _, f := range strings.Fields(text) {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return Stamp{}, fmt.Errorf("provenance: invalid field %q", f)
+		}
+		switch kv[0] {
+		case "tool":
+			s.Tool = kv[1]
+		case "thresh":
+			s.Thresh, err = strconv.ParseFloat(kv[1], 64)
+		case "origin":
+			s.Origin, err = strconv.Atoi(kv[1])
+		}
+		if err != nil {
+			return Stamp{}, fmt.Errorf("provenance: invalid field %q: %v", f, err)
+		}
+	}
+	return s, nil
+}
+
+// Check returns an error if the receiver was produced with a grouping
+// threshold greater than thresh, or if its coordinate origin differs
+// from that of other. Unknown origins are not checked.
+func (s Stamp) Check(thresh float64, other Stamp) error {
+	if thresh < s.Thresh {
+		return fmt.Errorf("provenance: threshold %v less than %v used by %s", thresh, s.Thresh, s.Tool)
+	}
+	if s.Origin != 0 && other.Origin != 0 && s.Origin != other.Origin {
+		return fmt.Errorf("provenance: coordinate origin mismatch: %s used %d, %s used %d",
+			s.Tool, s.Origin, other.Tool, other.Origin)
+	}
+	return nil
+}
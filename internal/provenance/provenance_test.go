@@ -0,0 +1,111 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provenance
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		in     string
+		want   Stamp
+		wantOK bool
+	}{
+		{
+			name: "stamped",
+			in: "##gff-version 2\n" +
+				"# Right coordinates (field 5) and strand (field 7) are hypothetical.\n" +
+				"# " + Stamp{Tool: "press", Thresh: 0.9, Origin: GFF}.String() + "\n" +
+				"chr1\tpress\tinsertion\t10\t20\t.\t+\t.\tRead r 1 10\n",
+			want:   Stamp{Tool: "press", Thresh: 0.9, Origin: GFF},
+			wantOK: true,
+		},
+		{
+			name: "read offset origin",
+			in: "##gff-version 2\n" +
+				"# " + Stamp{Tool: "press-global", Thresh: 0.75, Origin: ReadOffset}.String() + "\n",
+			want:   Stamp{Tool: "press-global", Thresh: 0.75, Origin: ReadOffset},
+			wantOK: true,
+		},
+		{
+			name: "unstamped",
+			in: "##gff-version 2\n" +
+				"chr1\tpress\tinsertion\t10\t20\t.\t+\t.\tRead r 1 10\n",
+		},
+		{
+			name: "stamp after features",
+			in: "##gff-version 2\n" +
+				"chr1\tpress\tinsertion\t10\t20\t.\t+\t.\tRead r 1 10\n" +
+				"# " + Stamp{Tool: "press", Thresh: 0.9, Origin: GFF}.String() + "\n",
+		},
+	} {
+		got, ok, err := Read(strings.NewReader(test.in))
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.name, err)
+			continue
+		}
+		if ok != test.wantOK || got != test.want {
+			t.Errorf("unexpected stamp for %s: got:%+v,%t want:%+v,%t", test.name, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, _, err := Read(strings.NewReader("# loopy-provenance tool=press thresh=high origin=1\n"))
+	if err == nil {
+		t.Error("expected error for invalid threshold")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		s       Stamp
+		thresh  float64
+		other   Stamp
+		wantErr string
+	}{
+		{
+			name:   "consistent",
+			s:      Stamp{Tool: "press", Thresh: 0.9, Origin: GFF},
+			thresh: 0.9,
+			other:  Stamp{Tool: "press", Thresh: 0.8, Origin: GFF},
+		},
+		{
+			name:    "threshold below",
+			s:       Stamp{Tool: "press", Thresh: 0.9, Origin: GFF},
+			thresh:  0.8,
+			other:   Stamp{Tool: "press", Thresh: 0.8, Origin: GFF},
+			wantErr: "threshold 0.8 less than 0.9 used by press",
+		},
+		{
+			name:    "origin mismatch",
+			s:       Stamp{Tool: "press", Thresh: 0.9, Origin: GFF},
+			thresh:  0.9,
+			other:   Stamp{Tool: "press-global", Thresh: 0.9, Origin: ReadOffset},
+			wantErr: "coordinate origin mismatch: press used 1, press-global used 2",
+		},
+		{
+			name:   "unknown origin",
+			s:      Stamp{Tool: "net", Thresh: 0.9},
+			thresh: 0.9,
+			other:  Stamp{Tool: "press", Thresh: 0.9, Origin: GFF},
+		},
+	} {
+		err := test.s.Check(test.thresh, test.other)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("unexpected error for %s: got:%v want:%q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package readname provides parsing of PacBio read names and the names
+// of sequences extracted from them.
+//
+// Subread names have the form movie/hole/start_end and CCS read names
+// have the form movie/hole/ccs. Sequences extracted from a read by wring
+// are named read//start_end, with a (-) suffix when the sequence is
+// reverse complemented relative to the read.
+package readname
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CCS is the final component of a CCS read name.
+const CCS = "ccs"
+
+// Name is a parsed PacBio read name.
+type Name struct {
+	// Movie is the name of the movie
+	// the read was sequenced in.
+	Movie string
+
+	// Hole is the hole number of the
+	// ZMW as it appears in the name.
+	Hole string
+
+	// Sub is the subread range as it
+	// appears in the name, or CCS.
+	Sub string
+
+	// Start and End are the subread
+	// range. They are zero for CCS reads.
+	Start, End int
+}
+
+// Parse returns the components of a PacBio read name. It returns an
+// error if the name does not have the form movie/hole/start_end or
+// movie/hole/ccs.
+func Parse(name string) (Name, error) {
+	fields := strings.Split(name, "/")
+	if len(fields) != 3 {
+		return Name{}, fmt.Errorf("invalid read name %q: want movie/hole/range", name)
+	}
+	n := Name{Movie: fields[0], Hole: fields[1], Sub: fields[2]}
+	if n.Movie == "" {
+		return Name{}, fmt.Errorf("invalid read name %q: empty movie name", name)
+	}
+	if _, err := strconv.Atoi(n.Hole); err != nil {
+		return Name{}, fmt.Errorf("invalid read name %q: bad hole number: %v", name, err)
+	}
+	if n.Sub != CCS {
+		var err error
+		n.Start, n.End, err = Range(n.Sub)
+		if err != nil {
+			return Name{}, fmt.Errorf("invalid read name %q: %v", name, err)
+		}
+	}
+	return n, nil
+}
+
+// IsCCS returns whether n is the name of a CCS read.
+func (n Name) IsCCS() bool { return n.Sub == CCS }
+
+// ZMW returns the movie/hole prefix of n.
+func (n Name) ZMW() string { return n.Movie + "/" + n.Hole }
+
+// String returns the read name represented by n.
+func (n Name) String() string { return n.ZMW() + "/" + n.Sub }
+
+// SplitAt splits a read name after its first depth path components into
+// a grouping prefix and the remainder without checking the components. For
+// a subread name, a depth of 1 gives the movie, 2 gives the ZMW and 3 gives
+// the complete name with an empty remainder. The returned ok is false if
+// name has fewer than depth components or depth is less than 1.
+func SplitAt(name string, depth int) (prefix, rest string, ok bool) {
+	if depth < 1 {
+		return "", "", false
+	}
+	fields := strings.SplitN(name, "/", depth+1)
+	if len(fields) < depth {
+		return "", "", false
+	}
+	prefix = strings.Join(fields[:depth], "/")
+	if len(fields) > depth {
+		rest = fields[depth]
+	}
+	return prefix, rest, true
+}
+
+// Range parses a range of the form start_end.
+func Range(s string) (start, end int, err error) {
+	fields := strings.Split(s, "_")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("range %q not of the form start_end", s)
+	}
+	start, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad range start: %v", err)
+	}
+	end, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad range end: %v", err)
+	}
+	return start, end, nil
+}
+
+// Extracted returns the name of the sequence extracted from the read
+// with the given name over [start,end). If minus is true the name is
+// marked as reverse complemented relative to the read.
+func Extracted(read string, start, end int, minus bool) string {
+	name := fmt.Sprintf("%s//%d_%d", read, start, end)
+	if minus {
+		name += "(-)"
+	}
+	return name
+}
+
+// ParseExtracted returns the read name and range of a sequence name
+// of the form written by Extracted.
+func ParseExtracted(name string) (read string, start, end int, minus bool, err error) {
+	fields := strings.Split(name, "//")
+	if len(fields) != 2 {
+		return "", 0, 0, false, fmt.Errorf("invalid extracted sequence name %q: want read//range", name)
+	}
+	rng := strings.TrimSuffix(fields[1], "(-)")
+	minus = len(rng) != len(fields[1])
+	start, end, err = Range(rng)
+	if err != nil {
+		return "", 0, 0, false, err
+	}
+	return fields[0], start, end, minus, nil
+}
+
+// ParseAttribute returns the read name and coordinates held in a reefer
+// GFF Read attribute of the form "name start end".
+func ParseAttribute(read string) (name string, start, end int, err error) {
+	fields := strings.Fields(read)
+	if len(fields) != 3 {
+		return "", 0, 0, errors.New("read attribute not of the form name start end")
+	}
+	start, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, 0, err
+	}
+	end, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return fields[0], start, end, nil
+}
@@ -0,0 +1,108 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package readname
+
+import (
+	"testing"
+)
+
+var parseTests = []struct {
+	name    string
+	want    Name
+	wantErr bool
+}{
+	{
+		name: "m160101_000000_42156_c1/10/0_300",
+		want: Name{Movie: "m160101_000000_42156_c1", Hole: "10", Sub: "0_300", Start: 0, End: 300},
+	},
+	{
+		name: "m1/4096/1200_5300",
+		want: Name{Movie: "m1", Hole: "4096", Sub: "1200_5300", Start: 1200, End: 5300},
+	},
+	{
+		name: "m1/10/ccs",
+		want: Name{Movie: "m1", Hole: "10", Sub: "ccs"},
+	},
+	{name: "m1/10", wantErr: true},
+	{name: "m1/10/0_300/extra", wantErr: true},
+	{name: "/10/0_300", wantErr: true},
+	{name: "m1/hole/0_300", wantErr: true},
+	{name: "m1/10/0-300", wantErr: true},
+	{name: "m1/10/a_300", wantErr: true},
+	{name: "m1/10/0_b", wantErr: true},
+	{name: "m1/10/CCS", wantErr: true},
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range parseTests {
+		got, err := Parse(test.name)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %q: got:%v want error:%t", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got != test.want {
+			t.Errorf("unexpected parse of %q: got:%+v want:%+v", test.name, got, test.want)
+		}
+		if got.String() != test.name {
+			t.Errorf("unexpected string for %q: got:%q", test.name, got.String())
+		}
+		if got.IsCCS() != (test.want.Sub == CCS) {
+			t.Errorf("unexpected CCS status for %q: got:%t", test.name, got.IsCCS())
+		}
+		if zmw := test.want.Movie + "/" + test.want.Hole; got.ZMW() != zmw {
+			t.Errorf("unexpected ZMW for %q: got:%q want:%q", test.name, got.ZMW(), zmw)
+		}
+	}
+}
+
+func TestExtracted(t *testing.T) {
+	for _, test := range []struct {
+		read       string
+		start, end int
+		minus      bool
+		want       string
+	}{
+		{read: "m1/10/0_300", start: 10, end: 200, want: "m1/10/0_300//10_200"},
+		{read: "m1/10/ccs", start: 0, end: 50, minus: true, want: "m1/10/ccs//0_50(-)"},
+	} {
+		got := Extracted(test.read, test.start, test.end, test.minus)
+		if got != test.want {
+			t.Errorf("unexpected extracted name: got:%q want:%q", got, test.want)
+		}
+		read, start, end, minus, err := ParseExtracted(got)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %v", got, err)
+			continue
+		}
+		if read != test.read || start != test.start || end != test.end || minus != test.minus {
+			t.Errorf("unexpected round trip of %q: got:%q %d %d %t", got, read, start, end, minus)
+		}
+	}
+	for _, name := range []string{"m1/10/0_300", "m1/10/0_300//10", "m1/10/0_300//10_x(-)"} {
+		_, _, _, _, err := ParseExtracted(name)
+		if err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
+
+func TestParseAttribute(t *testing.T) {
+	name, start, end, err := ParseAttribute("m1/10/0_300 11 200")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "m1/10/0_300" || start != 11 || end != 200 {
+		t.Errorf("unexpected attribute parse: got:%q %d %d", name, start, end)
+	}
+	for _, attr := range []string{"", "m1/10/0_300 11", "m1/10/0_300 a 200", "m1/10/0_300 11 b"} {
+		_, _, _, err := ParseAttribute(attr)
+		if err == nil {
+			t.Errorf("expected error for %q", attr)
+		}
+	}
+}
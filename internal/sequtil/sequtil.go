@@ -0,0 +1,154 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sequtil provides sequence manipulation helpers.
+package sequtil
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+// WriteFasta writes s to w in fasta format followed by a newline, with
+// sequence lines wrapped at wrap columns. If wrap is zero or negative the
+// sequence is written on a single line.
+func WriteFasta(w io.Writer, s fmt.Formatter, wrap int) error {
+	var err error
+	if wrap > 0 {
+		_, err = fmt.Fprintf(w, "%*a\n", wrap, s)
+	} else {
+		_, err = fmt.Fprintf(w, "%a\n", s)
+	}
+	return err
+}
+
+// TrimAmbiguous returns the half-open interval of s remaining after
+// removal of leading and trailing runs of the ambiguous letter of alpha.
+// If alpha is not case sensitive, both cases of the ambiguous letter are
+// removed. If s consists only of ambiguous letters, start equals end.
+func TrimAmbiguous(s alphabet.Letters, alpha alphabet.Alphabet) (start, end int) {
+	isAmbiguous := func(l alphabet.Letter) bool {
+		n := alpha.Ambiguous()
+		if alpha.IsCased() {
+			return l == n
+		}
+		return l|0x20 == n|0x20
+	}
+	end = len(s)
+	for start < end && isAmbiguous(s[start]) {
+		start++
+	}
+	for end > start && isAmbiguous(s[end-1]) {
+		end--
+	}
+	return start, end
+}
+
+// Upper converts the lower case letters of s to upper case in place,
+// removing soft masking. If alpha is case sensitive, s is not altered
+// since case distinguishes letters of the alphabet.
+func Upper(s alphabet.Letters, alpha alphabet.Alphabet) {
+	if alpha.IsCased() {
+		return
+	}
+	for i, l := range s {
+		if 'a' <= l && l <= 'z' {
+			s[i] = l &^ 0x20
+		}
+	}
+}
+
+// RevComp returns a reverse complemented copy of s, complemented using the
+// alphabet of s. The strand of the returned sequence is the inverse of the
+// strand of s and s is not altered. Letters without a complement in the
+// alphabet are retained unaltered. RevComp will panic if the alphabet of s
+// is not an alphabet.Complementor.
+func RevComp(s *linear.Seq) *linear.Seq {
+	c, ok := s.Alpha.(alphabet.Complementor)
+	if !ok {
+		panic(fmt.Sprintf("sequtil: alphabet %T is not a complementor", s.Alpha))
+	}
+	r := *s
+	r.Seq = revComp(s.Seq, c)
+	r.Strand = -s.Strand
+	return &r
+}
+
+// RevCompLetters returns a reverse complemented copy of s, complemented
+// using the redundant DNA alphabet so that IUPAC ambiguity codes and gaps
+// are handled. Letters without a complement are retained unaltered.
+func RevCompLetters(s alphabet.Letters) alphabet.Letters {
+	return revComp(s, alphabet.DNAredundant)
+}
+
+// revComp returns a reverse complemented copy of s using the complement
+// relationships of c.
+func revComp(s alphabet.Letters, c alphabet.Complementor) alphabet.Letters {
+	r := make(alphabet.Letters, len(s))
+	for i, l := range s {
+		r[len(s)-1-i], _ = c.Complement(l)
+	}
+	return r
+}
+
+// ReadContigs returns the sequences in the named fasta file keyed by name,
+// read using the alphabet alpha. If more than one sequence has the same
+// name ReadContigs returns an error, unless warn is true, in which case
+// the duplicate is logged and the last sequence with the name is retained.
+func ReadContigs(path string, alpha alphabet.Alphabet, warn bool) (map[string]*linear.Seq, error) {
+	seqs := make(map[string]*linear.Seq)
+	err := scanContigs(path, alpha, warn, func(s *linear.Seq) {
+		seqs[s.Name()] = s
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// ContigLengths returns the lengths of the sequences in the named fasta
+// file keyed by name. Duplicate names are handled as for ReadContigs.
+func ContigLengths(path string, warn bool) (map[string]int, error) {
+	lengths := make(map[string]int)
+	err := scanContigs(path, alphabet.DNA, warn, func(s *linear.Seq) {
+		lengths[s.Name()] = s.Len()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lengths, nil
+}
+
+// scanContigs calls fn on each sequence in the named fasta file, checking
+// for duplicate sequence names.
+func scanContigs(path string, alpha alphabet.Alphabet, warn bool, fn func(*linear.Seq)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alpha)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		name := s.Name()
+		if seen[name] {
+			if !warn {
+				return fmt.Errorf("sequtil: duplicate sequence name %q in %q", name, path)
+			}
+			log.Printf("duplicate sequence name %q in %q: using last", name, path)
+		}
+		seen[name] = true
+		fn(s)
+	}
+	return sc.Error()
+}
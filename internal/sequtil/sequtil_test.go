@@ -0,0 +1,175 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sequtil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/feat"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+var trimAmbiguousTests = []struct {
+	seq        string
+	alpha      alphabet.Alphabet
+	start, end int
+}{
+	{seq: "", alpha: alphabet.DNA, start: 0, end: 0},
+	{seq: "acgt", alpha: alphabet.DNA, start: 0, end: 4},
+	{seq: "NNacgtNNN", alpha: alphabet.DNA, start: 2, end: 6},
+	{seq: "nNacgtNn", alpha: alphabet.DNA, start: 2, end: 6},
+	{seq: "acNNgt", alpha: alphabet.DNA, start: 0, end: 6},
+	{seq: "NNacNNgtNN", alpha: alphabet.DNA, start: 2, end: 8},
+	{seq: "NNNN", alpha: alphabet.DNA, start: 4, end: 4},
+	{seq: "nnnn", alpha: alphabet.DNA, start: 4, end: 4},
+	{seq: "XXaaXX", alpha: alphabet.Protein, start: 2, end: 4},
+}
+
+func TestTrimAmbiguous(t *testing.T) {
+	for _, test := range trimAmbiguousTests {
+		start, end := TrimAmbiguous(alphabet.Letters(test.seq), test.alpha)
+		if start != test.start || end != test.end {
+			t.Errorf("unexpected trim of %q: got:[%d,%d) want:[%d,%d)", test.seq, start, end, test.start, test.end)
+		}
+	}
+}
+
+var revCompTests = []struct {
+	seq   string
+	alpha alphabet.Alphabet
+	want  string
+}{
+	{seq: "", alpha: alphabet.DNA, want: ""},
+	{seq: "acgt", alpha: alphabet.DNA, want: "acgt"},
+	{seq: "aaccg", alpha: alphabet.DNA, want: "cggtt"},
+	{seq: "AACcg", alpha: alphabet.DNA, want: "cgGTT"},
+	{seq: "acnNg", alpha: alphabet.DNA, want: "cNngt"},
+	{seq: "ac-g-t", alpha: alphabet.DNAgapped, want: "a-c-gt"},
+	{seq: "rykmbdhv", alpha: alphabet.DNAredundant, want: "bdhvkmry"},
+	{seq: "RYswN-", alpha: alphabet.DNAredundant, want: "-NwsRY"},
+}
+
+func TestRevComp(t *testing.T) {
+	for _, test := range revCompTests {
+		s := linear.NewSeq("test", alphabet.Letters(test.seq), test.alpha)
+		s.Strand = seq.Plus
+		got := RevComp(s)
+		if string(got.Seq) != test.want {
+			t.Errorf("unexpected reverse complement of %q: got:%q want:%q", test.seq, got.Seq, test.want)
+		}
+		if got.Strand != seq.Minus {
+			t.Errorf("unexpected strand for reverse complement of %q: got:%v want:%v", test.seq, got.Strand, seq.Minus)
+		}
+		if string(s.Seq) != test.seq || s.Strand != seq.Plus {
+			t.Errorf("input altered: got:%q %v want:%q %v", s.Seq, s.Strand, test.seq, seq.Plus)
+		}
+		if got.Alpha != test.alpha {
+			t.Errorf("unexpected alphabet for reverse complement of %q", test.seq)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for non-complementing alphabet")
+		}
+	}()
+	RevComp(linear.NewSeq("test", alphabet.Letters("acde"), alphabet.Protein))
+}
+
+func TestRevCompLetters(t *testing.T) {
+	for _, test := range revCompTests {
+		// RevCompLetters always uses the redundant
+		// DNA alphabet, which includes N and gaps.
+		got := RevCompLetters(alphabet.Letters(test.seq))
+		if string(got) != test.want {
+			t.Errorf("unexpected reverse complement of %q: got:%q want:%q", test.seq, got, test.want)
+		}
+	}
+}
+
+func TestReadContigsDuplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequtil")
+	if err != nil {
+		t.Fatalf("failed to make temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name    string
+		fasta   string
+		wantErr bool
+	}{
+		{name: "unique.fa", fasta: ">chr1\nacgt\n>chr2\nacgtacgt\n"},
+		{name: "dup.fa", fasta: ">chr1\nacgt\n>chr2\nacgtacgt\n>chr1 second\nacg\n", wantErr: true},
+	} {
+		path := filepath.Join(dir, test.name)
+		err := ioutil.WriteFile(path, []byte(test.fasta), 0664)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", test.name, err)
+		}
+
+		seqs, err := ReadContigs(path, alphabet.DNA, false)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for %s: got:%v want error:%t", test.name, err, test.wantErr)
+		}
+		if err == nil && len(seqs) != 2 {
+			t.Errorf("unexpected number of sequences for %s: got:%d want:2", test.name, len(seqs))
+		}
+		lengths, err := ContigLengths(path, false)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unexpected error for lengths of %s: got:%v want error:%t", test.name, err, test.wantErr)
+		}
+		if err == nil && (lengths["chr1"] != 4 || lengths["chr2"] != 8) {
+			t.Errorf("unexpected lengths for %s: %v", test.name, lengths)
+		}
+
+		// With warn, the last of duplicated sequences is kept.
+		seqs, err = ReadContigs(path, alphabet.DNA, true)
+		if err != nil {
+			t.Errorf("unexpected error for %s with warn: %v", test.name, err)
+			continue
+		}
+		want := 4
+		if test.wantErr {
+			want = 3
+		}
+		if got := seqs["chr1"].Len(); got != want {
+			t.Errorf("unexpected length of retained chr1 for %s: got:%d want:%d", test.name, got, want)
+		}
+	}
+}
+
+func TestUpper(t *testing.T) {
+	cased, err := alphabet.NewAlphabet("-acgtnACGTN", feat.DNA, '-', 'n', true)
+	if err != nil {
+		t.Fatalf("failed to make alphabet: %v", err)
+	}
+	for _, test := range []struct {
+		seq   string
+		alpha alphabet.Alphabet
+		want  string
+	}{
+		{seq: "", alpha: alphabet.DNA, want: ""},
+		{seq: "acgtn", alpha: alphabet.DNA, want: "ACGTN"},
+		{seq: "ACgtNNnnAC", alpha: alphabet.DNA, want: "ACGTNNNNAC"},
+		{seq: "ac-gT--nA", alpha: alphabet.DNAgapped, want: "AC-GT--NA"},
+		{seq: "ACGT", alpha: alphabet.DNAgapped, want: "ACGT"},
+
+		// Case distinguishes letters of a case
+		// sensitive alphabet, so is retained.
+		{seq: "ACgtNn", alpha: cased, want: "ACgtNn"},
+	} {
+		s := alphabet.BytesToLetters([]byte(test.seq))
+		Upper(s, test.alpha)
+		if got := string(alphabet.LettersToBytes(s)); got != test.want {
+			t.Errorf("unexpected result for %q: got:%q want:%q", test.seq, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,740 @@
+// Copyright ©2015 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package loopy provides analysis of blasr alignments of long reads and
+// their remapped unaligned flanks to identify candidate structural
+// variation features.
+//
+// The analysis is based on the original python code by Steve Turner.
+package loopy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+// HitSet represents a collection of blasr mapping results keyed
+// by full query name, including any subread coordinates.
+type HitSet map[string]*Hit
+
+// ReadHits returns a HitSet from the blasr format 4 output read from r.
+func ReadHits(r io.Reader) (HitSet, error) {
+	hits := make(HitSet)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		b, err := newHit(sc.Text())
+		if err != nil {
+			return nil, err
+		}
+		hits[b.QName] = b
+	}
+	return hits, sc.Err()
+}
+
+// WriteFlankSeqs writes fasta sequences of the unmapped flanks of the reads
+// read from the fasta stream, reads, that are identified in the primary hits
+// provided. cutoff specifies the minimum sequence length to consider. Left
+// and right flanks are written to left and right.
+func WriteFlankSeqs(left, right io.Writer, reads io.Reader, hits HitSet, cutoff int) error {
+	r := fasta.NewReader(reads, linear.NewSeq("", nil, alphabet.DNA))
+	sc := seqio.NewScanner(r)
+	for sc.Next() {
+		seq := sc.Seq().(*linear.Seq)
+		h, ok := hits[seq.Name()]
+		if !ok {
+			continue
+		}
+
+		all := seq.Seq
+		if h.QStart >= cutoff {
+			seq.Seq = all[:h.QStart]
+			_, err := fmt.Fprintf(left, "%60a\n", seq)
+			if err != nil {
+				return err
+			}
+		}
+		if h.QLen-h.QEnd >= cutoff {
+			seq.Seq = all[h.QEnd:]
+			_, err := fmt.Fprintf(right, "%60a\n", seq)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Error()
+}
+
+// WriteResults writes out the results of the analysis in a format similar to the
+// Pacific Biosciences bridgemapper program (29 tab separated fields), or if asJSON
+// is true, as a stream of JSON objects, one per read. It also writes candidate
+// discordances as GFF to discords if it is not nil; discordances derived from a
+// read with both flanks contributing are linked by a Mate attribute holding the
+// read name. Flanks mapping to a different contig to the core are written as
+// flank features, flanks on the same strand of the same contig as insertion and
+// deletion features, and flanks on the opposite strand of the same contig as
+// inversion features, according to filt.Events. Discordances where a flank maps
+// to a different contig to the core are also written to pairs as BEDPE if it is
+// not nil, pairing the core locus with the flank locus. Hits are filtered
+// according to filt. Reads are written in order of read name.
+func WriteResults(out, discords, pairs io.Writer, core, left, right HitSet, asJSON bool, filt Filter) error {
+	var enc *json.Encoder
+	if asJSON {
+		enc = json.NewEncoder(out)
+	}
+	var w *gff.Writer
+	if discords != nil {
+		w = gff.NewWriter(discords, 60, true)
+	}
+	reads := make([]string, 0, len(core))
+	for id := range core {
+		reads = append(reads, id)
+	}
+	sort.Strings(reads)
+	for _, id := range reads {
+		c := core[id]
+		if !filt.keepCore(c) {
+			continue
+		}
+		l, ok := left[id]
+		if ok && !filt.keepFlank(l) {
+			l = nil
+		}
+		r, ok := right[id]
+		if ok && !filt.keepFlank(r) {
+			r = nil
+		}
+		if l == nil && r == nil {
+			continue
+		}
+		var err error
+		if asJSON {
+			err = enc.Encode(result{Read: id, Length: c.QLen, Left: l, Core: c, Right: r})
+		} else {
+			_, err = fmt.Fprintf(out, "%s\t%d\t%v\t%v\t%v\n", id, c.QLen, l, c, r)
+		}
+		if err != nil {
+			return err
+		}
+		if discords != nil || pairs != nil {
+			var (
+				feats []*gff.Feature
+				n     int
+			)
+			for i, f := range [2]*Hit{l, r} {
+				if f == nil || !filt.discordant(f) {
+					continue
+				}
+				n++
+				switch {
+				case f.TName != c.TName:
+					if !filt.writes(Translocation) {
+						continue
+					}
+					if pairs != nil {
+						err = writeBEDPE(pairs, id, [2]string{"left", "right"}[i], c, f)
+						if err != nil {
+							return err
+						}
+					}
+					feats = append(feats, flankFeature(f))
+				case f.TStrand == c.TStrand:
+					if filt.writes(Indel) {
+						feats = append(feats, gapOrOverlap(f, c, filt.Flank, filt.MaxGap)...)
+					}
+				default:
+					if !filt.writes(Inversion) {
+						continue
+					}
+					if inv := inversionOf(f, c, filt.Flank); inv != nil {
+						feats = append(feats, inv)
+					}
+				}
+			}
+			if discords == nil {
+				continue
+			}
+			// Link features derived from both flanks of
+			// the same read so they can be treated as a
+			// single event downstream.
+			for _, g := range feats {
+				if n == 2 {
+					g.FeatAttributes = append(g.FeatAttributes, gff.Attribute{Tag: "Mate", Value: id})
+				}
+				_, err = w.Write(g)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeBEDPE writes a BEDPE record to w pairing the locus of the core hit
+// c with the locus of the flank hit f of the named read. The fields are
+// the two loci with zero-based half-open coordinates on the plus strand of
+// the reference, the read name, the flank score, the reference strands of
+// the two hits and the side of the read the flank was taken from.
+func writeBEDPE(w io.Writer, read, side string, c, f *Hit) error {
+	cStart, cEnd := c.forwardCoords()
+	fStart, fEnd := f.forwardCoords()
+	_, err := fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%d\t%s\t%d\t%v\t%v\t%s\n",
+		c.TName, cStart, cEnd, f.TName, fStart, fEnd, read, f.Score, c.TStrand, f.TStrand, side)
+	return err
+}
+
+// WriteHits writes the unfiltered hits of core, left and right to w in
+// long format, one hit per line labelled with its read name and its role
+// (core, left or right). If asJSON is true the hits are written as a
+// stream of JSON objects, otherwise as tab separated fields preceded by
+// a header line. Hits are grouped by role and written in order of read
+// name within each group.
+func WriteHits(w io.Writer, core, left, right HitSet, asJSON bool) error {
+	var enc *json.Encoder
+	if asJSON {
+		enc = json.NewEncoder(w)
+	} else {
+		_, err := fmt.Fprintln(w, "read\trole\ttName\ttStrand\ttStart\ttEnd\tqStart\tqEnd\tscore\tsimilarity\tmapQV")
+		if err != nil {
+			return err
+		}
+	}
+	for _, set := range []struct {
+		role string
+		hits HitSet
+	}{
+		{role: "core", hits: core},
+		{role: "left", hits: left},
+		{role: "right", hits: right},
+	} {
+		reads := make([]string, 0, len(set.hits))
+		for id := range set.hits {
+			reads = append(reads, id)
+		}
+		sort.Strings(reads)
+		for _, id := range reads {
+			b := set.hits[id]
+			start, end := b.targetCoords()
+			var err error
+			if asJSON {
+				err = enc.Encode(struct {
+					Read       string  `json:"read"`
+					Role       string  `json:"role"`
+					TName      string  `json:"tName"`
+					TStrand    string  `json:"tStrand"`
+					TStart     int     `json:"tStart"`
+					TEnd       int     `json:"tEnd"`
+					QStart     int     `json:"qStart"`
+					QEnd       int     `json:"qEnd"`
+					Score      int     `json:"score"`
+					Similarity float64 `json:"similarity"`
+					MapQV      int     `json:"mapQV"`
+				}{
+					Read:       id,
+					Role:       set.role,
+					TName:      b.TName,
+					TStrand:    b.TStrand.String(),
+					TStart:     start,
+					TEnd:       end,
+					QStart:     b.QStart,
+					QEnd:       b.QEnd,
+					Score:      b.Score,
+					Similarity: b.Similarity,
+					MapQV:      b.MapQV,
+				})
+			} else {
+				_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%d\t%d\t%d\t%d\t%d\t%f\t%d\n",
+					id, set.role, b.TName, b.TStrand, start, end, b.QStart, b.QEnd, b.Score, b.Similarity, b.MapQV)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Filter specifies criteria for retaining blasr hits.
+type Filter struct {
+	// Length is the minimum query length of core hits.
+	Length int
+	// Flank is the minimum target length of flank hits
+	// and the minimum size of discordances.
+	Flank int
+
+	// MinMapQV is the minimum mapQV of core hits, and
+	// of flank hits if FlankMapQV is true. If MinMapQV
+	// is zero, mapQV is not considered.
+	MinMapQV   int
+	FlankMapQV bool
+
+	// MinFlankSimilarity is the minimum blasr percent
+	// similarity of a flank hit for it to contribute
+	// to discordance features.
+	MinFlankSimilarity float64
+
+	// Exclude matches the names of target contigs
+	// of hits to ignore. If nil, no hits are ignored.
+	Exclude *regexp.Regexp
+
+	// MaxGap is the maximum reference gap between a
+	// flank hit and its core hit on the same strand
+	// that is reported as an indel. Pairs with a
+	// longer gap are reported as flank features. If
+	// MaxGap is zero there is no limit.
+	MaxGap int
+
+	// Events is the set of discordance classes
+	// written. If zero, all classes are written.
+	Events Event
+}
+
+// Event is a set of discordance event classes.
+type Event uint
+
+const (
+	// Translocation is a flank hit on a different
+	// contig to its core hit.
+	Translocation Event = 1 << iota
+
+	// Indel is an insertion or deletion between a
+	// flank hit and its core hit on the same strand
+	// of the same contig.
+	Indel
+
+	// Inversion is a flank hit on the opposite
+	// strand of the same contig to its core hit.
+	Inversion
+
+	// AllEvents is the set of all event classes.
+	AllEvents = Translocation | Indel | Inversion
+)
+
+// writes returns whether discordances of the class e are written.
+func (f Filter) writes(e Event) bool {
+	return f.Events == 0 || f.Events&e != 0
+}
+
+// discordant returns whether the flank hit b may be used to
+// call discordances.
+func (f Filter) discordant(b *Hit) bool {
+	return b.Similarity >= f.MinFlankSimilarity
+}
+
+// keepCore returns whether the core hit b satisfies the filter.
+func (f Filter) keepCore(b *Hit) bool {
+	return b.QEnd-b.QStart >= f.Length && f.mapQVOK(b) && !f.excluded(b)
+}
+
+// keepFlank returns whether the flank hit b satisfies the filter.
+func (f Filter) keepFlank(b *Hit) bool {
+	return abs(b.TEnd-b.TStart) >= f.Flank && (!f.FlankMapQV || f.mapQVOK(b)) && !f.excluded(b)
+}
+
+// excluded returns whether the target contig of b is excluded.
+func (f Filter) excluded(b *Hit) bool {
+	return f.Exclude != nil && f.Exclude.MatchString(b.TName)
+}
+
+// unavailableMapQV is the lowest mapQV value used by blasr as a
+// sentinel for an unavailable mapping quality.
+const unavailableMapQV = 254
+
+// mapQVOK returns whether b passes the mapQV threshold. Hits with
+// an unavailable mapQV do not pass a non-zero threshold since their
+// mapping quality cannot be established.
+func (f Filter) mapQVOK(b *Hit) bool {
+	if f.MinMapQV == 0 {
+		return true
+	}
+	return b.MapQV < unavailableMapQV && b.MapQV >= f.MinMapQV
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// flankFeature returns a feature describing the target span of the flank
+// hit f on the plus strand of the target.
+func flankFeature(f *Hit) *gff.Feature {
+	start, end := f.forwardCoords()
+	return &gff.Feature{
+		SeqName:    f.TName,
+		Feature:    "flank",
+		Source:     "loopy",
+		FeatStart:  start,
+		FeatEnd:    end,
+		FeatScore:  floatPtr(float64(f.Score)),
+		FeatStrand: f.TStrand,
+		FeatFrame:  gff.NoFrame,
+	}
+}
+
+// gapOrOverlap returns features that describe insertion or deletion events
+// in the reads relative to the reference. Only features cutoff or longer are
+// returned and pairs of read insertion/reference deletion that are within
+// cutoff in length are discarded. If maxGap is greater than zero and the
+// reference gap is longer than maxGap, the pair is not treated as an indel
+// and a flank feature for the flank hit is returned instead. Features are
+// placed on the plus strand of the target and carry the target strand of
+// the hits.
+func gapOrOverlap(flank, core *Hit, cutoff, maxGap int) []*gff.Feature {
+	if flank.TName != core.TName {
+		panic("bad hit pair")
+	}
+
+	var (
+		qGapStart, qGapEnd int
+		tGapStart, tGapEnd int
+	)
+	if flank.QStart < core.QStart {
+		qGapStart = flank.QEnd
+		qGapEnd = core.QStart
+	} else {
+		qGapStart = core.QEnd
+		qGapEnd = core.QEnd + flank.QStart
+	}
+	// The hits are on the same strand, so the part of the
+	// read nearer its start is nearer the start of the plus
+	// strand of the target only when the hits are on the
+	// plus strand.
+	cStart, cEnd := core.forwardCoords()
+	fStart, fEnd := flank.forwardCoords()
+	if (flank.QStart < core.QStart) == (flank.TStrand == seq.Plus) {
+		tGapStart = fEnd
+		tGapEnd = cStart
+	} else {
+		tGapStart = cEnd
+		tGapEnd = fStart
+	}
+	if tGapEnd < tGapStart {
+		tGapEnd, tGapStart = tGapStart, tGapEnd
+	}
+
+	if abs((qGapEnd-qGapStart)-(tGapEnd-tGapStart)) < cutoff {
+		return nil
+	}
+	if maxGap > 0 && tGapEnd-tGapStart > maxGap {
+		return []*gff.Feature{flankFeature(flank)}
+	}
+
+	f := make([]*gff.Feature, 0, 2)
+	if qGapEnd-qGapStart >= cutoff {
+		f = append(f, &gff.Feature{
+			SeqName:   flank.TName,
+			Feature:   "insertion",
+			Source:    "loopy",
+			FeatStart: tGapStart,
+
+			// We may have a zero-length feature, but GFF is
+			// broken by design, so paper over that here.
+			FeatEnd: max(tGapEnd, tGapStart+1),
+
+			FeatStrand: flank.TStrand,
+			FeatFrame:  gff.NoFrame,
+			FeatAttributes: gff.Attributes{{
+				Tag:   "Query",
+				Value: fmt.Sprintf("%s %d %d", flank.QName, qGapStart, qGapEnd),
+			}},
+		})
+	}
+	if tGapEnd-tGapStart >= cutoff {
+		f = append(f, &gff.Feature{
+			SeqName:    flank.TName,
+			Feature:    "deletion",
+			Source:     "loopy",
+			FeatStart:  tGapStart,
+			FeatEnd:    tGapEnd,
+			FeatStrand: flank.TStrand,
+			FeatFrame:  gff.NoFrame,
+		})
+	}
+	return f
+}
+
+// inversionOf returns a feature describing an inversion in the read
+// relative to the reference when flank maps to the opposite strand of the
+// contig that core maps to. The inverted segment extends from the end of
+// the core hit nearest the flank hit to the far end of the flank hit. If
+// the segment is shorter than cutoff, inversionOf returns nil.
+func inversionOf(flank, core *Hit, cutoff int) *gff.Feature {
+	if flank.TName != core.TName || flank.TStrand == core.TStrand {
+		panic("bad hit pair")
+	}
+
+	cStart, cEnd := core.forwardCoords()
+	fStart, fEnd := flank.forwardCoords()
+	var start, end int
+	if fStart+fEnd >= cStart+cEnd {
+		start, end = cEnd, fEnd
+	} else {
+		start, end = fStart, cStart
+	}
+	if end-start < cutoff {
+		return nil
+	}
+
+	return &gff.Feature{
+		SeqName:    flank.TName,
+		Feature:    "inversion",
+		Source:     "loopy",
+		FeatStart:  start,
+		FeatEnd:    end,
+		FeatScore:  floatPtr(float64(flank.Score)),
+		FeatStrand: flank.TStrand,
+		FeatFrame:  gff.NoFrame,
+		FeatAttributes: gff.Attributes{{
+			Tag:   "Query",
+			Value: fmt.Sprintf("%s %d %d", flank.QName, flank.QStart, flank.QEnd),
+		}},
+	}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+const (
+	qnameField = iota
+	tnameField
+	scoreField
+	pctsimilarityField
+	qstrandField
+	qstartField
+	qendField
+	qseqlengthField
+	tstrandField
+	tstartField
+	tendField
+	tseqlengthField
+	mapqvField
+	ncellsField
+	clusterScoreField
+	probscoreField
+	numSigClustersField
+
+	numFields
+)
+
+// Hit is a blasr mapping event. Target coordinates are as reported
+// by blasr, so the target coordinates of reverse strand hits are
+// relative to the reverse complement of the target.
+type Hit struct {
+	QName   string
+	QStrand seq.Strand
+	QStart  int
+	QEnd    int
+	QLen    int
+
+	TName   string
+	TStrand seq.Strand
+	TStart  int
+	TEnd    int
+	TLen    int
+
+	Score      int
+	Similarity float64
+	MapQV      int
+}
+
+func handlePanic(err *error) {
+	r := recover()
+	if r != nil {
+		switch r := r.(type) {
+		case error:
+			*err = r
+		default:
+			panic(r)
+		}
+	}
+}
+
+// newHit returns a Hit parsed from a blasr format 4 line.
+func newHit(line string) (b *Hit, err error) {
+	defer handlePanic(&err)
+	fields := strings.Fields(line)
+	return &Hit{
+		// The original code strips the subread start and end from the qname.
+		// This is incorrect since multiple movies and subreads of a single
+		// ZMW may exist in the read file, resulting in clobbered map entries
+		// (this is also true in the original python). We retain the full
+		// query name so that distinct subreads are keyed separately.
+		QName: fields[qnameField],
+
+		QStrand: mustStrand(mustAtoi(fields[qstrandField])),
+		QStart:  mustAtoi(fields[qstartField]),
+		QEnd:    mustAtoi(fields[qendField]),
+		QLen:    mustAtoi(fields[qseqlengthField]),
+
+		TName:   fields[tnameField],
+		TStrand: mustStrand(mustAtoi(fields[tstrandField])),
+		TStart:  mustAtoi(fields[tstartField]),
+		TEnd:    mustAtoi(fields[tendField]),
+		TLen:    mustAtoi(fields[tseqlengthField]),
+
+		Score:      mustAtoi(fields[scoreField]),
+		Similarity: mustAtof(fields[pctsimilarityField]),
+		MapQV:      mustAtoi(fields[mapqvField]),
+	}, nil
+}
+
+func mustAtoi(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+func mustAtof(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// mustStrand returns the seq.Strand corresponding to a blasr format-4
+// strand value, 0 for the forward strand and 1 for the reverse strand.
+func mustStrand(s int) seq.Strand {
+	switch s {
+	case 0:
+		return seq.Plus
+	case 1:
+		return seq.Minus
+	default:
+		panic(fmt.Sprintf("bad strand value: %d", s))
+	}
+}
+
+// targetCoords returns the target start and end of the hit in the
+// coordinates used for output. blasr reports target coordinates of
+// reverse strand hits relative to the reverse complement of the target.
+func (b *Hit) targetCoords() (start, end int) {
+	start = b.TStart
+	end = b.TEnd
+	if b.TStrand == seq.Minus {
+		start = b.TLen - start
+		end = b.TLen - end
+	}
+	return start, end
+}
+
+// forwardCoords returns the half-open interval of the hit on the
+// plus strand of the target.
+func (b *Hit) forwardCoords() (start, end int) {
+	if b.TStrand == seq.Minus {
+		return b.TLen - b.TEnd, b.TLen - b.TStart
+	}
+	return b.TStart, b.TEnd
+}
+
+// hitFields are the names of the tab separated fields written
+// by the Hit String method.
+var hitFields = []string{
+	"qStart",
+	"qEnd",
+	"tName",
+	"tStrand",
+	"tStart",
+	"tEnd",
+	"score",
+	"similarity",
+	"mapQV",
+}
+
+// WriteHeader writes the column names of the tab separated
+// results written by WriteResults to w.
+func WriteHeader(w io.Writer) error {
+	cols := []string{"read", "length"}
+	for _, hit := range []string{"left", "core", "right"} {
+		for _, f := range hitFields {
+			cols = append(cols, hit+"_"+f)
+		}
+	}
+	_, err := fmt.Fprintln(w, strings.Join(cols, "\t"))
+	return err
+}
+
+// String returns the tab separated fields of the hit named by
+// hitFields, as written by WriteResults. The target strand is
+// written as 1 or -1.
+func (b *Hit) String() string {
+	const empty = "_\t_\t_\t_\t_\t_\t_\t_\t_"
+	if b == nil {
+		return empty
+	}
+
+	start, end := b.targetCoords()
+	return fmt.Sprintf("%d\t%d\t%s\t%d\t%d\t%d\t%d\t%f\t%d",
+		b.QStart,
+		b.QEnd,
+		b.TName,
+		b.TStrand,
+		start,
+		end,
+		b.Score,
+		b.Similarity,
+		b.MapQV,
+	)
+}
+
+// result is the JSON representation of the analysis of a single read.
+type result struct {
+	Read   string `json:"read"`
+	Length int    `json:"length"`
+	Left   *Hit   `json:"left"`
+	Core   *Hit   `json:"core"`
+	Right  *Hit   `json:"right"`
+}
+
+// MarshalJSON implements the json.Marshaler interface. The fields
+// correspond to those written by the String method.
+func (b *Hit) MarshalJSON() ([]byte, error) {
+	start, end := b.targetCoords()
+	return json.Marshal(struct {
+		QStart     int     `json:"qStart"`
+		QEnd       int     `json:"qEnd"`
+		TName      string  `json:"tName"`
+		TStrand    string  `json:"tStrand"`
+		TStart     int     `json:"tStart"`
+		TEnd       int     `json:"tEnd"`
+		Score      int     `json:"score"`
+		Similarity float64 `json:"similarity"`
+		MapQV      int     `json:"mapQV"`
+	}{
+		QStart:     b.QStart,
+		QEnd:       b.QEnd,
+		TName:      b.TName,
+		TStrand:    b.TStrand.String(),
+		TStart:     start,
+		TEnd:       end,
+		Score:      b.Score,
+		Similarity: b.Similarity,
+		MapQV:      b.MapQV,
+	})
+}
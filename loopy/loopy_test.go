@@ -0,0 +1,343 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loopy
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// The testdata hits are blasr format 4 output for core alignments and
+// their left and right flanks for reads with the following events:
+//
+//  ins:   an insertion between the left flank and the core.
+//  del:   a deletion between the core and the right flank.
+//  rdel:  a deletion as for del with both hits on the minus strand.
+//  trans: a left flank on the minus strand of another contig.
+//  inv:   a right flank on the minus strand of the core contig.
+//  mate:  both flanks on other contigs.
+//  short: a core hit shorter than the length filter.
+
+// hitSet returns the hits in the named testdata file.
+func hitSet(t *testing.T, name string) HitSet {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to open hits: %v", err)
+	}
+	defer f.Close()
+	hits, err := ReadHits(f)
+	if err != nil {
+		t.Fatalf("failed to read hits: %v", err)
+	}
+	return hits
+}
+
+// golden compares got with the named golden file, updating
+// the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestWriteResultsGolden(t *testing.T) {
+	core := hitSet(t, "core.m4")
+	left := hitSet(t, "left.m4")
+	right := hitSet(t, "right.m4")
+	filt := Filter{Length: 100, Flank: 100}
+	for _, test := range []struct {
+		asJSON bool
+		out    string
+	}{
+		{out: "results.tsv"},
+		{asJSON: true, out: "results.json"},
+	} {
+		var out, discords, pairs bytes.Buffer
+		if !test.asJSON {
+			err := WriteHeader(&out)
+			if err != nil {
+				t.Fatalf("unexpected error writing header: %v", err)
+			}
+		}
+		err := WriteResults(&out, &discords, &pairs, core, left, right, test.asJSON, filt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		golden(t, test.out, out.Bytes())
+		golden(t, "discords.gff", discords.Bytes())
+		golden(t, "pairs.bedpe", pairs.Bytes())
+	}
+}
+
+func TestWriteHitsGolden(t *testing.T) {
+	core := hitSet(t, "core.m4")
+	left := hitSet(t, "left.m4")
+	right := hitSet(t, "right.m4")
+	for _, test := range []struct {
+		asJSON bool
+		out    string
+	}{
+		{out: "hits.tsv"},
+		{asJSON: true, out: "hits.json"},
+	} {
+		var buf bytes.Buffer
+		err := WriteHits(&buf, core, left, right, test.asJSON)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		golden(t, test.out, buf.Bytes())
+	}
+}
+
+// twoSubreads is blasr format 4 output for two subreads of a single
+// ZMW mapping to different loci.
+const twoSubreads = `m1/10/0_300 chr1 -500 90.0 0 100 200 300 0 1000 1100 5000 254 0 0 0 0
+m1/10/350_600 chr2 -400 90.0 0 50 200 250 1 2000 2150 6000 254 0 0 0 0
+`
+
+func TestReadHitsSubreads(t *testing.T) {
+	hits, err := ReadHits(strings.NewReader(twoSubreads))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("unexpected number of hits: got:%d want:2", len(hits))
+	}
+	for _, test := range []struct {
+		name   string
+		tName  string
+		qStart int
+		qEnd   int
+		qLen   int
+	}{
+		{name: "m1/10/0_300", tName: "chr1", qStart: 100, qEnd: 200, qLen: 300},
+		{name: "m1/10/350_600", tName: "chr2", qStart: 50, qEnd: 200, qLen: 250},
+	} {
+		h, ok := hits[test.name]
+		if !ok {
+			t.Errorf("missing hit for %s", test.name)
+			continue
+		}
+		if h.TName != test.tName || h.QStart != test.qStart || h.QEnd != test.qEnd || h.QLen != test.qLen {
+			t.Errorf("unexpected hit for %s: got:%+v", test.name, h)
+		}
+	}
+}
+
+func TestWriteFlankSeqsSubreads(t *testing.T) {
+	hits, err := ReadHits(strings.NewReader(twoSubreads))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reads := ">m1/10/0_300\n" + strings.Repeat("a", 100) + strings.Repeat("c", 100) + strings.Repeat("g", 100) + "\n" +
+		">m1/10/350_600\n" + strings.Repeat("t", 50) + strings.Repeat("c", 150) + strings.Repeat("a", 50) + "\n"
+
+	var left, right bytes.Buffer
+	err = WriteFlankSeqs(&left, &right, strings.NewReader(reads), hits, 50)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantLeft := ">m1/10/0_300\n" + strings.Repeat("a", 60) + "\n" + strings.Repeat("a", 40) + "\n" +
+		">m1/10/350_600\n" + strings.Repeat("t", 50) + "\n"
+	wantRight := ">m1/10/0_300\n" + strings.Repeat("g", 60) + "\n" + strings.Repeat("g", 40) + "\n" +
+		">m1/10/350_600\n" + strings.Repeat("a", 50) + "\n"
+	if left.String() != wantLeft {
+		t.Errorf("unexpected left flanks:\ngot:\n%s\nwant:\n%s", &left, wantLeft)
+	}
+	if right.String() != wantRight {
+		t.Errorf("unexpected right flanks:\ngot:\n%s\nwant:\n%s", &right, wantRight)
+	}
+}
+
+func TestWriteHitsStrand(t *testing.T) {
+	core := hitSet(t, "core.m4")
+	left := hitSet(t, "left.m4")
+	right := hitSet(t, "right.m4")
+
+	var tsv, jsonl bytes.Buffer
+	err := WriteHits(&tsv, core, left, right, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = WriteHits(&jsonl, core, left, right, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows := strings.Split(strings.TrimSpace(tsv.String()), "\n")[1:]
+	dec := json.NewDecoder(&jsonl)
+	for i, row := range rows {
+		var h struct {
+			Read    string `json:"read"`
+			TStrand string `json:"tStrand"`
+		}
+		err := dec.Decode(&h)
+		if err != nil {
+			t.Fatalf("unexpected error decoding hit %d: %v", i, err)
+		}
+		fields := strings.Split(row, "\t")
+		if fields[0] != h.Read {
+			t.Fatalf("unexpected read order for hit %d: got:%s want:%s", i, fields[0], h.Read)
+		}
+		if fields[3] != h.TStrand {
+			t.Errorf("unexpected strand for %s: TSV:%s JSON:%s", h.Read, fields[3], h.TStrand)
+		}
+		if fields[3] != "+" && fields[3] != "-" {
+			t.Errorf("unexpected strand representation for %s: %s", h.Read, fields[3])
+		}
+	}
+	if dec.More() {
+		t.Errorf("unexpected extra JSON hits")
+	}
+
+	// The results table keeps the integer strand.
+	c := core["trans"]
+	if got := strings.Split(c.String(), "\t")[3]; got != "1" {
+		t.Errorf("unexpected core strand for trans: got:%s want:1", got)
+	}
+	l := left["trans"]
+	if got := strings.Split(l.String(), "\t")[3]; got != "-1" {
+		t.Errorf("unexpected left strand for trans: got:%s want:-1", got)
+	}
+}
+
+func TestWriteBEDPEMinus(t *testing.T) {
+	core := hitSet(t, "core.m4")
+	left := hitSet(t, "left.m4")
+	var buf bytes.Buffer
+	err := writeBEDPE(&buf, "trans", "left", core["trans"], left["trans"])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The trans left flank maps to the minus strand of chr2
+	// at [44700,45000) of the reverse complement of the 50000
+	// base contig, so [5000,5300) of the plus strand.
+	want := "chr1\t30000\t31000\tchr2\t5000\t5300\ttrans\t-1500\t+\t-\tleft\n"
+	if buf.String() != want {
+		t.Errorf("unexpected BEDPE record:\ngot: %q\nwant:%q", &buf, want)
+	}
+}
+
+func TestMinFlankSimilarity(t *testing.T) {
+	for _, test := range []struct {
+		min, sim float64
+		want     bool
+	}{
+		{min: 0, sim: 70, want: true},
+		{min: 80, sim: 70, want: false},
+		{min: 80, sim: 80, want: true},
+		{min: 80, sim: 90, want: true},
+	} {
+		got := Filter{MinFlankSimilarity: test.min}.discordant(&Hit{Similarity: test.sim})
+		if got != test.want {
+			t.Errorf("unexpected discordance for similarity %v with minimum %v: got:%t want:%t",
+				test.sim, test.min, got, test.want)
+		}
+	}
+
+	// A low similarity trans left flank gives no
+	// flank feature or BEDPE pair, and other reads
+	// are unaffected.
+	core := hitSet(t, "core.m4")
+	left := hitSet(t, "left.m4")
+	right := hitSet(t, "right.m4")
+	left["trans"].Similarity = 70
+	for _, test := range []struct {
+		min       float64
+		wantTrans bool
+	}{
+		{min: 0, wantTrans: true},
+		{min: 80, wantTrans: false},
+	} {
+		var out, discords, pairs bytes.Buffer
+		filt := Filter{Length: 100, Flank: 100, MinFlankSimilarity: test.min}
+		err := WriteResults(&out, &discords, &pairs, core, left, right, false, filt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		const transFlank = "chr2\tloopy\tflank\t5001\t5300\t"
+		if got := strings.Contains(discords.String(), transFlank); got != test.wantTrans {
+			t.Errorf("unexpected trans flank feature with minimum similarity %v: got:%t want:%t\n%s",
+				test.min, got, test.wantTrans, &discords)
+		}
+		if got := strings.Contains(pairs.String(), "\ttrans\t"); got != test.wantTrans {
+			t.Errorf("unexpected trans BEDPE pair with minimum similarity %v: got:%t want:%t\n%s",
+				test.min, got, test.wantTrans, &pairs)
+		}
+		if !strings.Contains(discords.String(), "Query ins ") {
+			t.Errorf("missing ins features with minimum similarity %v:\n%s", test.min, &discords)
+		}
+		// The results table is not filtered by similarity.
+		if !strings.Contains(out.String(), "\ntrans\t") {
+			t.Errorf("missing trans result with minimum similarity %v:\n%s", test.min, &out)
+		}
+	}
+}
+
+func TestWriteResultsExclude(t *testing.T) {
+	core := hitSet(t, "core.m4")
+	left := hitSet(t, "left.m4")
+	right := hitSet(t, "right.m4")
+	for _, test := range []struct {
+		exclude string
+		absent  []string
+		present []string
+	}{
+		// Flank hits on excluded contigs are dropped,
+		// so trans has no flanks and no result.
+		{
+			exclude: "^chr2$",
+			absent:  []string{"chr2", "\ntrans\t"},
+			present: []string{"\nins\t", "chr4\tloopy\tflank"},
+		},
+		// Core hits on excluded contigs are dropped,
+		// so mate has no result.
+		{
+			exclude: "^chr3$",
+			absent:  []string{"chr3", "\nmate\t", "chr4", "chr5"},
+			present: []string{"chr2\tloopy\tflank"},
+		},
+	} {
+		var out, discords, pairs bytes.Buffer
+		filt := Filter{Length: 100, Flank: 100, Exclude: regexp.MustCompile(test.exclude)}
+		err := WriteResults(&out, &discords, &pairs, core, left, right, false, filt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		all := out.String() + discords.String() + pairs.String()
+		for _, s := range test.absent {
+			if strings.Contains(all, s) {
+				t.Errorf("unexpected %q in output excluding %q:\n%s", s, test.exclude, all)
+			}
+		}
+		for _, s := range test.present {
+			if !strings.Contains(all, s) {
+				t.Errorf("missing %q in output excluding %q:\n%s", s, test.exclude, all)
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+ins chr1 -5000 90.0 0 500 1500 2000 0 10000 11000 100000 254 0 0 0 0
+del chr1 -5000 90.0 0 0 1000 1600 0 20000 21000 100000 254 0 0 0 0
+trans chr1 -5000 90.0 0 300 1300 1300 0 30000 31000 100000 254 0 0 0 0
+inv chr1 -5000 90.0 0 0 1000 1500 0 40000 41000 100000 254 0 0 0 0
+mate chr3 -5000 90.0 0 400 1400 1800 0 1000 2000 20000 254 0 0 0 0
+short chr1 -250 90.0 0 0 50 600 0 50000 50050 100000 254 0 0 0 0
+rdel chr1 -5000 90.0 0 0 1000 1600 1 39000 40000 100000 254 0 0 0 0
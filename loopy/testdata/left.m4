@@ -0,0 +1,4 @@
+ins chr1 -1000 90.0 0 0 200 500 0 9700 9900 100000 254 0 0 0 0
+trans chr2 -1500 90.0 0 0 300 300 1 44700 45000 50000 254 0 0 0 0
+mate chr4 -2000 90.0 0 0 400 400 0 100 500 30000 254 0 0 0 0
+short chr2 -2000 90.0 0 0 400 400 0 100 500 50000 254 0 0 0 0
@@ -0,0 +1,4 @@
+del chr1 -2000 90.0 0 100 500 600 0 22000 22400 100000 254 0 0 0 0
+inv chr1 -2500 90.0 0 0 500 500 1 58500 59000 100000 254 0 0 0 0
+mate chr5 -2000 90.0 0 0 400 400 0 100 500 40000 254 0 0 0 0
+rdel chr1 -2000 90.0 0 100 500 600 1 41000 41400 100000 254 0 0 0 0
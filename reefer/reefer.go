@@ -0,0 +1,1226 @@
+// Copyright ©2015 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reefer provides analysis of internal mismatches in long read
+// alignments to identify candidate structural variation features.
+//
+// Features are written with 1-based fully closed GFF coordinates on the
+// reference, and the Read attribute holds the read name and the 1-based
+// fully closed range of the feature on the read as sequenced, including
+// any clipped bases. GFF cannot represent an empty interval, so by default
+// a feature that is empty on the reference is written as the base that
+// follows it. If Config.PointSites is true, empty intervals on either the
+// reference or the read are instead written as GFF3 zero length sites,
+// with start equal to end and the site to the right of the given base.
+package reefer
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/feat"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+	"github.com/biogo/hts/sam"
+
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+// RecordReader is a source of *sam.Records. Both *sam.Reader and
+// *bam.Reader satisfy RecordReader.
+type RecordReader interface {
+	Read() (*sam.Record, error)
+}
+
+// Exclude returns a RecordReader that skips records read from r that
+// are aligned to reference contigs with names matching exclude.
+func Exclude(r RecordReader, exclude *regexp.Regexp) RecordReader {
+	return excludeReader{r: r, exclude: exclude}
+}
+
+// excludeReader is a RecordReader that skips records aligned to
+// reference contigs with names matching exclude.
+type excludeReader struct {
+	r       RecordReader
+	exclude *regexp.Regexp
+}
+
+func (r excludeReader) Read() (*sam.Record, error) {
+	for {
+		rec, err := r.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		if rec.Ref == nil || !r.exclude.MatchString(rec.Ref.Name()) {
+			return rec, nil
+		}
+	}
+}
+
+// Skip returns a RecordReader that skips records read from r with
+// names in skip.
+func Skip(r RecordReader, skip map[string]bool) RecordReader {
+	return skipReader{r: r, skip: skip}
+}
+
+// skipReader is a RecordReader that skips records with names in skip.
+type skipReader struct {
+	r    RecordReader
+	skip map[string]bool
+}
+
+func (r skipReader) Read() (*sam.Record, error) {
+	for {
+		rec, err := r.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		if !r.skip[rec.Name] {
+			return rec, nil
+		}
+	}
+}
+
+// Config holds the parameters for Discordances.
+type Config struct {
+	// Window is the window over which the CIGAR
+	// cost of each record is smoothed.
+	Window int
+	// Shape is the weighting of the smoothing window.
+	Shape Shape
+	// MinSize is the minimum length of reported features.
+	MinSize int
+	// MinRefSize and MinQuerySize are the minimum
+	// lengths of reported features on the reference
+	// and query. A feature is reported if it meets
+	// either minimum. If zero, MinSize is used.
+	MinRefSize, MinQuerySize int
+
+	// Refiner refines feature breakpoints using
+	// paired Smith-Waterman alignments if not nil.
+	Refiner *Refiner
+	// Trace receives the smoothed cost trace of
+	// reads if not nil.
+	Trace *Tracer
+
+	// Verbose specifies that failed breakpoint
+	// refinements are logged.
+	Verbose bool
+
+	// PointSites specifies that empty intervals
+	// are written as GFF3 zero length sites.
+	// Otherwise they are written as the base
+	// to the right of the site. This applies
+	// to both the reference interval and the
+	// read interval of the Read attribute.
+	PointSites bool
+
+	// Tail specifies that the final window positions
+	// of each record are smoothed over the partial
+	// window that remains so that features near the
+	// end of the record can be detected. Otherwise
+	// features in the final window are not detected.
+	Tail bool
+
+	// ShortWarn is the fraction of records no
+	// longer than Window, and so not analysed,
+	// above which a warning is logged. If zero
+	// no warning is logged.
+	ShortWarn float64
+
+	// MergeGap is the reference distance below
+	// which consecutive candidate features of a
+	// record are merged before size filtering,
+	// refinement and output. If zero candidates
+	// are not merged.
+	MergeGap int
+
+	// MaxEvents is the maximum number of features
+	// reported for a record. Records with more
+	// features are logged and none of their
+	// features are written. If zero there is
+	// no limit.
+	MaxEvents int
+
+	// MinClip is the minimum length of a soft clip
+	// at either end of a record for the clip to be
+	// reported as a candidate breakpoint feature.
+	// Clips are only reported for records that are
+	// analysed, so records no longer than Window
+	// and records dropped by MaxEvents give no clip
+	// features. If zero clips are not reported.
+	MinClip int
+
+	// StrictCigar specifies that a record with
+	// CIGAR alignment match (M) operations is an
+	// error. Only sequence match (=) and mismatch
+	// (X) operations are costed, so such records
+	// cannot give features. Otherwise the records
+	// are counted and a warning is logged.
+	StrictCigar bool
+
+	// Dedup is the number of recently written
+	// features remembered in order to suppress
+	// exact duplicates with the same reference,
+	// reference interval and read name, as are
+	// produced by duplicated input reads. If zero
+	// no duplicates are suppressed.
+	Dedup int
+
+	// Programs holds the @PG lines of the alignment
+	// header. They are written in order as comments
+	// to record the alignment provenance.
+	Programs []*sam.Program
+
+	// NoHeader specifies that the GFF version line
+	// and the leading comments are not written, so
+	// that features can be appended to existing
+	// output.
+	NoHeader bool
+}
+
+// Shape is the shape of a smoothing window.
+type Shape int
+
+const (
+	// Flat weights all positions in the
+	// window equally.
+	Flat Shape = iota
+	// Triangular weights positions linearly
+	// decreasing from the window centre.
+	Triangular
+	// Gaussian weights positions by a Gaussian
+	// centred on the window centre with a
+	// standard deviation of a sixth of the
+	// window width.
+	Gaussian
+)
+
+var shapeNames = [...]string{
+	Flat:       "flat",
+	Triangular: "triangular",
+	Gaussian:   "gaussian",
+}
+
+// ParseShape returns the Shape with the given name, one of flat,
+// triangular or gaussian.
+func ParseShape(name string) (Shape, error) {
+	for s, n := range shapeNames {
+		if n == name {
+			return Shape(s), nil
+		}
+	}
+	return 0, fmt.Errorf("reefer: unknown window shape %q", name)
+}
+
+// String returns the name of the shape.
+func (s Shape) String() string {
+	if s < 0 || int(s) >= len(shapeNames) {
+		return fmt.Sprintf("Shape(%d)", int(s))
+	}
+	return shapeNames[s]
+}
+
+// weights returns the normalised weights for a window of width n with
+// the receiver's shape. It returns nil for a Flat window.
+func (s Shape) weights(n int) ([]float64, error) {
+	if s == Flat {
+		return nil, nil
+	}
+	w := make([]float64, n)
+	centre := float64(n-1) / 2
+	sigma := float64(n) / 6
+	for i := range w {
+		switch s {
+		case Triangular:
+			w[i] = centre + 1 - math.Abs(float64(i)-centre)
+		case Gaussian:
+			d := (float64(i) - centre) / sigma
+			w[i] = math.Exp(-d * d / 2)
+		default:
+			return nil, fmt.Errorf("reefer: invalid window shape: %v", s)
+		}
+	}
+	return normalise(w), nil
+}
+
+// normalise returns a copy of w scaled to sum to one.
+func normalise(w []float64) []float64 {
+	var sum float64
+	for _, v := range w {
+		sum += v
+	}
+	n := make([]float64, len(w))
+	for i, v := range w {
+		n[i] = v / sum
+	}
+	return n
+}
+
+// Discordances analyses the *sam.Records read from sr for regions of
+// internal mismatch, writing GFF features of at least cfg.MinRefSize length
+// on the reference or cfg.MinQuerySize length on the query
+// to out. The CIGAR cost of each record is smoothed over cfg.Window
+// with weights given by cfg.Shape; an invalid Shape is an error.
+// The analysis parameters and any cfg.Programs are written as leading
+// comments unless cfg.NoHeader is set.
+func Discordances(out io.Writer, sr RecordReader, cfg Config) error {
+	window, min := cfg.Window, cfg.MinSize
+	minRef, minQuery := min, min
+	if cfg.MinRefSize != 0 {
+		minRef = cfg.MinRefSize
+	}
+	if cfg.MinQuerySize != 0 {
+		minQuery = cfg.MinQuerySize
+	}
+	weights, err := cfg.Shape.weights(window)
+	if err != nil {
+		return err
+	}
+	w := gff.NewWriter(out, 60, !cfg.NoHeader)
+	cost := [...]float64{
+		sam.CigarInsertion: -2,
+		sam.CigarDeletion:  -2,
+		sam.CigarEqual:     1,
+		sam.CigarMismatch:  -1,
+
+		// Included for explicitness
+		sam.CigarSoftClipped: 0,
+
+		// Included to ensure no bounds panic.
+		// All CIGAR operations not listed above
+		// are given a zero cost.
+		sam.CigarBack: 0,
+	}
+
+	if !cfg.NoHeader {
+		_, err = w.WriteComment(fmt.Sprintf("smoothing window=%d", window))
+		if err != nil {
+			return err
+		}
+		if minRef == minQuery {
+			_, err = w.WriteComment(fmt.Sprintf("minimum feature length=%d", minRef))
+		} else {
+			_, err = w.WriteComment(fmt.Sprintf("minimum feature length=%d (reference) %d (query)", minRef, minQuery))
+		}
+		if err != nil {
+			return err
+		}
+		for _, p := range cfg.Programs {
+			_, err = w.WriteComment(p.String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	var seen *recent
+	if cfg.Dedup > 0 {
+		seen = newRecent(cfg.Dedup)
+	}
+	var suppressed, records, short, ambiguous, tooLarge int
+	gf := &gff.Feature{
+		Source:         "reefer",
+		Feature:        "discordance",
+		FeatFrame:      gff.NoFrame,
+		FeatAttributes: gff.Attributes{{Tag: "Read"}, {Tag: "Dup"}},
+	}
+	cf := &gff.Feature{
+		Source:         "reefer",
+		Feature:        "clip",
+		FeatFrame:      gff.NoFrame,
+		FeatAttributes: gff.Attributes{{Tag: "Read"}, {Tag: "Clip"}},
+	}
+	for {
+		r, err := sr.Read()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		var (
+			scores []costPos
+			ref    = r.Start()
+			query  int
+		)
+		for _, co := range r.Cigar {
+			for i := 0; i < co.Len(); i++ {
+				scores = append(scores, costPos{
+					ref:   ref,
+					query: query,
+					cost:  cost[co.Type()],
+					op:    co.Type(),
+				})
+				consume := co.Type().Consumes()
+				ref += consume.Reference
+				query += consume.Query
+			}
+		}
+		records++
+		if hasAlignMatch(r) {
+			if cfg.StrictCigar {
+				return fmt.Errorf("reefer: record %s has CIGAR alignment match operations: %v", r.Name, r.Cigar)
+			}
+			if ambiguous == 0 {
+				log.Printf("warning: record %s has CIGAR alignment match (M) operations which are not costed: align with =/X operations", r.Name)
+			}
+			ambiguous++
+		}
+		if len(scores) <= window {
+			short++
+			continue
+		}
+		smoothed := smooth(scores, window, weights, cfg.Tail)
+		err = cfg.Trace.trace(r, smoothed)
+		if err != nil {
+			return err
+		}
+
+		// Collect the features of the record so that
+		// noisy records can be dropped before any
+		// refinement is done.
+		var (
+			d          deletion
+			candidates []deletion
+		)
+		for i, v := range smoothed[1:] {
+			switch {
+			case d.record == nil && v.cost < 0 && smoothed[i].cost >= 0:
+				d = deletion{record: r, rstart: v.ref + 1, qstart: v.query + 1}
+			case d.record != nil && v.cost >= 0 && smoothed[i].cost < 0:
+				d.rend = v.ref
+				d.qend = v.query
+				candidates = append(candidates, d)
+				d.record = nil
+			}
+		}
+		if cfg.MergeGap > 0 {
+			candidates = merge(candidates, cfg.MergeGap)
+		}
+		var found []deletion
+		for _, d := range candidates {
+			if d.rend-d.rstart >= minRef || d.qend-d.qstart >= minQuery {
+				found = append(found, d)
+			}
+		}
+		if cfg.MaxEvents > 0 && len(found) > cfg.MaxEvents {
+			log.Printf("skipping %s: %d features exceeds limit of %d", r.Name, len(found), cfg.MaxEvents)
+			continue
+		}
+
+		for _, d := range found {
+			gf.SeqName = d.record.Ref.Name()
+			gf.FeatStrand = strandFor(d.record)
+
+			// Check whether a mismatch dominated region
+			// is an inversion before the read coordinates
+			// are put into the orientation of the read.
+			gf.Feature = "discordance"
+			var inverted bool
+			if cfg.Refiner != nil && cfg.Refiner.Inversions && mismatched(scores, d) {
+				d, inverted, err = cfg.Refiner.inversion(d)
+				if err == errTooLarge {
+					tooLarge++
+				} else if err != nil && cfg.Verbose {
+					log.Printf("failed inversion alignment %s: %v", d.record.Name, err)
+				}
+				if inverted {
+					gf.Feature = "inversion"
+				}
+			}
+
+			// Adjust ends based on paired SW alignments.
+			// The record's sequence is in the orientation
+			// of the reference, so this must also be done
+			// before the read coordinates are flipped.
+			var refined bool
+			if !inverted {
+				d, refined, err = cfg.Refiner.adjust(d)
+				if err == errTooLarge {
+					tooLarge++
+				} else if err != nil && cfg.Verbose {
+					log.Printf("failed alignment %s: %v", d.record.Name, err)
+				}
+			}
+
+			// Soft clipped bases are included in the
+			// record's sequence and in the CIGAR walk
+			// above, so Seq.Length is the length of
+			// the read less any hard clipping, which
+			// is accounted for when writing the
+			// feature.
+			if gf.FeatStrand == seq.Minus {
+				n := d.record.Seq.Length
+				d.qstart, d.qend = n-d.qend, n-d.qstart
+			}
+
+			gf.FeatStart, gf.FeatEnd = closed(d.rstart, d.rend, cfg.PointSites)
+
+			if refined {
+				gf.FeatAttributes = gf.FeatAttributes[:2]
+				gf.FeatAttributes[1].Value = strconv.Itoa(d.dup)
+			} else {
+				gf.FeatAttributes = gf.FeatAttributes[:1]
+			}
+			// The read interval is written with the
+			// same convention as the reference interval.
+			off := hardClipOffset(d.record)
+			qstart, qend := closed(d.qstart+off, d.qend+off, cfg.PointSites)
+			gf.FeatAttributes[0].Value = fmt.Sprintf("%s %d %d", d.record.Name, feat.ZeroToOne(qstart), qend)
+			if seen != nil && seen.has(featKey{ref: gf.SeqName, start: gf.FeatStart, end: gf.FeatEnd, read: d.record.Name}) {
+				suppressed++
+				continue
+			}
+			_, err = w.Write(gf)
+			if err != nil {
+				return err
+			}
+			if d.junctions != nil {
+				err = d.junctions.dump(cfg.Refiner.Dump, d, gf)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Clips are reported only for records that
+		// reach this point, so short and noisy
+		// records give no clip features.
+		if cfg.MinClip <= 0 {
+			continue
+		}
+		for _, c := range clips(r, cfg.MinClip) {
+			cf.SeqName = r.Ref.Name()
+			cf.FeatStrand = strandFor(r)
+			cf.FeatStart, cf.FeatEnd = closed(c.ref, c.ref, cfg.PointSites)
+			if cf.FeatStrand == seq.Minus {
+				n := r.Seq.Length
+				c.qstart, c.qend = n-c.qend, n-c.qstart
+			}
+			off := hardClipOffset(r)
+			cf.FeatAttributes[0].Value = fmt.Sprintf("%s %d %d", r.Name, feat.ZeroToOne(c.qstart+off), c.qend+off)
+			cf.FeatAttributes[1].Value = fmt.Sprintf("%s %d", c.side, c.qend-c.qstart)
+			if seen != nil && seen.has(featKey{ref: cf.SeqName, start: cf.FeatStart, end: cf.FeatEnd, read: r.Name}) {
+				suppressed++
+				continue
+			}
+			_, err = w.Write(cf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if seen != nil {
+		log.Printf("suppressed %d duplicate features", suppressed)
+	}
+	if tooLarge != 0 {
+		log.Printf("skipped %d alignments exceeding the alignment size limit of %d", tooLarge, cfg.Refiner.MaxAlign)
+	}
+	if ambiguous != 0 {
+		log.Printf("warning: %d of %d records have CIGAR alignment match (M) operations: mismatch dominated features in these records are not detected", ambiguous, records)
+	}
+	if cfg.ShortWarn > 0 && records > 0 && float64(short)/float64(records) > cfg.ShortWarn {
+		log.Printf("warning: %d of %d records (%.1f%%) are no longer than the smoothing window of %d and were not analysed: consider a smaller window",
+			short, records, 100*float64(short)/float64(records), window)
+	}
+	return nil
+}
+
+// clip is a soft clipped end of a record.
+type clip struct {
+	// side is the end of the alignment on the
+	// reference that is clipped, left or right.
+	side string
+	// ref is the reference position of the
+	// breakpoint at the clipped end.
+	ref int
+	// qstart and qend are the query interval of
+	// the clipped bases in the orientation of the
+	// alignment, excluding any hard clipping.
+	qstart, qend int
+}
+
+// clips returns the soft clipped ends of r that are at least min long.
+// A soft clip may be preceded by a hard clip at either end of the CIGAR.
+func clips(r *sam.Record, min int) []clip {
+	var c []clip
+	ops := r.Cigar
+	if len(ops) != 0 && ops[0].Type() == sam.CigarHardClipped {
+		ops = ops[1:]
+	}
+	if len(ops) != 0 && ops[len(ops)-1].Type() == sam.CigarHardClipped {
+		ops = ops[:len(ops)-1]
+	}
+	if len(ops) < 2 {
+		return nil
+	}
+	if co := ops[0]; co.Type() == sam.CigarSoftClipped && co.Len() >= min {
+		c = append(c, clip{side: "left", ref: r.Start(), qstart: 0, qend: co.Len()})
+	}
+	if co := ops[len(ops)-1]; co.Type() == sam.CigarSoftClipped && co.Len() >= min {
+		c = append(c, clip{side: "right", ref: r.End(), qstart: r.Seq.Length - co.Len(), qend: r.Seq.Length})
+	}
+	return c
+}
+
+// hasAlignMatch returns whether the CIGAR of r includes alignment match
+// operations, which do not distinguish sequence matches from mismatches.
+func hasAlignMatch(r *sam.Record) bool {
+	for _, co := range r.Cigar {
+		if co.Type() == sam.CigarMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// featKey identifies a written feature for duplicate suppression.
+type featKey struct {
+	ref        string
+	start, end int
+	read       string
+}
+
+// recent is a bounded least recently used set of feature keys.
+type recent struct {
+	keys  map[featKey]*list.Element
+	order *list.List
+	max   int
+}
+
+func newRecent(max int) *recent {
+	return &recent{keys: make(map[featKey]*list.Element), order: list.New(), max: max}
+}
+
+// has returns whether k has been seen among the most recently seen keys,
+// adding k as the most recently seen key. If the set is full, the least
+// recently seen key is dropped.
+func (r *recent) has(k featKey) bool {
+	if e, ok := r.keys[k]; ok {
+		r.order.MoveToFront(e)
+		return true
+	}
+	r.keys[k] = r.order.PushFront(k)
+	if r.order.Len() > r.max {
+		e := r.order.Back()
+		delete(r.keys, r.order.Remove(e).(featKey))
+	}
+	return false
+}
+
+// merge merges consecutive candidate features in c that are separated
+// on the reference by less than gap, returning the merged features.
+// The candidates in c must be in read order. merge reuses the backing
+// array of c.
+func merge(c []deletion, gap int) []deletion {
+	if len(c) < 2 {
+		return c
+	}
+	m := c[:1]
+	for _, d := range c[1:] {
+		last := &m[len(m)-1]
+		if d.rstart-last.rend >= gap {
+			m = append(m, d)
+			continue
+		}
+		if d.rend > last.rend {
+			last.rend = d.rend
+		}
+		if d.qend > last.qend {
+			last.qend = d.qend
+		}
+	}
+	return m
+}
+
+// closed returns the zero-based half-open interval that is written as
+// the GFF fully closed representation of [start, end). Non-empty intervals
+// are returned unchanged. An empty interval is returned as a GFF3 zero
+// length site, the base to the left of the site, if sites is true and
+// start is not zero, and otherwise as the base to the right of the site.
+func closed(start, end int, sites bool) (int, int) {
+	switch {
+	case start != end:
+		return start, end
+	case sites && start > 0:
+		return start - 1, end
+	default:
+		return start, end + 1
+	}
+}
+
+// Tracer writes smoothed cost traces for a sample of reads.
+type Tracer struct {
+	w     io.Writer
+	every int
+	n     int
+}
+
+// NewTracer returns a Tracer that writes a tab separated trace of
+// every nth read to w.
+func NewTracer(w io.Writer, every int) (*Tracer, error) {
+	if every < 1 {
+		every = 1
+	}
+	_, err := fmt.Fprintln(w, "read\tref\tquery\tcost")
+	if err != nil {
+		return nil, err
+	}
+	return &Tracer{w: w, every: every}, nil
+}
+
+// trace writes the smoothed cost trace for r if it is in the sample.
+// The ref and query positions are the zero-based centres of the
+// smoothing window. trace is a no-op for a nil receiver.
+func (t *Tracer) trace(r *sam.Record, smoothed []costPos) error {
+	if t == nil {
+		return nil
+	}
+	t.n++
+	if (t.n-1)%t.every != 0 {
+		return nil
+	}
+	for _, v := range smoothed {
+		_, err := fmt.Fprintf(t.w, "%s\t%d\t%d\t%f\n", r.Name, v.ref, v.query, v.cost)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deletion struct {
+	record *sam.Record
+
+	rstart, rend, dup int
+	qstart, qend      int
+
+	// junctions holds the refinement alignments
+	// of the deletion if they are to be dumped.
+	junctions *junctions
+}
+
+// junctions holds the paired junction alignments used to refine
+// a deletion and the offsets of the aligned windows. The read
+// breakpoints qstart and qend are in the orientation of the
+// reference, as are the read windows.
+type junctions struct {
+	ref, left, right          *linear.Seq
+	rOff, qOffLeft, qOffRight int
+	qstart, qend              int
+	alnl, alnr                []feat.Pair
+}
+
+// dump writes the refined feature f and the alignments that
+// produced it to w. The alignment spans are shown in window
+// coordinates with the offset of each window so that they can
+// be related to the coordinates of the written feature.
+func (j *junctions) dump(w io.Writer, d deletion, f *gff.Feature) error {
+	_, err := fmt.Fprintf(w, ">%s\t%s:%d-%d\tread=%s\trstart=%d\trend=%d\tdup=%d\tqstart=%d\tqend=%d\n",
+		d.record.Name, f.SeqName, feat.ZeroToOne(f.FeatStart), f.FeatEnd, f.FeatAttributes.Get("Read"),
+		d.rstart, d.rend, d.dup, j.qstart, j.qend)
+	if err != nil {
+		return err
+	}
+	for _, side := range []struct {
+		name  string
+		query *linear.Seq
+		qOff  int
+		aln   []feat.Pair
+	}{
+		{name: "left", query: j.left, qOff: j.qOffLeft, aln: j.alnl},
+		{name: "right", query: j.right, qOff: j.qOffRight, aln: j.alnr},
+	} {
+		fa := align.Format(j.ref, side.query, side.aln, '-')
+		_, err = fmt.Fprintf(w, "%s\trOff=%d\tqOff=%d\t%v\n%v\n%v\n",
+			side.name, j.rOff, side.qOff, side.aln, fa[0], fa[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type costPos struct {
+	ref, query int
+	cost       float64
+	op         sam.CigarOpType
+}
+
+// smooth returns the means of scores over windows of the given width,
+// weighted by weights if it is not nil. If tail is true, the final
+// positions are smoothed over the partial window that remains, using
+// the leading weights renormalised to sum to one.
+func smooth(scores []costPos, window int, weights []float64, tail bool) []costPos {
+	n := len(scores) - window
+	if tail {
+		n = len(scores)
+	}
+	smoothed := make([]costPos, n)
+	for i := range smoothed {
+		end := i + window
+		if end > len(scores) {
+			end = len(scores)
+		}
+		c := scores[i:end]
+		switch {
+		case weights == nil:
+			smoothed[i] = mean(c)
+		case len(c) < window:
+			smoothed[i] = weightedMean(c, normalise(weights[:len(c)]))
+		default:
+			smoothed[i] = weightedMean(c, weights)
+		}
+	}
+	return smoothed
+}
+
+func mean(c []costPos) costPos {
+	var mean costPos
+	for _, v := range c {
+		mean.cost += v.cost
+		mean.ref += v.ref
+		mean.query += v.query
+	}
+	scale := float64(len(c))
+	mean.cost /= scale
+	mean.ref = int(float64(mean.ref)/scale + 0.5)
+	mean.query = int(float64(mean.query)/scale + 0.5)
+	return mean
+}
+
+// weightedMean returns the mean of c weighted by the normalised
+// weights in w.
+func weightedMean(c []costPos, w []float64) costPos {
+	var (
+		mean       costPos
+		ref, query float64
+	)
+	for i, v := range c {
+		mean.cost += w[i] * v.cost
+		ref += w[i] * float64(v.ref)
+		query += w[i] * float64(v.query)
+	}
+	mean.ref = int(ref + 0.5)
+	mean.query = int(query + 0.5)
+	return mean
+}
+
+// hardClipOffset returns the number of hard clipped bases that precede
+// the record's sequence in the orientation of the original read.
+func hardClipOffset(r *sam.Record) int {
+	if len(r.Cigar) == 0 {
+		return 0
+	}
+	co := r.Cigar[0]
+	if r.Flags&sam.Reverse != 0 {
+		co = r.Cigar[len(r.Cigar)-1]
+	}
+	if co.Type() != sam.CigarHardClipped {
+		return 0
+	}
+	return co.Len()
+}
+
+func strandFor(r *sam.Record) seq.Strand {
+	if r.Flags&sam.Reverse != 0 {
+		return seq.Minus
+	}
+	return seq.Plus
+}
+
+// Reference is a source of reference sequences keyed by name.
+type Reference interface {
+	// Has returns whether the named
+	// sequence is available.
+	Has(name string) bool
+	// Contig returns the named sequence,
+	// or nil if it is not available.
+	Contig(name string) (*linear.Seq, error)
+}
+
+// Contigs is a Reference held in memory.
+type Contigs map[string]*linear.Seq
+
+// Has returns whether the named sequence is in c.
+func (c Contigs) Has(name string) bool {
+	_, ok := c[name]
+	return ok
+}
+
+// Contig returns the named sequence in c.
+func (c Contigs) Contig(name string) (*linear.Seq, error) {
+	return c[name], nil
+}
+
+// errTooLarge is returned by Refiner methods when an alignment
+// would exceed the MaxAlign limit.
+var errTooLarge = errors.New("reefer: alignment too large")
+
+// Refiner refines feature breakpoints using a pair of Smith-Waterman
+// alignments of the read to the reference around each feature.
+type Refiner struct {
+	// RefWindow is the width of the reference window
+	// around the middle of the reference indel.
+	RefWindow int
+	// QueryWindow is the width of the read window
+	// beyond the ends of the read indel.
+	QueryWindow int
+	// MinQueryGap is the minimum distance between
+	// read breakpoints.
+	MinQueryGap int
+	// MinRefFlank is the minimum distance of aligned
+	// breakpoints from the ends of the reference window.
+	MinRefFlank int
+
+	// Fractional specifies that MinRefFlankFrac and
+	// MinQueryGapFrac are used in place of MinRefFlank
+	// and MinQueryGap. The fractional thresholds are
+	// scaled by the length of the reference window and
+	// of each read window respectively.
+	Fractional      bool
+	MinRefFlankFrac float64
+	MinQueryGapFrac float64
+
+	// MaxAlign is the maximum size of an alignment
+	// matrix to attempt. If zero there is no limit.
+	// Features whose alignments would exceed the
+	// limit are written unrefined, and the number
+	// of skipped alignments is logged.
+	MaxAlign int
+
+	// Inversions specifies that mismatch dominated
+	// features are tested for inversion by aligning
+	// the reverse complement of the read segment to
+	// the reference. A feature is reported as an
+	// inversion if the reverse complement alignment
+	// scores better than the forward alignment and
+	// covers at least MinInversionCover of the read
+	// segment.
+	Inversions        bool
+	MinInversionCover float64
+
+	// Dump receives the left and right junction
+	// alignments of each refined feature if not nil.
+	Dump io.Writer
+
+	// Ref provides the reference sequences.
+	Ref Reference
+	// Aligner is the Smith-Waterman aligner used
+	// for refinement.
+	Aligner align.Aligner
+}
+
+// adjustDeletion performs a deletion ends refinement based on a
+// pair of Smith-Waterman alignments.
+//
+//                    l      s   e      r
+//  ref:         -----|------+~~~+------|----------
+//
+//  query_left:  ----|-----------+~~~~~~|~~~~~~+---------------
+//                   l           s      m      e
+//  query_right: ----------------+~~~~~~|~~~~~~+-----------|---
+//                               s      m      e           r
+//
+//  where ~~ is the region found by CIGAR score walking above in the
+//  deletions function.
+//
+//  align ref(l..r) with query_left(l..m) -> ref(s)-query_left(s)
+//  align ref(l..r) with query_right(m..r) -> ref(e)-query_left(e)
+//
+// This can give either of two outcomes:
+//  1. ref(s) < ref(e)
+//  2. ref(e) <= ref(s)
+//
+// The first case is a standard colinear alignment:
+//
+//                              s   e
+//  ref:             -----------+---+-----------------
+//                             /     \
+//                            /       \
+//                           /         \
+//                          /           \
+//  query: ----------------+-------------+---------------
+//                         s             e
+//
+//
+// The second case is a non-colinear alignment:
+//
+//                              e   s
+//  ref:             -----------+---+-----------------
+//                               \ /
+//                                /
+//                               / \
+//                              /   \
+//                             /     \
+//                            /       \
+//                           /         \
+//                          /           \
+//  query: ----------------+-------------+---------------
+//                         s             e
+//
+//
+// which has a potential target site duplication interpretation:
+//
+//                              e   s
+//  ref:             -----------+---+-----------------
+//                             / \ / \
+//                            /   /   \
+//                           /   / \   \
+//                          /   /   \   \
+//                         /   /     \   \
+//                        /   /       \   \
+//                       /   /         \   \
+//                      /   /           \   \
+//  query: ------------+---+-------------+---+-----------
+//                         s             e
+//
+// adjustDeletions handles the second case by making ref(s=e) for the
+// reference and adding annotation for the length of the duplication
+// (d) in ref:
+//
+//                             s|e s+d
+//  ref:             -----------+---+-----------------
+//                             / \ / \
+//                            /   /   \
+//                           /   / \   \
+//                          /   /   \   \
+//                         /   /     \   \
+//                        /   /       \   \
+//                       /   /         \   \
+//                      /   /           \   \
+//  query: ------------+---+-------------+---+-----------
+//                    s-d  s             e  e+d
+//
+func (r *Refiner) adjust(d deletion) (refined deletion, ok bool, err error) {
+	if r == nil {
+		return d, false, nil
+	}
+	if d.qend-d.qstart < d.rend-d.rstart {
+		// Do not do any work for deletions.
+		return d, false, fmt.Errorf("not an insertion: len(q)=%d len(r)=%d", d.qend-d.qstart, d.rend-d.rstart)
+	}
+
+	rs, rOff, err := r.refWindow(d)
+	if err != nil {
+		return d, false, err
+	}
+
+	q := alphabet.BytesToLetters(d.record.Seq.Expand())
+
+	// Align the left junction of the qeuery to
+	// the reference around the indel site.
+	qsl := linear.NewSeq(d.record.Name, nil, alphabet.DNAgapped)
+	qOffLeft := max(0, d.qstart-r.QueryWindow)
+	qsl.Seq = q[qOffLeft : (d.qstart+d.qend)/2]
+
+	// Align the right junction of the qeuery to
+	// the reference around the indel site.
+	qsr := linear.NewSeq(d.record.Name, nil, alphabet.DNAgapped)
+	qOffRight := (d.qstart + d.qend) / 2
+	qsr.Seq = q[qOffRight:min(d.qend+r.QueryWindow, len(q))]
+
+	// Skip refinement if either alignment would be too large.
+	if r.MaxAlign > 0 {
+		size := len(rs.Seq) * max(len(qsl.Seq), len(qsr.Seq))
+		if size > r.MaxAlign {
+			log.Printf("skipping refinement of %s: alignment size %d exceeds limit %d",
+				d.record.Name, size, r.MaxAlign)
+			return d, false, errTooLarge
+		}
+	}
+
+	alnl, err := r.Aligner.Align(rs, qsl)
+	if err != nil {
+		return d, false, err
+	}
+
+	alnr, err := r.Aligner.Align(rs, qsr)
+	if err != nil {
+		return d, false, err
+	}
+
+	// Get left and right ends of insertion in read
+	// and the aligned segment of the reference.
+	left := alnl[len(alnl)-1].Features()
+	right := alnr[0].Features()
+
+	minRefFlank := r.MinRefFlank
+	minLeftGap, minRightGap := r.MinQueryGap, r.MinQueryGap
+	if r.Fractional {
+		minRefFlank = int(r.MinRefFlankFrac * float64(len(rs.Seq)))
+		minLeftGap = int(r.MinQueryGapFrac * float64(len(qsl.Seq)))
+		minRightGap = int(r.MinQueryGapFrac * float64(len(qsr.Seq)))
+	}
+
+	// Bail out if the alignment extends too far.
+	// We might have continued alignment.
+	if flank := right[0].Start(); flank < minRefFlank {
+		return d, false, fmt.Errorf("skipping: right ref flank less than %d from left: len(flank)=%v",
+			minRefFlank, flank)
+	}
+	if flank := left[0].End(); len(rs.Seq)-flank < minRefFlank {
+		return d, false, fmt.Errorf("skipping: left ref flank less than %d from right: len(flank)=%v",
+			minRefFlank, len(rs.Seq)-flank)
+	}
+
+	centrel := r.QueryWindow + (d.qend-d.qstart)/2
+	centrer := 0
+
+	// Bail out if the insertion is too short.
+	// We might have continued alignment.
+	if gap := centrel - left[1].End(); gap < minLeftGap {
+		return d, false, fmt.Errorf("skipping left: left query gap less than %d from centre: len(gap)=%v",
+			minLeftGap, gap)
+	}
+	if gap := right[1].Start() - centrer; gap < minRightGap {
+		return d, false, fmt.Errorf("skipping right: right query gap less than %d from centre: len(gap)=%v",
+			minRightGap, gap)
+	}
+
+	d.rstart = rOff + left[0].End()
+	d.rend = rOff + right[0].Start()
+	if d.rend <= d.rstart {
+		d.dup = d.rstart - d.rend
+		d.rstart = d.rend
+	}
+
+	d.qstart = qOffLeft + left[1].End()
+	d.qend = qOffRight + alnr[0].Features()[1].Start()
+
+	if r.Dump != nil {
+		d.junctions = &junctions{
+			ref: rs, left: qsl, right: qsr,
+			rOff: rOff, qOffLeft: qOffLeft, qOffRight: qOffRight,
+			qstart: d.qstart, qend: d.qend,
+			alnl: alnl, alnr: alnr,
+		}
+	}
+
+	return d, true, nil
+}
+
+// refWindow returns the reference sequence around the feature d
+// extended by half of r.RefWindow on each side, and the offset of the
+// window in the reference.
+func (r *Refiner) refWindow(d deletion) (*linear.Seq, int, error) {
+	name := d.record.Ref.Name()
+	ref, err := r.Ref.Contig(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read reference sequence for %q: %v", name, err)
+	}
+	if ref == nil {
+		return nil, 0, fmt.Errorf("no reference sequence for %q", name)
+	}
+	rs := *ref
+	rOff := max(0, d.rstart-r.RefWindow/2)
+	rs.Seq = ref.Seq[rOff:min(d.rend+r.RefWindow/2, len(ref.Seq))]
+	return &rs, rOff, nil
+}
+
+// mismatched returns whether the CIGAR operations within the feature d
+// are dominated by mismatches rather than insertions and deletions.
+func mismatched(scores []costPos, d deletion) bool {
+	var mismatch, indel int
+	for _, c := range scores {
+		if c.ref < d.rstart || d.rend < c.ref || c.query < d.qstart || d.qend < c.query {
+			continue
+		}
+		switch c.op {
+		case sam.CigarMismatch:
+			mismatch++
+		case sam.CigarInsertion, sam.CigarDeletion:
+			indel++
+		}
+	}
+	return mismatch > indel
+}
+
+// inversion tests whether the feature d is an inversion. The read and
+// reference coordinates of d must be in the orientation of the
+// reference. If d is an inversion, its ends are set to the span of the
+// reverse complement alignment of the read to the reference.
+func (r *Refiner) inversion(d deletion) (inverted deletion, ok bool, err error) {
+	if d.qend <= d.qstart {
+		return d, false, nil
+	}
+	rs, rOff, err := r.refWindow(d)
+	if err != nil {
+		return d, false, err
+	}
+	q := alphabet.BytesToLetters(d.record.Seq.Expand())
+	qs := linear.NewSeq(d.record.Name, q[d.qstart:d.qend], alphabet.DNAgapped)
+	if qs.Len() == 0 || rs.Len() == 0 {
+		return d, false, nil
+	}
+	if r.MaxAlign > 0 {
+		size := rs.Len() * qs.Len()
+		if size > r.MaxAlign {
+			log.Printf("skipping inversion test of %s: alignment size %d exceeds limit %d",
+				d.record.Name, size, r.MaxAlign)
+			return d, false, errTooLarge
+		}
+	}
+
+	fwd, err := r.Aligner.Align(rs, qs)
+	if err != nil {
+		return d, false, err
+	}
+	rc := sequtil.RevComp(qs)
+	rev, err := r.Aligner.Align(rs, rc)
+	if err != nil {
+		return d, false, err
+	}
+	if alignScore(rev) <= alignScore(fwd) {
+		return d, false, nil
+	}
+	first := rev[0].Features()
+	last := rev[len(rev)-1].Features()
+	cover := last[1].End() - first[1].Start()
+	if float64(cover) < r.MinInversionCover*float64(qs.Len()) {
+		return d, false, fmt.Errorf("reverse complement alignment covers %d of %d", cover, qs.Len())
+	}
+
+	d.rstart = rOff + first[0].Start()
+	d.rend = rOff + last[0].End()
+	// Positions in the reverse complement are
+	// counted from the end of the read segment.
+	d.qstart, d.qend = d.qstart+qs.Len()-last[1].End(), d.qstart+qs.Len()-first[1].Start()
+	return d, true, nil
+}
+
+// alignScore returns the total score of the alignment aln.
+func alignScore(aln []feat.Pair) int {
+	type scorer interface {
+		Score() int
+	}
+	var sc int
+	for _, seg := range aln {
+		sc += seg.(scorer).Score()
+	}
+	return sc
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
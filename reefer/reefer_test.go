@@ -0,0 +1,1266 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package reefer
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/feat"
+	"github.com/biogo/biogo/io/featio"
+	"github.com/biogo/biogo/io/featio/gff"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+	"github.com/biogo/hts/sam"
+
+	"github.com/kortschak/loopy/internal/sequtil"
+	"github.com/kortschak/loopy/tsd"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// The testdata reads are all aligned to chr1 of ref.fa:
+//
+//  del:   a 300 base deletion on the plus strand.
+//  minus: a 300 base insertion on the minus strand, placed
+//         asymmetrically on the read so that the read
+//         coordinates are flipped into read orientation.
+//  tsd:   a 350 base insertion with a 15 base target site
+//         duplication, which is found by Refiner.adjust.
+
+// contigs returns the sequences in the named fasta file.
+func contigs(t *testing.T, path string) Contigs {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open reference: %v", err)
+	}
+	defer f.Close()
+	ref := make(Contigs)
+	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
+	for sc.Next() {
+		s := sc.Seq().(*linear.Seq)
+		ref[s.Name()] = s
+	}
+	if err := sc.Error(); err != nil {
+		t.Fatalf("failed to read reference: %v", err)
+	}
+	return ref
+}
+
+// refiner returns a Refiner for the testdata reference using the
+// default reefer command window parameters. The alignment scores are
+// stricter than the command default so that the random insertion
+// sequences of the testdata reads do not extend the alignments.
+func refiner(t *testing.T) *Refiner {
+	sw, err := tsd.NewAligner(alphabet.DNAgapped, tsd.Scores{1, -2, -3}, nil)
+	if err != nil {
+		t.Fatalf("failed to make aligner: %v", err)
+	}
+	return &Refiner{
+		RefWindow:   300,
+		QueryWindow: 500,
+		MinQueryGap: 50,
+		MinRefFlank: 10,
+		Ref:         contigs(t, filepath.Join("testdata", "ref.fa")),
+		Aligner:     sw,
+	}
+}
+
+// discordances returns the output of Discordances for the named
+// testdata SAM file with the given configuration.
+func discordances(t *testing.T, name string, cfg Config) []byte {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to open alignments: %v", err)
+	}
+	defer f.Close()
+	sr, err := sam.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read alignment header: %v", err)
+	}
+	cfg.Programs = sr.Header().Progs()
+	var buf bytes.Buffer
+	err = Discordances(&buf, sr, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// golden compares got with the named golden file, updating
+// the file instead if the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, got, 0664)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("unexpected output for %s:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestDiscordancesMinusRefined(t *testing.T) {
+	// The minus read has a 300 base insertion at
+	// [700,1000) in reference orientation, so at
+	// [900,1200) of its 1900 bases in read orientation.
+	// Refinement aligns the read windows of the record
+	// sequence, which is in reference orientation, so
+	// it must be given unflipped read coordinates.
+	const want = "chr1\treefer\tdiscordance\t3701\t3701\t.\t-\t.\tRead minus 901 1200; Dup 0\n"
+	out := discordances(t, "reads.sam", Config{Window: 50, MinSize: 100, Refiner: refiner(t)})
+	if !bytes.Contains(out, []byte(want)) {
+		t.Errorf("missing refined minus strand feature %q in output:\n%s", want, out)
+	}
+}
+
+func TestDiscordancesGolden(t *testing.T) {
+	for _, test := range []struct {
+		golden string
+		refine bool
+	}{
+		{golden: "plain.gff"},
+		{golden: "refined.gff", refine: true},
+	} {
+		cfg := Config{Window: 50, MinSize: 100}
+		if test.refine {
+			cfg.Refiner = refiner(t)
+		}
+		golden(t, test.golden, discordances(t, "reads.sam", cfg))
+	}
+}
+
+func TestDiscordancesMaxAlign(t *testing.T) {
+	plain, err := ioutil.ReadFile(filepath.Join("testdata", "plain.gff"))
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	refined, err := ioutil.ReadFile(filepath.Join("testdata", "refined.gff"))
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+
+	// The del read is a deletion so it is not
+	// refined and does not count against the limit.
+	for _, test := range []struct {
+		limit    int
+		want     []byte
+		wantLogs []string
+	}{
+		{limit: 0, want: refined},
+		{limit: 1 << 30, want: refined},
+		{
+			limit: 1000,
+			want:  plain,
+			wantLogs: []string{
+				"skipping refinement of minus: alignment size ",
+				"skipping refinement of tsd: alignment size ",
+				"skipped 2 alignments exceeding the alignment size limit of 1000\n",
+			},
+		},
+	} {
+		r := refiner(t)
+		r.MaxAlign = test.limit
+		var got []byte
+		logs := logged(func() {
+			got = discordances(t, "reads.sam", Config{Window: 50, MinSize: 100, Refiner: r})
+		})
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("unexpected output with limit %d:\ngot:\n%s\nwant:\n%s", test.limit, got, test.want)
+		}
+		for _, want := range test.wantLogs {
+			if !strings.Contains(logs, want) {
+				t.Errorf("missing log message with limit %d: %q in:\n%s", test.limit, want, logs)
+			}
+		}
+		if test.wantLogs == nil && logs != "" {
+			t.Errorf("unexpected log output with limit %d:\n%s", test.limit, logs)
+		}
+	}
+}
+
+func TestDiscordancesExclude(t *testing.T) {
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "plain.gff"))
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	f, err := os.Open(filepath.Join("testdata", "reads.sam"))
+	if err != nil {
+		t.Fatalf("failed to open alignments: %v", err)
+	}
+	defer f.Close()
+	h, err := sam.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read alignment header: %v", err)
+	}
+	progs := h.Header().Progs()
+
+	// The alt record is a copy of the del record
+	// aligned to an alternate contig.
+	alt, err := sam.NewReference("chr1_alt", "", "", 6000, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make reference: %v", err)
+	}
+	r := *record(t, "del")
+	r.Name = "alt"
+	r.Ref = alt
+
+	for _, test := range []struct {
+		exclude string
+		wantAlt bool
+	}{
+		{exclude: "", wantAlt: true},
+		{exclude: "_alt$", wantAlt: false},
+		{exclude: "^chrUn_|_random$", wantAlt: true},
+	} {
+		recs := append(allRecords(t), &r)
+		var sr RecordReader = &recs
+		if test.exclude != "" {
+			sr = Exclude(sr, regexp.MustCompile(test.exclude))
+		}
+		var buf bytes.Buffer
+		err := Discordances(&buf, sr, Config{Window: 50, MinSize: 100, Programs: progs})
+		if err != nil {
+			t.Fatalf("unexpected error excluding %q: %v", test.exclude, err)
+		}
+		got := bytes.Contains(buf.Bytes(), []byte("chr1_alt\treefer\t"))
+		if got != test.wantAlt {
+			t.Errorf("unexpected alt contig feature excluding %q: got:%t want:%t\n%s", test.exclude, got, test.wantAlt, &buf)
+		}
+		if !test.wantAlt && !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("unexpected output excluding %q:\ngot:\n%s\nwant:\n%s", test.exclude, &buf, want)
+		}
+	}
+}
+
+// record returns the named record in the testdata reads.
+func record(t *testing.T, name string) *sam.Record {
+	f, err := os.Open(filepath.Join("testdata", "reads.sam"))
+	if err != nil {
+		t.Fatalf("failed to open alignments: %v", err)
+	}
+	defer f.Close()
+	sr, err := sam.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read alignment header: %v", err)
+	}
+	for {
+		r, err := sr.Read()
+		if err != nil {
+			t.Fatalf("no record for %s: %v", name, err)
+		}
+		if r.Name == name {
+			return r
+		}
+	}
+}
+
+func TestAdjustFlankMode(t *testing.T) {
+	// The tsd read insertion is at 5200 on chr1, so the
+	// 300 base reference window places the aligned
+	// breakpoints about 150 bases from either end.
+	d := deletion{record: record(t, "tsd"), rstart: 5200, rend: 5200, qstart: 515, qend: 880}
+	for _, test := range []struct {
+		name string
+		set  func(r *Refiner)
+		want bool
+	}{
+		{
+			name: "absolute default",
+			set:  func(r *Refiner) {},
+			want: true,
+		},
+		{
+			name: "absolute wider than window half",
+			set:  func(r *Refiner) { r.MinRefFlank = 200 },
+			want: false,
+		},
+		{
+			name: "fraction less than window half",
+			set: func(r *Refiner) {
+				r.MinRefFlank = 200
+				r.Fractional = true
+				r.MinRefFlankFrac = 0.1
+				r.MinQueryGapFrac = 0.1
+			},
+			want: true,
+		},
+		{
+			name: "fraction more than window half",
+			set: func(r *Refiner) {
+				r.Fractional = true
+				r.MinRefFlankFrac = 0.6
+				r.MinQueryGapFrac = 0.1
+			},
+			want: false,
+		},
+		{
+			name: "query gap fraction",
+			set: func(r *Refiner) {
+				r.Fractional = true
+				r.MinRefFlankFrac = 0.1
+				r.MinQueryGapFrac = 0.9
+			},
+			want: false,
+		},
+	} {
+		r := refiner(t)
+		test.set(r)
+		got, ok, err := r.adjust(d)
+		if ok != test.want {
+			t.Errorf("unexpected refinement for %s: got:%t want:%t (%v)", test.name, ok, test.want, err)
+			continue
+		}
+		if !ok {
+			if err == nil {
+				t.Errorf("expected error for rejected refinement for %s", test.name)
+			}
+			continue
+		}
+		if got.rstart != 5200 || got.rend != 5200 || got.dup != 15 || got.qstart != 515 || got.qend != 865 {
+			t.Errorf("unexpected refinement for %s: got:%d-%d dup=%d query:%d-%d",
+				test.name, got.rstart, got.rend, got.dup, got.qstart, got.qend)
+		}
+	}
+}
+
+// records is a RecordReader over a slice of records.
+type records []*sam.Record
+
+func (r *records) Read() (*sam.Record, error) {
+	if len(*r) == 0 {
+		return nil, io.EOF
+	}
+	rec := (*r)[0]
+	*r = (*r)[1:]
+	return rec, nil
+}
+
+// clipped returns a copy of r with lead and trail bases, in the
+// orientation of the reference, clipped with the CIGAR operation typ.
+func clipped(r *sam.Record, typ sam.CigarOpType, lead, trail int) *sam.Record {
+	c := *r
+	c.Cigar = append(sam.Cigar{sam.NewCigarOp(typ, lead)}, r.Cigar...)
+	c.Cigar = append(c.Cigar, sam.NewCigarOp(typ, trail))
+	if typ == sam.CigarSoftClipped {
+		s := r.Seq.Expand()
+		s = append([]byte(strings.Repeat("a", lead)), s...)
+		s = append(s, strings.Repeat("c", trail)...)
+		c.Seq = sam.NewSeq(s)
+		c.Qual = nil
+	}
+	return &c
+}
+
+func TestDiscordancesClippedMinus(t *testing.T) {
+	r := record(t, "minus")
+	for _, refine := range []bool{false, true} {
+		var want string
+		for _, test := range []struct {
+			name   string
+			record *sam.Record
+			// shift is the expected offset of the read
+			// coordinates from those of the unclipped
+			// record, given by the clip at the end of
+			// the alignment since r is reversed.
+			shift int
+		}{
+			{name: "unclipped", record: r},
+			{name: "soft", record: clipped(r, sam.CigarSoftClipped, 100, 50), shift: 50},
+			{name: "hard", record: clipped(r, sam.CigarHardClipped, 100, 50), shift: 50},
+		} {
+			cfg := Config{Window: 50, MinSize: 100}
+			if refine {
+				cfg.Refiner = refiner(t)
+			}
+			var buf bytes.Buffer
+			err := Discordances(&buf, &records{test.record}, cfg)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", test.name, err)
+			}
+			var feats []*gff.Feature
+			sc := featio.NewScanner(gff.NewReader(&buf))
+			for sc.Next() {
+				feats = append(feats, sc.Feat().(*gff.Feature))
+			}
+			if err := sc.Error(); err != nil {
+				t.Fatalf("unexpected error reading output for %s: %v", test.name, err)
+			}
+			if len(feats) != 1 {
+				t.Fatalf("unexpected number of features for %s: got:%d want:1", test.name, len(feats))
+			}
+			var start, end int
+			_, err = fmt.Sscanf(feats[0].FeatAttributes.Get("Read"), "minus %d %d", &start, &end)
+			if err != nil {
+				t.Fatalf("unexpected Read attribute for %s: %v", test.name, err)
+			}
+			got := fmt.Sprintf("%s:%d-%d %d-%d",
+				feats[0].SeqName, feats[0].FeatStart, feats[0].FeatEnd, start-test.shift, end-test.shift)
+			if want == "" {
+				want = got
+				continue
+			}
+			if got != want {
+				t.Errorf("unexpected feature for %s clipped minus strand record (refine=%t): got:%s want:%s",
+					test.name, refine, got, want)
+			}
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	for _, test := range []struct {
+		start, end int
+		sites      bool
+		wantStart  int
+		wantEnd    int
+	}{
+		// Non-empty intervals are unaltered.
+		{start: 10, end: 20, wantStart: 10, wantEnd: 20},
+		{start: 10, end: 20, sites: true, wantStart: 10, wantEnd: 20},
+		{start: 10, end: 11, wantStart: 10, wantEnd: 11},
+
+		// Empty intervals are the base to the right
+		// of the site, or with sites, the base to the
+		// left unless the site is at the start.
+		{start: 10, end: 10, wantStart: 10, wantEnd: 11},
+		{start: 10, end: 10, sites: true, wantStart: 9, wantEnd: 10},
+		{start: 0, end: 0, wantStart: 0, wantEnd: 1},
+		{start: 0, end: 0, sites: true, wantStart: 0, wantEnd: 1},
+	} {
+		start, end := closed(test.start, test.end, test.sites)
+		if start != test.wantStart || end != test.wantEnd {
+			t.Errorf("unexpected closed interval for [%d,%d) sites=%t: got:[%d,%d) want:[%d,%d)",
+				test.start, test.end, test.sites, start, end, test.wantStart, test.wantEnd)
+		}
+	}
+}
+
+func TestDiscordancesCoordinates(t *testing.T) {
+	for _, test := range []struct {
+		sites bool
+		want  []string
+	}{
+		{
+			want: []string{
+				// Deletion.
+				"chr1 1494 1809 Read del 991 1011",
+				// Insertions, empty on the reference.
+				"chr1 3701 3701 Read minus 901 1200",
+				"chr1 5201 5201 Read tsd 516 865",
+			},
+		},
+		{
+			sites: true,
+			want: []string{
+				"chr1 1494 1809 Read del 991 1011",
+				"chr1 3700 3700 Read minus 901 1200",
+				"chr1 5200 5200 Read tsd 516 865",
+			},
+		},
+	} {
+		cfg := Config{Window: 50, MinSize: 100, PointSites: test.sites, Refiner: refiner(t)}
+		var got []string
+		sc := featio.NewScanner(gff.NewReader(bytes.NewReader(discordances(t, "reads.sam", cfg))))
+		for sc.Next() {
+			f := sc.Feat().(*gff.Feature)
+			got = append(got, fmt.Sprintf("%s %d %d Read %s",
+				f.SeqName, feat.ZeroToOne(f.FeatStart), f.FeatEnd, f.FeatAttributes.Get("Read")))
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output: %v", err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected coordinates with sites=%t:\ngot: %q\nwant:%q", test.sites, got, test.want)
+		}
+	}
+}
+
+func TestParseShape(t *testing.T) {
+	for _, s := range []Shape{Flat, Triangular, Gaussian} {
+		got, err := ParseShape(s.String())
+		if err != nil {
+			t.Errorf("unexpected error for %v: %v", s, err)
+		}
+		if got != s {
+			t.Errorf("unexpected shape: got:%v want:%v", got, s)
+		}
+	}
+	_, err := ParseShape("boxcar")
+	if err == nil {
+		t.Error("expected error for unknown shape")
+	}
+}
+
+func TestWeights(t *testing.T) {
+	for _, s := range []Shape{Triangular, Gaussian} {
+		for _, n := range []int{1, 2, 7, 50} {
+			w, err := s.weights(n)
+			if err != nil {
+				t.Fatalf("unexpected error for %v: %v", s, err)
+			}
+			if len(w) != n {
+				t.Fatalf("unexpected number of %v weights: got:%d want:%d", s, len(w), n)
+			}
+			var sum float64
+			for i, v := range w {
+				sum += v
+				if math.Abs(v-w[n-1-i]) > 1e-12 {
+					t.Errorf("asymmetric %v weights for n=%d: %v", s, n, w)
+					break
+				}
+			}
+			if math.Abs(sum-1) > 1e-12 {
+				t.Errorf("unexpected sum of %v weights for n=%d: got:%v want:1", s, n, sum)
+			}
+			if n > 2 && !(w[n/2] > w[0]) {
+				t.Errorf("%v weights for n=%d not peaked at centre: %v", s, n, w)
+			}
+		}
+	}
+	w, err := Flat.weights(50)
+	if w != nil || err != nil {
+		t.Errorf("unexpected flat weights: got:%v %v", w, err)
+	}
+	_, err = Shape(-1).weights(50)
+	if err == nil {
+		t.Error("expected error for invalid shape weights")
+	}
+}
+
+func TestDiscordancesInvalidShape(t *testing.T) {
+	for _, s := range []Shape{-1, Gaussian + 1} {
+		var buf bytes.Buffer
+		err := Discordances(&buf, &records{record(t, "tsd")}, Config{Window: 50, MinSize: 100, Shape: s})
+		if err == nil {
+			t.Errorf("expected error for shape %v", s)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("unexpected output for shape %v: %q", s, &buf)
+		}
+	}
+}
+
+func TestDiscordancesShape(t *testing.T) {
+	// The tsd read has a 365 base insertion at 5200 on the
+	// reference and at 515 on the read. Weighted smoothing
+	// places the breakpoints closer to the insertion than
+	// flat smoothing.
+	var flatDist int
+	for _, s := range []Shape{Flat, Triangular, Gaussian} {
+		var buf bytes.Buffer
+		err := Discordances(&buf, &records{record(t, "tsd")}, Config{Window: 50, MinSize: 100, Shape: s})
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", s, err)
+		}
+		var feats []*gff.Feature
+		sc := featio.NewScanner(gff.NewReader(&buf))
+		for sc.Next() {
+			feats = append(feats, sc.Feat().(*gff.Feature))
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output for %v: %v", s, err)
+		}
+		if len(feats) != 1 {
+			t.Fatalf("unexpected number of features for %v: got:%d want:1", s, len(feats))
+		}
+		var qstart, qend int
+		_, err = fmt.Sscanf(feats[0].FeatAttributes.Get("Read"), "tsd %d %d", &qstart, &qend)
+		if err != nil {
+			t.Fatalf("unexpected Read attribute for %v: %v", s, err)
+		}
+		dist := abs(feats[0].FeatStart-5200) + abs(feats[0].FeatEnd-5200) +
+			abs(qstart-1-515) + abs(qend-880)
+		if s == Flat {
+			flatDist = dist
+			continue
+		}
+		if dist >= flatDist {
+			t.Errorf("%v breakpoints not closer than flat: got:%d flat:%d", s, dist, flatDist)
+		}
+	}
+}
+
+func TestSmoothTail(t *testing.T) {
+	const (
+		n      = 20
+		window = 8
+		cost   = 0.5
+	)
+	scores := make([]costPos, n)
+	for i := range scores {
+		scores[i] = costPos{ref: 1000 + i, query: i, cost: cost}
+	}
+	for _, s := range []Shape{Flat, Triangular, Gaussian} {
+		weights, err := s.weights(window)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", s, err)
+		}
+		full := smooth(scores, window, weights, false)
+		if len(full) != n-window {
+			t.Errorf("unexpected number of %v smoothed positions without tail: got:%d want:%d", s, len(full), n-window)
+		}
+		tail := smooth(scores, window, weights, true)
+		if len(tail) != n {
+			t.Fatalf("unexpected number of %v smoothed positions with tail: got:%d want:%d", s, len(tail), n)
+		}
+		if !reflect.DeepEqual(tail[:len(full)], full) {
+			t.Errorf("unexpected %v smoothing of complete windows with tail:\ngot: %v\nwant:%v", s, tail[:len(full)], full)
+		}
+		for i, v := range tail {
+			// The kernel of each partial window sums to one,
+			// so a constant cost is unchanged by smoothing.
+			if math.Abs(v.cost-cost) > 1e-12 {
+				t.Errorf("unexpected %v smoothed cost at %d: got:%v want:%v", s, i, v.cost, cost)
+			}
+			end := i + window
+			if end > n {
+				end = n
+			}
+			if v.query < i || end <= v.query || v.ref < 1000+i || 1000+end <= v.ref {
+				t.Errorf("unexpected %v smoothed position at %d: got:%d %d want in [%d,%d) [%d,%d)",
+					s, i, v.ref, v.query, 1000+i, 1000+end, i, end)
+			}
+		}
+		if weights == nil {
+			continue
+		}
+		for i := len(full); i < n; i++ {
+			var sum float64
+			for _, w := range weights[:n-i] {
+				sum += w
+			}
+			var query float64
+			for j, w := range weights[:n-i] {
+				query += w / sum * float64(i+j)
+			}
+			if want := int(query + 0.5); tail[i].query != want {
+				t.Errorf("unexpected %v smoothed query position at %d: got:%d want:%d", s, i, tail[i].query, want)
+			}
+		}
+	}
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// inverted returns a record named name aligned to chr1 at 1000 with a 300
+// base segment replacing reference [2000,2300) and aligned with mismatches.
+// The segment is the reverse complement of that region if rc is true, and
+// the forward sequence of reference [4000,4300) otherwise.
+func inverted(t *testing.T, name string, rc bool) *sam.Record {
+	ref := contigs(t, filepath.Join("testdata", "ref.fa"))["chr1"]
+	var segment alphabet.Letters
+	if rc {
+		s := *ref
+		s.Seq = ref.Seq[2000:2300]
+		segment = sequtil.RevComp(&s).Seq
+	} else {
+		segment = ref.Seq[4000:4300]
+	}
+	var read []byte
+	read = append(read, ref.Seq[1000:2000].String()...)
+	read = append(read, segment.String()...)
+	read = append(read, ref.Seq[2300:3300].String()...)
+	cigar := []sam.CigarOp{
+		sam.NewCigarOp(sam.CigarEqual, 1000),
+		sam.NewCigarOp(sam.CigarMismatch, 300),
+		sam.NewCigarOp(sam.CigarEqual, 1000),
+	}
+	r, err := sam.NewRecord(name, record(t, "del").Ref, nil, 1000, -1, 0, 60, cigar, read, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make record: %v", err)
+	}
+	return r
+}
+
+func TestDiscordancesInversion(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		rc         bool
+		inversions bool
+		want       string
+	}{
+		{name: "inv", rc: true, inversions: true, want: "inversion"},
+		{name: "inv", rc: true, inversions: false, want: "discordance"},
+		{name: "sub", rc: false, inversions: true, want: "discordance"},
+	} {
+		r := refiner(t)
+		r.Inversions = test.inversions
+		r.MinInversionCover = 0.8
+		var buf bytes.Buffer
+		err := Discordances(&buf, &records{inverted(t, test.name, test.rc)}, Config{Window: 50, MinSize: 100, Refiner: r})
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		var feats []*gff.Feature
+		sc := featio.NewScanner(gff.NewReader(&buf))
+		for sc.Next() {
+			feats = append(feats, sc.Feat().(*gff.Feature))
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output for %s: %v", test.name, err)
+		}
+		if len(feats) != 1 {
+			t.Fatalf("unexpected number of features for %s with inversions=%t: got:%d want:1\n%s",
+				test.name, test.inversions, len(feats), &buf)
+		}
+		f := feats[0]
+		if f.Feature != test.want {
+			t.Errorf("unexpected feature type for %s with inversions=%t: got:%s want:%s",
+				test.name, test.inversions, f.Feature, test.want)
+		}
+		if f.Feature != "inversion" {
+			continue
+		}
+		// The inversion spans the inverted reference region
+		// and read segment.
+		var qstart, qend int
+		_, err = fmt.Sscanf(f.FeatAttributes.Get("Read"), test.name+" %d %d", &qstart, &qend)
+		if err != nil {
+			t.Fatalf("unexpected Read attribute for %s: %v", test.name, err)
+		}
+		if abs(f.FeatStart-2001) > 5 || abs(f.FeatEnd-2300) > 5 || abs(qstart-1001) > 5 || abs(qend-1300) > 5 {
+			t.Errorf("unexpected inversion coordinates: got:%d %d read:%d %d want about:2001 2300 read:1001 1300",
+				f.FeatStart, f.FeatEnd, qstart, qend)
+		}
+	}
+}
+
+func TestDiscordancesDump(t *testing.T) {
+	r := refiner(t)
+	var dump bytes.Buffer
+	r.Dump = &dump
+	out := discordances(t, "reads.sam", Config{Window: 50, MinSize: 100, Refiner: r})
+
+	// Dumping does not change the written features.
+	golden(t, "refined.gff", out)
+	golden(t, "dump.txt", dump.Bytes())
+
+	// Each refined feature is dumped with its breakpoints
+	// and the Read attribute it was written with.
+	var feats []*gff.Feature
+	sc := featio.NewScanner(gff.NewReader(bytes.NewReader(out)))
+	for sc.Next() {
+		f := sc.Feat().(*gff.Feature)
+		if f.FeatAttributes.Get("Dup") != "" {
+			feats = append(feats, f)
+		}
+	}
+	if err := sc.Error(); err != nil {
+		t.Fatalf("unexpected error reading output: %v", err)
+	}
+	var headers []string
+	for _, l := range strings.Split(dump.String(), "\n") {
+		if strings.HasPrefix(l, ">") {
+			headers = append(headers, l)
+		}
+	}
+	if len(headers) != len(feats) {
+		t.Fatalf("unexpected number of dumped features: got:%d want:%d", len(headers), len(feats))
+	}
+	for i, f := range feats {
+		fields := strings.Split(headers[i], "\t")
+		want := []string{
+			">" + strings.Fields(f.FeatAttributes.Get("Read"))[0],
+			fmt.Sprintf("%s:%d-%d", f.SeqName, feat.ZeroToOne(f.FeatStart), f.FeatEnd),
+			"read=" + f.FeatAttributes.Get("Read"),
+		}
+		if !reflect.DeepEqual(fields[:3], want) {
+			t.Errorf("unexpected dump header for feature %d: got:%q want prefix:%q", i, fields, want)
+		}
+		if dup := "dup=" + f.FeatAttributes.Get("Dup"); fields[5] != dup {
+			t.Errorf("unexpected dump duplication for feature %d: got:%s want:%s", i, fields[5], dup)
+		}
+	}
+}
+
+// noisy returns a record aligned to chr1 at 100 with n blocks of 150
+// mismatches separated by 300 matches.
+func noisy(t *testing.T, n int) *sam.Record {
+	var cigar []sam.CigarOp
+	for i := 0; i < n; i++ {
+		cigar = append(cigar,
+			sam.NewCigarOp(sam.CigarEqual, 300),
+			sam.NewCigarOp(sam.CigarMismatch, 150),
+		)
+	}
+	cigar = append(cigar, sam.NewCigarOp(sam.CigarEqual, 300))
+	read := bytes.Repeat([]byte("a"), 300+450*n)
+	r, err := sam.NewRecord("noisy", record(t, "del").Ref, nil, 100, -1, 0, 60, cigar, read, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make record: %v", err)
+	}
+	return r
+}
+
+func TestDiscordancesMaxEvents(t *testing.T) {
+	for _, test := range []struct {
+		max  int
+		want int
+	}{
+		{max: 0, want: 10},
+		{max: 10, want: 10},
+		{max: 9, want: 0},
+		{max: 5, want: 0},
+	} {
+		var buf bytes.Buffer
+		err := Discordances(&buf, &records{noisy(t, 10), record(t, "tsd")}, Config{Window: 50, MinSize: 100, MaxEvents: test.max})
+		if err != nil {
+			t.Fatalf("unexpected error for max=%d: %v", test.max, err)
+		}
+		var got, other int
+		sc := featio.NewScanner(gff.NewReader(&buf))
+		for sc.Next() {
+			if strings.HasPrefix(sc.Feat().(*gff.Feature).FeatAttributes.Get("Read"), "noisy ") {
+				got++
+			} else {
+				other++
+			}
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output for max=%d: %v", test.max, err)
+		}
+		if got != test.want {
+			t.Errorf("unexpected number of noisy read features for max=%d: got:%d want:%d", test.max, got, test.want)
+		}
+		// Features of other reads are not affected.
+		if other != 1 {
+			t.Errorf("unexpected number of other features for max=%d: got:%d want:1", test.max, other)
+		}
+	}
+}
+
+// allRecords returns the records in the testdata reads.
+func allRecords(t *testing.T) records {
+	f, err := os.Open(filepath.Join("testdata", "reads.sam"))
+	if err != nil {
+		t.Fatalf("failed to open alignments: %v", err)
+	}
+	defer f.Close()
+	sr, err := sam.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read alignment header: %v", err)
+	}
+	var recs records
+	for {
+		r, err := sr.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("failed to read record: %v", err)
+		}
+		recs = append(recs, r)
+	}
+}
+
+func TestDiscordancesDedup(t *testing.T) {
+	recs := allRecords(t)
+	var want bytes.Buffer
+	once := append(records(nil), recs...)
+	err := Discordances(&want, &once, Config{Window: 50, MinSize: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each record is duplicated both adjacently
+	// and after the complete set of records.
+	var dups records
+	for _, r := range recs {
+		dups = append(dups, r, r)
+	}
+	dups = append(dups, recs...)
+
+	for _, test := range []struct {
+		dedup int
+		want  int
+	}{
+		{dedup: 0, want: 3 * len(recs)},
+		{dedup: 1, want: 2 * len(recs)},
+		{dedup: 10, want: len(recs)},
+	} {
+		in := append(records(nil), dups...)
+		var buf bytes.Buffer
+		err := Discordances(&buf, &in, Config{Window: 50, MinSize: 100, Dedup: test.dedup})
+		if err != nil {
+			t.Fatalf("unexpected error for dedup=%d: %v", test.dedup, err)
+		}
+		var got int
+		sc := featio.NewScanner(gff.NewReader(bytes.NewReader(buf.Bytes())))
+		for sc.Next() {
+			got++
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output for dedup=%d: %v", test.dedup, err)
+		}
+		if got != test.want {
+			t.Errorf("unexpected number of features for dedup=%d: got:%d want:%d", test.dedup, got, test.want)
+		}
+		if test.dedup == 10 && !bytes.Equal(buf.Bytes(), want.Bytes()) {
+			t.Errorf("unexpected deduplicated output:\ngot:\n%s\nwant:\n%s", &buf, &want)
+		}
+	}
+}
+
+func TestRecent(t *testing.T) {
+	r := newRecent(2)
+	key := func(start int) featKey { return featKey{ref: "chr1", start: start, end: start + 100, read: "read"} }
+	for i, test := range []struct {
+		key  featKey
+		want bool
+	}{
+		{key: key(1), want: false},
+		{key: key(1), want: true},
+		{key: key(2), want: false},
+		{key: key(1), want: true},
+		{key: key(3), want: false}, // Drops key(2).
+		{key: key(1), want: true},
+		{key: key(2), want: false},
+		{key: key(3), want: false}, // Dropped by key(2).
+		{key: featKey{ref: "chr1", start: 3, end: 103, read: "other"}, want: false},
+	} {
+		if got := r.has(test.key); got != test.want {
+			t.Errorf("unexpected result for test %d %+v: got:%t want:%t", i, test.key, got, test.want)
+		}
+	}
+}
+
+func TestDiscordancesMinSizes(t *testing.T) {
+	// Without refinement, the del feature is 316
+	// bases on the reference and 21 on the read,
+	// the minus feature is 21 and 316 and the tsd
+	// feature is 21 and 381.
+	for _, test := range []struct {
+		cfg     Config
+		comment string
+		want    []string
+	}{
+		{
+			cfg:     Config{Window: 50, MinSize: 100},
+			comment: "minimum feature length=100",
+			want:    []string{"del", "minus", "tsd"},
+		},
+		{
+			cfg:     Config{Window: 50, MinSize: 600, MinRefSize: 300},
+			comment: "minimum feature length=300 (reference) 600 (query)",
+			want:    []string{"del"},
+		},
+		{
+			cfg:     Config{Window: 50, MinSize: 600, MinQuerySize: 320},
+			comment: "minimum feature length=600 (reference) 320 (query)",
+			want:    []string{"tsd"},
+		},
+		{
+			cfg:     Config{Window: 50, MinSize: 400, MinRefSize: 400, MinQuerySize: 400},
+			comment: "minimum feature length=400",
+			want:    nil,
+		},
+	} {
+		out := discordances(t, "reads.sam", test.cfg)
+		if !bytes.Contains(out, []byte("# "+test.comment+"\n")) {
+			t.Errorf("missing comment %q for %+v:\n%s", test.comment, test.cfg, out)
+		}
+		var got []string
+		sc := featio.NewScanner(gff.NewReader(bytes.NewReader(out)))
+		for sc.Next() {
+			got = append(got, strings.Fields(sc.Feat().(*gff.Feature).FeatAttributes.Get("Read"))[0])
+		}
+		if err := sc.Error(); err != nil {
+			t.Fatalf("unexpected error reading output: %v", err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected features for min=%d min-ref=%d min-query=%d: got:%v want:%v",
+				test.cfg.MinSize, test.cfg.MinRefSize, test.cfg.MinQuerySize, got, test.want)
+		}
+	}
+}
+
+// fragmented returns a record aligned to chr1 at 100 with n blocks of
+// 80 mismatches separated by 100 matches, flanked by 1000 matches.
+func fragmented(t *testing.T, n int) *sam.Record {
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarEqual, 1000)}
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			cigar = append(cigar, sam.NewCigarOp(sam.CigarEqual, 100))
+		}
+		cigar = append(cigar, sam.NewCigarOp(sam.CigarMismatch, 80))
+	}
+	cigar = append(cigar, sam.NewCigarOp(sam.CigarEqual, 1000))
+	read := bytes.Repeat([]byte("a"), 2000+180*n-100)
+	r, err := sam.NewRecord("fragmented", record(t, "del").Ref, nil, 100, -1, 0, 60, cigar, read, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make record: %v", err)
+	}
+	return r
+}
+
+func TestDiscordancesMergeGap(t *testing.T) {
+	// The three fragments of the event are each
+	// shorter than the minimum feature size and
+	// are separated by 102 bases on the reference.
+	const merged = "chr1\treefer\tdiscordance\t1103\t1540\t.\t+\t.\tRead fragmented 1003 1440\n"
+	for _, test := range []struct {
+		gap  int
+		want string
+	}{
+		{gap: 0, want: ""},
+		{gap: 50, want: ""},
+		{gap: 102, want: ""},
+		{gap: 103, want: merged},
+		{gap: 1000, want: merged},
+	} {
+		var buf bytes.Buffer
+		err := Discordances(&buf, &records{fragmented(t, 3)}, Config{Window: 50, MinSize: 100, MergeGap: test.gap, NoHeader: true})
+		if err != nil {
+			t.Fatalf("unexpected error for gap=%d: %v", test.gap, err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("unexpected output for gap=%d:\ngot:\n%s\nwant:\n%s", test.gap, got, test.want)
+		}
+	}
+}
+
+// logged returns the log output written during a call to fn.
+func logged(fn func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	fn()
+	return buf.String()
+}
+
+// short returns a record named name aligned to chr1 at 100 with n matches.
+func short(t *testing.T, name string, n int) *sam.Record {
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarEqual, n)}
+	r, err := sam.NewRecord(name, record(t, "del").Ref, nil, 100, -1, 0, 60, cigar, bytes.Repeat([]byte("a"), n), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make record: %v", err)
+	}
+	return r
+}
+
+func TestDiscordancesShortWarn(t *testing.T) {
+	// The three testdata reads are longer
+	// than the smoothing window.
+	for _, test := range []struct {
+		short int
+		warn  float64
+		want  string
+	}{
+		{short: 0, warn: 0.1, want: ""},
+		{short: 1, warn: 0.5, want: ""},
+		{short: 1, warn: 0.2, want: "warning: 1 of 4 records (25.0%) are no longer than the smoothing window of 50 and were not analysed: consider a smaller window\n"},
+		{short: 3, warn: 0.5, want: ""},
+		{short: 4, warn: 0.5, want: "warning: 4 of 7 records (57.1%) are no longer than the smoothing window of 50 and were not analysed: consider a smaller window\n"},
+		{short: 4, warn: 0, want: ""},
+	} {
+		recs := allRecords(t)
+		for i := 0; i < test.short; i++ {
+			// A record as long as the window
+			// is not analysed.
+			recs = append(recs, short(t, fmt.Sprintf("short%d", i), 50-i))
+		}
+		var buf bytes.Buffer
+		var err error
+		got := logged(func() {
+			err = Discordances(&buf, &recs, Config{Window: 50, MinSize: 100, ShortWarn: test.warn})
+		})
+		if err != nil {
+			t.Fatalf("unexpected error for %d short records with warn=%v: %v", test.short, test.warn, err)
+		}
+		if !strings.HasSuffix(got, test.want) || (test.want == "" && got != "") {
+			t.Errorf("unexpected log output for %d short records with warn=%v:\ngot: %q\nwant:%q", test.short, test.warn, got, test.want)
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("\treefer\t")); n != 3 {
+			t.Errorf("unexpected number of features for %d short records with warn=%v: got:%d want:3", test.short, test.warn, n)
+		}
+	}
+}
+
+// indel returns a record named name aligned to chr1 at 100 with 40
+// matching bases either side of an operation of type op and length n.
+func indel(t *testing.T, name string, op sam.CigarOpType, n int) *sam.Record {
+	cigar := []sam.CigarOp{
+		sam.NewCigarOp(sam.CigarEqual, 40),
+		sam.NewCigarOp(op, n),
+		sam.NewCigarOp(sam.CigarEqual, 40),
+	}
+	read := bytes.Repeat([]byte("a"), 80+op.Consumes().Query*n)
+	r, err := sam.NewRecord(name, record(t, "del").Ref, nil, 100, -1, 0, 60, cigar, read, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make record: %v", err)
+	}
+	return r
+}
+
+func TestDiscordancesTrace(t *testing.T) {
+	// Only analysed records are sampled, so the short
+	// record does not count towards the sample and
+	// the first and third indel records are traced.
+	recs := records{
+		indel(t, "ins", sam.CigarInsertion, 20),
+		short(t, "short", 10),
+		indel(t, "del", sam.CigarDeletion, 20),
+		indel(t, "mis", sam.CigarMismatch, 20),
+	}
+	var gff, trace bytes.Buffer
+	tr, err := NewTracer(&trace, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = Discordances(&gff, &recs, Config{Window: 10, MinSize: 10, Trace: tr})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	golden(t, "trace.tsv", trace.Bytes())
+	if !bytes.Contains(gff.Bytes(), []byte("\tRead ins ")) || !bytes.Contains(gff.Bytes(), []byte("\tRead mis ")) {
+		t.Errorf("missing features for traced records:\n%s", &gff)
+	}
+}
+
+// matched returns a record named name aligned to chr1 at 100 with n
+// alignment match (M) operations.
+func matched(t *testing.T, name string, n int) *sam.Record {
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, n)}
+	r, err := sam.NewRecord(name, record(t, "del").Ref, nil, 100, -1, 0, 60, cigar, bytes.Repeat([]byte("a"), n), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make record: %v", err)
+	}
+	return r
+}
+
+func TestDiscordancesAlignMatch(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		recs := append(allRecords(t), matched(t, "m0", 1000), matched(t, "m1", 2000))
+		var buf bytes.Buffer
+		var err error
+		got := logged(func() {
+			err = Discordances(&buf, &recs, Config{Window: 50, MinSize: 100, StrictCigar: strict})
+		})
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), "record m0 has CIGAR alignment match operations") {
+				t.Errorf("unexpected error with strict CIGAR: got:%v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The first M record is named and the
+		// total is given at the end.
+		for _, want := range []string{
+			"warning: record m0 has CIGAR alignment match (M) operations which are not costed",
+			"warning: 2 of 5 records have CIGAR alignment match (M) operations",
+		} {
+			if strings.Count(got, want) != 1 {
+				t.Errorf("expected one %q in log output:\n%s", want, got)
+			}
+		}
+		if strings.Contains(got, "record m1 ") {
+			t.Errorf("unexpected warning for second M record:\n%s", got)
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("\treefer\t")); n != 3 {
+			t.Errorf("unexpected number of features: got:%d want:3", n)
+		}
+	}
+}
+
+func TestDiscordancesClips(t *testing.T) {
+	const (
+		delLeft    = "chr1\treefer\tclip\t501\t501\t.\t+\t.\tRead del 1 200; Clip left 200\n"
+		delRight   = "chr1\treefer\tclip\t2801\t2801\t.\t+\t.\tRead del 2201 2300; Clip right 100\n"
+		minusLeft  = "chr1\treefer\tclip\t3001\t3001\t.\t-\t.\tRead minus 2001 2200; Clip left 200\n"
+		minusRight = "chr1\treefer\tclip\t4601\t4601\t.\t-\t.\tRead minus 1 100; Clip right 100\n"
+	)
+	for _, test := range []struct {
+		name string
+		recs records
+		cfg  Config
+		want string
+	}{
+		{
+			name: "min-clip=100",
+			recs: records{
+				clipped(record(t, "del"), sam.CigarSoftClipped, 200, 100),
+				clipped(record(t, "minus"), sam.CigarSoftClipped, 200, 100),
+			},
+			cfg:  Config{MinClip: 100},
+			want: delLeft + delRight + minusLeft + minusRight,
+		},
+		{
+			name: "min-clip=150",
+			recs: records{
+				clipped(record(t, "del"), sam.CigarSoftClipped, 200, 100),
+				clipped(record(t, "minus"), sam.CigarSoftClipped, 200, 100),
+			},
+			cfg:  Config{MinClip: 150},
+			want: delLeft + minusLeft,
+		},
+		{
+			name: "min-clip=0",
+			recs: records{clipped(record(t, "del"), sam.CigarSoftClipped, 200, 100)},
+			cfg:  Config{},
+			want: "",
+		},
+		{
+			// Soft clipped bases are counted in the
+			// record length checked against the window.
+			name: "short",
+			recs: records{clipped(short(t, "short", 10), sam.CigarSoftClipped, 20, 20)},
+			cfg:  Config{MinClip: 20},
+			want: "",
+		},
+		{
+			name: "max-events",
+			recs: records{clipped(noisy(t, 10), sam.CigarSoftClipped, 200, 100)},
+			cfg:  Config{MinClip: 100, MaxEvents: 5},
+			want: "",
+		},
+	} {
+		test.cfg.Window = 50
+		test.cfg.MinSize = 100
+		test.cfg.NoHeader = true
+		var buf bytes.Buffer
+		err := Discordances(&buf, &test.recs, test.cfg)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		var got strings.Builder
+		for _, l := range strings.SplitAfter(buf.String(), "\n") {
+			if strings.Contains(l, "\tclip\t") {
+				got.WriteString(l)
+			}
+		}
+		if got.String() != test.want {
+			t.Errorf("unexpected clip features for %s:\ngot:\n%s\nwant:\n%s", test.name, got.String(), test.want)
+		}
+	}
+}
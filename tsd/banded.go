@@ -0,0 +1,201 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/feat"
+)
+
+// Banded is a linear gap Smith-Waterman aligner that restricts alignment
+// to the cells within Width of the diagonal passing through the centres
+// of the reference and query. Since TSD search windows are centred on the
+// insertion junctions, the copies of a duplication shorter than Width lie
+// within the band.
+//
+// Cells outside the band are treated as having a zero score, so when the
+// optimal path lies within the band, the alignment is the same as the
+// alignment returned by the equivalent align.SW.
+type Banded struct {
+	Matrix align.Linear
+	Width  int
+}
+
+var _ align.Aligner = Banded{}
+
+// Align aligns the sequence data of reference and query, which must be
+// alphabet.Letters.
+func (a Banded) Align(reference, query align.AlphabetSlicer) ([]feat.Pair, error) {
+	alpha := reference.Alphabet()
+	if alpha == nil {
+		return nil, align.ErrNoAlphabet
+	}
+	if alpha != query.Alphabet() {
+		return nil, align.ErrMismatchedAlphabets
+	}
+	if alpha.IndexOf(alpha.Gap()) != 0 {
+		return nil, align.ErrNotGappedAlphabet
+	}
+	rSeq, ok := reference.Slice().(alphabet.Letters)
+	if !ok {
+		return nil, errors.New("tsd: banded alignment requires alphabet.Letters")
+	}
+	qSeq, ok := query.Slice().(alphabet.Letters)
+	if !ok {
+		return nil, align.ErrMismatchedTypes
+	}
+	return a.alignLetters(rSeq, qSeq, alpha)
+}
+
+// alignLetters follows the dynamic programming and traceback of
+// align.SW so that tie breaking is the same.
+func (a Banded) alignLetters(rSeq, qSeq alphabet.Letters, alpha alphabet.Alphabet) ([]feat.Pair, error) {
+	let := len(a.Matrix)
+	if let < alpha.Len() {
+		return nil, align.ErrMatrixWrongSize{Size: let, Len: alpha.Len()}
+	}
+	for _, row := range a.Matrix {
+		if len(row) != let {
+			return nil, align.ErrMatrixNotSquare
+		}
+	}
+
+	// The table holds only the band. Row i holds the
+	// cells at columns i-d-Width through i-d+Width.
+	r, c := len(rSeq)+1, len(qSeq)+1
+	d := (len(rSeq) - len(qSeq)) / 2
+	width := 2*a.Width + 1
+	table := make([]int, r*width)
+	at := func(i, j int) int {
+		k := j - (i - d - a.Width)
+		if i < 1 || j < 1 || j >= c || k < 0 || k >= width {
+			return 0
+		}
+		return table[i*width+k]
+	}
+
+	var (
+		index = alpha.LetterIndex()
+
+		maxS, maxI, maxJ = 0, 0, 0
+	)
+	for i := 1; i < r; i++ {
+		rVal := index[rSeq[i-1]]
+		if rVal < 0 {
+			return nil, fmt.Errorf("tsd: illegal letter %q at position %d in rSeq", rSeq[i-1], i-1)
+		}
+		for j := max(1, i-d-a.Width); j < min(c, i-d+a.Width+1); j++ {
+			qVal := index[qSeq[j-1]]
+			if qVal < 0 {
+				return nil, fmt.Errorf("tsd: illegal letter %q at position %d in qSeq", qSeq[j-1], j-1)
+			}
+
+			diagScore := at(i-1, j-1) + a.Matrix[rVal][qVal]
+			upScore := at(i-1, j) + a.Matrix[rVal][0]
+			leftScore := at(i, j-1) + a.Matrix[0][qVal]
+
+			score := max(diagScore, max(upScore, leftScore))
+			switch {
+			case score > 0:
+				if score >= maxS && score == diagScore {
+					maxS, maxI, maxJ = score, i, j
+				}
+			default:
+				score = 0
+			}
+			table[i*width+j-(i-d-a.Width)] = score
+		}
+	}
+
+	const (
+		diag = iota
+		up
+		left
+	)
+	var aln []feat.Pair
+	score, last := 0, diag
+	i, j := maxI, maxJ
+loop:
+	for i > 0 && j > 0 {
+		rVal := index[rSeq[i-1]]
+		qVal := index[qSeq[j-1]]
+		switch p := at(i, j); p {
+		case 0:
+			break loop
+		case at(i-1, j-1) + a.Matrix[rVal][qVal]:
+			if last != diag {
+				aln = append(aln, &pair{a: segment{i, maxI}, b: segment{j, maxJ}, score: score})
+				maxI, maxJ = i, j
+				score = 0
+			}
+			score += p - at(i-1, j-1)
+			i--
+			j--
+			last = diag
+		case at(i-1, j) + a.Matrix[rVal][0]:
+			if last != up {
+				aln = append(aln, &pair{a: segment{i, maxI}, b: segment{j, maxJ}, score: score})
+				maxI, maxJ = i, j
+				score = 0
+			}
+			score += p - at(i-1, j)
+			i--
+			last = up
+		case at(i, j-1) + a.Matrix[0][qVal]:
+			if last != left {
+				aln = append(aln, &pair{a: segment{i, maxI}, b: segment{j, maxJ}, score: score})
+				maxI, maxJ = i, j
+				score = 0
+			}
+			score += p - at(i, j-1)
+			j--
+			last = left
+		default:
+			panic(fmt.Sprintf("tsd: banded sw internal error: no path at row: %d col:%d", i, j))
+		}
+	}
+	aln = append(aln, &pair{a: segment{i, maxI}, b: segment{j, maxJ}, score: score})
+
+	for i, j := 0, len(aln)-1; i < j; i, j = i+1, j-1 {
+		aln[i], aln[j] = aln[j], aln[i]
+	}
+	return aln, nil
+}
+
+// segment is an unnamed aligned segment.
+type segment struct {
+	start, end int
+}
+
+func (s segment) Name() string           { return "" }
+func (s segment) Description() string    { return "" }
+func (s segment) Location() feat.Feature { return nil }
+func (s segment) Start() int             { return s.start }
+func (s segment) End() int               { return s.end }
+func (s segment) Len() int               { return s.end - s.start }
+
+// pair is an aligned pair of segments. It is formatted in the
+// same way as the feature pairs returned by align.SW.
+type pair struct {
+	a, b  segment
+	score int
+}
+
+func (p *pair) Features() [2]feat.Feature { return [2]feat.Feature{p.a, p.b} }
+func (p *pair) Score() int                { return p.score }
+func (p *pair) Invert()                   { p.a, p.b = p.b, p.a }
+func (p *pair) String() string {
+	switch {
+	case p.a.start == p.a.end:
+		return fmt.Sprintf("-/[%d,%d)=%d", p.b.start, p.b.end, p.score)
+	case p.b.start == p.b.end:
+		return fmt.Sprintf("[%d,%d)/-=%d", p.a.start, p.a.end, p.score)
+	}
+	return fmt.Sprintf("[%d,%d)/[%d,%d)=%d", p.a.start, p.a.end, p.b.start, p.b.end, p.score)
+}
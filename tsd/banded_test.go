@@ -0,0 +1,200 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsd
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/feat"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+// randSeq returns a random DNA sequence of length n.
+func randSeq(rnd *rand.Rand, n int) *linear.Seq {
+	const bases = "acgt"
+	s := make(alphabet.Letters, n)
+	for i := range s {
+		s[i] = alphabet.Letter(bases[rnd.Intn(len(bases))])
+	}
+	return linear.NewSeq("", s, alphabet.DNAgapped)
+}
+
+// mutate returns a copy of s with the given fraction of positions
+// substituted, inserted after or deleted at random.
+func mutate(rnd *rand.Rand, s *linear.Seq, frac float64) *linear.Seq {
+	const bases = "acgt"
+	var m alphabet.Letters
+	for _, l := range s.Seq {
+		if rnd.Float64() >= frac {
+			m = append(m, l)
+			continue
+		}
+		switch rnd.Intn(3) {
+		case 0:
+			m = append(m, alphabet.Letter(bases[rnd.Intn(len(bases))]))
+		case 1:
+			m = append(m, l, alphabet.Letter(bases[rnd.Intn(len(bases))]))
+		}
+	}
+	return linear.NewSeq("", m, alphabet.DNAgapped)
+}
+
+func TestBandedFull(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, s := range []Scores{{1, -1, -1}, {1, -2, -3}, {2, -3, -5}} {
+		sw, err := NewAligner(alphabet.DNAgapped, s, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := 0; i < 50; i++ {
+			ref := randSeq(rnd, 10+rnd.Intn(100))
+			query := mutate(rnd, ref, 0.1)
+
+			// A band wider than both sequences
+			// covers the complete table.
+			width := max(ref.Len(), query.Len())
+			band, err := NewBandedAligner(alphabet.DNAgapped, s, nil, width)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertSameAlignment(t, sw, band, ref, query)
+		}
+	}
+}
+
+func TestBandedTSD(t *testing.T) {
+	sw, err := NewAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		right    string
+		inverted bool
+	}{
+		{right: dup},
+		{right: invDup, inverted: true},
+	} {
+		s, start, end := tsdSeq(test.right)
+		w := Windows(start, end, s.Len(), 15, 15)
+
+		// The duplication lies on the diagonal through
+		// the window centres, so a band wider than the
+		// duplication covers the optimal path.
+		band, err := NewBandedAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil, 2*len(dup))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !test.inverted {
+			left := *s
+			left.Seq = s.Seq[w.LeftStart:w.LeftEnd]
+			right := *s
+			right.Seq = s.Seq[w.RightStart:w.RightEnd]
+			assertSameAlignment(t, sw, band, &right, &left)
+		}
+
+		find := Find
+		if test.inverted {
+			find = FindInverted
+		}
+		want, err := find(s, w, sw, 6, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want == nil {
+			t.Fatalf("failed to find TSD for %s", test.right)
+		}
+		got, err := find(s, w, band, 6, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("unexpected banded TSD for %s: got:%v want:%v", test.right, got, want)
+		}
+	}
+}
+
+func TestBandedNarrow(t *testing.T) {
+	// A band narrower than the offset of the only
+	// matching segment from the diagonal misses it.
+	ref := linear.NewSeq("", alphabet.BytesToLetters([]byte("gattacaggccccccccccccccccc")), alphabet.DNAgapped)
+	query := linear.NewSeq("", alphabet.BytesToLetters([]byte("tttttttttttttttttgattacagg")), alphabet.DNAgapped)
+	band, err := NewBandedAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	aln, err := band.Align(ref, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc := alignScore(aln); sc >= 9 {
+		t.Errorf("unexpected alignment outside band: %v", aln)
+	}
+}
+
+func assertSameAlignment(t *testing.T, sw, band align.Aligner, ref, query *linear.Seq) {
+	t.Helper()
+	want, err := sw.Align(ref, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := band.Align(ref, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("unexpected banded alignment of\n%s\n%s\ngot: %v\nwant:%v", ref.Seq, query.Seq, got, want)
+	}
+	if alignScore(got) != alignScore(want) {
+		t.Errorf("unexpected banded alignment score: got:%d want:%d", alignScore(got), alignScore(want))
+	}
+}
+
+// alignScore returns the total score of the alignment aln.
+func alignScore(aln []feat.Pair) int {
+	type scorer interface {
+		Score() int
+	}
+	var sc int
+	for _, seg := range aln {
+		sc += seg.(scorer).Score()
+	}
+	return sc
+}
+
+func BenchmarkAlign(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, window := range []int{50, 100, 500, 1000} {
+		ref := randSeq(rnd, window)
+		query := mutate(rnd, ref, 0.1)
+		sw, err := NewAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil)
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		band, err := NewBandedAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil, 20)
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		for _, test := range []struct {
+			name string
+			sw   align.Aligner
+		}{
+			{name: "full", sw: sw},
+			{name: "banded", sw: band},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", test.name, window), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, err := test.sw.Align(ref, query)
+					if err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tsd provides target site duplication detection for insertion
+// events using Smith-Waterman alignment of the sequence flanking each
+// end of the insertion.
+package tsd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/feat"
+	"github.com/biogo/biogo/seq/linear"
+
+	"github.com/kortschak/loopy/internal/sequtil"
+)
+
+// Scores holds alignment scoring parameters. Three values specify
+// match, mismatch and linear gap scores. Four values specify match,
+// mismatch, gap open and gap extend scores for affine gap alignment;
+// the gap open score is added to the gap extend score at the first
+// position of each gap. Scores satisfies the flag.Value interface.
+type Scores []int
+
+// Set sets the scores from a comma separated list of three or four
+// integers.
+func (v *Scores) Set(s string) error {
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 && len(fields) != 4 {
+		return fmt.Errorf("invalid number of fields: %q", s)
+	}
+	m := make(Scores, len(fields))
+	var err error
+	for i, f := range fields {
+		m[i], err = strconv.Atoi(f)
+		if err != nil {
+			return fmt.Errorf("invalid fields: %v", err)
+		}
+	}
+	*v = m
+	return nil
+}
+
+func (v *Scores) String() string {
+	f := make([]string, len(*v))
+	for i, s := range *v {
+		f[i] = strconv.Itoa(s)
+	}
+	return strings.Join(f, ",")
+}
+
+// NewAligner returns a Smith-Waterman aligner for the given scoring
+// parameters. If sub is not nil, it is used as the substitution matrix
+// in place of the match, mismatch and gap values of s, and must be
+// square with the dimension of the alphabet. If s has four values,
+// an affine gap aligner is returned.
+func NewAligner(alpha alphabet.Alphabet, s Scores, sub [][]int) (align.Aligner, error) {
+	sw, err := linearMatrix(alpha, s, sub)
+	if err != nil {
+		return nil, err
+	}
+	if len(s) == 4 {
+		return align.SWAffine{Matrix: sw, GapOpen: s[2]}, nil
+	}
+	return align.SW(sw), nil
+}
+
+// NewBandedAligner returns a Banded aligner with the given band width
+// for the given scoring parameters, which are interpreted as for
+// NewAligner. Affine gap scoring is not supported.
+func NewBandedAligner(alpha alphabet.Alphabet, s Scores, sub [][]int, width int) (align.Aligner, error) {
+	if len(s) == 4 {
+		return nil, errors.New("tsd: banded alignment does not support affine gaps")
+	}
+	if width < 0 {
+		return nil, fmt.Errorf("tsd: negative band width: %d", width)
+	}
+	sw, err := linearMatrix(alpha, s, sub)
+	if err != nil {
+		return nil, err
+	}
+	return Banded{Matrix: sw, Width: width}, nil
+}
+
+// linearMatrix returns the linear gap scoring matrix for the given
+// scoring parameters as described for NewAligner.
+func linearMatrix(alpha alphabet.Alphabet, s Scores, sub [][]int) (align.Linear, error) {
+	if sub != nil {
+		if len(sub) != alpha.Len() {
+			return nil, fmt.Errorf("invalid matrix dimensions: %d rows for alphabet of length %d", len(sub), alpha.Len())
+		}
+		for i, row := range sub {
+			if len(row) != alpha.Len() {
+				return nil, fmt.Errorf("invalid matrix dimensions: row %d has %d columns for alphabet of length %d", i, len(row), alpha.Len())
+			}
+		}
+		return sub, nil
+	}
+	match := s[0]
+	mismatch := s[1]
+	gap := s[len(s)-1]
+	sw := make(align.Linear, alpha.Len())
+	for i := range sw {
+		row := make([]int, alpha.Len())
+		for j := range row {
+			row[j] = mismatch
+		}
+		row[i] = match
+		sw[i] = row
+	}
+	for i := range sw {
+		sw[0][i] = gap
+		sw[i][0] = gap
+	}
+	return sw, nil
+}
+
+// ReadMatrix reads a whitespace separated substitution matrix from the
+// named file. Blank lines and lines starting with '#' are ignored.
+func ReadMatrix(path string) ([][]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m [][]int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		row := make([]int, len(fields))
+		for i, v := range fields {
+			row[i], err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid matrix value: %v", err)
+			}
+		}
+		m = append(m, row)
+	}
+	return m, sc.Err()
+}
+
+// Window holds the half-open search intervals flanking each end of
+// an insertion.
+type Window struct {
+	LeftStart, LeftEnd   int
+	RightStart, RightEnd int
+}
+
+// Span returns the half-open interval covering both windows of w.
+func (w Window) Span() (start, end int) {
+	return min(w.LeftStart, w.RightStart), max(w.LeftEnd, w.RightEnd)
+}
+
+// Shift returns w with all positions shifted by d. To search a
+// subsequence starting at off that holds the windows, search with
+// w.Shift(-off) and shift the Window of the returned TSD by off to
+// give its positions in the complete sequence.
+func (w Window) Shift(d int) Window {
+	return Window{
+		LeftStart:  w.LeftStart + d,
+		LeftEnd:    w.LeftEnd + d,
+		RightStart: w.RightStart + d,
+		RightEnd:   w.RightEnd + d,
+	}
+}
+
+// Windows returns the search windows for an insertion at [start, end)
+// in a sequence of the given length. The left window extends lhw either
+// side of start and the right window extends rhw either side of end.
+// Overlapping windows are clamped to their midpoint.
+func Windows(start, end, length, lhw, rhw int) Window {
+	w := Window{
+		LeftStart:  max(0, start-lhw),
+		LeftEnd:    min(length, start+lhw),
+		RightStart: max(0, end-rhw),
+		RightEnd:   min(length, end+rhw),
+	}
+	if w.LeftEnd > w.RightStart {
+		w.LeftEnd = (w.LeftEnd + w.RightStart) / 2
+		w.RightStart = w.LeftEnd
+	}
+	return w
+}
+
+// DupWindows returns the search windows for an insertion at [start, end)
+// in a sequence of the given length with refined ends and a duplication
+// of length dup.
+func DupWindows(start, end, length, dup int) Window {
+	return Window{
+		LeftStart:  max(0, start-dup),
+		LeftEnd:    start,
+		RightStart: end,
+		RightEnd:   min(length, end+dup),
+	}
+}
+
+// TSD is a target site duplication.
+type TSD struct {
+	// Window is the search window used to
+	// find the duplication.
+	Window
+
+	// Alignment is the alignment of the left
+	// window (query) to the right window
+	// (reference) and Formatted is its text
+	// representation.
+	Alignment []feat.Pair
+	Formatted [2]alphabet.Slice
+
+	// Score is the total alignment score.
+	Score int
+
+	// Identity is the fraction of alignment
+	// columns that are matches. N does not
+	// match any base.
+	Identity float64
+
+	// Inverted indicates that the right copy
+	// of the duplication is the reverse complement
+	// of the left copy, as found by FindInverted.
+	// The Alignment and Formatted fields are then
+	// of the reverse complement of the right window.
+	Inverted bool
+}
+
+// Find searches for a target site duplication in s within the windows
+// in w using the provided aligner. If either window or either aligned
+// half of the duplication is shorter than thresh, Find returns nil.
+// Aligned N positions do not contribute to the length of a duplication
+// half, and are scored as gaps by the aligner. If the fraction of N in
+// either window is greater than maxN, Find returns nil without performing
+// the alignment.
+func Find(s *linear.Seq, w Window, sw align.Aligner, thresh int, maxN float64) (*TSD, error) {
+	return find(s, w, sw, thresh, maxN, false)
+}
+
+// FindInverted searches for an inverted duplication in s within the windows
+// in w, aligning the reverse complement of the right window to the left
+// window. The returned TSD has Inverted set and is otherwise as described
+// for Find.
+func FindInverted(s *linear.Seq, w Window, sw align.Aligner, thresh int, maxN float64) (*TSD, error) {
+	return find(s, w, sw, thresh, maxN, true)
+}
+
+func find(s *linear.Seq, w Window, sw align.Aligner, thresh int, maxN float64, inverted bool) (*TSD, error) {
+	if w.LeftEnd-w.LeftStart < thresh || w.RightEnd-w.RightStart < thresh {
+		// Don't do fruitless work.
+		return nil, nil
+	}
+	if fracN(s.Seq[w.LeftStart:w.LeftEnd]) > maxN || fracN(s.Seq[w.RightStart:w.RightEnd]) > maxN {
+		// Don't call duplications across assembly gaps.
+		return nil, nil
+	}
+
+	left := *s
+	left.ID = "prefix"
+	left.Seq = left.Seq[w.LeftStart:w.LeftEnd]
+	right := *s
+	right.ID = "postfix"
+	right.Seq = right.Seq[w.RightStart:w.RightEnd]
+	if inverted {
+		right.Seq = sequtil.RevCompLetters(right.Seq)
+	}
+
+	// N is not a letter of the alignment alphabet,
+	// so align with N masked as gaps, but format the
+	// alignment with the original letters.
+	aln, err := sw.Align(maskN(&right), maskN(&left))
+	if err != nil {
+		return nil, err
+	}
+
+	fa := align.Format(&right, &left, aln, '-')
+	for _, seg := range fa {
+		var n int
+		for _, l := range seg.(alphabet.Letters) {
+			if l != '-' && !isN(l) {
+				n++
+			}
+		}
+		if n < thresh {
+			return nil, nil
+		}
+	}
+
+	var sc int
+	for _, seg := range aln {
+		type scorer interface {
+			Score() int
+		}
+		sc += seg.(scorer).Score()
+	}
+	return &TSD{Window: w, Alignment: aln, Formatted: fa, Score: sc, Identity: identity(fa), Inverted: inverted}, nil
+}
+
+// identity returns the fraction of columns in the formatted alignment fa
+// that are case-insensitive matches between bases other than N.
+func identity(fa [2]alphabet.Slice) float64 {
+	a := fa[0].(alphabet.Letters)
+	b := fa[1].(alphabet.Letters)
+	if len(a) == 0 {
+		return 0
+	}
+	var n int
+	for i, l := range a {
+		if l != '-' && !isN(l) && unicode.ToLower(rune(l)) == unicode.ToLower(rune(b[i])) {
+			n++
+		}
+	}
+	return float64(n) / float64(len(a))
+}
+
+// String returns the representation of t used for the GFF TSD attribute.
+// The coordinates in the representation are the end of the left copy and
+// the start of the right copy of the duplication, as returned by Spans.
+// The representation of an inverted duplication ends with "inverted".
+func (t *TSD) String() string {
+	left, right := t.Spans()
+	s := fmt.Sprintf(`%v %d %d %v "%v" %d`,
+		t.Formatted[0], left[1], right[0], t.Formatted[1],
+		t.Alignment, t.Score)
+	if t.Inverted {
+		s += " inverted"
+	}
+	return s
+}
+
+// Orientation returns the orientation of the right copy of the duplication
+// relative to the left copy, "forward" for a direct repeat as found by Find
+// and "inverted" for a reverse complement as found by FindInverted.
+func (t *TSD) Orientation() string {
+	if t.Inverted {
+		return "inverted"
+	}
+	return "forward"
+}
+
+// Spans returns the half-open intervals of the left and right copies
+// of the duplication.
+func (t *TSD) Spans() (left, right [2]int) {
+	// The alignment reference is the right
+	// window and the query is the left window.
+	first := t.Alignment[0].Features()
+	last := t.Alignment[len(t.Alignment)-1].Features()
+	left = [2]int{first[1].Start() + t.LeftStart, last[1].End() + t.LeftStart}
+	right = [2]int{first[0].Start(), last[0].End()}
+	if t.Inverted {
+		n := t.RightEnd - t.RightStart
+		right = [2]int{n - right[1], n - right[0]}
+	}
+	right[0] += t.RightStart
+	right[1] += t.RightStart
+	return left, right
+}
+
+// maskN returns a copy of s with N replaced by the gap letter of the
+// alphabet of s.
+func maskN(s *linear.Seq) *linear.Seq {
+	m := *s
+	m.Seq = make(alphabet.Letters, len(s.Seq))
+	for i, l := range s.Seq {
+		if isN(l) {
+			l = s.Alpha.Gap()
+		}
+		m.Seq[i] = l
+	}
+	return &m
+}
+
+// fracN returns the fraction of N in s.
+func fracN(s alphabet.Letters) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+	var n int
+	for _, l := range s {
+		if isN(l) {
+			n++
+		}
+	}
+	return float64(n) / float64(len(s))
+}
+
+func isN(l alphabet.Letter) bool { return l == 'n' || l == 'N' }
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
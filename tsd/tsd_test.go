@@ -0,0 +1,403 @@
+// Copyright ©2016 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsd
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+const (
+	// flankLeft and flankRight are background sequence with
+	// no significant similarity to each other or the insertion.
+	flankLeft  = "ttgacctagcgatcatgcgtaccgttagcatacgggtcaatcgagtccat"
+	flankRight = "gcatgtcgctacgtagacttcgccatgtacgtctaggatcgcaatgtctg"
+	insertion  = "acgacaacaactgcgatgtttaccggatccttgaggtcta"
+
+	// dup is a target site duplication and invDup is its
+	// reverse complement.
+	dup    = "gattacagg"
+	invDup = "cctgtaatc"
+)
+
+// tsdSeq returns a sequence with the right copy of a duplication flanking
+// an insertion, and the start and end of the insertion.
+func tsdSeq(right string) (s *linear.Seq, start, end int) {
+	seq := flankLeft + dup + insertion + right + flankRight
+	start = len(flankLeft) + len(dup)
+	end = start + len(insertion)
+	return linear.NewSeq("test", alphabet.BytesToLetters([]byte(seq)), alphabet.DNAgapped), start, end
+}
+
+var findTests = []struct {
+	name      string
+	right     string
+	inverted  bool
+	wantLeft  [2]int
+	wantRight [2]int
+}{
+	{
+		name:      "direct",
+		right:     dup,
+		wantLeft:  [2]int{50, 59},
+		wantRight: [2]int{99, 108},
+	},
+	{
+		name:      "inverted",
+		right:     invDup,
+		inverted:  true,
+		wantLeft:  [2]int{50, 59},
+		wantRight: [2]int{99, 108},
+	},
+}
+
+func TestFindSpans(t *testing.T) {
+	sw, err := NewAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range findTests {
+		s, start, end := tsdSeq(test.right)
+		w := Windows(start, end, s.Len(), 15, 15)
+		find := Find
+		if test.inverted {
+			find = FindInverted
+		}
+		got, err := find(s, w, sw, 6, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		if got == nil {
+			t.Fatalf("failed to find TSD for %s", test.name)
+		}
+		if got.Inverted != test.inverted {
+			t.Errorf("unexpected inverted flag for %s: got:%t want:%t", test.name, got.Inverted, test.inverted)
+		}
+		wantOrient := "forward"
+		if test.inverted {
+			wantOrient = "inverted"
+		}
+		if o := got.Orientation(); o != wantOrient {
+			t.Errorf("unexpected orientation for %s: got:%s want:%s", test.name, o, wantOrient)
+		}
+		left, right := got.Spans()
+		if left != test.wantLeft || right != test.wantRight {
+			t.Errorf("unexpected spans for %s: got:%v %v want:%v %v",
+				test.name, left, right, test.wantLeft, test.wantRight)
+		}
+		if l := string(s.Seq[left[0]:left[1]]); l != dup {
+			t.Errorf("unexpected left copy for %s: got:%s want:%s", test.name, l, dup)
+		}
+		if r := string(s.Seq[right[0]:right[1]]); r != test.right {
+			t.Errorf("unexpected right copy for %s: got:%s want:%s", test.name, r, test.right)
+		}
+
+		// The coordinates of the TSD attribute are the inner
+		// ends of the spans used for BED output.
+		fields := strings.Fields(got.String())
+		wantCoords := fmt.Sprint(left[1], " ", right[0])
+		if coords := strings.Join(fields[1:3], " "); coords != wantCoords {
+			t.Errorf("unexpected TSD attribute coordinates for %s: got:%s want:%s", test.name, coords, wantCoords)
+		}
+		if strings.HasSuffix(got.String(), " inverted") != test.inverted {
+			t.Errorf("unexpected TSD attribute for %s: %s", test.name, got)
+		}
+	}
+}
+
+func TestFindN(t *testing.T) {
+	sw, err := NewAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, start, end := tsdSeq(dup)
+	w := Windows(start, end, s.Len(), 15, 15)
+
+	// Mask most of the left window as an assembly gap.
+	gap := *s
+	gap.Seq = append(alphabet.Letters(nil), s.Seq...)
+	for i := w.LeftStart; i < w.LeftStart+20; i++ {
+		gap.Seq[i] = 'n'
+	}
+	got, err := Find(&gap, w, sw, 6, 0.5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("unexpected TSD in N-rich window: %s", got)
+	}
+	got, err = Find(&gap, w, sw, 6, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("unexpected TSD with N columns counted toward threshold: %s", got)
+	}
+
+	// N positions in both copies of the duplication
+	// do not count toward the threshold.
+	masked := *s
+	masked.Seq = append(alphabet.Letters(nil), s.Seq...)
+	masked.Seq[len(flankLeft)+4] = 'n'
+	masked.Seq[len(flankLeft)+len(dup)+len(insertion)+4] = 'n'
+	for _, test := range []struct {
+		thresh int
+		want   bool
+	}{
+		{thresh: len(dup) - 1, want: true},
+		{thresh: len(dup), want: false},
+	} {
+		got, err := Find(&masked, w, sw, test.thresh, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if (got != nil) != test.want {
+			t.Errorf("unexpected result for N-masked duplication with thresh=%d: got:%v want found:%t", test.thresh, got, test.want)
+		}
+		if got == nil {
+			continue
+		}
+		for i, f := range got.Formatted {
+			if !strings.Contains(fmt.Sprint(f), "n") {
+				t.Errorf("N not retained in formatted alignment %d: %v", i, f)
+			}
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want float64
+	}{
+		{a: "gattacagg", b: "gattacagg", want: 1},
+		{a: "gattacagg", b: "GATTACAGG", want: 1},
+		{a: "gattacagg", b: "gatcacagg", want: 8.0 / 9},
+		{a: "gatt-acagg", b: "gattcacagg", want: 9.0 / 10},
+		{a: "gattnacagg", b: "gattnacagg", want: 9.0 / 10},
+		{a: "", b: "", want: 0},
+	} {
+		got := identity([2]alphabet.Slice{alphabet.Letters(test.a), alphabet.Letters(test.b)})
+		if got != test.want {
+			t.Errorf("unexpected identity for %s/%s: got:%v want:%v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestFindIdentity(t *testing.T) {
+	sw, err := NewAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		right string
+		want  float64
+	}{
+		{right: dup, want: 1},
+		{right: "gatcacagg", want: 8.0 / 9},
+	} {
+		s, start, end := tsdSeq(test.right)
+		got, err := Find(s, Windows(start, end, s.Len(), 15, 15), sw, 6, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.right, err)
+		}
+		if got == nil {
+			t.Fatalf("failed to find TSD for %s", test.right)
+		}
+		if got.Identity != test.want {
+			t.Errorf("unexpected identity for %s: got:%v want:%v", test.right, got.Identity, test.want)
+		}
+	}
+}
+
+func TestNewAlignerAffine(t *testing.T) {
+	// The right copy of the duplication has a two
+	// base insertion relative to the left copy.
+	const (
+		left  = "gattacaggtcagt"
+		right = "gattacattggtcagt"
+	)
+	s := linear.NewSeq("test", alphabet.BytesToLetters([]byte(flankLeft+left+insertion+right+flankRight)), alphabet.DNAgapped)
+	start := len(flankLeft) + len(left)
+	end := start + len(insertion)
+	w := Windows(start, end, s.Len(), 20, 20)
+
+	for _, test := range []struct {
+		scores    Scores
+		affine    bool
+		wantLeft  [2]int
+		wantRight [2]int
+		wantScore int
+	}{
+		// The gap costs 6 with linear scoring, so the
+		// whole duplication is aligned across the gap.
+		{
+			scores:    Scores{1, -2, -3},
+			wantLeft:  [2]int{50, 64},
+			wantRight: [2]int{104, 120},
+			wantScore: 8,
+		},
+		// A zero gap open score is linear scoring.
+		{
+			scores:    Scores{1, -2, 0, -3},
+			affine:    true,
+			wantLeft:  [2]int{50, 64},
+			wantRight: [2]int{104, 120},
+			wantScore: 8,
+		},
+		// The gap costs 9 with the open score, so only
+		// the longer ungapped part is aligned.
+		{
+			scores:    Scores{1, -2, -3, -3},
+			affine:    true,
+			wantLeft:  [2]int{57, 64},
+			wantRight: [2]int{113, 120},
+			wantScore: 7,
+		},
+	} {
+		sw, err := NewAligner(alphabet.DNAgapped, test.scores, nil)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", test.scores, err)
+		}
+		if _, ok := sw.(align.SWAffine); ok != test.affine {
+			t.Errorf("unexpected aligner type for %v: got:%T", test.scores, sw)
+		}
+		got, err := Find(s, w, sw, 6, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", test.scores, err)
+		}
+		if got == nil {
+			t.Fatalf("failed to find TSD for %v", test.scores)
+		}
+		l, r := got.Spans()
+		if l != test.wantLeft || r != test.wantRight {
+			t.Errorf("unexpected spans for %v: got:%v %v want:%v %v",
+				test.scores, l, r, test.wantLeft, test.wantRight)
+		}
+		if got.Score != test.wantScore {
+			t.Errorf("unexpected score for %v: got:%d want:%d", test.scores, got.Score, test.wantScore)
+		}
+	}
+}
+
+func TestReadMatrix(t *testing.T) {
+	for _, test := range []struct {
+		file         string
+		want         [][]int
+		wantReadErr  bool
+		wantAlignErr bool
+	}{
+		{
+			file: "matrix.txt",
+			want: [][]int{
+				{-3, -3, -3, -3, -3},
+				{-3, 1, -2, -2, -2},
+				{-3, -2, 1, -2, -2},
+				{-3, -2, -2, 1, -2},
+				{-3, -2, -2, -2, 1},
+			},
+		},
+		{file: "missing.txt", wantReadErr: true},
+		{file: "bad-value.txt", wantReadErr: true},
+		{file: "ragged.txt", wantAlignErr: true},
+		{file: "short.txt", wantAlignErr: true},
+	} {
+		sub, err := ReadMatrix(filepath.Join("testdata", test.file))
+		if (err != nil) != test.wantReadErr {
+			t.Errorf("unexpected read error for %s: got:%v want error:%t", test.file, err, test.wantReadErr)
+		}
+		if err != nil {
+			continue
+		}
+		if test.want != nil && !reflect.DeepEqual(sub, test.want) {
+			t.Errorf("unexpected matrix for %s: got:%v want:%v", test.file, sub, test.want)
+		}
+
+		// The matrix overrides the scores, which
+		// would not find the duplication.
+		sw, err := NewAligner(alphabet.DNAgapped, Scores{-1, -1, -1}, sub)
+		if (err != nil) != test.wantAlignErr {
+			t.Errorf("unexpected aligner error for %s: got:%v want error:%t", test.file, err, test.wantAlignErr)
+		}
+		if err != nil {
+			continue
+		}
+		s, start, end := tsdSeq(dup)
+		got, err := Find(s, Windows(start, end, s.Len(), 15, 15), sw, 6, 0.5)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.file, err)
+		}
+		if got == nil {
+			t.Errorf("failed to find TSD with matrix from %s", test.file)
+		}
+	}
+}
+
+func TestFindCase(t *testing.T) {
+	// Soft-masked (lower case) and unmasked bases
+	// are scored identically, so the case of the
+	// sequence does not change the TSD found.
+	upper := strings.ToUpper(flankLeft + dup + insertion + dup + flankRight)
+	lower := strings.ToLower(upper)
+	n := len(flankLeft) + len(dup)
+	mixed := lower[:n] + upper[n:]
+	start := len(flankLeft) + len(dup)
+	end := start + len(insertion)
+
+	sw, err := NewAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	band, err := NewBandedAligner(alphabet.DNAgapped, Scores{1, -2, -3}, nil, 2*len(dup))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, aligner := range []struct {
+		name string
+		sw   align.Aligner
+	}{
+		{name: "full", sw: sw},
+		{name: "banded", sw: band},
+	} {
+		var want *TSD
+		for _, c := range []struct {
+			name string
+			seq  string
+		}{
+			{name: "upper", seq: upper},
+			{name: "lower", seq: lower},
+			{name: "mixed", seq: mixed},
+		} {
+			s := linear.NewSeq("test", alphabet.BytesToLetters([]byte(c.seq)), alphabet.DNAgapped)
+			got, err := Find(s, Windows(start, end, s.Len(), 15, 15), aligner.sw, 6, 0.5)
+			if err != nil {
+				t.Fatalf("unexpected error for %s %s: %v", aligner.name, c.name, err)
+			}
+			if got == nil {
+				t.Fatalf("failed to find TSD for %s %s", aligner.name, c.name)
+			}
+			if got.Identity != 1 {
+				t.Errorf("unexpected identity for %s %s: got:%v want:1", aligner.name, c.name, got.Identity)
+			}
+			if want == nil {
+				want = got
+				continue
+			}
+			gl, gr := got.Spans()
+			wl, wr := want.Spans()
+			if gl != wl || gr != wr || got.Score != want.Score {
+				t.Errorf("unexpected TSD for %s %s: got:%v %v score=%d want:%v %v score=%d",
+					aligner.name, c.name, gl, gr, got.Score, wl, wr, want.Score)
+			}
+		}
+	}
+}